@@ -3,136 +3,209 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/sirupsen/logrus"
-	"github.com/syndtr/goleveldb/leveldb"
-	"github.com/syndtr/goleveldb/leveldb/opt"
-	"github.com/user/tg-forward-to-xx/config"
-	"github.com/user/tg-forward-to-xx/internal/models"
+	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/encryption"
+	"github.com/user/tg-forward-to-xx/internal/migration"
 )
 
 var (
 	configFile string
+	storeName  string
+	target     int
 	dryRun     bool
+	noBackup   bool
+	diffLimit  int
+
+	// 主密钥，数据库已加密时迁移和重新加密需要
+	masterKeys *encryption.MasterKeys
 )
 
 func init() {
 	flag.StringVar(&configFile, "config", "/etc/tg-forward/config.yaml", "配置文件路径")
+	flag.StringVar(&storeName, "store", "all", "要迁移的数据库：all、chat_history 或 queue")
+	flag.IntVar(&target, "to", -1, "目标版本，up 默认迁移到最新版本，down 默认回滚一个版本")
 	flag.BoolVar(&dryRun, "dry-run", false, "是否只预览变更而不实际执行")
-	flag.Parse()
+	flag.BoolVar(&noBackup, "no-backup", false, "跳过迁移前的自动备份")
+	flag.IntVar(&diffLimit, "diff-limit", 20, "预览时每个迁移最多显示的变更数量，0 表示全部")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "用法: migrate [参数] [status|up|down|reencrypt|decrypt]")
+		flag.PrintDefaults()
+	}
 }
 
-func sanitizeMessage(content string) string {
-	// 检查是否包含无法解析的字符
-	if strings.ContainsRune(content, '\uFFFD') {
-		return "Emoji 解析失败"
-	}
-	return content
+// target 一个待迁移的数据库及其路径
+type storeTarget struct {
+	store *migration.Store
+	path  string
 }
 
 func main() {
+	flag.Parse()
+
+	command := "up"
+	if flag.NArg() > 0 {
+		command = flag.Arg(0)
+	}
+	switch command {
+	case "status", migration.DirectionUp, migration.DirectionDown, "reencrypt", "decrypt":
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
 	// 加载配置
 	if err := config.LoadConfig(configFile); err != nil {
 		logrus.Fatalf("加载配置文件失败: %v", err)
 	}
-
-	// 打开数据库
-	dbPath := filepath.Join(config.AppConfig.Queue.Path, "chat_history")
-	options := &opt.Options{
-		ErrorIfExist:   false,
-		ErrorIfMissing: false,
+	var err error
+	if masterKeys, err = encryption.KeysFromConfig(config.Current().Encryption); err != nil {
+		logrus.Fatalf("读取主密钥失败: %v", err)
 	}
 
-	db, err := leveldb.OpenFile(dbPath, options)
+	targets, err := selectTargets()
 	if err != nil {
-		logrus.Fatalf("打开数据库失败: %v", err)
+		logrus.Fatal(err)
 	}
-	defer db.Close()
-
-	// 创建群组名称映射
-	groupNames := make(map[int64]string)
-	for _, chatID := range config.AppConfig.Telegram.ChatIDs {
-		groupNames[chatID] = fmt.Sprintf("群组(%d)", chatID)
-	}
-
-	// 统计信息
-	var total, updated int
-
-	// 开始迭代所有记录
-	iter := db.NewIterator(nil, nil)
-	defer iter.Release()
 
-	batch := new(leveldb.Batch)
-
-	for iter.Next() {
-		total++
-		key := iter.Key()
-		value := iter.Value()
+	failed := false
+	for _, t := range targets {
+		if _, err := os.Stat(t.path); os.IsNotExist(err) {
+			logrus.Infof("%s 数据库不存在，跳过: %s", t.store.Name, t.path)
+			continue
+		}
 
-		// 解析消息
-		message, err := models.FromJSONHistory(value)
+		switch command {
+		case "status":
+			err = printStatus(t)
+		case "reencrypt", "decrypt":
+			err = runEncryption(t, command)
+		default:
+			err = runMigration(t, command)
+		}
 		if err != nil {
-			logrus.Errorf("解析消息失败 [key=%x]: %v", key, err)
-			continue
+			logrus.Errorf("%s: %v", t.store.Name, err)
+			failed = true
 		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
 
-		needsUpdate := false
+// selectTargets 根据 -store 参数和配置确定要处理的数据库
+func selectTargets() ([]storeTarget, error) {
+	queueCfg := config.Current().Queue
+	queuePath := queueCfg.Path
+	all := []storeTarget{
+		{store: migration.ChatHistory, path: filepath.Join(queuePath, "chat_history")},
+	}
+	if queueCfg.Type == "leveldb" {
+		all = append(all, storeTarget{store: migration.Queue, path: queuePath})
+	}
 
-		// 检查是否需要更新群组名称
-		if message.GroupName == "" {
-			message.GroupName = groupNames[message.ChatID]
-			needsUpdate = true
+	if storeName == "all" {
+		return all, nil
+	}
+	for _, t := range all {
+		if t.store.Name == storeName {
+			return []storeTarget{t}, nil
 		}
+	}
+	return nil, fmt.Errorf("未知或未启用的数据库: %s", storeName)
+}
 
-		// 检查消息内容是否需要处理
-		sanitizedText := sanitizeMessage(message.Text)
-		if sanitizedText != message.Text {
-			message.Text = sanitizedText
-			needsUpdate = true
-		}
+// printStatus 输出数据库当前版本和待执行的迁移
+func printStatus(t storeTarget) error {
+	status, err := migration.GetStatus(t.store, t.path)
+	if err != nil {
+		return err
+	}
 
-		if needsUpdate {
-			updated++
-			// 序列化消息
-			newValue, err := message.ToJSON()
-			if err != nil {
-				logrus.Errorf("序列化消息失败 [id=%d]: %v", message.ID, err)
-				continue
-			}
-
-			if !dryRun {
-				// 添加到批处理
-				batch.Put(key, newValue)
-
-				// 每1000条记录执行一次批处理
-				if updated%1000 == 0 {
-					if err := db.Write(batch, nil); err != nil {
-						logrus.Errorf("写入批处理失败: %v", err)
-					}
-					batch.Reset()
-					logrus.Infof("已处理 %d/%d 条记录，更新 %d 条", total, total, updated)
-				}
-			}
+	version := fmt.Sprintf("%d", status.Version)
+	if !status.Found {
+		version = "未记录"
+		if status.Empty {
+			version += "（空库，启动时自动标记为最新版本）"
 		}
 	}
-
-	// 处理剩余的批处理
-	if !dryRun && batch.Len() > 0 {
-		if err := db.Write(batch, nil); err != nil {
-			logrus.Errorf("写入最后的批处理失败: %v", err)
+	encrypted := "否"
+	if status.Encrypted {
+		encrypted = "是"
+	}
+	fmt.Printf("%s (%s)\n  当前版本: %s\n  最新版本: %d\n  静态加密: %s\n", t.store.Name, status.Path, version, status.Latest, encrypted)
+	for _, m := range status.Pending {
+		down := ""
+		if m.Down == nil {
+			down = "（不可回滚）"
 		}
+		fmt.Printf("  待执行: %s%s\n", m.ID(), down)
+	}
+	return nil
+}
+
+// runMigration 执行或预览迁移
+func runMigration(t storeTarget, direction string) error {
+	opts := migration.Options{
+		Direction: direction,
+		Target:    target,
+		DryRun:    dryRun,
+		NoBackup:  noBackup,
+		DiffLimit: diffLimit,
+		Keys:      masterKeys,
+	}
+	if dryRun {
+		opts.Diff = os.Stdout
 	}
 
-	if err := iter.Error(); err != nil {
-		logrus.Errorf("迭代过程中发生错误: %v", err)
+	steps, backupPath, err := migration.Run(t.store, t.path, opts)
+	if backupPath != "" {
+		logrus.Infof("%s 迁移前的备份: %s", t.store.Name, backupPath)
+	}
+	if err != nil {
+		return err
+	}
+	if len(steps) == 0 {
+		logrus.Infof("%s 已是目标版本，无需迁移", t.store.Name)
+		return nil
+	}
+
+	var changes int
+	for _, step := range steps {
+		changes += step.Changes
 	}
 
 	// 输出统计信息
 	if dryRun {
-		logrus.Infof("预览模式：共发现 %d 条记录，需要更新 %d 条", total, updated)
+		logrus.Infof("预览模式：%s 共 %d 个迁移，需要变更 %d 条记录", t.store.Name, len(steps), changes)
 	} else {
-		logrus.Infof("迁移完成：共处理 %d 条记录，更新 %d 条", total, updated)
+		logrus.Infof("迁移完成：%s 共执行 %d 个迁移，变更 %d 条记录", t.store.Name, len(steps), changes)
+	}
+	return nil
+}
+
+// runEncryption 重新加密或解密数据库中的全部数据
+func runEncryption(t storeTarget, command string) error {
+	run, action := migration.Reencrypt, "重新加密"
+	if command == "decrypt" {
+		run, action = migration.Decrypt, "解密"
 	}
-} 
\ No newline at end of file
+
+	count, backupPath, err := run(t.store, t.path, masterKeys, migration.Options{DryRun: dryRun, NoBackup: noBackup})
+	if backupPath != "" {
+		logrus.Infof("%s %s前的备份: %s", t.store.Name, action, backupPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		logrus.Infof("预览模式：%s 主密钥校验通过，需要%s %d 条记录", t.store.Name, action, count)
+	} else {
+		logrus.Infof("%s完成：%s 共改写 %d 条记录", action, t.store.Name, count)
+	}
+	return nil
+}
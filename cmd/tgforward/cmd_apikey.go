@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/user/tg-forward-to-xx/internal/auth"
+)
+
+// runAPIKeyGenerate 生成 API Key，明文只输出一次，配置文件中只保存哈希
+func runAPIKeyGenerate(args []string) int {
+	fs, _ := newCommandFlags("apikey generate")
+	name := fs.String("name", "", "API Key 名称，记录在审计日志中")
+	scopes := fs.String("scopes", auth.ScopeHistoryRead, "逗号分隔的权限范围：history:read、history:export、history:admin、backup:admin、queue:admin、send、metrics:read、*")
+	chats := fs.String("chats", "", "逗号分隔的群组ID，限制只能访问这些群组，为空表示不限制")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *name == "" {
+		fmt.Fprintln(os.Stderr, "必须通过 -name 指定 API Key 名称")
+		return exitUsage
+	}
+
+	var chatIDs []string
+	for _, s := range splitList(*chats) {
+		if _, err := strconv.ParseInt(s, 10, 64); err != nil {
+			fmt.Fprintf(os.Stderr, "无效的群组ID: %s\n", s)
+			return exitUsage
+		}
+		chatIDs = append(chatIDs, s)
+	}
+
+	key, hash, err := auth.GenerateKey()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	fmt.Fprintf(os.Stderr, "API Key（只显示一次，请妥善保存）:\n%s\n\n将以下内容添加到配置文件的 api.auth.keys:\n", key)
+	fmt.Printf("- name: %q\n  hash: %q\n  scopes: [%s]\n", *name, hash, quoteList(splitList(*scopes)))
+	if len(chatIDs) > 0 {
+		fmt.Printf("  chat_ids: [%s]\n", strings.Join(chatIDs, ", "))
+	}
+	return exitOK
+}
+
+// splitList 拆分逗号分隔的参数并去掉空项
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// quoteList 将列表格式化为 YAML 行内数组的内容
+func quoteList(items []string) string {
+	quoted := make([]string, len(items))
+	for i, item := range items {
+		quoted[i] = strconv.Quote(item)
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/user/tg-forward-to-xx/internal/backup"
+	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/encryption"
+)
+
+// runBackupCreate 备份聊天记录和 LevelDB 重试队列
+// 指定 -server 时通过 HTTP API 让运行中的服务执行在线备份，否则在服务停止时直接读取数据库
+func runBackupCreate(args []string) int {
+	fs, path := newCommandFlags("backup create")
+	output := fs.String("o", "", "备份文件路径，默认保存到 backup.dir 并按 backup.keep 清理旧备份")
+	upload := fs.Bool("upload", false, "上传到 s3.bucket，默认按 backup.upload 配置")
+	server := fs.String("server", "", "运行中服务的 HTTP API 地址，例如 http://127.0.0.1:8080，指定后由服务执行在线备份")
+	apiKey := fs.String("api-key", os.Getenv("TGFWD_API_KEY"), "调用 -server 使用的 API Key，需要 backup:admin 权限，默认读取 TGFWD_API_KEY")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if err := loadCommandConfig(*path); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	cfg := config.Current()
+
+	var report *backup.Report
+	var err error
+	if *server != "" {
+		if *output != "" || *upload {
+			fmt.Fprintln(os.Stderr, "-server 由服务按其配置备份，不支持 -o 和 -upload")
+			return exitUsage
+		}
+		report, err = requestBackup(*server, cfg.API.Auth.HeaderName, *apiKey)
+	} else {
+		report, err = createBackup(cfg, *output, *upload || cfg.Backup.Upload)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "备份失败: %v\n", err)
+		return exitFailure
+	}
+
+	fmt.Printf("备份已保存到 %s（%d 字节）\n", report.File, report.Size)
+	for _, store := range report.Stores {
+		fmt.Printf("  %s: %d 条记录，结构版本 %d\n", store.Name, store.Records, store.SchemaVersion)
+	}
+	if report.Object != "" {
+		fmt.Printf("已上传到 S3: %s\n", report.Object)
+	}
+	if report.Pruned > 0 {
+		fmt.Printf("已删除 %d 个旧备份\n", report.Pruned)
+	}
+	return exitOK
+}
+
+// createBackup 在服务停止时直接读取数据库创建备份
+func createBackup(cfg *config.Config, output string, upload bool) (*backup.Report, error) {
+	sources, closeFn, err := backup.OpenSources(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%w，服务运行中请使用 -server 通过 API 备份", err)
+	}
+	defer closeFn()
+
+	dir := backup.Dir(cfg)
+	path := output
+	if path == "" {
+		path = filepath.Join(dir, backup.FileName(time.Now()))
+	}
+	report, err := backup.Create(sources, path, upload, cfg.Backup.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	if output == "" {
+		if report.Pruned, err = backup.Prune(dir, cfg.Backup.Keep); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// requestBackup 调用运行中服务的 POST /api/backup
+func requestBackup(server, headerName, apiKey string) (*backup.Report, error) {
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(server, "/")+"/api/backup", nil)
+	if err != nil {
+		return nil, err
+	}
+	if apiKey != "" {
+		req.Header.Set(headerName, apiKey)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求服务失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应失败: %w", err)
+	}
+	var report backup.Report
+	if err := json.Unmarshal(body, &report); err != nil {
+		return nil, fmt.Errorf("服务返回 %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if report.Error != "" {
+		return nil, fmt.Errorf("%s", report.Error)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("服务返回 %s", resp.Status)
+	}
+	return &report, nil
+}
+
+// runBackupRestore 从备份文件恢复聊天记录和 LevelDB 重试队列，需要先停止服务
+// 未加 -yes 时只校验备份文件
+func runBackupRestore(args []string) int {
+	fs, path := newCommandFlags("backup restore")
+	file := fs.String("file", "", "备份文件（.tar.zst）")
+	yes := fs.Bool("yes", false, "确认替换现有的数据库")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *file == "" {
+		fmt.Fprintln(os.Stderr, "必须通过 -file 指定备份文件")
+		return exitUsage
+	}
+
+	if err := loadCommandConfig(*path); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	cfg := config.Current()
+	keys, err := encryption.KeysFromConfig(cfg.Encryption)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	result, err := backup.Restore(*file, backup.RestoreOptions{QueuePath: cfg.Queue.Path, Keys: keys, DryRun: !*yes})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "恢复失败: %v\n", err)
+		return exitFailure
+	}
+
+	fmt.Printf("备份创建于 %s，校验通过\n", result.Manifest.CreatedAt.Local().Format(time.RFC3339))
+	needsMigration := false
+	for _, store := range result.Stores {
+		note := ""
+		if store.NeedsMigration {
+			note = "，低于程序要求的版本"
+			needsMigration = true
+		}
+		fmt.Printf("  %s → %s: %d 条记录，结构版本 %d%s\n", store.Name, store.Path, store.Records, store.SchemaVersion, note)
+	}
+	if !*yes {
+		fmt.Println("将替换以上数据库，原有数据会移动到 queue.path 下的 pre-restore-<时间> 目录，确认请加 -yes")
+		return exitOK
+	}
+
+	if result.Previous != "" {
+		fmt.Printf("原有数据已移动到 %s\n", result.Previous)
+	}
+	if needsMigration {
+		fmt.Println("请先运行 migrate up，再运行 tgforward history reindex 重建搜索索引和统计汇总")
+	} else if result.Manifest.Store("chat_history") != nil {
+		fmt.Println("请运行 tgforward history reindex 重建搜索索引和统计汇总")
+	}
+	return exitOK
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/queue"
+	"github.com/user/tg-forward-to-xx/internal/storage"
+	"golang.org/x/sys/unix"
+)
+
+// 连通性检查的超时时间
+const doctorTimeout = 5 * time.Second
+
+// 检查结果状态
+const (
+	checkOK   = "OK"
+	checkWarn = "WARN"
+	checkFail = "FAIL"
+)
+
+// doctor 汇总各项检查结果
+type doctor struct {
+	failed int
+}
+
+// report 打印一项检查结果
+func (d *doctor) report(status, name, detail string) {
+	if status == checkFail {
+		d.failed++
+	}
+	// 错误信息中可能带有含密钥的请求地址
+	fmt.Printf("[%-4s] %-24s %s\n", status, name, config.RedactSecrets(detail))
+}
+
+// result 根据错误打印检查结果
+func (d *doctor) result(name string, err error, okDetail string) {
+	if err != nil {
+		d.report(checkFail, name, err.Error())
+		return
+	}
+	d.report(checkOK, name, okDetail)
+}
+
+// runDoctor 检查配置、目录权限以及各端点的连通性
+func runDoctor(args []string) int {
+	fs, path := newCommandFlags("doctor")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	d := &doctor{}
+	if err := loadCommandConfig(*path); err != nil {
+		d.report(checkFail, "配置文件", err.Error())
+		return exitFailure
+	}
+	cfg := config.Current()
+	d.report(checkOK, "配置文件", fmt.Sprintf("%s (%s)", *path, config.CurrentVersion().Checksum))
+
+	d.checkDirectories(cfg)
+	d.checkEndpoints(cfg)
+
+	if d.failed > 0 {
+		fmt.Printf("\n共 %d 项检查未通过\n", d.failed)
+		return exitFailure
+	}
+	fmt.Println("\n全部检查通过")
+	return exitOK
+}
+
+// checkDirectories 检查队列、聊天记录、搜索索引和日志目录的权限
+func (d *doctor) checkDirectories(cfg *config.Config) {
+	if cfg.Queue.Type == "leveldb" {
+		d.result("队列目录", checkWritableDir(cfg.Queue.Path), cfg.Queue.Path)
+
+		q, err := queue.OpenLevelDBQueue(cfg.Queue.Path)
+		if err != nil {
+			d.report(checkWarn, "队列数据库", err.Error())
+		} else {
+			size, _ := q.Size()
+			q.Close()
+			d.report(checkOK, "队列数据库", fmt.Sprintf("待重试消息 %d 条", size))
+		}
+
+		historyPath := filepath.Join(cfg.Queue.Path, "chat_history")
+		d.result("聊天记录目录", checkWritableDir(historyPath), historyPath)
+
+		searchPath := filepath.Join(cfg.Queue.Path, "chat_search")
+		d.result("搜索索引目录", checkWritableDir(searchPath), searchPath)
+	}
+
+	if cfg.Log.FilePath != "" {
+		logDir := filepath.Dir(cfg.Log.FilePath)
+		d.result("日志目录", checkWritableDir(logDir), logDir)
+	}
+
+	for _, s := range cfg.Sinks {
+		if s.Enabled && s.File != nil {
+			d.result("投递目标 "+s.Name, checkWritableDir(s.File.Dir), s.File.Dir)
+		}
+	}
+}
+
+// checkEndpoints 检查已配置的各端点能否连通
+func (d *doctor) checkEndpoints(cfg *config.Config) {
+	d.result("Telegram", checkTelegram(cfg.Telegram.Token), "Bot Token 有效")
+
+	if cfg.DingTalk.Enabled {
+		d.result("钉钉", checkURL(cfg.DingTalk.WebhookURL), config.MaskURL(cfg.DingTalk.WebhookURL))
+	}
+	if cfg.Feishu.Enabled {
+		d.result("飞书", checkURL(cfg.Feishu.WebhookURL), config.MaskURL(cfg.Feishu.WebhookURL))
+	}
+	if cfg.Bark.Enabled {
+		d.result("Bark", checkURL("https://api.day.app"), "https://api.day.app")
+	}
+	if cfg.Harmony.Enabled {
+		d.result("HarmonyOS_MeoW", checkURL(cfg.Harmony.BaseURL), cfg.Harmony.BaseURL)
+	}
+	if cfg.S3.Endpoint != "" {
+		d.result("S3", checkS3(), fmt.Sprintf("%s/%s", cfg.S3.Endpoint, cfg.S3.Bucket))
+	}
+
+	for _, s := range cfg.Sinks {
+		if !s.Enabled {
+			continue
+		}
+		name := "投递目标 " + s.Name
+		switch {
+		case s.Telegram != nil:
+			token := s.Telegram.Token
+			if token == "" {
+				token = cfg.Telegram.Token
+			}
+			d.result(name, checkTelegram(token), "Bot Token 有效")
+		case s.Matrix != nil:
+			d.result(name, checkURL(s.Matrix.HomeserverURL), s.Matrix.HomeserverURL)
+		case s.Mattermost != nil:
+			d.result(name, checkURL(s.Mattermost.WebhookURL), config.MaskURL(s.Mattermost.WebhookURL))
+		case s.RocketChat != nil:
+			d.result(name, checkURL(s.RocketChat.WebhookURL), config.MaskURL(s.RocketChat.WebhookURL))
+		case s.MQTT != nil:
+			d.result(name, checkURL(s.MQTT.Broker), s.MQTT.Broker)
+		case s.Redis != nil:
+			d.result(name, checkAddr(s.Redis.Addr, false), s.Redis.Addr)
+		}
+	}
+}
+
+// checkWritableDir 检查目录存在且当前用户可写，目录不存在时检查能否创建
+func checkWritableDir(dir string) error {
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		parent := filepath.Dir(dir)
+		for {
+			if _, err := os.Stat(parent); err == nil {
+				break
+			}
+			next := filepath.Dir(parent)
+			if next == parent {
+				break
+			}
+			parent = next
+		}
+		if err := unix.Access(parent, unix.W_OK); err != nil {
+			return fmt.Errorf("目录不存在且无法在 %s 下创建: %w", parent, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("检查目录状态失败: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s 不是目录", dir)
+	}
+	if err := unix.Access(dir, unix.W_OK); err != nil {
+		return fmt.Errorf("目录不可写: %w", err)
+	}
+	return nil
+}
+
+// checkTelegram 调用 getMe 检查 Bot Token 是否有效
+func checkTelegram(token string) error {
+	bot, err := tgbotapi.NewBotAPIWithClient(token, tgbotapi.APIEndpoint, newDoctorHTTPClient())
+	if err != nil {
+		return fmt.Errorf("调用 getMe 失败: %w", err)
+	}
+	if bot.Self.UserName == "" {
+		return fmt.Errorf("getMe 未返回 Bot 信息")
+	}
+	return nil
+}
+
+// newDoctorHTTPClient 创建带超时的 HTTP 客户端
+func newDoctorHTTPClient() *http.Client {
+	return &http.Client{Timeout: doctorTimeout}
+}
+
+// checkS3 检查存储桶是否可访问
+func checkS3() error {
+	client, err := storage.NewS3Client()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+	return client.CheckBucket(ctx)
+}
+
+// checkURL 按 URL 的协议连接对应主机和端口，https 等加密协议会完成 TLS 握手
+func checkURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("无效的地址: %s", config.MaskURL(rawURL))
+	}
+
+	ports := map[string]string{
+		"http": "80", "https": "443",
+		"ws": "80", "wss": "443",
+		"tcp": "1883", "mqtt": "1883",
+		"ssl": "8883", "tls": "8883", "mqtts": "8883",
+	}
+	port := u.Port()
+	if port == "" {
+		port = ports[u.Scheme]
+	}
+	if port == "" {
+		return fmt.Errorf("无法确定 %s 协议的端口", u.Scheme)
+	}
+
+	secure := map[string]bool{"https": true, "wss": true, "ssl": true, "tls": true, "mqtts": true}[u.Scheme]
+	return checkAddr(net.JoinHostPort(u.Hostname(), port), secure)
+}
+
+// checkAddr 建立 TCP 连接，secure 为 true 时同时校验服务端证书
+func checkAddr(addr string, secure bool) error {
+	dialer := &net.Dialer{Timeout: doctorTimeout}
+	if secure {
+		host, _, _ := net.SplitHostPort(addr)
+		conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: host})
+		if err != nil {
+			return fmt.Errorf("连接 %s 失败: %w", addr, err)
+		}
+		return conn.Close()
+	}
+
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("连接 %s 失败: %w", addr, err)
+	}
+	return conn.Close()
+}
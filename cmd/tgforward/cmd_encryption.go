@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/user/tg-forward-to-xx/internal/encryption"
+)
+
+// runEncryptionKeygen 生成静态加密的主密钥
+func runEncryptionKeygen(args []string) int {
+	fs, _ := newCommandFlags("encryption keygen")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	fmt.Fprintln(os.Stderr, "主密钥（请妥善保存，丢失后已加密的数据无法恢复）:")
+	fmt.Println(encryption.GenerateKey())
+	fmt.Fprintln(os.Stderr, "\n建议保存到只有服务用户可读的文件中，通过 encryption.key_file 或 TGFWD_ENCRYPTION_KEY_FILE 引用")
+	return exitOK
+}
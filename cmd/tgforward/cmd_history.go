@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/export"
+	"github.com/user/tg-forward-to-xx/internal/importer"
+	"github.com/user/tg-forward-to-xx/internal/storage"
+)
+
+// runHistoryExport 离线导出聊天记录，与 /api/chat/history/export 输出相同的格式
+func runHistoryExport(args []string) int {
+	fs, path := newCommandFlags("history export")
+	chatID := fs.Int64("chat", 0, "群组ID")
+	start := fs.String("start", "", "开始时间（RFC3339），默认不限")
+	end := fs.String("end", "", "结束时间（RFC3339），默认为当前时间")
+	user := fs.String("user", "", "只导出指定用户的消息")
+	format := fs.String("format", "csv", "导出格式："+strings.Join(export.Formats(), "、"))
+	output := fs.String("o", "", "输出文件，默认为 chat_history_<群组ID>_<时间>.<格式>")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *chatID == 0 {
+		fmt.Fprintln(os.Stderr, "必须通过 -chat 指定群组ID")
+		return exitUsage
+	}
+	name, ok := export.Normalize(*format)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "不支持的导出格式: %s，支持 %s\n", *format, strings.Join(export.Formats(), "、"))
+		return exitUsage
+	}
+
+	startTime, endTime, err := parseExportRange(*start, *end)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	if err := loadCommandConfig(*path); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	history, err := storage.NewChatHistoryStorage()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v（服务是否仍在运行？）\n", err)
+		return exitFailure
+	}
+	defer history.Close()
+
+	now := time.Now()
+	filePath := *output
+	if filePath == "" {
+		filePath = export.FileName(*chatID, name, now)
+	}
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "创建导出文件失败: %v\n", err)
+		return exitFailure
+	}
+	defer file.Close()
+
+	info := &export.Info{ChatID: *chatID, End: endTime, ExportedAt: now}
+	if *start != "" {
+		info.Start = startTime
+	}
+	query := &storage.HistoryQuery{ChatID: *chatID, Start: startTime, End: endTime, FromUser: *user}
+	w := bufio.NewWriter(file)
+	count, err := export.Export(w, name, history, query, info)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "导出失败: %v\n", err)
+		return exitFailure
+	}
+
+	fmt.Printf("已导出 %d 条聊天记录到 %s\n", count, filePath)
+	return exitOK
+}
+
+// runHistoryReindex 清空并重建全文搜索索引和统计汇总，升级后首次使用搜索、统计或索引损坏时运行
+func runHistoryReindex(args []string) int {
+	fs, path := newCommandFlags("history reindex")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if err := loadCommandConfig(*path); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	history, err := storage.NewChatHistoryStorage()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v（服务是否仍在运行？）\n", err)
+		return exitFailure
+	}
+	defer history.Close()
+
+	started := time.Now()
+	count, err := history.RebuildIndex(func(indexed int) {
+		fmt.Fprintf(os.Stderr, "\r已索引 %d 条消息", indexed)
+	})
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "重建索引失败: %v\n", err)
+		return exitFailure
+	}
+
+	fmt.Printf("搜索索引已重建，共 %d 条消息，耗时 %s\n", count, time.Since(started).Round(time.Millisecond))
+
+	started = time.Now()
+	count, err = history.RebuildStats(func(processed int) {
+		fmt.Fprintf(os.Stderr, "\r已统计 %d 条消息", processed)
+	})
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "重建统计汇总失败: %v\n", err)
+		return exitFailure
+	}
+
+	fmt.Printf("统计汇总已重建，共 %d 条消息，耗时 %s\n", count, time.Since(started).Round(time.Millisecond))
+	return exitOK
+}
+
+// runHistoryImport 导入 Telegram Desktop 导出的 result.json，补全 Bot 入群之前的聊天记录
+// 可以重复运行，已存在的消息会被跳过
+func runHistoryImport(args []string) int {
+	fs, path := newCommandFlags("history import")
+	file := fs.String("file", "", "Telegram Desktop 导出的 result.json")
+	chatID := fs.Int64("chat", 0, "只导入指定群组（Bot API 格式，例如 -1001234567890），默认导入文件中的全部聊天")
+	uploadMedia := fs.Bool("upload-media", false, "将导出目录中的媒体文件上传到 S3")
+	dryRun := fs.Bool("dry-run", false, "只统计将要导入的消息，不写入")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *file == "" {
+		fmt.Fprintln(os.Stderr, "必须通过 -file 指定 result.json")
+		return exitUsage
+	}
+
+	if err := loadCommandConfig(*path); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	if *uploadMedia && config.Current().S3.Endpoint == "" {
+		fmt.Fprintln(os.Stderr, "-upload-media 需要配置 s3.endpoint")
+		return exitUsage
+	}
+
+	history, err := storage.NewChatHistoryStorage()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v（服务是否仍在运行？）\n", err)
+		return exitFailure
+	}
+	defer history.Close()
+
+	stats, err := importer.ImportFile(history, *file, importer.Options{
+		ChatID:      *chatID,
+		UploadMedia: *uploadMedia,
+		DryRun:      *dryRun,
+		Progress: func(stats importer.Stats) {
+			fmt.Fprintf(os.Stderr, "\r已读取 %d 条消息，新增 %d 条", stats.Messages, stats.Imported)
+		},
+	})
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "导入失败: %v\n", err)
+		fmt.Fprintf(os.Stderr, "失败前已新增 %d 条消息，修复后重新运行会跳过已导入的消息\n", stats.Imported)
+		return exitFailure
+	}
+
+	action := "已导入"
+	if *dryRun {
+		action = "将导入"
+	}
+	fmt.Printf("%s %d 个聊天的 %d 条消息，跳过已存在 %d 条、系统消息 %d 条\n",
+		action, stats.Chats, stats.Imported, stats.Duplicates, stats.Service)
+	if stats.MediaUploaded > 0 || stats.MediaMissing > 0 {
+		fmt.Printf("上传媒体 %d 个，缺失 %d 个（导出时未下载或文件不存在）\n", stats.MediaUploaded, stats.MediaMissing)
+	}
+	return exitOK
+}
+
+// parseExportRange 解析导出的时间范围
+func parseExportRange(start, end string) (time.Time, time.Time, error) {
+	startTime := time.Unix(0, 0)
+	endTime := time.Now()
+
+	var err error
+	if start != "" {
+		if startTime, err = time.Parse(time.RFC3339, start); err != nil {
+			return startTime, endTime, fmt.Errorf("无效的开始时间: %w", err)
+		}
+	}
+	if end != "" {
+		if endTime, err = time.Parse(time.RFC3339, end); err != nil {
+			return startTime, endTime, fmt.Errorf("无效的结束时间: %w", err)
+		}
+	}
+	if !endTime.After(startTime) {
+		return startTime, endTime, fmt.Errorf("结束时间必须晚于开始时间")
+	}
+	return startTime, endTime, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/queue"
+)
+
+// 列表中消息摘要的最大显示长度（字符数）
+const queueSummaryWidth = 40
+
+// runQueue 离线管理 LevelDB 重试队列，服务运行时队列被锁定，需要先停止服务
+func runQueue(action string, args []string) int {
+	fs, path := newCommandFlags("queue " + action)
+	key := fs.String("key", "", "只处理指定存储键的消息，例如 msg:12")
+	limit := fs.Int("limit", 0, "ls 最多显示的消息数量，0 表示全部")
+	yes := fs.Bool("yes", false, "purge 时确认删除")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	switch action {
+	case "ls", "peek", "purge", "replay":
+	default:
+		fmt.Fprintf(os.Stderr, "未知的队列命令: %s，支持 ls、peek、purge、replay\n", action)
+		return exitUsage
+	}
+
+	if err := loadCommandConfig(*path); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	cfg := config.Current()
+	if cfg.Queue.Type != "leveldb" {
+		fmt.Fprintf(os.Stderr, "队列类型为 %s，只有 leveldb 队列支持离线管理\n", cfg.Queue.Type)
+		return exitFailure
+	}
+
+	q, err := queue.OpenLevelDBQueue(cfg.Queue.Path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "打开队列失败: %v\n", err)
+		return exitFailure
+	}
+	defer q.Close()
+
+	entries, err := selectEntries(q, *key)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	switch action {
+	case "ls":
+		err = queueList(entries, *limit)
+	case "peek":
+		err = queuePeek(entries)
+	case "purge":
+		err = queuePurge(q, entries, *yes)
+	case "replay":
+		err = queueReplay(q, entries)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// selectEntries 返回全部消息，指定 key 时只返回该条
+func selectEntries(q *queue.LevelDBQueue, key string) ([]queue.Entry, error) {
+	if key == "" {
+		return q.Entries()
+	}
+	msg, err := q.Get(key)
+	if err != nil {
+		return nil, fmt.Errorf("读取消息 %s 失败: %w", key, err)
+	}
+	return []queue.Entry{{Key: key, Message: msg}}, nil
+}
+
+// queueList 以表格形式列出队列中的消息
+func queueList(entries []queue.Entry, limit int) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tCHAT\tTYPE\tATTEMPTS\tLAST_ATTEMPT\tCREATED_AT\tSUMMARY")
+	for i, e := range entries {
+		if limit > 0 && i >= limit {
+			break
+		}
+		if e.Message == nil {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\t-\t<无法解析>\n", e.Key)
+			continue
+		}
+		m := e.Message
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			e.Key,
+			m.Chat.Name(),
+			m.MessageType,
+			m.Attempts,
+			formatQueueTime(m.LastAttempt),
+			formatQueueTime(m.CreatedAt),
+			truncate(m.Summary(), queueSummaryWidth),
+		)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("\n共 %d 条消息\n", len(entries))
+	return nil
+}
+
+// queuePeek 以 JSON 输出第一条消息的完整内容
+func queuePeek(entries []queue.Entry) error {
+	if len(entries) == 0 {
+		fmt.Println("队列为空")
+		return nil
+	}
+	e := entries[0]
+	if e.Message == nil {
+		return fmt.Errorf("消息 %s 无法解析", e.Key)
+	}
+
+	fmt.Printf("# %s\n", e.Key)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(e.Message)
+}
+
+// queuePurge 删除消息，未加 -yes 时只提示将要删除的数量
+func queuePurge(q *queue.LevelDBQueue, entries []queue.Entry, yes bool) error {
+	if len(entries) == 0 {
+		fmt.Println("队列为空")
+		return nil
+	}
+	if !yes {
+		return fmt.Errorf("将删除 %d 条消息，确认请加 -yes", len(entries))
+	}
+
+	keys := make([]string, 0, len(entries))
+	for _, e := range entries {
+		keys = append(keys, e.Key)
+	}
+	deleted, err := q.Delete(keys...)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("已删除 %d 条消息\n", deleted)
+	return nil
+}
+
+// queueReplay 清零重试次数，服务启动后会立即重新投递这些消息
+// 已达到最大重试次数的消息会在下一轮重试时被丢弃，重放后可以再完整重试一遍
+func queueReplay(q *queue.LevelDBQueue, entries []queue.Entry) error {
+	var replayed int
+	for _, e := range entries {
+		if e.Message == nil {
+			fmt.Fprintf(os.Stderr, "跳过无法解析的消息 %s\n", e.Key)
+			continue
+		}
+		e.Message.Attempts = 0
+		e.Message.LastAttempt = time.Time{}
+		if err := q.Update(e.Key, e.Message); err != nil {
+			return fmt.Errorf("更新消息 %s 失败: %w", e.Key, err)
+		}
+		replayed++
+	}
+	fmt.Printf("已重置 %d 条消息的重试次数，启动服务后将重新投递\n", replayed)
+	return nil
+}
+
+// formatQueueTime 格式化时间，零值显示为 -
+func formatQueueTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+// truncate 按字符截断文本并去掉换行
+func truncate(s string, width int) string {
+	runes := make([]rune, 0, width)
+	for _, r := range s {
+		if r == '\n' || r == '\r' {
+			r = ' '
+		}
+		runes = append(runes, r)
+		if len(runes) > width {
+			return string(runes[:width]) + "…"
+		}
+	}
+	return string(runes)
+}
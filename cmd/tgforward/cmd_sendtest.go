@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/user/tg-forward-to-xx/internal/bot"
+	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/models"
+	"github.com/user/tg-forward-to-xx/internal/notifier"
+	"github.com/user/tg-forward-to-xx/internal/sink"
+)
+
+// 测试消息的默认内容和来源
+const (
+	defaultTestText = "这是一条来自 tgforward send-test 的测试消息"
+	testSenderName  = "tgforward"
+	testChatTitle   = "tgforward 测试"
+)
+
+// runSendTest 构造一条测试消息发送到指定的投递目标或内置通知
+// 未启用的目标同样会发送，便于上线前验证配置
+func runSendTest(args []string) int {
+	fs, path := newCommandFlags("send-test")
+	name := fs.String("sink", "", "投递目标名称，或内置通知 dingtalk、feishu、bark、harmony")
+	text := fs.String("text", defaultTestText, "测试消息内容")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *name == "" {
+		fmt.Fprintln(os.Stderr, "必须通过 -sink 指定投递目标")
+		return exitUsage
+	}
+
+	if err := loadCommandConfig(*path); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	cfg := config.Current()
+
+	var chatID int64
+	if len(cfg.Telegram.ChatIDs) > 0 {
+		chatID = cfg.Telegram.ChatIDs[0]
+	}
+	msg := models.NewMessage(*text,
+		models.Sender{DisplayName: testSenderName},
+		models.Chat{ID: chatID, Title: testChatTitle, Type: "supergroup"},
+	)
+	msg.MessageType = models.MessageTypeText
+
+	if err := sendTestMessage(cfg, *name, msg); err != nil {
+		fmt.Fprintf(os.Stderr, "发送测试消息到 %s 失败: %s\n", *name, config.RedactSecrets(err.Error()))
+		return exitFailure
+	}
+
+	fmt.Printf("测试消息已发送到 %s\n", *name)
+	return exitOK
+}
+
+// sendTestMessage 按名称查找投递目标并发送消息，内置通知优先于同名的通用投递目标
+func sendTestMessage(cfg *config.Config, name string, msg *models.Message) error {
+	switch name {
+	case "dingtalk":
+		warnDisabled(name, cfg.DingTalk.Enabled)
+		return bot.NewDingTalkClient().SendMessage(msg)
+	case "feishu":
+		warnDisabled(name, cfg.Feishu.Enabled)
+		feishuCfg := *cfg.Feishu
+		feishuCfg.Enabled = true
+		return notifier.NewFeishuNotifier(&feishuCfg).Send(msg)
+	case "bark":
+		warnDisabled(name, cfg.Bark.Enabled)
+		cfg.Bark.Enabled = true
+		return bot.NewBarkClient().SendMessage(msg.Chat.Title, msg)
+	case "harmony":
+		warnDisabled(name, cfg.Harmony.Enabled)
+		cfg.Harmony.Enabled = true
+		return bot.NewHarmonyClient().SendMessage(msg.Chat.Title, msg)
+	}
+
+	for _, sinkCfg := range cfg.Sinks {
+		if sinkCfg.Name != name {
+			continue
+		}
+		warnDisabled(name, sinkCfg.Enabled)
+
+		s, err := sink.Create(sinkCfg)
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+		return s.Send(msg)
+	}
+
+	return fmt.Errorf("配置中没有名为 %s 的投递目标", name)
+}
+
+// warnDisabled 提示目标在配置中未启用
+func warnDisabled(name string, enabled bool) {
+	if !enabled {
+		fmt.Fprintf(os.Stderr, "提示: %s 在配置中未启用，仅本次测试发送\n", name)
+	}
+}
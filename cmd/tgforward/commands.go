@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/user/tg-forward-to-xx/internal/config"
+)
+
+// 子命令退出码
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// runCommand 执行子命令，返回进程退出码
+func runCommand(args []string) int {
+	sub := ""
+	if len(args) >= 2 {
+		sub = args[1]
+	}
+
+	switch {
+	case args[0] == "config" && sub == "validate":
+		return runConfigValidate(args[2:])
+	case args[0] == "doctor":
+		return runDoctor(args[1:])
+	case args[0] == "send-test":
+		return runSendTest(args[1:])
+	case args[0] == "queue" && sub != "":
+		return runQueue(sub, args[2:])
+	case args[0] == "history" && sub == "export":
+		return runHistoryExport(args[2:])
+	case args[0] == "history" && sub == "reindex":
+		return runHistoryReindex(args[2:])
+	case args[0] == "history" && sub == "import":
+		return runHistoryImport(args[2:])
+	case args[0] == "apikey" && sub == "generate":
+		return runAPIKeyGenerate(args[2:])
+	case args[0] == "backup" && sub == "create":
+		return runBackupCreate(args[2:])
+	case args[0] == "backup" && sub == "restore":
+		return runBackupRestore(args[2:])
+	case args[0] == "encryption" && sub == "keygen":
+		return runEncryptionKeygen(args[2:])
+	default:
+		fmt.Fprintf(os.Stderr, "未知的命令: %v\n", args)
+		printUsage()
+		return exitUsage
+	}
+}
+
+// printUsage 打印子命令用法
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "用法:")
+	fmt.Fprintln(os.Stderr, "  tgforward [-config 路径]                       启动转发服务")
+	fmt.Fprintln(os.Stderr, "  tgforward config validate [-config 路径]       校验配置文件")
+	fmt.Fprintln(os.Stderr, "  tgforward doctor [-config 路径]                检查配置、目录权限和各端点连通性")
+	fmt.Fprintln(os.Stderr, "  tgforward send-test -sink 名称 [-text 内容]    向投递目标发送一条测试消息")
+	fmt.Fprintln(os.Stderr, "  tgforward queue ls|peek|purge|replay [参数]    离线管理重试队列（需先停止服务）")
+	fmt.Fprintln(os.Stderr, "  tgforward history export -chat ID [参数]       离线导出聊天记录")
+	fmt.Fprintln(os.Stderr, "  tgforward history reindex [-config 路径]       重建聊天记录全文搜索索引和统计汇总（需先停止服务）")
+	fmt.Fprintln(os.Stderr, "  tgforward history import -file result.json     导入 Telegram Desktop 导出的聊天记录（需先停止服务）")
+	fmt.Fprintln(os.Stderr, "  tgforward apikey generate -name 名称 [参数]    生成 HTTP API 的 API Key")
+	fmt.Fprintln(os.Stderr, "  tgforward backup create [-server 地址]         备份聊天记录和重试队列，服务运行中通过 -server 在线备份")
+	fmt.Fprintln(os.Stderr, "  tgforward backup restore -file 备份 [-yes]     校验并恢复备份（需先停止服务）")
+	fmt.Fprintln(os.Stderr, "  tgforward encryption keygen                    生成静态加密的主密钥")
+	fmt.Fprintln(os.Stderr, "各子命令均支持 -config 指定配置文件，使用 -h 查看完整参数")
+}
+
+// newCommandFlags 创建子命令参数集，所有子命令都支持 -config
+func newCommandFlags(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	path := fs.String("config", configPath, "配置文件路径")
+	return fs, path
+}
+
+// loadCommandConfig 为离线子命令加载配置
+// 子命令的输出面向终端，日志只保留警告和错误并写到标准错误
+func loadCommandConfig(path string) error {
+	logrus.SetOutput(os.Stderr)
+	logrus.SetLevel(logrus.WarnLevel)
+
+	if err := config.LoadConfig(path); err != nil {
+		return fmt.Errorf("加载配置失败: %w", err)
+	}
+	return nil
+}
+
+// runConfigValidate 校验配置文件并打印全部问题
+func runConfigValidate(args []string) int {
+	fs, path := newCommandFlags("config validate")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if _, err := config.Load(*path); err != nil {
+		var verr *config.ValidationError
+		if errors.As(err, &verr) {
+			fmt.Fprintf(os.Stderr, "%s\n%v\n", *path, verr)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *path, err)
+		}
+		return exitFailure
+	}
+
+	fmt.Printf("%s: 配置有效\n", *path)
+	return exitOK
+}
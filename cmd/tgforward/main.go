@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -12,8 +13,12 @@ import (
 
 	"github.com/sirupsen/logrus"
 	"github.com/user/tg-forward-to-xx/internal/api"
+	"github.com/user/tg-forward-to-xx/internal/auth"
+	"github.com/user/tg-forward-to-xx/internal/backup"
 	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/export"
 	"github.com/user/tg-forward-to-xx/internal/handlers"
+	"github.com/user/tg-forward-to-xx/internal/migration"
 	"github.com/user/tg-forward-to-xx/internal/queue"
 	"github.com/user/tg-forward-to-xx/internal/storage"
 	"golang.org/x/sys/unix"
@@ -76,10 +81,16 @@ func (h *fileHook) Levels() []logrus.Level {
 func main() {
 	flag.Parse()
 
+	// 执行子命令，例如 config validate
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(flag.Args()))
+	}
+
 	// 加载配置
 	if err := config.LoadConfig(configPath); err != nil {
 		logrus.Fatalf("加载配置失败: %v", err)
 	}
+	cfg := config.Current()
 
 	// 设置日志格式
 	formatter := &logrus.TextFormatter{
@@ -99,9 +110,9 @@ func main() {
 	// 优先使用命令行参数的日志级别
 	if logLevel != "" {
 		level, err = logrus.ParseLevel(logLevel)
-	} else if config.AppConfig.Log.Level != "" {
+	} else if cfg.Log.Level != "" {
 		// 如果命令行参数未指定，使用配置文件中的日志级别
-		level, err = logrus.ParseLevel(config.AppConfig.Log.Level)
+		level, err = logrus.ParseLevel(cfg.Log.Level)
 	} else {
 		// 默认使用 info 级别
 		level = logrus.InfoLevel
@@ -113,15 +124,15 @@ func main() {
 	logrus.SetLevel(level)
 
 	// 配置日志输出
-	if config.AppConfig.Log.FilePath != "" {
+	if cfg.Log.FilePath != "" {
 		// 确保日志目录存在
-		logDir := filepath.Dir(config.AppConfig.Log.FilePath)
+		logDir := filepath.Dir(cfg.Log.FilePath)
 		if err := os.MkdirAll(logDir, 0755); err != nil {
 			logrus.Fatalf("创建日志目录失败: %v", err)
 		}
 
 		// 打开日志文件
-		logFile, err := os.OpenFile(config.AppConfig.Log.FilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		logFile, err := os.OpenFile(cfg.Log.FilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 		if err != nil {
 			logrus.Fatalf("打开日志文件失败: %v", err)
 		}
@@ -159,17 +170,17 @@ func main() {
 		"version":     Version,
 		"config_path": configPath,
 		"log_level":   level.String(),
-		"log_file":    config.AppConfig.Log.FilePath,
+		"log_file":    cfg.Log.FilePath,
 		"pid":        os.Getpid(),
 	}).Info("🚀 启动 Telegram 转发服务")
 
 	// 打印关键配置信息
 	logrus.WithFields(logrus.Fields{
-		"telegram_chat_ids": config.AppConfig.Telegram.ChatIDs,
-		"queue_type":       config.AppConfig.Queue.Type,
-		"queue_path":       config.AppConfig.Queue.Path,
-		"retry_attempts":   config.AppConfig.Retry.MaxAttempts,
-		"retry_interval":   config.AppConfig.Retry.Interval,
+		"telegram_chat_ids": cfg.Telegram.ChatIDs,
+		"queue_type":       cfg.Queue.Type,
+		"queue_path":       cfg.Queue.Path,
+		"retry_attempts":   cfg.Retry.MaxAttempts,
+		"retry_interval":   cfg.Retry.Interval,
 	}).Debug("已加载配置")
 
 	// 初始化聊天记录存储
@@ -179,6 +190,11 @@ func main() {
 	}
 	defer chatHistoryStorage.Close()
 
+	// 按保留策略定期清理过期的聊天记录
+	retentionSweeper := storage.NewRetentionSweeper(chatHistoryStorage)
+	retentionSweeper.Start()
+	defer retentionSweeper.Stop()
+
 	// 创建消息队列
 	messageQueue, err := createQueue()
 	if err != nil {
@@ -196,13 +212,60 @@ func main() {
 		logrus.Fatalf("启动消息处理失败: %v", err)
 	}
 
+	// 异步导出任务，结束后可以通知指定的投递目标
+	exportJobs, err := export.NewJobManager(chatHistoryStorage, messageHandler)
+	if err != nil {
+		logrus.Fatalf("初始化导出任务失败: %v", err)
+	}
+	exportJobs.Start()
+	defer exportJobs.Stop()
+
+	// 在线备份聊天记录和 LevelDB 重试队列，使用内存队列时只备份聊天记录
+	backupSources := []backup.Source{backup.ChatHistorySource(chatHistoryStorage)}
+	if q, ok := messageQueue.(*queue.LevelDBQueue); ok {
+		backupSources = append(backupSources, backup.QueueSource(q))
+	}
+	backupManager := backup.NewManager(backupSources)
+	backupManager.Start()
+
 	// 创建 API 处理器
 	chatHistoryHandler := api.NewChatHistoryHandler(chatHistoryStorage)
+	exportJobHandler := api.NewExportJobHandler(exportJobs)
 
-	// 设置 HTTP 路由
-	http.HandleFunc("/api/chat/history", chatHistoryHandler.QueryHandler)
-	http.HandleFunc("/api/chat/history/user", chatHistoryHandler.QueryByUserHandler)
-	http.HandleFunc("/api/chat/history/export", chatHistoryHandler.ExportHandler)
+	queueHandler := api.NewQueueHandler(messageQueue)
+	sendHandler := api.NewSendHandler(messageHandler)
+
+	// 初始化 API 认证和审计日志
+	if err := auth.Init(); err != nil {
+		logrus.Fatalf("初始化 API 认证失败: %v", err)
+	}
+	if authCfg := cfg.API.Auth; !authCfg.Enabled {
+		if authCfg.Insecure {
+			logrus.Warn("HTTP API 未启用认证且设置了 api.auth.insecure，任何能访问端口的人都可以读取聊天记录")
+		} else {
+			logrus.Warn("HTTP API 未启用认证，只接受来自本机的请求；请配置 api.auth，或设置 api.auth.insecure: true 允许匿名远程访问")
+		}
+	}
+
+	// 设置 HTTP 路由，每个接口要求对应的权限范围
+	http.HandleFunc("/api/chat/history", auth.Default.Require(auth.ScopeHistoryRead, chatHistoryHandler.QueryHandler))
+	http.HandleFunc("/api/chat/history/user", auth.Default.Require(auth.ScopeHistoryRead, chatHistoryHandler.QueryByUserHandler))
+	http.HandleFunc("/api/chat/search", auth.Default.Require(auth.ScopeHistoryRead, chatHistoryHandler.SearchHandler))
+	http.HandleFunc("/api/chat/stats/activity", auth.Default.Require(auth.ScopeHistoryRead, chatHistoryHandler.StatsActivityHandler))
+	http.HandleFunc("/api/chat/stats/senders", auth.Default.Require(auth.ScopeHistoryRead, chatHistoryHandler.StatsSendersHandler))
+	http.HandleFunc("/api/chat/stats/types", auth.Default.Require(auth.ScopeHistoryRead, chatHistoryHandler.StatsTypesHandler))
+	http.HandleFunc("/api/chat/stats/response-time", auth.Default.Require(auth.ScopeHistoryRead, chatHistoryHandler.StatsResponseTimeHandler))
+	http.HandleFunc("/api/chat/stats/threads", auth.Default.Require(auth.ScopeHistoryRead, chatHistoryHandler.StatsThreadsHandler))
+	http.HandleFunc("/api/chat/history/export", auth.Default.Require(auth.ScopeHistoryExport, chatHistoryHandler.ExportHandler))
+	http.HandleFunc("/api/chat/export/jobs", auth.Default.Require(auth.ScopeHistoryExport, exportJobHandler.ServeHTTP))
+	// 下载链接自带令牌和有效期，不要求 API Key
+	http.HandleFunc("/api/chat/export/download", exportJobHandler.DownloadHandler)
+	http.HandleFunc("/api/chat/retention", auth.Default.Require(auth.ScopeHistoryAdmin, api.NewRetentionHandler(retentionSweeper).ServeHTTP))
+	http.HandleFunc("/api/backup", auth.Default.Require(auth.ScopeBackupAdmin, api.NewBackupHandler(backupManager).ServeHTTP))
+	http.HandleFunc("/api/queue", auth.Default.Require(auth.ScopeQueueAdmin, queueHandler.StatusHandler))
+	http.HandleFunc("/api/send", auth.Default.Require(auth.ScopeSend, sendHandler.ServeHTTP))
+	http.HandleFunc("/api/message", auth.Default.Require(auth.ScopeSend, api.NewMessageHandler(messageHandler).ServeHTTP))
+	http.HandleFunc("/api/config/version", auth.Default.Require("", api.ConfigVersionHandler))
 
 	// 启动 HTTP 服务
 	go func() {
@@ -214,26 +277,39 @@ func main() {
 	}()
 
 	// 打印指标收集状态
-	if config.AppConfig.Metrics.Enabled {
+	if cfg.Metrics.Enabled {
 		logrus.WithFields(logrus.Fields{
-			"interval":     config.AppConfig.Metrics.Interval,
-			"output_file": config.AppConfig.Metrics.OutputFile,
-			"http_enabled": config.AppConfig.Metrics.HTTP.Enabled,
-			"http_port":    config.AppConfig.Metrics.HTTP.Port,
-			"http_path":    config.AppConfig.Metrics.HTTP.Path,
+			"interval":     cfg.Metrics.Interval,
+			"output_file": cfg.Metrics.OutputFile,
+			"http_enabled": cfg.Metrics.HTTP.Enabled,
+			"http_port":    cfg.Metrics.HTTP.Port,
+			"http_path":    cfg.Metrics.HTTP.Path,
 		}).Info("指标收集已启用")
 	} else {
 		logrus.Info("指标收集已禁用")
 	}
 
-	logrus.Info("服务已启动，按 Ctrl+C 停止")
+	// 监听配置文件变化，保存后自动重新加载
+	config.Watch()
+
+	logrus.Info("服务已启动，按 Ctrl+C 停止，发送 SIGHUP 重新加载配置")
 
-	// 等待中断信号
+	// 等待信号，SIGHUP 重新加载配置，SIGINT/SIGTERM 退出
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+	signal.Notify(sigChan, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		logrus.Info("收到 SIGHUP，重新加载配置")
+		if err := config.Reload(); err != nil {
+			logrus.Errorf("重新加载配置失败: %v", err)
+		}
+	}
 
 	logrus.Info("正在关闭服务...")
+	// 先等待正在执行的备份完成，消息处理器停止时会关闭队列
+	backupManager.Stop()
 	messageHandler.Stop()
 	logrus.Info("服务已关闭")
 }
@@ -246,12 +322,13 @@ func setLogLevel(level string) {
 
 // 创建队列
 func createQueue() (queue.Queue, error) {
-	queueType := config.AppConfig.Queue.Type
+	queueCfg := config.Current().Queue
+	queueType := queueCfg.Type
 	logrus.Infof("配置的队列类型: %s", queueType)
 	
 	// 检查队列路径
 	if queueType == "leveldb" {
-		queuePath := config.AppConfig.Queue.Path
+		queuePath := queueCfg.Path
 		logrus.Infof("LevelDB 队列路径: %s", queuePath)
 		
 		// 检查并创建完整的队列目录
@@ -284,6 +361,10 @@ func createQueue() (queue.Queue, error) {
 	// 尝试创建 LevelDB 队列
 	logrus.Debug("开始创建队列")
 	leveldbQueue, err := queue.Create(queueType)
+	if errors.Is(err, migration.ErrNotMigrated) || errors.Is(err, migration.ErrTooNew) {
+		// 版本不匹配时切换到内存队列会让积压的消息被忽略，直接拒绝启动
+		return nil, err
+	}
 	if err != nil {
 		logrus.Errorf("创建 LevelDB 队列失败: %v", err)
 		
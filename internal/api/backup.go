@@ -0,0 +1,57 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/user/tg-forward-to-xx/internal/backup"
+	"github.com/user/tg-forward-to-xx/internal/config"
+)
+
+// BackupHandler 在线备份 API 处理器
+type BackupHandler struct {
+	manager *backup.Manager
+}
+
+// BackupStatus 在线备份状态
+type BackupStatus struct {
+	Enabled  bool           `json:"enabled"`            // 是否启用定时备份
+	Interval int            `json:"interval"`           // 定时备份间隔（秒）
+	Dir      string         `json:"dir"`                // 备份文件目录
+	Upload   bool           `json:"upload"`             // 是否上传到 S3
+	LastRun  *backup.Report `json:"last_run,omitempty"` // 最近一次备份的结果
+}
+
+// NewBackupHandler 创建新的在线备份 API 处理器
+func NewBackupHandler(manager *backup.Manager) *BackupHandler {
+	return &BackupHandler{manager: manager}
+}
+
+// ServeHTTP GET 返回最近一次备份的结果，POST 立即按当前配置执行一次备份并返回结果
+func (h *BackupHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		cfg := config.Current()
+		status := BackupStatus{
+			Enabled:  cfg.Backup.Enabled,
+			Interval: cfg.Backup.Interval,
+			Dir:      backup.Dir(cfg),
+			Upload:   cfg.Backup.Upload,
+			LastRun:  h.manager.LastReport(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+
+	case http.MethodPost:
+		report, err := h.manager.Run()
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			// 报告中带有失败原因
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		json.NewEncoder(w).Encode(report)
+
+	default:
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+	}
+}
@@ -2,14 +2,18 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/user/tg-forward-to-xx/internal/auth"
+	"github.com/user/tg-forward-to-xx/internal/export"
+	"github.com/user/tg-forward-to-xx/internal/models"
 	"github.com/user/tg-forward-to-xx/internal/storage"
 )
 
@@ -23,7 +27,13 @@ func NewChatHistoryHandler(storage *storage.ChatHistoryStorage) *ChatHistoryHand
 	return &ChatHistoryHandler{storage: storage}
 }
 
-// QueryHandler 处理聊天记录查询请求
+// 分页参数
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// QueryHandler 处理聊天记录查询请求，支持游标分页和过滤
 func (h *ChatHistoryHandler) QueryHandler(w http.ResponseWriter, r *http.Request) {
 	// 只允许 GET 请求
 	if r.Method != http.MethodGet {
@@ -31,93 +41,152 @@ func (h *ChatHistoryHandler) QueryHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// 获取查询参数
-	chatID, err := strconv.ParseInt(r.URL.Query().Get("chat_id"), 10, 64)
-	if err != nil {
-		http.Error(w, "无效的群组ID", http.StatusBadRequest)
-		return
-	}
-
-	startTime, err := time.Parse(time.RFC3339, r.URL.Query().Get("start_time"))
-	if err != nil {
-		http.Error(w, "无效的开始时间", http.StatusBadRequest)
+	query, ok := parseHistoryQuery(w, r)
+	if !ok {
 		return
 	}
+	h.writePage(w, query)
+}
 
-	endTime, err := time.Parse(time.RFC3339, r.URL.Query().Get("end_time"))
-	if err != nil {
-		http.Error(w, "无效的结束时间", http.StatusBadRequest)
+// QueryByUserHandler 处理按用户查询聊天记录请求，等同于带 user 参数的 QueryHandler
+func (h *ChatHistoryHandler) QueryByUserHandler(w http.ResponseWriter, r *http.Request) {
+	// 只允许 GET 请求
+	if r.Method != http.MethodGet {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// 查询消息
-	messages, err := h.storage.QueryMessages(chatID, startTime, endTime)
-	if err != nil {
-		http.Error(w, "查询失败: "+err.Error(), http.StatusInternalServerError)
+	query, ok := parseHistoryQuery(w, r)
+	if !ok {
 		return
 	}
-
-	// 设置响应头
-	w.Header().Set("Content-Type", "application/json")
-
-	// 返回结果
-	if err := json.NewEncoder(w).Encode(messages); err != nil {
-		http.Error(w, "编码响应失败: "+err.Error(), http.StatusInternalServerError)
+	if query.FromUser == "" {
+		http.Error(w, "用户名不能为空", http.StatusBadRequest)
 		return
 	}
+	h.writePage(w, query)
 }
 
-// QueryByUserHandler 处理按用户查询聊天记录请求
-func (h *ChatHistoryHandler) QueryByUserHandler(w http.ResponseWriter, r *http.Request) {
-	// 只允许 GET 请求
-	if r.Method != http.MethodGet {
-		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
-		return
-	}
+// parseHistoryQuery 解析查询参数，参数无效时写入 400 响应并返回 false
+func parseHistoryQuery(w http.ResponseWriter, r *http.Request) (*storage.HistoryQuery, bool) {
+	params := r.URL.Query()
 
 	// 获取查询参数
-	chatID, err := strconv.ParseInt(r.URL.Query().Get("chat_id"), 10, 64)
+	chatID, err := strconv.ParseInt(params.Get("chat_id"), 10, 64)
 	if err != nil {
 		http.Error(w, "无效的群组ID", http.StatusBadRequest)
-		return
+		return nil, false
+	}
+	if !auth.AuthorizeChat(w, r, chatID) {
+		return nil, false
 	}
 
-	username := r.URL.Query().Get("username")
-	if username == "" {
-		http.Error(w, "用户名不能为空", http.StatusBadRequest)
-		return
+	query := &storage.HistoryQuery{
+		ChatID:      chatID,
+		Cursor:      params.Get("cursor"),
+		Limit:       defaultPageSize,
+		FromUser:    params.Get("user"),
+		MessageType: params.Get("type"),
+		Text:        params.Get("q"),
+	}
+	if query.FromUser == "" {
+		query.FromUser = params.Get("username")
 	}
 
-	startTime, err := time.Parse(time.RFC3339, r.URL.Query().Get("start_time"))
-	if err != nil {
-		http.Error(w, "无效的开始时间", http.StatusBadRequest)
-		return
+	if v := params.Get("start_time"); v != "" {
+		if query.Start, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "无效的开始时间", http.StatusBadRequest)
+			return nil, false
+		}
+	}
+	if v := params.Get("end_time"); v != "" {
+		if query.End, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "无效的结束时间", http.StatusBadRequest)
+			return nil, false
+		}
 	}
 
-	endTime, err := time.Parse(time.RFC3339, r.URL.Query().Get("end_time"))
-	if err != nil {
-		http.Error(w, "无效的结束时间", http.StatusBadRequest)
-		return
+	if v := params.Get("limit"); v != "" {
+		query.Limit, err = strconv.Atoi(v)
+		if err != nil || query.Limit < 1 || query.Limit > maxPageSize {
+			http.Error(w, fmt.Sprintf("无效的分页大小，范围为 1-%d", maxPageSize), http.StatusBadRequest)
+			return nil, false
+		}
 	}
 
-	// 查询消息
-	messages, err := h.storage.QueryMessagesByUser(chatID, username, startTime, endTime)
-	if err != nil {
-		http.Error(w, "查询失败: "+err.Error(), http.StatusInternalServerError)
-		return
+	switch params.Get("order") {
+	case "", "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		http.Error(w, "无效的排序方向，支持 asc、desc", http.StatusBadRequest)
+		return nil, false
 	}
 
-	// 设置响应头
-	w.Header().Set("Content-Type", "application/json")
+	if v := params.Get("has_media"); v != "" {
+		hasMedia, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "无效的 has_media 参数，支持 true、false", http.StatusBadRequest)
+			return nil, false
+		}
+		query.HasMedia = &hasMedia
+	}
 
-	// 返回结果
-	if err := json.NewEncoder(w).Encode(messages); err != nil {
-		http.Error(w, "编码响应失败: "+err.Error(), http.StatusInternalServerError)
-		return
+	return query, true
+}
+
+// writePage 边遍历边输出一页查询结果，格式为 {"messages": [...], "next_cursor": "..."}
+func (h *ChatHistoryHandler) writePage(w http.ResponseWriter, query *storage.HistoryQuery) {
+	started := false
+	start := func() {
+		if !started {
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"messages":[`)
+			started = true
+		}
+	}
+
+	count := 0
+	nextCursor, err := h.storage.Iterate(query, func(message *models.ChatHistory) error {
+		start()
+		if count > 0 {
+			io.WriteString(w, ",")
+		}
+		count++
+		data, err := json.Marshal(message)
+		if err != nil {
+			return fmt.Errorf("编码响应失败: %w", err)
+		}
+		_, err = w.Write(data)
+		return err
+	})
+
+	if err != nil && !started {
+		status := http.StatusInternalServerError
+		if errors.Is(err, storage.ErrInvalidCursor) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, "查询失败: "+err.Error(), status)
+		return
+	}
+
+	start()
+	tail := struct {
+		NextCursor string `json:"next_cursor"`
+		Error      string `json:"error,omitempty"` // 输出过程中出错时结果不完整
+	}{NextCursor: nextCursor}
+	if err != nil {
+		logrus.Errorf("查询聊天记录失败: %v", err)
+		tail.Error = err.Error()
 	}
+	data, _ := json.Marshal(tail)
+	// 将 {"next_cursor":...} 拼接到 messages 数组之后
+	io.WriteString(w, "],")
+	w.Write(data[1:])
 }
 
-// ExportHandler 处理导出聊天记录请求
+// ExportHandler 处理导出聊天记录请求，支持与查询接口相同的过滤参数
+// 记录边遍历边写入响应，导出大范围的记录不会占用大量内存
 func (h *ChatHistoryHandler) ExportHandler(w http.ResponseWriter, r *http.Request) {
 	// 只允许 GET 请求
 	if r.Method != http.MethodGet {
@@ -125,61 +194,48 @@ func (h *ChatHistoryHandler) ExportHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// 获取查询参数
-	chatID, err := strconv.ParseInt(r.URL.Query().Get("chat_id"), 10, 64)
-	if err != nil {
-		http.Error(w, "无效的群组ID", http.StatusBadRequest)
-		return
-	}
-
-	startTime, err := time.Parse(time.RFC3339, r.URL.Query().Get("start_time"))
-	if err != nil {
-		http.Error(w, "无效的开始时间", http.StatusBadRequest)
+	format, ok := export.Normalize(r.URL.Query().Get("format"))
+	if !ok {
+		http.Error(w, "不支持的导出格式，支持 "+strings.Join(export.Formats(), "、"), http.StatusBadRequest)
 		return
 	}
 
-	endTime, err := time.Parse(time.RFC3339, r.URL.Query().Get("end_time"))
-	if err != nil {
-		http.Error(w, "无效的结束时间", http.StatusBadRequest)
+	query, ok := parseHistoryQuery(w, r)
+	if !ok {
 		return
 	}
 
-	username := r.URL.Query().Get("username")
-
-	// 创建临时文件
-	tmpDir := os.TempDir()
-	fileName := fmt.Sprintf("chat_history_%d_%s.csv", chatID, time.Now().Format("20060102_150405"))
-	filePath := filepath.Join(tmpDir, fileName)
+	now := time.Now()
+	info := &export.Info{ChatID: query.ChatID, Start: query.Start, End: query.End, ExportedAt: now}
 
-	// 导出数据
-	var exportErr error
-	if username != "" {
-		exportErr = h.storage.ExportUserToCSV(chatID, username, startTime, endTime, filePath)
-	} else {
-		exportErr = h.storage.ExportToCSV(chatID, startTime, endTime, filePath)
-	}
+	// 响应头已经发出后无法再修改状态码，输出过程中的错误通过 trailer 告知客户端
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", export.FileName(query.ChatID, format, now)))
+	w.Header().Set("Trailer", "X-Export-Error")
 
-	if exportErr != nil {
-		http.Error(w, "导出失败: "+exportErr.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// 读取文件内容
-	fileContent, err := os.ReadFile(filePath)
+	out := &responseWriter{w: w}
+	count, err := export.Export(out, format, h.storage, query, info)
 	if err != nil {
-		http.Error(w, "读取导出文件失败: "+err.Error(), http.StatusInternalServerError)
+		if !out.written {
+			w.Header().Del("Content-Disposition")
+			w.Header().Del("Trailer")
+			http.Error(w, "导出失败: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logrus.Errorf("导出聊天记录中断: 群组=%d, 已导出=%d, 错误=%v", query.ChatID, count, err)
+		w.Header().Set("X-Export-Error", err.Error())
 		return
 	}
+	logrus.Infof("导出聊天记录: 群组=%d, 格式=%s, 数量=%d", query.ChatID, format, count)
+}
 
-	// 删除临时文件
-	defer os.Remove(filePath)
-
-	// 设置响应头
-	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileName))
+// responseWriter 记录是否已经向客户端输出内容
+type responseWriter struct {
+	w       io.Writer
+	written bool
+}
 
-	// 发送文件内容
-	if _, err := w.Write(fileContent); err != nil {
-		logrus.Errorf("发送文件内容失败: %v", err)
-	}
-} 
\ No newline at end of file
+func (r *responseWriter) Write(p []byte) (int, error) {
+	r.written = true
+	return r.w.Write(p)
+}
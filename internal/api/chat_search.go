@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/user/tg-forward-to-xx/internal/auth"
+	"github.com/user/tg-forward-to-xx/internal/storage"
+)
+
+// 搜索分页参数
+const (
+	defaultSearchSize = 20
+	maxSearchSize     = 100
+)
+
+// SearchHandler 处理全文搜索请求，结果按相关度排序并带有高亮摘要
+// 不指定 chat_id 时搜索调用方有权访问的全部群组
+func (h *ChatHistoryHandler) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	// 只允许 GET 请求
+	if r.Method != http.MethodGet {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	query := &storage.SearchQuery{
+		Query:    params.Get("q"),
+		FromUser: params.Get("user"),
+		Limit:    defaultSearchSize,
+	}
+	if strings.TrimSpace(query.Query) == "" {
+		http.Error(w, "搜索词不能为空", http.StatusBadRequest)
+		return
+	}
+
+	// chat_id 支持逗号分隔的多个群组
+	if v := params.Get("chat_id"); v != "" {
+		for _, s := range strings.Split(v, ",") {
+			chatID, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+			if err != nil {
+				http.Error(w, "无效的群组ID", http.StatusBadRequest)
+				return
+			}
+			if !auth.AuthorizeChat(w, r, chatID) {
+				return
+			}
+			query.ChatIDs = append(query.ChatIDs, chatID)
+		}
+	} else if principal := auth.FromContext(r.Context()); principal != nil {
+		// 限制了群组的 API Key 只搜索允许的群组
+		query.ChatIDs = principal.ChatIDs
+	}
+
+	var err error
+	if v := params.Get("start_time"); v != "" {
+		if query.Start, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "无效的开始时间", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := params.Get("end_time"); v != "" {
+		if query.End, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "无效的结束时间", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if v := params.Get("limit"); v != "" {
+		query.Limit, err = strconv.Atoi(v)
+		if err != nil || query.Limit < 1 || query.Limit > maxSearchSize {
+			http.Error(w, fmt.Sprintf("无效的分页大小，范围为 1-%d", maxSearchSize), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := params.Get("offset"); v != "" {
+		query.Offset, err = strconv.Atoi(v)
+		if err != nil || query.Offset < 0 {
+			http.Error(w, "无效的 offset 参数", http.StatusBadRequest)
+			return
+		}
+	}
+
+	result, err := h.storage.Search(query)
+	if err != nil {
+		if errors.Is(err, storage.ErrEmptySearch) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logrus.Errorf("搜索聊天记录失败: %v", err)
+		http.Error(w, "搜索失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		logrus.Errorf("编码响应失败: %v", err)
+	}
+}
@@ -0,0 +1,301 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/user/tg-forward-to-xx/internal/auth"
+	"github.com/user/tg-forward-to-xx/internal/storage"
+)
+
+// 排行榜数量参数
+const (
+	defaultStatsLimit = 10
+	maxStatsLimit     = 100
+)
+
+// StatsActivityHandler 按小时、天或一天中的时段统计消息数量
+func (h *ChatHistoryHandler) StatsActivityHandler(w http.ResponseWriter, r *http.Request) {
+	query, ok := parseStatsQuery(w, r)
+	if !ok {
+		return
+	}
+
+	interval := r.URL.Query().Get("interval")
+	if interval == "" {
+		interval = storage.IntervalDay
+	}
+	buckets, err := h.storage.Activity(query, interval)
+	if errors.Is(err, storage.ErrInvalidInterval) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeStatsError(w, err)
+		return
+	}
+
+	if wantCSV(r) {
+		rows := make([][]string, 0, len(buckets))
+		for _, b := range buckets {
+			rows = append(rows, []string{b.Bucket, strconv.Itoa(b.Messages), strconv.Itoa(b.Media), strconv.Itoa(b.Replies)})
+		}
+		writeStatsCSV(w, "activity", query.ChatID, []string{"时间段", "消息数", "媒体数", "回复数"}, rows)
+		return
+	}
+	writeStatsJSON(w, map[string]any{
+		"chat_id":    query.ChatID,
+		"interval":   interval,
+		"buckets":    buckets,
+		"incomplete": !h.storage.StatsReady(),
+	})
+}
+
+// StatsSendersHandler 统计发言最多的用户
+func (h *ChatHistoryHandler) StatsSendersHandler(w http.ResponseWriter, r *http.Request) {
+	query, ok := parseStatsQuery(w, r)
+	if !ok {
+		return
+	}
+	limit, ok := parseStatsLimit(w, r)
+	if !ok {
+		return
+	}
+
+	senders, total, err := h.storage.TopSenders(query, limit)
+	if err != nil {
+		writeStatsError(w, err)
+		return
+	}
+
+	if wantCSV(r) {
+		rows := make([][]string, 0, len(senders))
+		for _, s := range senders {
+			rows = append(rows, []string{s.User, strconv.Itoa(s.Messages), formatShare(s.Share)})
+		}
+		writeStatsCSV(w, "senders", query.ChatID, []string{"用户名", "消息数", "占比"}, rows)
+		return
+	}
+	writeStatsJSON(w, map[string]any{
+		"chat_id":    query.ChatID,
+		"total":      total,
+		"senders":    senders,
+		"incomplete": !h.storage.StatsReady(),
+	})
+}
+
+// StatsTypesHandler 按消息类型统计数量
+func (h *ChatHistoryHandler) StatsTypesHandler(w http.ResponseWriter, r *http.Request) {
+	query, ok := parseStatsQuery(w, r)
+	if !ok {
+		return
+	}
+
+	types, err := h.storage.MessageTypes(query)
+	if err != nil {
+		writeStatsError(w, err)
+		return
+	}
+
+	if wantCSV(r) {
+		rows := make([][]string, 0, len(types))
+		for _, t := range types {
+			rows = append(rows, []string{t.Type, strconv.Itoa(t.Messages), formatShare(t.Share)})
+		}
+		writeStatsCSV(w, "types", query.ChatID, []string{"消息类型", "消息数", "占比"}, rows)
+		return
+	}
+	writeStatsJSON(w, map[string]any{
+		"chat_id":    query.ChatID,
+		"types":      types,
+		"incomplete": !h.storage.StatsReady(),
+	})
+}
+
+// StatsResponseTimeHandler 统计消息到第一条其他人回复之间的时间
+func (h *ChatHistoryHandler) StatsResponseTimeHandler(w http.ResponseWriter, r *http.Request) {
+	query, ok := parseStatsQuery(w, r)
+	if !ok {
+		return
+	}
+
+	stats, err := h.storage.ResponseTimes(query)
+	if err != nil {
+		writeStatsError(w, err)
+		return
+	}
+
+	if wantCSV(r) {
+		// 每行为一个分布区间，汇总值放在前几行
+		rows := [][]string{
+			{"回复数", strconv.Itoa(stats.Replies)},
+			{"平均值", strconv.FormatFloat(stats.Average, 'f', -1, 64)},
+			{"中位数", strconv.FormatFloat(stats.Median, 'f', -1, 64)},
+			{"P90", strconv.FormatFloat(stats.P90, 'f', -1, 64)},
+			{"最小值", strconv.FormatInt(stats.Min, 10)},
+			{"最大值", strconv.FormatInt(stats.Max, 10)},
+		}
+		for _, b := range stats.Buckets {
+			label := "更长"
+			if b.UpTo > 0 {
+				label = fmt.Sprintf("<= %d", b.UpTo)
+			}
+			rows = append(rows, []string{label, strconv.FormatInt(b.Count, 10)})
+		}
+		writeStatsCSV(w, "response_time", query.ChatID, []string{"项目", "值（秒）/ 回复数"}, rows)
+		return
+	}
+	writeStatsJSON(w, struct {
+		ChatID int64 `json:"chat_id"`
+		*storage.ResponseTimeStats
+		Incomplete bool `json:"incomplete"`
+	}{query.ChatID, stats, !h.storage.StatsReady()})
+}
+
+// StatsThreadsHandler 统计回复最多的讨论串
+func (h *ChatHistoryHandler) StatsThreadsHandler(w http.ResponseWriter, r *http.Request) {
+	query, ok := parseStatsQuery(w, r)
+	if !ok {
+		return
+	}
+	limit, ok := parseStatsLimit(w, r)
+	if !ok {
+		return
+	}
+
+	threads, err := h.storage.BusiestThreads(query, limit)
+	if err != nil {
+		writeStatsError(w, err)
+		return
+	}
+
+	if wantCSV(r) {
+		rows := make([][]string, 0, len(threads))
+		for _, t := range threads {
+			var fromUser, text string
+			if t.Root != nil {
+				fromUser, text = t.Root.FromUser, t.Root.Text
+			}
+			rows = append(rows, []string{
+				strconv.FormatInt(t.RootID, 10),
+				strconv.Itoa(t.Replies),
+				t.LastReply.Format(time.RFC3339),
+				fromUser,
+				text,
+			})
+		}
+		writeStatsCSV(w, "threads", query.ChatID, []string{"根消息ID", "回复数", "最后回复", "发送者", "消息内容"}, rows)
+		return
+	}
+	writeStatsJSON(w, map[string]any{
+		"chat_id":    query.ChatID,
+		"threads":    threads,
+		"incomplete": !h.storage.StatsReady(),
+	})
+}
+
+// parseStatsQuery 解析统计接口的公共参数，参数无效时写入响应并返回 false
+func parseStatsQuery(w http.ResponseWriter, r *http.Request) (*storage.StatsQuery, bool) {
+	// 只允许 GET 请求
+	if r.Method != http.MethodGet {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	params := r.URL.Query()
+
+	chatID, err := strconv.ParseInt(params.Get("chat_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "无效的群组ID", http.StatusBadRequest)
+		return nil, false
+	}
+	if !auth.AuthorizeChat(w, r, chatID) {
+		return nil, false
+	}
+
+	query := &storage.StatsQuery{ChatID: chatID}
+	if v := params.Get("start_time"); v != "" {
+		if query.Start, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "无效的开始时间", http.StatusBadRequest)
+			return nil, false
+		}
+	}
+	if v := params.Get("end_time"); v != "" {
+		if query.End, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "无效的结束时间", http.StatusBadRequest)
+			return nil, false
+		}
+	}
+	if v := params.Get("tz"); v != "" {
+		if query.Location, err = time.LoadLocation(v); err != nil {
+			http.Error(w, "无效的时区，例如 Asia/Shanghai", http.StatusBadRequest)
+			return nil, false
+		}
+	}
+
+	switch params.Get("format") {
+	case "", "json", "csv":
+	default:
+		http.Error(w, "不支持的格式，支持 json、csv", http.StatusBadRequest)
+		return nil, false
+	}
+	return query, true
+}
+
+// parseStatsLimit 解析排行榜数量参数
+func parseStatsLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return defaultStatsLimit, true
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 || limit > maxStatsLimit {
+		http.Error(w, fmt.Sprintf("无效的数量，范围为 1-%d", maxStatsLimit), http.StatusBadRequest)
+		return 0, false
+	}
+	return limit, true
+}
+
+// wantCSV 判断是否以 CSV 返回结果
+func wantCSV(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "csv"
+}
+
+// writeStatsJSON 以 JSON 返回统计结果
+func writeStatsJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.Errorf("编码响应失败: %v", err)
+	}
+}
+
+// writeStatsCSV 以带 UTF-8 BOM 的 CSV 返回统计结果，与聊天记录 CSV 导出一致
+func writeStatsCSV(w http.ResponseWriter, name string, chatID int64, header []string, rows [][]string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=stats_%s_%d.csv", name, chatID))
+	io.WriteString(w, "\xEF\xBB\xBF")
+
+	cw := csv.NewWriter(w)
+	cw.Write(header)
+	cw.WriteAll(rows)
+	if err := cw.Error(); err != nil {
+		logrus.Errorf("写入CSV数据失败: %v", err)
+	}
+}
+
+// writeStatsError 统计查询失败时返回 500
+func writeStatsError(w http.ResponseWriter, err error) {
+	logrus.Errorf("统计聊天记录失败: %v", err)
+	http.Error(w, "统计失败: "+err.Error(), http.StatusInternalServerError)
+}
+
+// formatShare 将占比格式化为百分比
+func formatShare(share float64) string {
+	return strconv.FormatFloat(share*100, 'f', 2, 64) + "%"
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/user/tg-forward-to-xx/internal/auth"
+	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/export"
+)
+
+// ExportJobHandler 异步导出任务 API 处理器
+type ExportJobHandler struct {
+	jobs *export.JobManager
+}
+
+// NewExportJobHandler 创建新的异步导出任务 API 处理器
+func NewExportJobHandler(jobs *export.JobManager) *ExportJobHandler {
+	return &ExportJobHandler{jobs: jobs}
+}
+
+// ServeHTTP POST 创建任务，GET 查询任务状态（带 id 参数）或列出任务，DELETE 取消并删除任务
+func (h *ExportJobHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		h.create(w, r)
+	case http.MethodGet:
+		if r.URL.Query().Get("id") != "" {
+			h.get(w, r)
+		} else {
+			h.list(w, r)
+		}
+	case http.MethodDelete:
+		h.delete(w, r)
+	default:
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+	}
+}
+
+// create 创建导出任务，参数与 /api/chat/history/export 相同，另外支持 notify 指定完成后通知的投递目标
+func (h *ExportJobHandler) create(w http.ResponseWriter, r *http.Request) {
+	format, ok := export.Normalize(r.URL.Query().Get("format"))
+	if !ok {
+		http.Error(w, "不支持的导出格式，支持 "+strings.Join(export.Formats(), "、"), http.StatusBadRequest)
+		return
+	}
+
+	query, ok := parseHistoryQuery(w, r)
+	if !ok {
+		return
+	}
+
+	params := export.NewJobParams(query, format)
+	params.Notify = r.URL.Query().Get("notify")
+	if params.Notify == "" {
+		params.Notify = config.Current().ChatHistory.Export.NotifySink
+	} else if !sinkEnabled(params.Notify) {
+		http.Error(w, fmt.Sprintf("投递目标 %s 不存在或未启用", params.Notify), http.StatusBadRequest)
+		return
+	}
+
+	createdBy := ""
+	if principal := auth.FromContext(r.Context()); principal != nil {
+		createdBy = principal.Name
+	}
+
+	job, err := h.jobs.Create(params, createdBy)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, export.ErrTooManyJobs) {
+			status = http.StatusTooManyRequests
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/chat/export/jobs?id="+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// get 返回任务状态和进度，完成后包含下载链接
+func (h *ExportJobHandler) get(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.authorizedJob(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// list 列出调用方有权访问的任务，可以用 chat_id 参数只列出一个群组的任务
+func (h *ExportJobHandler) list(w http.ResponseWriter, r *http.Request) {
+	var chatID int64
+	if v := r.URL.Query().Get("chat_id"); v != "" {
+		var err error
+		if chatID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			http.Error(w, "无效的群组ID", http.StatusBadRequest)
+			return
+		}
+		if !auth.AuthorizeChat(w, r, chatID) {
+			return
+		}
+	}
+
+	principal := auth.FromContext(r.Context())
+	jobs := h.jobs.List(func(job *export.Job) bool {
+		if chatID != 0 && job.ChatID != chatID {
+			return false
+		}
+		return principal == nil || principal.AllowsChat(job.ChatID)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]export.Job{"jobs": jobs})
+}
+
+// delete 取消运行中的任务，或删除已完成的任务及其导出文件
+func (h *ExportJobHandler) delete(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.authorizedJob(w, r)
+	if !ok {
+		return
+	}
+	if err := h.jobs.Delete(job.ID); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, export.ErrJobNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizedJob 按 id 参数查找任务并校验群组权限，失败时写入响应并返回 false
+func (h *ExportJobHandler) authorizedJob(w http.ResponseWriter, r *http.Request) (export.Job, bool) {
+	job, err := h.jobs.Get(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return job, false
+	}
+	if !auth.AuthorizeChat(w, r, job.ChatID) {
+		return job, false
+	}
+	return job, true
+}
+
+// DownloadHandler 下载本地保存的导出文件，链接中的令牌即为凭证，不需要 API Key
+func (h *ExportJobHandler) DownloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	file, job, err := h.jobs.Open(r.URL.Query().Get("id"), r.URL.Query().Get("token"))
+	switch {
+	case errors.Is(err, export.ErrJobNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, export.ErrJobNotReady):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", export.ContentType(job.Format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", job.FileName))
+	// 支持断点续传
+	http.ServeContent(w, r, job.FileName, job.FinishedAt, file)
+}
+
+// sinkEnabled 判断当前配置中是否有启用的同名投递目标
+func sinkEnabled(name string) bool {
+	for _, s := range config.Current().Sinks {
+		if s != nil && s.Name == name && s.Enabled {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/user/tg-forward-to-xx/internal/auth"
+)
+
+// MessageRetractor 撤回已投递到各投递目标的消息
+type MessageRetractor interface {
+	Retract(chatID int64, messageID int) error
+}
+
+// MessageHandler 消息管理 API 处理器
+type MessageHandler struct {
+	retractor MessageRetractor
+}
+
+// NewMessageHandler 创建新的消息管理 API 处理器
+func NewMessageHandler(retractor MessageRetractor) *MessageHandler {
+	return &MessageHandler{retractor: retractor}
+}
+
+// ServeHTTP DELETE 将源消息的删除同步到支持删除的投递目标
+// Telegram 不会把群组中删除消息的事件推送给 Bot，需要由管理员或自动化脚本调用
+func (h *MessageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	chatID, err := strconv.ParseInt(params.Get("chat_id"), 10, 64)
+	if err != nil || chatID == 0 {
+		http.Error(w, "无效的群组ID", http.StatusBadRequest)
+		return
+	}
+	messageID, err := strconv.Atoi(params.Get("message_id"))
+	if err != nil || messageID <= 0 {
+		http.Error(w, "无效的消息ID", http.StatusBadRequest)
+		return
+	}
+	if !auth.AuthorizeChat(w, r, chatID) {
+		return
+	}
+
+	if err := h.retractor.Retract(chatID, messageID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/user/tg-forward-to-xx/internal/auth"
+	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/models"
+	"github.com/user/tg-forward-to-xx/internal/queue"
+)
+
+// QueueHandler 重试队列 API 处理器
+type QueueHandler struct {
+	queue queue.Queue
+}
+
+// QueueStatus 重试队列状态
+type QueueStatus struct {
+	Type string          `json:"type"`
+	Size int             `json:"size"`
+	Next *models.Message `json:"next,omitempty"` // 下一条待重试的消息，不属于 API Key 允许的群组时省略
+}
+
+// NewQueueHandler 创建新的重试队列 API 处理器
+func NewQueueHandler(q queue.Queue) *QueueHandler {
+	return &QueueHandler{queue: q}
+}
+
+// StatusHandler 返回重试队列的大小和下一条待重试的消息
+func (h *QueueHandler) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	size, err := h.queue.Size()
+	if err != nil {
+		http.Error(w, "获取队列大小失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	status := QueueStatus{Type: config.Current().Queue.Type, Size: size}
+	next, err := h.queue.Peek()
+	if err != nil && !errors.Is(err, queue.ErrQueueEmpty) {
+		http.Error(w, "读取队列失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// 限制了群组的 API Key 只能看到允许群组的消息
+	if next != nil {
+		if principal := auth.FromContext(r.Context()); principal == nil || principal.AllowsChat(next.Chat.ID) {
+			status.Next = next
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
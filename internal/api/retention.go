@@ -0,0 +1,49 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/storage"
+)
+
+// RetentionHandler 聊天记录保留策略 API 处理器
+type RetentionHandler struct {
+	sweeper *storage.RetentionSweeper
+}
+
+// RetentionStatus 保留策略状态
+type RetentionStatus struct {
+	Enabled  bool                     `json:"enabled"`            // 是否启用后台定期清理
+	Interval int                      `json:"interval"`           // 清理间隔（秒）
+	LastRun  *storage.RetentionReport `json:"last_run,omitempty"` // 最近一次清理的结果
+}
+
+// NewRetentionHandler 创建新的保留策略 API 处理器
+func NewRetentionHandler(sweeper *storage.RetentionSweeper) *RetentionHandler {
+	return &RetentionHandler{sweeper: sweeper}
+}
+
+// ServeHTTP GET 返回最近一次清理的结果，POST 立即按当前配置执行一次清理并返回结果
+func (h *RetentionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		cfg := config.Current().ChatHistory.Retention
+		status := RetentionStatus{Enabled: cfg.Enabled, Interval: cfg.Interval, LastRun: h.sweeper.LastReport()}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+
+	case http.MethodPost:
+		report, err := h.sweeper.Run()
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			// 报告中带有失败原因和失败前已删除的数量
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		json.NewEncoder(w).Encode(report)
+
+	default:
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/user/tg-forward-to-xx/internal/auth"
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// MessageSubmitter 接收 API 提交的消息
+type MessageSubmitter interface {
+	Submit(msg *models.Message) error
+}
+
+// SendHandler 发送消息 API 处理器
+type SendHandler struct {
+	submitter MessageSubmitter
+}
+
+// SendRequest 发送消息请求
+type SendRequest struct {
+	ChatID int64  `json:"chat_id"`
+	Text   string `json:"text"`
+}
+
+// NewSendHandler 创建新的发送消息 API 处理器
+func NewSendHandler(submitter MessageSubmitter) *SendHandler {
+	return &SendHandler{submitter: submitter}
+}
+
+// ServeHTTP 以指定群组的名义将消息投递到所有启用的通知渠道
+func (h *SendHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SendRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+		http.Error(w, "无效的请求内容", http.StatusBadRequest)
+		return
+	}
+	if req.ChatID == 0 {
+		http.Error(w, "无效的群组ID", http.StatusBadRequest)
+		return
+	}
+	if req.Text == "" {
+		http.Error(w, "消息内容不能为空", http.StatusBadRequest)
+		return
+	}
+	if !auth.AuthorizeChat(w, r, req.ChatID) {
+		return
+	}
+
+	// 发送者记为 API Key 名称，便于在通知中区分来源
+	sender := models.Sender{DisplayName: "API"}
+	if principal := auth.FromContext(r.Context()); principal != nil {
+		sender.DisplayName = principal.Name
+	}
+	msg := models.NewMessage(req.Text, sender, models.Chat{ID: req.ChatID})
+	msg.MessageType = models.MessageTypeText
+
+	if err := h.submitter.Submit(msg); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]int64{"id": msg.ID})
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AuditEntry 一次 API 访问的审计记录
+type AuditEntry struct {
+	Time       time.Time `json:"time"`
+	Key        string    `json:"key,omitempty"` // API Key 名称，认证失败时为空
+	RemoteAddr string    `json:"remote_addr"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Scope      string    `json:"scope,omitempty"`
+	ChatID     *int64    `json:"chat_id,omitempty"`
+	Status     int       `json:"status"`
+	DurationMs int64     `json:"duration_ms"`
+	Reason     string    `json:"reason,omitempty"` // 拒绝访问的原因
+}
+
+var (
+	auditMutex sync.Mutex
+	auditFile  *os.File
+)
+
+// SetAuditLog 设置审计日志文件，path 为空时审计记录写入服务日志
+func SetAuditLog(path string) error {
+	var file *os.File
+	if path != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("创建审计日志目录失败: %w", err)
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("打开审计日志失败: %w", err)
+		}
+		file = f
+	}
+
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	if auditFile != nil {
+		auditFile.Close()
+	}
+	auditFile = file
+	return nil
+}
+
+// newAuditEntry 根据请求创建审计记录
+func newAuditEntry(r *http.Request, scope string) *AuditEntry {
+	entry := &AuditEntry{
+		Time:       time.Now(),
+		RemoteAddr: r.RemoteAddr,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Scope:      scope,
+	}
+	if id, err := strconv.ParseInt(r.URL.Query().Get("chat_id"), 10, 64); err == nil {
+		entry.ChatID = &id
+	}
+	return entry
+}
+
+// finish 补充响应结果
+func (e *AuditEntry) finish(rec *statusRecorder) {
+	e.Status = rec.status
+	e.DurationMs = time.Since(e.Time).Milliseconds()
+	if rec.chatID != nil {
+		e.ChatID = rec.chatID
+	}
+	if e.Reason == "" {
+		e.Reason = rec.reason
+	}
+}
+
+// writeAudit 写入审计记录
+func writeAudit(entry *AuditEntry) {
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+
+	if auditFile == nil {
+		fields := logrus.Fields{
+			"key":    entry.Key,
+			"remote": entry.RemoteAddr,
+			"method": entry.Method,
+			"path":   entry.Path,
+			"status": entry.Status,
+		}
+		if entry.ChatID != nil {
+			fields["chat_id"] = *entry.ChatID
+		}
+		if entry.Reason != "" {
+			fields["reason"] = entry.Reason
+		}
+		logrus.WithFields(fields).Info("API 访问审计")
+		return
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		logrus.Errorf("序列化审计记录失败: %v", err)
+		return
+	}
+	if _, err := auditFile.Write(append(data, '\n')); err != nil {
+		logrus.Errorf("写入审计日志失败: %v", err)
+	}
+}
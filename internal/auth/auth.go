@@ -0,0 +1,297 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/user/tg-forward-to-xx/internal/config"
+)
+
+// 权限范围
+const (
+	ScopeHistoryRead   = "history:read"   // 查询聊天记录
+	ScopeHistoryExport = "history:export" // 导出聊天记录
+	ScopeHistoryAdmin  = "history:admin"  // 管理聊天记录保留策略和清理
+	ScopeBackupAdmin   = "backup:admin"   // 执行和查看在线备份
+	ScopeQueueAdmin    = "queue:admin"    // 查看和管理重试队列
+	ScopeSend          = "send"           // 通过 API 发送消息
+	ScopeMetrics       = "metrics:read"   // 读取指标
+	ScopeAll           = "*"              // 全部权限
+)
+
+var (
+	// ErrMissingCredentials 请求未携带 API Key
+	ErrMissingCredentials = errors.New("未提供认证信息")
+	// ErrInvalidCredentials API Key 不存在
+	ErrInvalidCredentials = errors.New("认证失败")
+	// ErrRemoteAnonymous 未启用认证且未设置 api.auth.insecure 时拒绝非本机请求
+	ErrRemoteAnonymous = errors.New("未启用 API 认证，只允许本机访问")
+)
+
+// Principal 通过认证的调用方
+type Principal struct {
+	Name    string
+	Scopes  []string
+	ChatIDs []int64 // 为空表示不限制群组
+}
+
+// anonymous 未启用认证时使用的调用方，拥有全部权限
+var anonymous = &Principal{Name: "anonymous", Scopes: []string{ScopeAll}}
+
+// Allows 判断是否拥有指定权限，scope 为空表示只要求通过认证
+func (p *Principal) Allows(scope string) bool {
+	if scope == "" {
+		return true
+	}
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeAll {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsChat 判断是否允许访问指定群组
+func (p *Principal) AllowsChat(chatID int64) bool {
+	if len(p.ChatIDs) == 0 {
+		return true
+	}
+	for _, id := range p.ChatIDs {
+		if id == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+// credential 已加载的 API Key
+type credential struct {
+	hash      []byte
+	principal *Principal
+}
+
+// Default HTTP API 使用的认证器，由 Init 根据 api.auth 配置初始化
+var Default = New(false, "")
+
+// Init 根据当前配置初始化默认认证器和审计日志，配置重新加载后自动更新
+func Init() error {
+	cfg := config.Current().API.Auth
+	if err := SetAuditLog(cfg.AuditLog); err != nil {
+		return err
+	}
+	Default.Update(cfg)
+
+	config.OnReload(func(oldCfg, newCfg *config.Config) error {
+		if newCfg.API.Auth.AuditLog != oldCfg.API.Auth.AuditLog {
+			if err := SetAuditLog(newCfg.API.Auth.AuditLog); err != nil {
+				return err
+			}
+		}
+		Default.Update(newCfg.API.Auth)
+		return nil
+	})
+	return nil
+}
+
+// Authenticator 校验请求中的 API Key，可在运行时替换密钥列表
+type Authenticator struct {
+	mutex       sync.RWMutex
+	enabled     bool
+	localOnly   bool // 未启用认证时只允许本机匿名访问
+	headerName  string
+	credentials []credential
+}
+
+// New 创建认证器，headerName 为空时只接受 Authorization: Bearer
+func New(enabled bool, headerName string) *Authenticator {
+	return &Authenticator{enabled: enabled, headerName: headerName}
+}
+
+// FromConfig 根据 api.auth 配置创建认证器
+func FromConfig(cfg *config.APIAuthConfig) *Authenticator {
+	a := New(false, "")
+	a.Update(cfg)
+	return a
+}
+
+// Enabled 返回是否启用认证
+func (a *Authenticator) Enabled() bool {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.enabled
+}
+
+// SetKeys 替换全部 API Key
+func (a *Authenticator) SetKeys(keys []*config.APIKeyConfig) {
+	credentials := make([]credential, 0, len(keys))
+	for _, key := range keys {
+		credentials = append(credentials, credential{
+			hash:      []byte(key.Hash),
+			principal: &Principal{Name: key.Name, Scopes: key.Scopes, ChatIDs: key.ChatIDs},
+		})
+	}
+
+	a.mutex.Lock()
+	a.credentials = credentials
+	a.mutex.Unlock()
+}
+
+// AddKey 添加一个明文 API Key，用于兼容 metrics.http.api_key 等旧配置
+func (a *Authenticator) AddKey(name, key string, scopes ...string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.credentials = append(a.credentials, credential{
+		hash:      []byte(HashKey(key)),
+		principal: &Principal{Name: name, Scopes: scopes},
+	})
+}
+
+// Update 使用新的 api.auth 配置更新认证器
+func (a *Authenticator) Update(cfg *config.APIAuthConfig) {
+	a.SetKeys(cfg.Keys)
+	a.mutex.Lock()
+	a.enabled = cfg.Enabled
+	a.localOnly = !cfg.Enabled && !cfg.Insecure
+	a.headerName = cfg.HeaderName
+	a.mutex.Unlock()
+}
+
+// Authenticate 校验请求，未启用认证时返回拥有全部权限的匿名调用方
+// 按 api.auth 配置且未设置 insecure 时，匿名访问只允许来自本机
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	if !a.enabled {
+		if a.localOnly && !fromLoopback(r) {
+			return nil, ErrRemoteAnonymous
+		}
+		return anonymous, nil
+	}
+
+	key := bearerToken(r)
+	if key == "" && a.headerName != "" {
+		key = r.Header.Get(a.headerName)
+	}
+	if key == "" {
+		return nil, ErrMissingCredentials
+	}
+
+	// 比较哈希值而不是明文，配置中也只保存哈希
+	hash := []byte(HashKey(key))
+	var found *Principal
+	for _, c := range a.credentials {
+		if subtle.ConstantTimeCompare(hash, c.hash) == 1 {
+			found = c.principal
+		}
+	}
+	if found == nil {
+		return nil, ErrInvalidCredentials
+	}
+	return found, nil
+}
+
+// fromLoopback 判断请求是否来自本机
+func fromLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// bearerToken 读取 Authorization: Bearer 中的令牌
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	const prefix = "bearer "
+	if len(header) > len(prefix) && strings.ToLower(header[:len(prefix)]) == prefix {
+		return strings.TrimSpace(header[len(prefix):])
+	}
+	return ""
+}
+
+// principalKey 请求上下文中保存调用方的键
+type principalKey struct{}
+
+// FromContext 返回请求上下文中的调用方，未经过认证中间件时返回 nil
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// Require 认证中间件，校验 API Key 和权限范围并记录审计日志
+func (a *Authenticator) Require(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		entry := newAuditEntry(r, scope)
+		defer func() {
+			entry.finish(rec)
+			writeAudit(entry)
+		}()
+
+		principal, err := a.Authenticate(r)
+		if err != nil {
+			entry.Reason = err.Error()
+			status := http.StatusUnauthorized
+			if errors.Is(err, ErrRemoteAnonymous) {
+				status = http.StatusForbidden
+			}
+			http.Error(rec, err.Error(), status)
+			return
+		}
+		entry.Key = principal.Name
+
+		if !principal.Allows(scope) {
+			entry.Reason = "缺少权限 " + scope
+			http.Error(rec, "权限不足", http.StatusForbidden)
+			return
+		}
+
+		next(rec, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	}
+}
+
+// AuthorizeChat 校验调用方是否允许访问指定群组，不允许时写入 403 响应并返回 false
+func AuthorizeChat(w http.ResponseWriter, r *http.Request, chatID int64) bool {
+	rec, _ := w.(*statusRecorder)
+	if rec != nil {
+		rec.chatID = &chatID
+	}
+
+	principal := FromContext(r.Context())
+	if principal == nil || principal.AllowsChat(chatID) {
+		return true
+	}
+	if rec != nil {
+		rec.reason = "无权访问群组"
+	}
+	http.Error(w, "无权访问该群组", http.StatusForbidden)
+	return false
+}
+
+// statusRecorder 记录响应状态码，供审计日志使用
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	reason string
+	chatID *int64 // 处理器校验过的群组
+}
+
+// WriteHeader 记录状态码
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush 支持流式响应
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
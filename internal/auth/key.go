@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// keyPrefix 生成的 API Key 前缀，便于在日志和代码仓库中识别泄露的密钥
+const keyPrefix = "tgf_"
+
+// GenerateKey 生成随机 API Key，返回明文和用于配置文件的哈希
+func GenerateKey() (key, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", fmt.Errorf("生成随机密钥失败: %w", err)
+	}
+	key = keyPrefix + base64.RawURLEncoding.EncodeToString(buf)
+	return key, HashKey(key), nil
+}
+
+// HashKey 计算 API Key 的哈希，格式为 sha256:<hex>
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,309 @@
+package backup
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/user/tg-forward-to-xx/internal/encryption"
+	"github.com/user/tg-forward-to-xx/internal/migration"
+)
+
+// 存档格式：zstd 压缩的 tar，每个数据库的记录按键顺序分块保存为 <数据库>/<序号>.kv，
+// 每条记录为 uvarint(键长度) + 键 + uvarint(值长度) + 值；manifest.json 写在最后
+const (
+	formatVersion = 1
+	manifestName  = "manifest.json"
+	chunkSuffix   = ".kv"
+	chunkSize     = 16 << 20 // 每个分块的大小上限，写入和恢复时需要整块放在内存中
+)
+
+// ErrCorrupted 存档内容与清单不一致或无法解析
+var ErrCorrupted = errors.New("备份存档已损坏")
+
+// Source 一个需要备份的 LevelDB 数据库
+type Source struct {
+	Store    *migration.Store
+	Dir      string // 相对 queue.path 的目录，重试队列为 "."
+	Snapshot func() (*leveldb.Snapshot, error)
+}
+
+// Manifest 备份存档的清单
+type Manifest struct {
+	Format    int             `json:"format"`
+	CreatedAt time.Time       `json:"created_at"`
+	Stores    []StoreManifest `json:"stores"`
+}
+
+// StoreManifest 存档中的一个数据库
+type StoreManifest struct {
+	Name          string `json:"name"`
+	Dir           string `json:"dir"`            // 相对 queue.path 的目录
+	SchemaVersion int    `json:"schema_version"` // 备份时的结构版本，未记录时为 0
+	Encrypted     bool   `json:"encrypted"`      // 是否启用了静态加密，存档中的值保持密文
+	Records       int    `json:"records"`        // 键值对数量，包括版本等元数据键
+	Chunks        int    `json:"chunks"`
+	Bytes         int64  `json:"bytes"`  // 全部分块的大小
+	SHA256        string `json:"sha256"` // 按顺序计算全部分块的 SHA-256
+}
+
+// Store 返回清单中的数据库，不存在时返回 nil
+func (m *Manifest) Store(name string) *StoreManifest {
+	for i := range m.Stores {
+		if m.Stores[i].Name == name {
+			return &m.Stores[i]
+		}
+	}
+	return nil
+}
+
+// WriteArchive 先为全部数据库创建快照，再依次写入 w，快照期间服务可以继续读写
+func WriteArchive(w io.Writer, sources []Source) (*Manifest, error) {
+	snapshots := make([]*leveldb.Snapshot, 0, len(sources))
+	defer func() {
+		for _, snapshot := range snapshots {
+			snapshot.Release()
+		}
+	}()
+	for _, src := range sources {
+		snapshot, err := src.Snapshot()
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return nil, fmt.Errorf("创建 zstd 压缩器失败: %w", err)
+	}
+	tw := tar.NewWriter(zw)
+
+	manifest := &Manifest{Format: formatVersion, CreatedAt: time.Now().UTC()}
+	for i, src := range sources {
+		store, err := writeStore(tw, src, snapshots[i], manifest.CreatedAt)
+		if err != nil {
+			zw.Close()
+			return nil, fmt.Errorf("备份 %s 失败: %w", src.Store.Name, err)
+		}
+		manifest.Stores = append(manifest.Stores, *store)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		zw.Close()
+		return nil, fmt.Errorf("序列化备份清单失败: %w", err)
+	}
+	if err := writeEntry(tw, manifestName, data, manifest.CreatedAt); err != nil {
+		zw.Close()
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		zw.Close()
+		return nil, fmt.Errorf("写入备份存档失败: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("写入备份存档失败: %w", err)
+	}
+	return manifest, nil
+}
+
+// writeStore 将一个数据库快照中的全部记录分块写入存档
+func writeStore(tw *tar.Writer, src Source, snapshot *leveldb.Snapshot, modTime time.Time) (*StoreManifest, error) {
+	store := &StoreManifest{Name: src.Store.Name, Dir: src.Dir}
+	if data, err := snapshot.Get([]byte(migration.SchemaVersionKey), nil); err == nil {
+		if store.SchemaVersion, err = strconv.Atoi(string(data)); err != nil {
+			return nil, fmt.Errorf("解析数据库版本 %q 失败: %w", data, err)
+		}
+	} else if err != leveldb.ErrNotFound {
+		return nil, fmt.Errorf("读取数据库版本失败: %w", err)
+	}
+	encrypted, err := encryption.Encrypted(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	store.Encrypted = encrypted
+
+	sum := sha256.New()
+	var chunk []byte
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		store.Chunks++
+		name := fmt.Sprintf("%s/%06d%s", store.Name, store.Chunks, chunkSuffix)
+		if err := writeEntry(tw, name, chunk, modTime); err != nil {
+			return err
+		}
+		sum.Write(chunk)
+		store.Bytes += int64(len(chunk))
+		chunk = chunk[:0]
+		return nil
+	}
+
+	iter := snapshot.NewIterator(nil, nil)
+	defer iter.Release()
+	for iter.Next() {
+		chunk = binary.AppendUvarint(chunk, uint64(len(iter.Key())))
+		chunk = append(chunk, iter.Key()...)
+		chunk = binary.AppendUvarint(chunk, uint64(len(iter.Value())))
+		chunk = append(chunk, iter.Value()...)
+		store.Records++
+		if len(chunk) >= chunkSize {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("遍历数据库快照失败: %w", err)
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	store.SHA256 = hex.EncodeToString(sum.Sum(nil))
+	return store, nil
+}
+
+// writeEntry 写入一个 tar 文件项
+func writeEntry(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	header := &tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: modTime, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("写入备份存档失败: %w", err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("写入备份存档失败: %w", err)
+	}
+	return nil
+}
+
+// storeReader 读取存档时一个数据库的校验状态
+type storeReader struct {
+	chunks  int
+	records int
+	bytes   int64
+	hash    hash.Hash
+}
+
+// readArchive 按顺序读取存档，每个分块解码后交给 fn，读完后校验清单中的记录数和校验和
+func readArchive(r io.Reader, fn func(store string, key, value []byte) error) (*Manifest, error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupted, err)
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+
+	stores := make(map[string]*storeReader)
+	var manifest *Manifest
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupted, err)
+		}
+		if manifest != nil {
+			return nil, fmt.Errorf("%w: 清单之后还有 %s", ErrCorrupted, header.Name)
+		}
+		if header.Size > chunkSize*2 {
+			return nil, fmt.Errorf("%w: %s 过大", ErrCorrupted, header.Name)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: 读取 %s 失败: %v", ErrCorrupted, header.Name, err)
+		}
+
+		if header.Name == manifestName {
+			manifest = &Manifest{}
+			if err := json.Unmarshal(data, manifest); err != nil {
+				return nil, fmt.Errorf("%w: 解析清单失败: %v", ErrCorrupted, err)
+			}
+			continue
+		}
+
+		name, file := path.Split(header.Name)
+		name = strings.TrimSuffix(name, "/")
+		if name == "" || !strings.HasSuffix(file, chunkSuffix) {
+			return nil, fmt.Errorf("%w: 未知的文件 %s", ErrCorrupted, header.Name)
+		}
+		s, ok := stores[name]
+		if !ok {
+			s = &storeReader{hash: sha256.New()}
+			stores[name] = s
+		}
+		s.chunks++
+		if file != fmt.Sprintf("%06d%s", s.chunks, chunkSuffix) {
+			return nil, fmt.Errorf("%w: %s 的分块顺序不正确", ErrCorrupted, header.Name)
+		}
+		s.hash.Write(data)
+		s.bytes += int64(len(data))
+
+		for len(data) > 0 {
+			key, rest, ok := readField(data)
+			if !ok {
+				return nil, fmt.Errorf("%w: %s 的记录不完整", ErrCorrupted, header.Name)
+			}
+			value, rest, ok := readField(rest)
+			if !ok {
+				return nil, fmt.Errorf("%w: %s 的记录不完整", ErrCorrupted, header.Name)
+			}
+			if err := fn(name, key, value); err != nil {
+				return nil, err
+			}
+			s.records++
+			data = rest
+		}
+	}
+
+	if manifest == nil {
+		return nil, fmt.Errorf("%w: 缺少 %s，备份可能没有写完", ErrCorrupted, manifestName)
+	}
+	if manifest.Format != formatVersion {
+		return nil, fmt.Errorf("不支持的备份格式版本 %d", manifest.Format)
+	}
+	for name := range stores {
+		if manifest.Store(name) == nil {
+			return nil, fmt.Errorf("%w: 清单中没有 %s", ErrCorrupted, name)
+		}
+	}
+	for _, store := range manifest.Stores {
+		s, ok := stores[store.Name]
+		if !ok {
+			s = &storeReader{hash: sha256.New()}
+		}
+		sum := hex.EncodeToString(s.hash.Sum(nil))
+		if s.chunks != store.Chunks || s.records != store.Records || s.bytes != store.Bytes || sum != store.SHA256 {
+			return nil, fmt.Errorf("%w: %s 的记录数或校验和与清单不一致", ErrCorrupted, store.Name)
+		}
+	}
+	return manifest, nil
+}
+
+// readField 读取一个 uvarint 长度前缀的字段
+func readField(data []byte) ([]byte, []byte, bool) {
+	n, size := binary.Uvarint(data)
+	if size <= 0 || uint64(len(data)-size) < n {
+		return nil, nil, false
+	}
+	data = data[size:]
+	return data[:n], data[n:], true
+}
+
+// ReadManifest 读取并校验整个存档，返回清单，不写入任何数据
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	return readArchive(r, func(string, []byte, []byte) error { return nil })
+}
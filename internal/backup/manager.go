@@ -0,0 +1,269 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/migration"
+	"github.com/user/tg-forward-to-xx/internal/queue"
+	"github.com/user/tg-forward-to-xx/internal/storage"
+)
+
+// 备份文件名的前缀和后缀，清理旧备份时只处理符合格式的文件
+const (
+	filePrefix = "tgforward-backup-"
+	fileSuffix = ".tar.zst"
+)
+
+// Report 一次备份的结果
+type Report struct {
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt time.Time       `json:"finished_at"`
+	File       string          `json:"file,omitempty"`   // 本地备份文件路径
+	Size       int64           `json:"size"`             // 备份文件大小
+	Object     string          `json:"object,omitempty"` // 上传到 S3 的对象名称
+	Stores     []StoreManifest `json:"stores,omitempty"`
+	Pruned     int             `json:"pruned,omitempty"` // 删除的旧备份数量
+	Error      string          `json:"error,omitempty"`  // 备份失败的原因
+}
+
+// FileName 返回备份文件名，例如 tgforward-backup-20250101-120000.tar.zst
+func FileName(t time.Time) string {
+	return filePrefix + t.Format("20060102-150405") + fileSuffix
+}
+
+// Dir 返回备份目录，未配置时为 <queue.path>/backups
+func Dir(cfg *config.Config) string {
+	if cfg.Backup.Dir != "" {
+		return cfg.Backup.Dir
+	}
+	return filepath.Join(cfg.Queue.Path, "backups")
+}
+
+// ChatHistorySource 服务运行中的聊天记录数据库
+func ChatHistorySource(s *storage.ChatHistoryStorage) Source {
+	return Source{Store: migration.ChatHistory, Dir: "chat_history", Snapshot: s.Snapshot}
+}
+
+// QueueSource 服务运行中的 LevelDB 重试队列
+func QueueSource(q *queue.LevelDBQueue) Source {
+	return Source{Store: migration.Queue, Dir: ".", Snapshot: q.Snapshot}
+}
+
+// OpenSources 在服务停止时以只读方式打开 queue.path 下的数据库，返回的 closeFn 用于关闭数据库
+// 服务仍在运行时 LevelDB 已被锁定，返回错误
+func OpenSources(cfg *config.Config) ([]Source, func(), error) {
+	var dbs []*leveldb.DB
+	closeFn := func() {
+		for _, db := range dbs {
+			db.Close()
+		}
+	}
+
+	candidates := []Source{{Store: migration.ChatHistory, Dir: "chat_history"}}
+	if cfg.Queue.Type == "leveldb" {
+		candidates = append(candidates, Source{Store: migration.Queue, Dir: "."})
+	}
+
+	var sources []Source
+	for _, src := range candidates {
+		path := target(cfg.Queue.Path, src.Dir)
+		if _, err := os.Stat(filepath.Join(path, "CURRENT")); err != nil {
+			continue
+		}
+		db, err := leveldb.OpenFile(path, &opt.Options{ReadOnly: true, ErrorIfMissing: true})
+		if err != nil {
+			closeFn()
+			return nil, nil, fmt.Errorf("打开 %s 失败（服务是否仍在运行？）: %w", path, err)
+		}
+		dbs = append(dbs, db)
+		src.Snapshot = db.GetSnapshot
+		sources = append(sources, src)
+	}
+	if len(sources) == 0 {
+		return nil, nil, fmt.Errorf("%s 下没有可以备份的数据库", cfg.Queue.Path)
+	}
+	return sources, closeFn, nil
+}
+
+// Create 将各数据库的快照写入 path，upload 为 true 时上传到 s3.bucket 的 prefix 下
+// 先写入临时文件，完成后再改名，目录中不会出现不完整的备份
+func Create(sources []Source, path string, upload bool, prefix string) (*Report, error) {
+	report := &Report{StartedAt: time.Now(), File: path}
+	defer func() { report.FinishedAt = time.Now() }()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return report, fmt.Errorf("创建备份目录失败: %w", err)
+	}
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return report, fmt.Errorf("创建备份文件失败: %w", err)
+	}
+	manifest, err := WriteArchive(file, sources)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return report, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return report, fmt.Errorf("保存备份文件失败: %w", err)
+	}
+
+	report.Stores = manifest.Stores
+	if stat, err := os.Stat(path); err == nil {
+		report.Size = stat.Size()
+	}
+
+	if upload {
+		objectName := prefix + filepath.Base(path)
+		if err := uploadFile(path, objectName); err != nil {
+			return report, err
+		}
+		report.Object = objectName
+	}
+	return report, nil
+}
+
+// uploadFile 上传备份文件到 S3
+func uploadFile(path, objectName string) error {
+	client, err := storage.NewS3Client()
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("打开备份文件失败: %w", err)
+	}
+	defer file.Close()
+	_, err = client.UploadFile(file, objectName, "application/zstd")
+	return err
+}
+
+// Prune 只保留 dir 中最近的 keep 个备份，keep 为 0 时不删除，返回删除的数量
+func Prune(dir string, keep int) (int, error) {
+	if keep <= 0 {
+		return 0, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, fmt.Errorf("读取备份目录失败: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, filePrefix) && strings.HasSuffix(name, fileSuffix) {
+			names = append(names, name)
+		}
+	}
+	// 文件名中的时间可以按字符串排序
+	sort.Strings(names)
+
+	pruned := 0
+	for len(names)-pruned > keep {
+		if err := os.Remove(filepath.Join(dir, names[pruned])); err != nil {
+			return pruned, fmt.Errorf("删除旧备份失败: %w", err)
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// Manager 在线备份任务，按 backup.interval 定时执行，也可以手动触发
+type Manager struct {
+	sources  []Source
+	stopChan chan struct{}
+	runMutex sync.Mutex // 保证同一时间只有一次备份
+
+	lastMutex sync.RWMutex
+	last      *Report
+}
+
+// NewManager 创建在线备份任务
+func NewManager(sources []Source) *Manager {
+	return &Manager{sources: sources, stopChan: make(chan struct{})}
+}
+
+// Start 启动定时备份，第一次备份在启动一个间隔之后执行
+// 每次执行前读取当前配置，重新加载配置后立即生效
+func (m *Manager) Start() {
+	go m.run()
+}
+
+// Stop 停止定时备份，等待正在执行的备份完成后返回，之后可以安全关闭数据库
+func (m *Manager) Stop() {
+	close(m.stopChan)
+	m.runMutex.Lock()
+	defer m.runMutex.Unlock()
+}
+
+// run 定时备份循环
+func (m *Manager) run() {
+	for {
+		cfg := config.Current().Backup
+		select {
+		case <-m.stopChan:
+			return
+		case <-time.After(time.Duration(cfg.Interval) * time.Second):
+		}
+
+		if config.Current().Backup.Enabled {
+			if _, err := m.Run(); err != nil {
+				logrus.Errorf("在线备份失败: %v", err)
+			}
+		}
+	}
+}
+
+// Run 立即按当前配置执行一次备份
+func (m *Manager) Run() (*Report, error) {
+	m.runMutex.Lock()
+	defer m.runMutex.Unlock()
+
+	cfg := config.Current()
+	dir := Dir(cfg)
+	report, err := Create(m.sources, filepath.Join(dir, FileName(time.Now())), cfg.Backup.Upload, cfg.Backup.Prefix)
+	if err == nil {
+		report.Pruned, err = Prune(dir, cfg.Backup.Keep)
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+
+	m.lastMutex.Lock()
+	m.last = report
+	m.lastMutex.Unlock()
+
+	if err == nil {
+		logrus.WithFields(logrus.Fields{
+			"file":   report.File,
+			"size":   report.Size,
+			"object": report.Object,
+			"pruned": report.Pruned,
+		}).Info("在线备份完成")
+	}
+	return report, err
+}
+
+// LastReport 返回最近一次备份的结果，尚未执行过时返回 nil
+func (m *Manager) LastReport() *Report {
+	m.lastMutex.RLock()
+	defer m.lastMutex.RUnlock()
+	return m.last
+}
@@ -0,0 +1,263 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/user/tg-forward-to-xx/internal/encryption"
+	"github.com/user/tg-forward-to-xx/internal/migration"
+)
+
+// restoreBatchSize 恢复时每批写入的记录数量
+const restoreBatchSize = 1000
+
+// derivedDirs 可以由聊天记录重建的派生数据库，恢复聊天记录后一并移走，避免与恢复的数据不一致
+var derivedDirs = []string{"chat_search", "chat_stats"}
+
+// stores 存档中可能出现的数据库
+var stores = map[string]*migration.Store{
+	migration.ChatHistory.Name: migration.ChatHistory,
+	migration.Queue.Name:       migration.Queue,
+}
+
+// RestoreOptions 恢复选项
+type RestoreOptions struct {
+	QueuePath string                 // 恢复到的 queue.path
+	Keys      *encryption.MasterKeys // 存档中的数据库已加密时用于校验主密钥
+	DryRun    bool                   // 只校验存档，不替换现有的数据库
+}
+
+// RestoreResult 恢复结果
+type RestoreResult struct {
+	Manifest *Manifest
+	Stores   []RestoredStore
+	Previous string // 原有数据库移动到的目录，没有原有数据时为空
+}
+
+// RestoredStore 恢复的一个数据库
+type RestoredStore struct {
+	Name           string
+	Path           string
+	Records        int
+	SchemaVersion  int
+	NeedsMigration bool // 备份的结构版本低于程序要求，启动前需要运行 migrate up
+}
+
+// Restore 从存档恢复数据库，服务必须已停止
+// 先把存档解压到 queue.path 下的临时目录并校验清单、结构版本和主密钥，全部通过后才替换现有数据库；
+// 原有的数据库文件移动到 <queue.path>/pre-restore-<时间>，聊天记录的搜索索引和统计汇总也一并移走，需要重建
+func Restore(archivePath string, opts RestoreOptions) (*RestoreResult, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("打开备份文件失败: %w", err)
+	}
+	defer file.Close()
+
+	stamp := time.Now().Format("20060102-150405")
+	staging := filepath.Join(opts.QueuePath, "restore-"+stamp+".tmp")
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		return nil, fmt.Errorf("创建临时目录失败: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	manifest, err := extract(file, staging)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RestoreResult{Manifest: manifest}
+	for _, sm := range manifest.Stores {
+		restored, err := check(filepath.Join(staging, sm.Name), &sm, opts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", sm.Name, err)
+		}
+		restored.Path = target(opts.QueuePath, sm.Dir)
+		if err := ensureStopped(restored.Path); err != nil {
+			return nil, err
+		}
+		result.Stores = append(result.Stores, *restored)
+	}
+	if opts.DryRun {
+		return result, nil
+	}
+
+	previous := filepath.Join(opts.QueuePath, "pre-restore-"+stamp)
+	moved := false
+	for _, sm := range manifest.Stores {
+		dst := target(opts.QueuePath, sm.Dir)
+		n, err := moveFiles(dst, filepath.Join(previous, sm.Name))
+		if err != nil {
+			return result, fmt.Errorf("移走原有的 %s 失败: %w", sm.Name, err)
+		}
+		moved = moved || n > 0
+		if _, err := moveFiles(filepath.Join(staging, sm.Name), dst); err != nil {
+			return result, fmt.Errorf("替换 %s 失败，原有数据在 %s: %w", sm.Name, previous, err)
+		}
+		if sm.Name != migration.ChatHistory.Name {
+			continue
+		}
+		for _, dir := range derivedDirs {
+			n, err := moveFiles(filepath.Join(opts.QueuePath, dir), filepath.Join(previous, dir))
+			if err != nil {
+				return result, fmt.Errorf("移走 %s 失败: %w", dir, err)
+			}
+			moved = moved || n > 0
+		}
+	}
+	if moved {
+		result.Previous = previous
+	}
+	return result, nil
+}
+
+// extract 将存档中的每个数据库写入 staging 下的同名 LevelDB，返回校验通过的清单
+func extract(r io.Reader, staging string) (*Manifest, error) {
+	dbs := make(map[string]*leveldb.DB)
+	batches := make(map[string]*leveldb.Batch)
+	defer func() {
+		for _, db := range dbs {
+			db.Close()
+		}
+	}()
+
+	manifest, err := readArchive(r, func(name string, key, value []byte) error {
+		db, ok := dbs[name]
+		if !ok {
+			if _, known := stores[name]; !known {
+				return fmt.Errorf("%w: 未知的数据库 %s", ErrCorrupted, name)
+			}
+			var err error
+			if db, err = leveldb.OpenFile(filepath.Join(staging, name), nil); err != nil {
+				return fmt.Errorf("创建临时数据库失败: %w", err)
+			}
+			dbs[name] = db
+			batches[name] = new(leveldb.Batch)
+		}
+		b := batches[name]
+		b.Put(key, value)
+		if b.Len() >= restoreBatchSize {
+			if err := db.Write(b, nil); err != nil {
+				return fmt.Errorf("写入临时数据库失败: %w", err)
+			}
+			b.Reset()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name, db := range dbs {
+		if err := db.Write(batches[name], &opt.WriteOptions{Sync: true}); err != nil {
+			return nil, fmt.Errorf("写入临时数据库失败: %w", err)
+		}
+	}
+	for _, sm := range manifest.Stores {
+		if _, known := stores[sm.Name]; !known {
+			return nil, fmt.Errorf("%w: 未知的数据库 %s", ErrCorrupted, sm.Name)
+		}
+		if _, ok := dbs[sm.Name]; !ok {
+			// 备份时数据库为空
+			db, err := leveldb.OpenFile(filepath.Join(staging, sm.Name), nil)
+			if err != nil {
+				return nil, fmt.Errorf("创建临时数据库失败: %w", err)
+			}
+			dbs[sm.Name] = db
+		}
+	}
+	return manifest, nil
+}
+
+// check 重新打开解压后的数据库，核对记录数、结构版本和主密钥
+func check(path string, sm *StoreManifest, opts RestoreOptions) (*RestoredStore, error) {
+	db, err := leveldb.OpenFile(path, &opt.Options{ErrorIfMissing: true})
+	if err != nil {
+		return nil, fmt.Errorf("打开恢复的数据库失败: %w", err)
+	}
+	defer db.Close()
+
+	records := 0
+	iter := db.NewIterator(nil, nil)
+	for iter.Next() {
+		records++
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("遍历恢复的数据库失败: %w", err)
+	}
+	if records != sm.Records {
+		return nil, fmt.Errorf("%w: 恢复了 %d 条记录，清单中为 %d 条", ErrCorrupted, records, sm.Records)
+	}
+
+	store := stores[sm.Name]
+	version, found, err := migration.Version(db)
+	if err != nil {
+		return nil, err
+	}
+	if found && version != sm.SchemaVersion {
+		return nil, fmt.Errorf("%w: 结构版本 %d 与清单中的 %d 不一致", ErrCorrupted, version, sm.SchemaVersion)
+	}
+	if version > store.Latest() {
+		return nil, fmt.Errorf("%w: 备份的结构版本 %d，程序支持的最高版本 %d，请使用新版程序恢复", migration.ErrTooNew, version, store.Latest())
+	}
+
+	if err := encryption.Verify(db, opts.Keys); err != nil {
+		return nil, fmt.Errorf("备份已加密，需要配置备份时使用的主密钥: %w", err)
+	}
+
+	return &RestoredStore{
+		Name:           sm.Name,
+		Records:        records,
+		SchemaVersion:  version,
+		NeedsMigration: found && version < store.Latest(),
+	}, nil
+}
+
+// target 返回数据库在 queue.path 下的目录
+func target(queuePath, dir string) string {
+	return filepath.Join(queuePath, filepath.FromSlash(dir))
+}
+
+// ensureStopped 确认数据库没有被服务打开，LevelDB 同一时间只能被一个进程打开
+func ensureStopped(path string) error {
+	if _, err := os.Stat(filepath.Join(path, "CURRENT")); err != nil {
+		return nil
+	}
+	db, err := leveldb.OpenFile(path, &opt.Options{ErrorIfMissing: true})
+	if err != nil {
+		return fmt.Errorf("打开 %s 失败（服务是否仍在运行？恢复前需要停止服务）: %w", path, err)
+	}
+	return db.Close()
+}
+
+// moveFiles 将 src 目录下的普通文件移动到 dst，子目录属于其他数据库，保持不动；返回移动的文件数
+func moveFiles(src, dst string) (int, error) {
+	entries, err := os.ReadDir(src)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if moved == 0 {
+			if err := os.MkdirAll(dst, 0755); err != nil {
+				return moved, err
+			}
+		}
+		if err := os.Rename(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+			return moved, err
+		}
+		moved++
+	}
+	return moved, nil
+}
@@ -34,7 +34,8 @@ type BarkMessage struct {
 // NewBarkClient 创建一个新的 Bark 通知客户端
 func NewBarkClient() *BarkClient {
 	// 如果 Bark 配置为空，创建一个禁用的客户端
-	if config.AppConfig.Bark == nil {
+	barkCfg := config.Current().Bark
+	if barkCfg == nil {
 		return &BarkClient{
 			enabled:    false,
 			keys:      []string{},
@@ -45,10 +46,10 @@ func NewBarkClient() *BarkClient {
 	}
 
 	return &BarkClient{
-		enabled:    config.AppConfig.Bark.Enabled,
-		keys:       config.AppConfig.Bark.Keys,
-		sound:      config.AppConfig.Bark.Sound,
-		icon:       config.AppConfig.Bark.Icon,
+		enabled:    barkCfg.Enabled,
+		keys:       barkCfg.Keys,
+		sound:      barkCfg.Sound,
+		icon:       barkCfg.Icon,
 		httpClient: &http.Client{
 			Timeout: 10 * time.Second,
 		},
@@ -80,7 +81,7 @@ func (c *BarkClient) SendMessage(chatName string, message *models.Message) error
 		
 		req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
 		if err != nil {
-			logrus.Errorf("创建 Bark 请求失败 (key: %s): %v", key, err)
+			logrus.Errorf("创建 Bark 请求失败 (key: %s): %v", config.MaskSecret(key), err)
 			continue
 		}
 
@@ -88,19 +89,19 @@ func (c *BarkClient) SendMessage(chatName string, message *models.Message) error
 
 		resp, err := c.httpClient.Do(req)
 		if err != nil {
-			logrus.Errorf("发送 Bark 通知失败 (key: %s): %v", key, err)
+			logrus.Errorf("发送 Bark 通知失败 (key: %s): %v", config.MaskSecret(key), err)
 			continue
 		}
 		resp.Body.Close()
 
 		if resp.StatusCode != http.StatusOK {
-			logrus.Errorf("Bark 服务器返回错误 (key: %s): %d", key, resp.StatusCode)
+			logrus.Errorf("Bark 服务器返回错误 (key: %s): %d", config.MaskSecret(key), resp.StatusCode)
 			continue
 		}
 
 		logrus.WithFields(logrus.Fields{
 			"chat_name": chatName,
-			"key":       key[:4] + "****" + key[len(key)-4:],
+			"key":       config.MaskSecret(key),
 		}).Debug("Bark 通知发送成功")
 	}
 
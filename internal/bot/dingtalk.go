@@ -6,12 +6,12 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -25,159 +25,242 @@ type DingTalkClient struct {
 	secret     string
 	atMobiles  []string
 	isAtAll    bool
+	cfg        *config.DingTalkConfig
 	httpClient *http.Client
 }
 
-// DingTalkMessage 钉钉消息结构
-type DingTalkMessage struct {
-	MsgType string `json:"msgtype"`
-	Text    struct {
-		Content string `json:"content"`
-	} `json:"text"`
+// 钉钉限流错误码，触发后需要等待一段时间再重试
+const dingTalkRateLimitCode = 130101
+
+// ErrDingTalkRateLimited 钉钉发送频率超限，由重试队列稍后重新发送
+var ErrDingTalkRateLimited = errors.New("钉钉发送频率超限")
+
+// dingTalkResponse 钉钉接口响应
+type dingTalkResponse struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}
+
+// 消息类型对应的中文描述
+var dingTalkTypeNames = map[string]string{
+	models.MessageTypeText:     "文字消息",
+	models.MessageTypePhoto:    "图片消息",
+	models.MessageTypeDocument: "文档消息",
+	models.MessageTypeVideo:    "视频消息",
+	models.MessageTypeAudio:    "音频消息",
+	models.MessageTypeAlbum:    "相册消息",
+	models.MessageTypeOther:    "其他消息",
 }
 
 // NewDingTalkClient 创建一个新的钉钉机器人客户端
 func NewDingTalkClient() *DingTalkClient {
+	dtCfg := config.Current().DingTalk
 	return &DingTalkClient{
-		webhookURL: config.AppConfig.DingTalk.WebhookURL,
-		secret:     config.AppConfig.DingTalk.Secret,
-		atMobiles:  config.AppConfig.DingTalk.AtMobiles,
-		isAtAll:    config.AppConfig.DingTalk.IsAtAll,
+		webhookURL: dtCfg.WebhookURL,
+		secret:     dtCfg.Secret,
+		atMobiles:  dtCfg.AtMobiles,
+		isAtAll:    dtCfg.IsAtAll,
+		cfg:        dtCfg,
 		httpClient: &http.Client{
 			Timeout: 10 * time.Second,
 		},
 	}
 }
 
-// SendMessage 发送消息到钉钉
+// SendMessage 发送消息到钉钉，按消息类型选择 text、markdown、actionCard 或 feedCard
 func (c *DingTalkClient) SendMessage(msg *models.Message) error {
 	logrus.WithFields(logrus.Fields{
-		"message_id": msg.ID,
-		"chat_id":   msg.ChatID,
-		"from":      msg.From,
+		"message_id":   msg.ID,
+		"chat_id":      msg.Chat.ID,
+		"from":         msg.Sender.Name(),
+		"message_type": msg.MessageType,
 	}).Debug("准备发送消息到钉钉")
 
+	data := c.buildPayload(msg)
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("序列化消息失败: %w", err)
+	}
+
+	result, err := c.post(jsonData)
+	if err != nil {
+		return err
+	}
+
+	switch result.ErrCode {
+	case 0:
+		logrus.WithFields(logrus.Fields{
+			"message_id": msg.ID,
+			"msgtype":    data["msgtype"],
+		}).Debug("钉钉消息发送成功")
+		return nil
+	case dingTalkRateLimitCode:
+		// 不在这里等待，调用方持有输出锁，等待会阻塞配置重新加载和其他消息
+		return fmt.Errorf("%w: errmsg=%s", ErrDingTalkRateLimited, result.ErrMsg)
+	default:
+		return fmt.Errorf("钉钉返回错误: errcode=%d, errmsg=%s", result.ErrCode, result.ErrMsg)
+	}
+}
+
+// post 发送一次请求并解析钉钉响应
+func (c *DingTalkClient) post(jsonData []byte) (*dingTalkResponse, error) {
+	requestURL, err := c.buildRequestURL()
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.WithField("url", config.MaskURL(requestURL)).Debug("发送 HTTP 请求到钉钉")
+
+	resp, err := c.httpClient.Post(requestURL, "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("发送请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应失败: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("钉钉返回错误状态码: %d, 响应: %s", resp.StatusCode, string(body))
+	}
+
+	var result dingTalkResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("解析钉钉响应失败: %w, 响应: %s", err, string(body))
+	}
+	return &result, nil
+}
+
+// buildPayload 按消息类型构造钉钉消息体
+func (c *DingTalkClient) buildPayload(msg *models.Message) map[string]interface{} {
 	// 构造发送者信息
-	senderInfo := fmt.Sprintf("来自 %s 的", msg.From)
-	if msg.ChatTitle != "" {
-		senderInfo = fmt.Sprintf("来自 %s(%s) 的", msg.From, msg.ChatTitle)
+	senderInfo := fmt.Sprintf("来自 %s 的", msg.Sender.Name())
+	if msg.Chat.Title != "" {
+		senderInfo = fmt.Sprintf("来自 %s(%s) 的", msg.Sender.Name(), msg.Chat.Title)
 	}
 
-	// 构造消息体
-	var data map[string]interface{}
-	if msg.IsMarkdown {
-		// Markdown 格式消息
-		messageTitle := fmt.Sprintf("%s图片消息", senderInfo)
-		var messageContent string
-		
-		if config.AppConfig.DingTalk.NotifyVerbose {
-			// 详细模式：显示完整消息内容
-			messageContent = fmt.Sprintf("### %s\n%s", messageTitle, msg.Content)
-		} else {
-			// 简略模式：只显示消息类型
-			messageContent = fmt.Sprintf("### %s", messageTitle)
-		}
+	typeName, ok := dingTalkTypeNames[msg.MessageType]
+	if !ok {
+		typeName = dingTalkTypeNames[models.MessageTypeText]
+	}
+	title := senderInfo + typeName
 
-		data = map[string]interface{}{
-			"msgtype": "markdown",
-			"markdown": map[string]string{
-				"title": messageTitle,
-				"text":  messageContent,
-			},
-		}
-		// 只有在启用 @ 功能时才添加 at 字段
-		if config.AppConfig.DingTalk.EnableAt {
-			data["at"] = map[string]interface{}{
-				"atMobiles": c.atMobiles,
-				"isAtAll":   c.isAtAll,
-			}
-		}
-	} else {
-		// 普通文本消息
-		messageType := "文字消息"
-		if strings.Contains(msg.Content, "[图片]") {
-			messageType = "图片消息"
-		} else if strings.Contains(msg.Content, "[视频]") {
-			messageType = "视频消息"
-		} else if strings.Contains(msg.Content, "[音频]") {
-			messageType = "音频消息"
-		} else if strings.Contains(msg.Content, "[文档]") {
-			messageType = "文档消息"
-		} else if strings.Contains(msg.Content, "[贴纸]") {
-			messageType = "贴纸消息"
-		}
+	var data map[string]interface{}
+	switch {
+	case msg.MessageType == models.MessageTypeAlbum && len(msg.Attachments) > 0:
+		data = c.buildFeedCard(msg, title)
+	case (msg.MessageType == models.MessageTypeDocument || msg.MessageType == models.MessageTypeVideo) && c.originalURL(msg) != "":
+		data = c.buildActionCard(msg, title)
+	case msg.MessageType == models.MessageTypePhoto && len(msg.Images()) > 0:
+		data = c.buildMarkdown(msg, title)
+	default:
+		data = c.buildText(msg, title)
+	}
 
-		var content string
-		if config.AppConfig.DingTalk.NotifyVerbose {
-			// 详细模式：显示完整消息内容
-			content = fmt.Sprintf("%s%s：\n%s", senderInfo, messageType, msg.Content)
-		} else {
-			// 简略模式：只显示消息类型
-			content = fmt.Sprintf("%s%s", senderInfo, messageType)
+	// 只有在启用 @ 功能时才添加 at 字段，actionCard 和 feedCard 不支持 @
+	if c.cfg.EnableAt && (data["msgtype"] == "text" || data["msgtype"] == "markdown") {
+		data["at"] = map[string]interface{}{
+			"atMobiles": c.atMobiles,
+			"isAtAll":   c.isAtAll,
 		}
+	}
+	return data
+}
 
-		// 只有在启用 @ 功能时才添加 @ 信息
-		if config.AppConfig.DingTalk.EnableAt && len(c.atMobiles) > 0 {
-			content += "\n"
-			for _, mobile := range c.atMobiles {
-				content += fmt.Sprintf("@%s ", mobile)
-			}
-		}
+// buildText 构造文本消息
+func (c *DingTalkClient) buildText(msg *models.Message, title string) map[string]interface{} {
+	var content string
+	if c.cfg.NotifyVerbose {
+		// 详细模式：显示完整消息内容
+		content = fmt.Sprintf("%s：\n%s", title, msg.PlainText())
+	} else {
+		// 简略模式：只显示消息类型
+		content = title
+	}
 
-		data = map[string]interface{}{
-			"msgtype": "text",
-			"text": map[string]string{
-				"content": content,
-			},
-		}
-		// 只有在启用 @ 功能时才添加 at 字段
-		if config.AppConfig.DingTalk.EnableAt {
-			data["at"] = map[string]interface{}{
-				"atMobiles": c.atMobiles,
-				"isAtAll":   c.isAtAll,
-			}
+	// 只有在启用 @ 功能时才添加 @ 信息
+	if c.cfg.EnableAt && len(c.atMobiles) > 0 {
+		content += "\n"
+		for _, mobile := range c.atMobiles {
+			content += fmt.Sprintf("@%s ", mobile)
 		}
 	}
 
-	// 生成签名
-	timestamp := time.Now().UnixMilli()
-	sign := c.generateSign(timestamp)
-
-	// 构造完整的 URL
-	url := fmt.Sprintf("%s&timestamp=%d&sign=%s", c.webhookURL, timestamp, sign)
+	return map[string]interface{}{
+		"msgtype": "text",
+		"text": map[string]string{
+			"content": content,
+		},
+	}
+}
 
-	logrus.WithField("url", url).Debug("发送 HTTP 请求到钉钉")
+// buildMarkdown 构造内嵌图片的 markdown 消息
+func (c *DingTalkClient) buildMarkdown(msg *models.Message, title string) map[string]interface{} {
+	text := fmt.Sprintf("### %s\n", title)
+	if c.cfg.NotifyVerbose && msg.Text != "" {
+		text += msg.Text + "\n\n"
+	}
+	for _, image := range msg.Images() {
+		text += fmt.Sprintf("![图片](%s)\n", image.URL)
+	}
 
-	// 发送请求
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		return fmt.Errorf("序列化消息失败: %w", err)
+	return map[string]interface{}{
+		"msgtype": "markdown",
+		"markdown": map[string]string{
+			"title": title,
+			"text":  text,
+		},
 	}
+}
 
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("发送请求失败: %w", err)
+// buildActionCard 构造带“查看原文件”按钮的 actionCard 消息
+func (c *DingTalkClient) buildActionCard(msg *models.Message, title string) map[string]interface{} {
+	text := fmt.Sprintf("### %s", title)
+	if c.cfg.NotifyVerbose {
+		text += "\n" + msg.Summary()
 	}
-	defer resp.Body.Close()
 
-	// 读取响应
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("读取响应失败: %w", err)
+	return map[string]interface{}{
+		"msgtype": "actionCard",
+		"actionCard": map[string]string{
+			"title":       title,
+			"text":        text,
+			"singleTitle": "查看原文件",
+			"singleURL":   c.originalURL(msg),
+		},
 	}
+}
 
-	logrus.WithFields(logrus.Fields{
-		"message_id": msg.ID,
-		"status":     resp.StatusCode,
-		"response":   string(body),
-	}).Debug("钉钉消息发送成功")
+// buildFeedCard 构造相册的 feedCard 消息，每个媒体文件一条链接
+func (c *DingTalkClient) buildFeedCard(msg *models.Message, title string) map[string]interface{} {
+	links := make([]map[string]string, 0, len(msg.Attachments))
+	for i, attachment := range msg.Attachments {
+		link := map[string]string{
+			"title":      fmt.Sprintf("%s (%d/%d)", title, i+1, len(msg.Attachments)),
+			"messageURL": attachment.URL,
+		}
+		if attachment.IsImage() {
+			link["picURL"] = attachment.URL
+		}
+		links = append(links, link)
+	}
 
-	// 检查响应状态码
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("钉钉返回错误状态码: %d, 响应: %s", resp.StatusCode, string(body))
+	return map[string]interface{}{
+		"msgtype": "feedCard",
+		"feedCard": map[string]interface{}{
+			"links": links,
+		},
 	}
+}
 
-	return nil
+// originalURL 返回原文件地址，没有文件时使用 Telegram 消息链接
+func (c *DingTalkClient) originalURL(msg *models.Message) string {
+	if attachment := msg.PrimaryAttachment(); attachment != nil {
+		return attachment.URL
+	}
+	return msg.TelegramLink()
 }
 
 // 构建带签名的请求 URL
@@ -208,12 +291,3 @@ func (c *DingTalkClient) buildRequestURL() (string, error) {
 
 	return baseURL.String(), nil
 }
-
-// generateSign 生成钉钉签名
-func (c *DingTalkClient) generateSign(timestamp int64) string {
-	signStr := fmt.Sprintf("%d\n%s", timestamp, c.secret)
-	hmac256 := hmac.New(sha256.New, []byte(c.secret))
-	hmac256.Write([]byte(signStr))
-	signature := base64.StdEncoding.EncodeToString(hmac256.Sum(nil))
-	return url.QueryEscape(signature)
-}
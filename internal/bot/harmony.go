@@ -1,27 +1,52 @@
 package bot
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// HarmonyOS_MeoW 请求方式
+const (
+	HarmonyMethodAuto = "auto" // 优先 POST，服务端不支持时回退到 GET
+	HarmonyMethodPost = "post" // 仅使用 POST JSON
+	HarmonyMethodGet  = "get"  // 仅使用 GET
 )
 
 // HarmonyClient HarmonyOS_MeoW 通知客户端
 type HarmonyClient struct {
-	enabled  bool
-	userIDs  []string
-	baseURL  string
-	client   *http.Client
+	enabled bool
+	userIDs []string
+	baseURL string
+	method  string
+	client  *http.Client
+	// postUnsupported 自动模式下服务端不支持 POST 时置为 true，之后直接使用 GET
+	postUnsupported atomic.Bool
+}
+
+// harmonyPayload POST 请求体
+type harmonyPayload struct {
+	Title string `json:"title"`
+	Msg   string `json:"msg"`
+	URL   string `json:"url,omitempty"`
 }
 
+// errHarmonyPostUnsupported 服务端不支持 POST 请求
+var errHarmonyPostUnsupported = fmt.Errorf("HarmonyOS_MeoW 服务端不支持 POST 请求")
+
 // NewHarmonyClient 创建新的 HarmonyOS_MeoW 客户端
 func NewHarmonyClient() *HarmonyClient {
-	cfg := config.AppConfig.Harmony
+	cfg := config.Current().Harmony
 	if cfg == nil {
 		return &HarmonyClient{enabled: false}
 	}
@@ -31,80 +56,155 @@ func NewHarmonyClient() *HarmonyClient {
 		baseURL = "https://api.chuckfang.com"
 	}
 
+	method := strings.ToLower(cfg.Method)
+	switch method {
+	case HarmonyMethodPost, HarmonyMethodGet:
+	case "", HarmonyMethodAuto:
+		method = HarmonyMethodAuto
+	default:
+		logrus.Warnf("不支持的 HarmonyOS_MeoW 请求方式: %s，将使用 auto", cfg.Method)
+		method = HarmonyMethodAuto
+	}
+
 	return &HarmonyClient{
-		enabled:  cfg.Enabled,
-		userIDs:  cfg.UserIDs,
-		baseURL:  baseURL,
-		client:   &http.Client{Timeout: 10 * time.Second},
+		enabled: cfg.Enabled,
+		userIDs: cfg.UserIDs,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		method:  method,
+		client:  &http.Client{Timeout: 10 * time.Second},
 	}
 }
 
-// SendMessage 发送通知消息
-func (c *HarmonyClient) SendMessage(chatName string, text string, imageURL string) error {
+// SendMessage 发送通知消息，图片和文件地址取自消息的媒体字段
+func (c *HarmonyClient) SendMessage(chatName string, msg *models.Message) error {
 	if !c.enabled || len(c.userIDs) == 0 {
 		return nil
 	}
 
+	payload := &harmonyPayload{
+		Title: chatName,
+		Msg:   harmonyText(msg),
+		URL:   harmonyLink(msg),
+	}
+
 	var lastErr error
 	for _, userID := range c.userIDs {
-		// 构建通知 URL，不使用 url.PathEscape，直接拼接字符串
-		notifyURL := fmt.Sprintf("%s/%s/%s/%s", 
-			strings.TrimRight(c.baseURL, "/"),
-			userID,
-			chatName,
-			text,
-		)
-
-		// 打印完整的请求 URL
-		logrus.WithFields(logrus.Fields{
-			"user_id": userID,
-			"title": chatName,
-			"content": text,
-			"full_url": notifyURL,
-		}).Info("HarmonyOS_MeoW 请求 URL")
-
-		// 发送 GET 请求
-		resp, err := c.client.Get(notifyURL)
-		if err != nil {
+		if err := c.send(userID, payload); err != nil {
 			logrus.WithFields(logrus.Fields{
-				"user_id": userID,
-				"title": chatName,
-				"error": err,
-				"url": notifyURL,
+				"user_id": config.MaskSecret(userID),
+				"title":   chatName,
+				"error":   err,
 			}).Error("发送 HarmonyOS_MeoW 通知失败")
 			lastErr = err
 			continue
 		}
 
-		// 读取响应内容
-		body, _ := io.ReadAll(resp.Body)
-		resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			err = fmt.Errorf("通知发送失败，状态码：%d，响应：%s，URL：%s", 
-				resp.StatusCode, 
-				string(body),
-				notifyURL,
-			)
-			logrus.WithFields(logrus.Fields{
-				"user_id": userID,
-				"title": chatName,
-				"status_code": resp.StatusCode,
-				"response": string(body),
-				"url": notifyURL,
-			}).Error("HarmonyOS_MeoW 通知返回错误状态码")
-			lastErr = err
-			continue
-		}
-
 		logrus.WithFields(logrus.Fields{
-			"user_id": userID,
-			"title": chatName,
-			"status_code": resp.StatusCode,
-			"response": string(body),
-			"url": notifyURL,
+			"user_id":    config.MaskSecret(userID),
+			"title":      chatName,
+			"message_id": msg.ID,
 		}).Debug("HarmonyOS_MeoW 通知发送成功")
 	}
 
 	return lastErr
-} 
\ No newline at end of file
+}
+
+// send 按配置的请求方式向单个用户发送通知
+func (c *HarmonyClient) send(userID string, payload *harmonyPayload) error {
+	if c.method == HarmonyMethodGet || (c.method == HarmonyMethodAuto && c.postUnsupported.Load()) {
+		return c.sendGet(userID, payload)
+	}
+
+	err := c.sendPost(userID, payload)
+	if err == errHarmonyPostUnsupported && c.method == HarmonyMethodAuto {
+		logrus.Warn("HarmonyOS_MeoW 服务端不支持 POST，回退到 GET 请求")
+		c.postUnsupported.Store(true)
+		return c.sendGet(userID, payload)
+	}
+	return err
+}
+
+// sendPost 以 JSON 请求体发送通知，内容不受 URL 长度和特殊字符限制
+func (c *HarmonyClient) sendPost(userID string, payload *harmonyPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("序列化通知内容失败: %w", err)
+	}
+
+	notifyURL := c.baseURL + "/" + url.PathEscape(userID)
+	req, err := http.NewRequest(http.MethodPost, notifyURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("创建请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	status, respBody, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusNotFound || status == http.StatusMethodNotAllowed {
+		return errHarmonyPostUnsupported
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("通知发送失败，状态码：%d，响应：%s", status, respBody)
+	}
+	return nil
+}
+
+// sendGet 将标题和内容逐段转义后拼接到路径中发送通知
+func (c *HarmonyClient) sendGet(userID string, payload *harmonyPayload) error {
+	notifyURL := fmt.Sprintf("%s/%s/%s/%s",
+		c.baseURL,
+		url.PathEscape(userID),
+		url.PathEscape(payload.Title),
+		url.PathEscape(payload.Msg),
+	)
+	if payload.URL != "" {
+		notifyURL += "?" + url.Values{"url": {payload.URL}}.Encode()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, notifyURL, nil)
+	if err != nil {
+		return fmt.Errorf("创建请求失败: %w", err)
+	}
+
+	status, respBody, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("通知发送失败，状态码：%d，响应：%s", status, respBody)
+	}
+	return nil
+}
+
+// do 发送请求并读取响应
+func (c *HarmonyClient) do(req *http.Request) (int, string, error) {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, "", fmt.Errorf("发送请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, "", fmt.Errorf("读取响应失败: %w", err)
+	}
+	return resp.StatusCode, string(body), nil
+}
+
+// harmonyText 构造通知正文，标题中已包含来源，不再重复消息头
+func harmonyText(msg *models.Message) string {
+	if text := msg.Summary(); text != "" {
+		return text
+	}
+	return fmt.Sprintf("来自 %s 的消息", msg.Sender.Name())
+}
+
+// harmonyLink 返回点击通知时打开的地址，优先使用媒体文件
+func harmonyLink(msg *models.Message) string {
+	if attachment := msg.PrimaryAttachment(); attachment != nil {
+		return attachment.URL
+	}
+	return msg.TelegramLink()
+}
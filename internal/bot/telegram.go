@@ -8,7 +8,7 @@ import (
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/sirupsen/logrus"
-	"github.com/user/tg-forward-to-xx/config"
+	"github.com/user/tg-forward-to-xx/internal/config"
 	"github.com/user/tg-forward-to-xx/internal/models"
 	"github.com/user/tg-forward-to-xx/internal/storage"
 	"github.com/user/tg-forward-to-xx/internal/utils"
@@ -23,7 +23,8 @@ type TelegramClient struct {
 
 // NewTelegramClient 创建一个新的 Telegram 机器人客户端
 func NewTelegramClient() (*TelegramClient, error) {
-	token := config.AppConfig.Telegram.Token
+	tgCfg := config.Current().Telegram
+	token := tgCfg.Token
 	if token == "" {
 		return nil, fmt.Errorf("Telegram Bot Token 未配置")
 	}
@@ -53,10 +54,10 @@ func NewTelegramClient() (*TelegramClient, error) {
 
 	// 创建聊天 ID 映射
 	chatIDs := make(map[int64]bool)
-	if len(config.AppConfig.Telegram.ChatIDs) == 0 {
+	if len(tgCfg.ChatIDs) == 0 {
 		logrus.Warn("⚠️ 未配置任何聊天 ID，将不会转发任何消息")
 	} else {
-		for _, id := range config.AppConfig.Telegram.ChatIDs {
+		for _, id := range tgCfg.ChatIDs {
 			chatIDs[id] = true
 			logrus.WithField("chat_id", id).Info("➕ 添加监听聊天")
 		}
@@ -227,16 +228,36 @@ func (c *TelegramClient) handleMessage(message *tgbotapi.Message, msgChan chan<-
 	// 创建消息对象
 	msg := models.NewMessage(
 		content,
-		from,
-		message.Chat.ID,
-		message.Chat.Title,
+		models.Sender{
+			ID:          message.From.ID,
+			Username:    message.From.UserName,
+			DisplayName: strings.TrimSpace(message.From.FirstName + " " + message.From.LastName),
+		},
+		models.Chat{
+			ID:    message.Chat.ID,
+			Title: message.Chat.Title,
+			Type:  message.Chat.Type,
+		},
 	)
+	msg.MessageID = message.MessageID
+	switch msgType := getMessageType(message); msgType {
+	case models.MessageTypeText, models.MessageTypePhoto, models.MessageTypeDocument,
+		models.MessageTypeVideo, models.MessageTypeAudio:
+		msg.MessageType = msgType
+	default:
+		msg.MessageType = models.MessageTypeOther
+	}
+	if fileURL != "" {
+		// 媒体消息的文件地址作为附件，正文只保留说明文字
+		msg.Text = utils.SanitizeMessage(message.Caption)
+		msg.Attachments = []models.Attachment{{Kind: msg.MessageType, URL: fileURL}}
+	}
 
 	logrus.WithFields(logrus.Fields{
 		"message_id": msg.ID,
-		"chat_id":   msg.ChatID,
-		"from":      msg.From,
-		"content":   msg.Content,
+		"chat_id":   msg.Chat.ID,
+		"from":      msg.Sender.Name(),
+		"content":   msg.Text,
 		"file_url":  fileURL,
 	}).Info("✅ 消息已确认，准备转发")
 
@@ -245,12 +266,12 @@ func (c *TelegramClient) handleMessage(message *tgbotapi.Message, msgChan chan<-
 	case msgChan <- msg:
 		logrus.WithFields(logrus.Fields{
 			"message_id": msg.ID,
-			"chat_id":   msg.ChatID,
+			"chat_id":   msg.Chat.ID,
 		}).Debug("消息已加入处理队列")
 	default:
 		logrus.WithFields(logrus.Fields{
 			"message_id": msg.ID,
-			"chat_id":   msg.ChatID,
+			"chat_id":   msg.Chat.ID,
 		}).Warn("消息通道已满，消息可能丢失")
 	}
 }
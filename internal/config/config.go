@@ -1,27 +1,40 @@
 package config
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
+// DefaultConfigPath 默认配置文件路径
+const DefaultConfigPath = "/etc/tg-forward/config.yaml"
+
 // GetConfigPath 获取配置文件路径
 func GetConfigPath() string {
-	// 首先检查当前目录
+	// 1. 检查环境变量
+	if envPath := os.Getenv("TG_FORWARD_CONFIG"); envPath != "" {
+		return envPath
+	}
+
+	// 2. 检查当前目录的 env.yaml
 	if _, err := os.Stat("env.yaml"); err == nil {
 		return "env.yaml"
 	}
 
-	// 然后检查 /etc/tg-forward/env.yaml
+	// 3. 检查 /etc/tg-forward/env.yaml
 	if _, err := os.Stat("/etc/tg-forward/env.yaml"); err == nil {
 		return "/etc/tg-forward/env.yaml"
 	}
 
-	// 最后检查用户主目录
+	// 4. 检查用户主目录的 env.yaml
 	home, err := os.UserHomeDir()
 	if err == nil {
 		configPath := filepath.Join(home, ".tg-forward", "env.yaml")
@@ -30,7 +43,17 @@ func GetConfigPath() string {
 		}
 	}
 
-	// 如果都找不到，返回默认路径
+	// 5. 如果找不到 env.yaml，则检查 config.yaml
+	if _, err := os.Stat("config.yaml"); err == nil {
+		return "config.yaml"
+	}
+
+	// 6. 检查默认路径的 config.yaml
+	if _, err := os.Stat(DefaultConfigPath); err == nil {
+		return DefaultConfigPath
+	}
+
+	// 7. 如果都找不到，返回默认的 env.yaml 路径
 	return "env.yaml"
 }
 
@@ -46,11 +69,17 @@ type Config struct {
 	S3       *S3Config       `mapstructure:"s3"`
 	Bark     *BarkConfig     `mapstructure:"bark"`
 	Harmony  *HarmonyConfig  `mapstructure:"harmony"`  // HarmonyOS_MeoW 配置
+	Sinks    []*SinkConfig   `mapstructure:"sinks"`    // 通用投递目标列表
+	Routes   []*RouteConfig  `mapstructure:"routes"`   // 转发路由列表
+	API      *APIConfig      `mapstructure:"api"`      // HTTP API 配置
+	ChatHistory *ChatHistoryConfig `mapstructure:"chat_history"` // 聊天记录配置
+	Encryption  *EncryptionConfig  `mapstructure:"encryption"`   // 聊天记录和重试队列的静态加密
+	Backup      *BackupConfig      `mapstructure:"backup"`       // 在线备份
 }
 
 // TelegramConfig Telegram 配置
 type TelegramConfig struct {
-	Token   string  `mapstructure:"token"`    // Bot Token
+	Token   string  `mapstructure:"token" secret:"true"` // Bot Token
 	ChatIDs []int64 `mapstructure:"chat_ids"` // 要监听的聊天ID列表
 }
 
@@ -65,8 +94,8 @@ type LogConfig struct {
 // DingTalkConfig 钉钉机器人配置
 type DingTalkConfig struct {
 	Enabled       bool     `mapstructure:"enabled"`       // 是否启用钉钉通知
-	WebhookURL    string   `mapstructure:"webhook_url"`   // Webhook URL
-	Secret        string   `mapstructure:"secret"`        // 签名密钥
+	WebhookURL    string   `mapstructure:"webhook_url" secret:"true"` // Webhook URL
+	Secret        string   `mapstructure:"secret" secret:"true"`      // 签名密钥
 	EnableAt      bool     `mapstructure:"enable_at"`     // 是否启用 @ 功能
 	AtMobiles     []string `mapstructure:"at_mobiles"`    // 需要 @ 的手机号列表
 	IsAtAll       bool     `mapstructure:"is_at_all"`     // 是否 @ 所有人
@@ -76,12 +105,15 @@ type DingTalkConfig struct {
 // FeishuConfig 飞书机器人配置
 type FeishuConfig struct {
 	Enabled       bool     `mapstructure:"enabled"`       // 是否启用飞书通知
-	WebhookURL    string   `mapstructure:"webhook_url"`   // Webhook URL
-	Secret        string   `mapstructure:"secret"`        // 签名密钥
+	WebhookURL    string   `mapstructure:"webhook_url" secret:"true"` // Webhook URL
+	Secret        string   `mapstructure:"secret" secret:"true"`      // 签名密钥
 	EnableAt      bool     `mapstructure:"enable_at"`     // 是否启用 @ 功能
 	AtUserIDs     []string `mapstructure:"at_user_ids"`   // 需要 @ 的用户ID列表
 	IsAtAll       bool     `mapstructure:"is_at_all"`     // 是否 @ 所有人
 	NotifyVerbose bool     `mapstructure:"notify_verbose"`// 是否显示详细信息
+	MsgType       string   `mapstructure:"msg_type"`      // 消息类型：interactive（卡片）或 post（富文本），默认 interactive
+	AppID         string   `mapstructure:"app_id"`        // 自建应用 App ID，配置后图片将上传到飞书
+	AppSecret     string   `mapstructure:"app_secret" secret:"true"` // 自建应用 App Secret
 }
 
 // QueueConfig 队列配置
@@ -110,11 +142,132 @@ type HTTPConfig struct {
 	Port       int    `mapstructure:"port"`        // HTTP 服务端口
 	Path       string `mapstructure:"path"`        // 指标 API 路径
 	Auth       bool   `mapstructure:"auth"`        // 是否启用认证
-	APIKey     string `mapstructure:"api_key"`     // API Key
+	APIKey     string `mapstructure:"api_key" secret:"true"` // API Key
 	HeaderName string `mapstructure:"header_name"` // API Key 请求头名称
 	TLS        *TLSConfig `mapstructure:"tls"`     // TLS 配置
 }
 
+// APIConfig HTTP API 配置
+type APIConfig struct {
+	Auth *APIAuthConfig `mapstructure:"auth"` // 认证配置
+}
+
+// APIAuthConfig HTTP API 认证配置
+type APIAuthConfig struct {
+	Enabled    bool            `mapstructure:"enabled"`     // 是否启用认证
+	Insecure   bool            `mapstructure:"insecure"`    // 未启用认证时允许其他主机匿名访问，默认只允许本机访问
+	HeaderName string          `mapstructure:"header_name"` // API Key 请求头名称，也可使用 Authorization: Bearer
+	AuditLog   string          `mapstructure:"audit_log"`   // 审计日志文件路径，为空时写入服务日志
+	Keys       []*APIKeyConfig `mapstructure:"keys"`        // API Key 列表
+}
+
+// APIKeyConfig API Key 配置，只保存密钥的哈希值
+type APIKeyConfig struct {
+	Name    string   `mapstructure:"name"`     // 名称，记录在审计日志中
+	Hash    string   `mapstructure:"hash"`     // 密钥哈希，格式为 sha256:<hex>，由 tgforward apikey generate 生成
+	Scopes  []string `mapstructure:"scopes"`   // 权限范围
+	ChatIDs []int64  `mapstructure:"chat_ids"` // 允许访问的群组，为空表示不限制
+}
+
+// ChatHistoryConfig 聊天记录配置
+type ChatHistoryConfig struct {
+	Retention *RetentionConfig `mapstructure:"retention"` // 保留策略
+	Export    *ExportConfig    `mapstructure:"export"`    // 异步导出任务
+}
+
+// ExportConfig 异步导出任务配置，导出结果保存在本地目录或 S3，通过有时效的链接下载
+type ExportConfig struct {
+	Storage       string `mapstructure:"storage"`        // 导出结果的存储位置：local 或 s3，默认 local
+	Dir           string `mapstructure:"dir"`            // 本地存储目录，默认为 <queue.path>/exports
+	PublicURL     string `mapstructure:"public_url"`     // 本地下载链接的外部访问地址，例如 https://tg.example.com，为空时返回相对路径
+	MaxConcurrent int    `mapstructure:"max_concurrent"` // 同时运行的任务数，默认 2，修改后需要重启
+	LinkTTL       int    `mapstructure:"link_ttl"`       // 下载链接有效期（秒），默认 86400，过期后删除导出文件和任务记录
+	NotifySink    string `mapstructure:"notify_sink"`    // 任务结束后通知的投递目标，创建任务时可以覆盖
+}
+
+// EncryptionConfig 静态加密配置，聊天记录和 LevelDB 重试队列中的值使用 AES-256-GCM 加密
+// 每个数据库有独立的数据密钥，数据密钥由主密钥加密后保存在数据库中
+type EncryptionConfig struct {
+	Enabled      bool     `mapstructure:"enabled"`                     // 是否启用加密，启用后首次启动时加密已有的明文数据
+	Key          string   `mapstructure:"key" secret:"true"`           // 主密钥，32 字节的 base64 或十六进制，建议使用 key_file 或 TGFWD_ENCRYPTION_KEY 提供
+	PreviousKeys []string `mapstructure:"previous_keys" secret:"true"` // 轮换前的主密钥，启动时用于解开旧的数据密钥并改用新主密钥保存
+}
+
+// MasterKeys 解析当前主密钥和轮换前的主密钥
+func (e *EncryptionConfig) MasterKeys() ([]byte, [][]byte, error) {
+	current, err := decodeKey(e.Key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encryption.key %w", err)
+	}
+	var previous [][]byte
+	for i, k := range e.PreviousKeys {
+		key, err := decodeKey(k)
+		if err != nil {
+			return nil, nil, fmt.Errorf("encryption.previous_keys[%d] %w", i, err)
+		}
+		previous = append(previous, key)
+	}
+	return current, previous, nil
+}
+
+// decodeKey 解析 base64 或十六进制编码的 32 字节密钥
+func decodeKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, fmt.Errorf("不能为空")
+	}
+	if key, err := hex.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, fmt.Errorf("必须是 32 字节的 base64 或十六进制编码，可使用 tgforward encryption keygen 生成")
+}
+
+// BackupConfig 在线备份配置，聊天记录和 LevelDB 重试队列的快照打包为 tar.zst
+type BackupConfig struct {
+	Enabled  bool   `mapstructure:"enabled"`  // 是否启用定时备份，手动备份不受影响
+	Interval int    `mapstructure:"interval"` // 定时备份间隔（秒），默认 86400
+	Dir      string `mapstructure:"dir"`      // 备份文件目录，默认为 <queue.path>/backups
+	Keep     int    `mapstructure:"keep"`     // 本地保留的最近备份数量，0 表示不删除旧备份
+	Upload   bool   `mapstructure:"upload"`   // 是否上传到 s3.bucket
+	Prefix   string `mapstructure:"prefix"`   // S3 对象名称前缀，默认 backups/
+}
+
+// RetentionConfig 聊天记录保留策略，过期的记录由后台任务定期删除
+type RetentionConfig struct {
+	Enabled     bool                   `mapstructure:"enabled"`      // 是否启用自动清理
+	Interval    int                    `mapstructure:"interval"`     // 清理间隔（秒），默认 3600
+	MaxAgeDays  int                    `mapstructure:"max_age_days"` // 默认保留天数，0 表示不按时间清理
+	MaxMessages int                    `mapstructure:"max_messages"` // 默认每个群组保留的最新消息数，0 表示不限制
+	Chats       []*ChatRetentionConfig `mapstructure:"chats"`        // 按群组覆盖默认策略
+	LegalHold   []int64                `mapstructure:"legal_hold"`   // 法律保全的群组，不做任何清理
+	DeleteMedia bool                   `mapstructure:"delete_media"` // 是否同时删除 S3 上的媒体文件
+}
+
+// ChatRetentionConfig 单个群组的保留策略
+type ChatRetentionConfig struct {
+	ChatID      int64 `mapstructure:"chat_id"`      // 群组ID
+	MaxAgeDays  int   `mapstructure:"max_age_days"` // 保留天数，0 表示不按时间清理
+	MaxMessages int   `mapstructure:"max_messages"` // 保留的最新消息数，0 表示不限制
+}
+
+// Policy 返回群组生效的保留策略，第二个返回值为 false 表示该群组处于法律保全中
+func (r *RetentionConfig) Policy(chatID int64) (ChatRetentionConfig, bool) {
+	for _, id := range r.LegalHold {
+		if id == chatID {
+			return ChatRetentionConfig{ChatID: chatID}, false
+		}
+	}
+	for _, c := range r.Chats {
+		if c != nil && c.ChatID == chatID {
+			return *c, true
+		}
+	}
+	return ChatRetentionConfig{ChatID: chatID, MaxAgeDays: r.MaxAgeDays, MaxMessages: r.MaxMessages}, true
+}
+
 // TLSConfig TLS 配置
 type TLSConfig struct {
 	Enabled    bool   `mapstructure:"enabled"`     // 是否启用 HTTPS
@@ -129,8 +282,8 @@ type S3Config struct {
 	Endpoint        string `mapstructure:"endpoint"`         // S3 端点
 	Region          string `mapstructure:"region"`           // 区域
 	Bucket          string `mapstructure:"bucket"`           // 存储桶名称
-	AccessKeyID     string `mapstructure:"access_key_id"`    // 访问密钥 ID
-	SecretAccessKey string `mapstructure:"secret_access_key"`// 访问密钥
+	AccessKeyID     string `mapstructure:"access_key_id" secret:"true"` // 访问密钥 ID
+	SecretAccessKey string `mapstructure:"secret_access_key" secret:"true"` // 访问密钥
 	UseSSL          bool   `mapstructure:"use_ssl"`         // 是否使用 SSL
 	PublicBaseURL   string `mapstructure:"public_base_url"` // 公共访问基础 URL
 }
@@ -138,7 +291,7 @@ type S3Config struct {
 // BarkConfig Bark 通知配置
 type BarkConfig struct {
 	Enabled bool     `mapstructure:"enabled"`    // 是否启用 Bark 通知
-	Keys    []string `mapstructure:"keys"`       // Bark 设备密钥列表
+	Keys    []string `mapstructure:"keys" secret:"true"` // Bark 设备密钥列表
 	Sound   string   `mapstructure:"sound"`      // 通知声音
 	Icon    string   `mapstructure:"icon"`       // 通知图标
 }
@@ -146,45 +299,167 @@ type BarkConfig struct {
 // HarmonyConfig HarmonyOS_MeoW 通知配置
 type HarmonyConfig struct {
 	Enabled  bool     `mapstructure:"enabled"`   // 是否启用
-	UserIDs  []string `mapstructure:"user_ids"`  // 用户ID列表
+	UserIDs  []string `mapstructure:"user_ids" secret:"true"` // 用户ID列表
 	BaseURL  string   `mapstructure:"base_url"`  // API基础URL，默认为 https://api.chuckfang.com
+	Method   string   `mapstructure:"method"`    // 请求方式：auto、post 或 get，默认 auto
+}
+
+// SinkConfig 投递目标配置
+type SinkConfig struct {
+	Name     string              `mapstructure:"name"`     // 投递目标名称，供路由引用
+	Type     string              `mapstructure:"type"`     // 投递目标类型，例如 telegram
+	Enabled  bool                `mapstructure:"enabled"`  // 是否启用
+	Telegram   *TelegramSinkConfig `mapstructure:"telegram"`   // Telegram 转发配置
+	Matrix     *MatrixSinkConfig   `mapstructure:"matrix"`     // Matrix 配置
+	Mattermost *WebhookSinkConfig  `mapstructure:"mattermost"` // Mattermost 传入 Webhook 配置
+	RocketChat *WebhookSinkConfig  `mapstructure:"rocketchat"` // Rocket.Chat 传入 Webhook 配置
+	MQTT       *MQTTSinkConfig     `mapstructure:"mqtt"`       // MQTT 发布配置
+	Redis      *RedisSinkConfig    `mapstructure:"redis"`      // Redis Stream 发布配置
+	File       *FileSinkConfig     `mapstructure:"file"`       // JSON Lines 文件归档配置
+}
+
+// TelegramSinkConfig Telegram 转发目标配置
+type TelegramSinkConfig struct {
+	Token               string `mapstructure:"token" secret:"true"`  // Bot Token，为空时使用 telegram.token
+	ChatID              int64  `mapstructure:"chat_id"`              // 目标聊天ID
+	Mode                string `mapstructure:"mode"`                 // 转发方式：forward、copy 或 render，默认 copy
+	DisableNotification bool   `mapstructure:"disable_notification"` // 是否静默发送
+}
+
+// MatrixSinkConfig Matrix 投递目标配置
+type MatrixSinkConfig struct {
+	HomeserverURL string `mapstructure:"homeserver_url"` // Homeserver 地址，例如 https://matrix.example.com
+	AccessToken   string `mapstructure:"access_token" secret:"true"` // 访问令牌
+	RoomID        string `mapstructure:"room_id"`        // 房间ID，例如 !abc:example.com
+	UploadMedia   bool   `mapstructure:"upload_media"`   // 是否将图片上传到 Homeserver 后以 m.image 发送
 }
 
-// AppConfig 全局配置实例
+// WebhookSinkConfig 传入 Webhook 类投递目标配置
+type WebhookSinkConfig struct {
+	WebhookURL string `mapstructure:"webhook_url" secret:"true"` // 传入 Webhook URL
+	Channel    string `mapstructure:"channel"`     // 覆盖默认频道，可选
+	Username   string `mapstructure:"username"`    // 显示的发送者名称，可选
+	IconURL    string `mapstructure:"icon_url"`    // 显示的头像 URL，可选
+	Secret     string `mapstructure:"secret" secret:"true"` // 签名密钥，可选，设置后请求带 HMAC-SHA256 签名头
+}
+
+// MQTTSinkConfig MQTT 发布配置
+type MQTTSinkConfig struct {
+	Broker   string `mapstructure:"broker"`    // Broker 地址，例如 tcp://127.0.0.1:1883
+	ClientID string `mapstructure:"client_id"` // 客户端ID，为空时自动生成
+	Username string `mapstructure:"username"`  // 用户名，可选
+	Password string `mapstructure:"password" secret:"true"` // 密码，可选
+	Topic    string `mapstructure:"topic"`     // 主题模板，支持 {{.ChatID}}、{{.ChatTitle}}
+	QoS      *byte  `mapstructure:"qos"`       // 服务质量等级，默认 1
+	Retained bool   `mapstructure:"retained"`  // 是否保留消息
+}
+
+// RedisSinkConfig Redis Stream 发布配置
+type RedisSinkConfig struct {
+	Addr         string `mapstructure:"addr"`          // Redis 地址，例如 127.0.0.1:6379
+	Password     string `mapstructure:"password" secret:"true"` // 密码，可选
+	DB           int    `mapstructure:"db"`            // 数据库编号
+	Stream       string `mapstructure:"stream"`        // Stream 名称模板，支持 {{.ChatID}}、{{.ChatTitle}}
+	MaxLen       int64  `mapstructure:"max_len"`       // Stream 最大长度，0 表示不限制
+	ApproxMaxLen bool   `mapstructure:"approx_max_len"` // 是否使用近似裁剪（MAXLEN ~），性能更好
+}
+
+// FileSinkConfig JSON Lines 文件归档配置
+type FileSinkConfig struct {
+	Dir          string `mapstructure:"dir"`           // 归档目录，按 <聊天ID>/<日期>.jsonl 存放
+	Gzip         bool   `mapstructure:"gzip"`          // 轮转后是否压缩为 .jsonl.gz
+	Durability   string `mapstructure:"durability"`    // 落盘策略：none、interval 或 always，默认 interval
+	SyncInterval int    `mapstructure:"sync_interval"` // interval 策略的 fsync 间隔（秒），默认 1
+}
+
+// RouteConfig 转发路由配置
+type RouteConfig struct {
+	Name    string        `mapstructure:"name"`     // 路由名称
+	ChatIDs []int64       `mapstructure:"chat_ids"` // 源聊天ID列表，为空表示所有监听的聊天
+	Sinks   []string      `mapstructure:"sinks"`    // 投递目标名称列表
+	Redact  *RedactConfig `mapstructure:"redact"`   // 脱敏配置
+}
+
+// RedactConfig 脱敏配置
+type RedactConfig struct {
+	HideSender  bool     `mapstructure:"hide_sender"` // 是否隐藏发送者
+	Patterns    []string `mapstructure:"patterns"`    // 需要替换的正则表达式列表
+	Replacement string   `mapstructure:"replacement"` // 替换文本，默认为 ***
+}
+
+// AppConfig 启动时加载的配置，重新加载后不再更新
+// 运行期间读取配置请使用 Current()，该变量只为兼容保留
 var AppConfig Config
 
-// LoadConfig 加载配置文件
+// LoadConfig 加载、补全并校验配置文件，成功后设为当前生效配置，只在启动时调用
 func LoadConfig(configPath string) error {
 	logrus.WithField("path", configPath).Info("正在加载配置文件")
 
-	viper.SetConfigFile(configPath)
-	viper.SetConfigType("yaml")
-
-	if err := viper.ReadInConfig(); err != nil {
-		return fmt.Errorf("读取配置文件失败: %w", err)
-	}
-
-	if err := viper.Unmarshal(&AppConfig); err != nil {
-		return fmt.Errorf("解析配置文件失败: %w", err)
+	cfg, err := Load(configPath)
+	if err != nil {
+		return err
 	}
+	AppConfig = *cfg
+	activate(cfg, configPath, configChecksum(cfg))
 
 	// 打印配置信息（隐藏敏感信息）
 	logrus.WithFields(logrus.Fields{
-		"telegram_token":    maskString(AppConfig.Telegram.Token),
-		"telegram_chat_ids": AppConfig.Telegram.ChatIDs,
-		"dingtalk_webhook": maskString(AppConfig.DingTalk.WebhookURL),
-		"dingtalk_secret":  maskString(AppConfig.DingTalk.Secret),
-		"queue_type":       AppConfig.Queue.Type,
-		"queue_path":       AppConfig.Queue.Path,
+		"telegram_token":    MaskSecret(cfg.Telegram.Token),
+		"telegram_chat_ids": cfg.Telegram.ChatIDs,
+		"dingtalk_webhook": MaskURL(cfg.DingTalk.WebhookURL),
+		"dingtalk_secret":  MaskSecret(cfg.DingTalk.Secret),
+		"queue_type":       cfg.Queue.Type,
+		"queue_path":       cfg.Queue.Path,
 	}).Info("配置加载完成")
 
 	return nil
 }
 
-// maskString 隐藏敏感信息
-func maskString(s string) string {
-	if len(s) <= 8 {
-		return "***"
+// Load 读取配置文件，合并环境变量和密钥文件，填充默认值并校验，不修改全局配置
+// 配置中出现未知的配置项同样视为错误，避免拼写错误被静默忽略
+func Load(configPath string) (*Config, error) {
+	v := viper.New()
+	v.SetConfigFile(configPath)
+	v.SetConfigType("yaml")
+
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %w", err)
+	}
+
+	settings := v.AllSettings()
+	if err := applyOverrides(settings); err != nil {
+		return nil, fmt.Errorf("应用环境变量和密钥文件失败: %w", err)
 	}
-	return s[:4] + "..." + s[len(s)-4:]
-} 
\ No newline at end of file
+
+	// 与 viper.Unmarshal 使用相同的解码规则，列表类型的环境变量按逗号分隔
+	var cfg Config
+	var metadata mapstructure.Metadata
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Metadata:         &metadata,
+		Result:           &cfg,
+		WeaklyTypedInput: true,
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("创建配置解码器失败: %w", err)
+	}
+	if err := decoder.Decode(settings); err != nil {
+		return nil, fmt.Errorf("解析配置文件失败: %w", err)
+	}
+
+	applyDefaults(&cfg)
+
+	errs := cfg.validate()
+	sort.Strings(metadata.Unused)
+	for _, key := range metadata.Unused {
+		errs.add(key, "未知的配置项")
+	}
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
@@ -0,0 +1,141 @@
+package config
+
+// 配置默认值
+const (
+	defaultLogLevel          = "info"
+	defaultQueueType         = "leveldb"
+	defaultQueuePath         = "./data/queue"
+	defaultRetryMaxAttempts  = 3
+	defaultRetryInterval     = 60
+	defaultMetricsInterval   = 60
+	defaultMetricsHTTPPort   = 9090
+	defaultMetricsHTTPPath   = "/metrics"
+	defaultMetricsHeaderName = "X-API-Key"
+	defaultHarmonyBaseURL    = "https://api.chuckfang.com"
+	defaultAPIHeaderName     = "X-API-Key"
+	defaultRetentionInterval = 3600
+	defaultExportStorage     = "local"
+	defaultExportConcurrent  = 2
+	defaultExportLinkTTL     = 86400
+	defaultBackupInterval    = 86400
+	defaultBackupPrefix      = "backups/"
+)
+
+// applyDefaults 补全缺失的配置段和默认值
+// 所有配置段在此之后都不为 nil，调用方无需再做空指针检查
+func applyDefaults(cfg *Config) {
+	if cfg.Telegram == nil {
+		cfg.Telegram = &TelegramConfig{}
+	}
+	if cfg.Log == nil {
+		cfg.Log = &LogConfig{}
+	}
+	if cfg.DingTalk == nil {
+		cfg.DingTalk = &DingTalkConfig{}
+	}
+	if cfg.Feishu == nil {
+		cfg.Feishu = &FeishuConfig{}
+	}
+	if cfg.Queue == nil {
+		cfg.Queue = &QueueConfig{}
+	}
+	if cfg.Retry == nil {
+		cfg.Retry = &RetryConfig{MaxAttempts: defaultRetryMaxAttempts, Interval: defaultRetryInterval}
+	}
+	if cfg.Metrics == nil {
+		cfg.Metrics = &MetricsConfig{}
+	}
+	if cfg.Metrics.HTTP == nil {
+		cfg.Metrics.HTTP = &HTTPConfig{}
+	}
+	if cfg.Metrics.HTTP.TLS == nil {
+		cfg.Metrics.HTTP.TLS = &TLSConfig{}
+	}
+	if cfg.S3 == nil {
+		cfg.S3 = &S3Config{}
+	}
+	if cfg.Bark == nil {
+		cfg.Bark = &BarkConfig{}
+	}
+	if cfg.Harmony == nil {
+		cfg.Harmony = &HarmonyConfig{}
+	}
+	if cfg.API == nil {
+		cfg.API = &APIConfig{}
+	}
+	if cfg.API.Auth == nil {
+		cfg.API.Auth = &APIAuthConfig{}
+	}
+	if cfg.ChatHistory == nil {
+		cfg.ChatHistory = &ChatHistoryConfig{}
+	}
+	if cfg.ChatHistory.Retention == nil {
+		cfg.ChatHistory.Retention = &RetentionConfig{}
+	}
+	if cfg.ChatHistory.Export == nil {
+		cfg.ChatHistory.Export = &ExportConfig{}
+	}
+	if cfg.Encryption == nil {
+		cfg.Encryption = &EncryptionConfig{}
+	}
+	if cfg.Backup == nil {
+		cfg.Backup = &BackupConfig{}
+	}
+
+	if cfg.Log.Level == "" {
+		cfg.Log.Level = defaultLogLevel
+	}
+
+	if cfg.Queue.Type == "" {
+		cfg.Queue.Type = defaultQueueType
+	}
+	if cfg.Queue.Type == "leveldb" && cfg.Queue.Path == "" {
+		cfg.Queue.Path = defaultQueuePath
+	}
+
+	if cfg.Retry.Interval == 0 {
+		cfg.Retry.Interval = defaultRetryInterval
+	}
+
+	if cfg.Metrics.Interval == 0 {
+		cfg.Metrics.Interval = defaultMetricsInterval
+	}
+	if cfg.Metrics.HTTP.Port == 0 {
+		cfg.Metrics.HTTP.Port = defaultMetricsHTTPPort
+	}
+	if cfg.Metrics.HTTP.Path == "" {
+		cfg.Metrics.HTTP.Path = defaultMetricsHTTPPath
+	}
+	if cfg.Metrics.HTTP.HeaderName == "" {
+		cfg.Metrics.HTTP.HeaderName = defaultMetricsHeaderName
+	}
+
+	if cfg.Harmony.BaseURL == "" {
+		cfg.Harmony.BaseURL = defaultHarmonyBaseURL
+	}
+
+	if cfg.API.Auth.HeaderName == "" {
+		cfg.API.Auth.HeaderName = defaultAPIHeaderName
+	}
+
+	if cfg.ChatHistory.Retention.Interval == 0 {
+		cfg.ChatHistory.Retention.Interval = defaultRetentionInterval
+	}
+
+	if cfg.ChatHistory.Export.Storage == "" {
+		cfg.ChatHistory.Export.Storage = defaultExportStorage
+	}
+	if cfg.ChatHistory.Export.MaxConcurrent == 0 {
+		cfg.ChatHistory.Export.MaxConcurrent = defaultExportConcurrent
+	}
+	if cfg.ChatHistory.Export.LinkTTL == 0 {
+		cfg.ChatHistory.Export.LinkTTL = defaultExportLinkTTL
+	}
+
+	if cfg.Backup.Interval == 0 {
+		cfg.Backup.Interval = defaultBackupInterval
+	}
+	if cfg.Backup.Prefix == "" {
+		cfg.Backup.Prefix = defaultBackupPrefix
+	}
+}
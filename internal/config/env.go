@@ -0,0 +1,112 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+const (
+	// envPrefix 环境变量前缀，例如 TGFWD_TELEGRAM_TOKEN 覆盖 telegram.token
+	envPrefix = "TGFWD"
+	// fileSuffix 从文件读取配置值的后缀，例如 token_file 或 TGFWD_TELEGRAM_TOKEN_FILE
+	fileSuffix = "_file"
+)
+
+// applyOverrides 在解码前把环境变量和 *_file 引用合并到原始配置中
+// 优先级从高到低：TGFWD_<KEY>、TGFWD_<KEY>_FILE、配置文件中的 <key>_file、配置文件中的 <key>
+// 列表中的元素按下标覆盖，例如 TGFWD_SINKS_0_MATRIX_ACCESS_TOKEN
+func applyOverrides(settings map[string]interface{}) error {
+	return overrideStruct(settings, reflect.TypeOf(Config{}), envPrefix, "")
+}
+
+// overrideStruct 按结构体字段的 mapstructure 标签遍历配置段
+func overrideStruct(m map[string]interface{}, t reflect.Type, envName, keyPath string) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("mapstructure")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		env := envName + "_" + strings.ToUpper(tag)
+		key := tag
+		if keyPath != "" {
+			key = keyPath + "." + tag
+		}
+
+		ft := field.Type
+		switch {
+		case ft.Kind() == reflect.Ptr && ft.Elem().Kind() == reflect.Struct:
+			// 配置文件中缺少的配置段也可以完全由环境变量提供
+			sub, ok := m[tag].(map[string]interface{})
+			if !ok {
+				sub = make(map[string]interface{})
+			}
+			if err := overrideStruct(sub, ft.Elem(), env, key); err != nil {
+				return err
+			}
+			if ok || len(sub) > 0 {
+				m[tag] = sub
+			}
+
+		case ft.Kind() == reflect.Slice && ft.Elem().Kind() == reflect.Ptr && ft.Elem().Elem().Kind() == reflect.Struct:
+			items, _ := m[tag].([]interface{})
+			for idx, item := range items {
+				sub, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				itemEnv := fmt.Sprintf("%s_%d", env, idx)
+				itemKey := fmt.Sprintf("%s[%d]", key, idx)
+				if err := overrideStruct(sub, ft.Elem().Elem(), itemEnv, itemKey); err != nil {
+					return err
+				}
+			}
+
+		default:
+			if err := overrideValue(m, tag, env, key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// overrideValue 覆盖单个配置项，列表类型的环境变量使用逗号分隔
+func overrideValue(m map[string]interface{}, tag, env, key string) error {
+	fileKey := tag + fileSuffix
+	if path, ok := m[fileKey]; ok {
+		delete(m, fileKey)
+		if _, exists := m[tag]; exists {
+			return fmt.Errorf("%s 和 %s 不能同时配置", key, key+fileSuffix)
+		}
+		value, err := readSecretFile(fmt.Sprint(path))
+		if err != nil {
+			return fmt.Errorf("读取 %s 失败: %w", key+fileSuffix, err)
+		}
+		m[tag] = value
+	}
+
+	if path, ok := os.LookupEnv(env + strings.ToUpper(fileSuffix)); ok {
+		value, err := readSecretFile(path)
+		if err != nil {
+			return fmt.Errorf("读取 %s 失败: %w", env+strings.ToUpper(fileSuffix), err)
+		}
+		m[tag] = value
+	}
+
+	if value, ok := os.LookupEnv(env); ok {
+		m[tag] = value
+	}
+	return nil
+}
+
+// readSecretFile 读取密钥文件，去掉末尾的换行符
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
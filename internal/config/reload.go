@@ -0,0 +1,174 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// 文件变化后等待的时间，编辑器保存时通常会连续触发多次事件
+const watchDebounce = 500 * time.Millisecond
+
+// VersionInfo 当前生效配置的版本信息
+type VersionInfo struct {
+	Version  int64     `json:"version"`   // 配置版本号，每次成功加载后递增
+	Checksum string    `json:"checksum"`  // 生效配置的 SHA-256 摘要（前 12 位），包含环境变量和密钥文件
+	Path     string    `json:"path"`      // 配置文件路径
+	LoadedAt time.Time `json:"loaded_at"` // 加载时间
+}
+
+// ReloadListener 配置重新加载后的回调，返回错误表示新配置未能完全生效
+type ReloadListener func(oldCfg, newCfg *Config) error
+
+var (
+	stateMutex  sync.RWMutex
+	current     *Config
+	versionInfo VersionInfo
+	listeners   []ReloadListener
+	// reloadMutex 保证同一时间只有一次重新加载
+	reloadMutex sync.Mutex
+)
+
+// Current 返回当前生效配置的快照，运行期间读取配置应使用该函数而不是 AppConfig
+func Current() *Config {
+	stateMutex.RLock()
+	defer stateMutex.RUnlock()
+	if current == nil {
+		return &AppConfig
+	}
+	return current
+}
+
+// CurrentVersion 返回当前生效配置的版本信息
+func CurrentVersion() VersionInfo {
+	stateMutex.RLock()
+	defer stateMutex.RUnlock()
+	return versionInfo
+}
+
+// OnReload 注册配置重新加载后的回调
+func OnReload(listener ReloadListener) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	listeners = append(listeners, listener)
+}
+
+// activate 将配置设为当前生效配置
+func activate(cfg *Config, path, checksum string) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	current = cfg
+	registerSecrets(cfg)
+	versionInfo = VersionInfo{
+		Version:  versionInfo.Version + 1,
+		Checksum: checksum,
+		Path:     path,
+		LoadedAt: time.Now(),
+	}
+}
+
+// Reload 重新读取配置文件、环境变量和密钥文件，校验通过后替换当前配置并通知监听者
+// 校验失败时保留原配置；内容未变化时不做任何处理
+func Reload() error {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	path := CurrentVersion().Path
+	newCfg, err := Load(path)
+	if err != nil {
+		return fmt.Errorf("新配置未生效，继续使用版本 %d: %w", CurrentVersion().Version, err)
+	}
+
+	// 密钥文件轮换后发送 SIGHUP 同样会生效，因此按解析后的配置计算摘要
+	checksum := configChecksum(newCfg)
+	if checksum == CurrentVersion().Checksum {
+		logrus.WithField("path", path).Debug("配置内容未变化，跳过重新加载")
+		return nil
+	}
+
+	oldCfg := Current()
+	warnRestartRequired(oldCfg, newCfg)
+	activate(newCfg, path, checksum)
+
+	stateMutex.RLock()
+	callbacks := append([]ReloadListener(nil), listeners...)
+	stateMutex.RUnlock()
+
+	var lastErr error
+	for _, listener := range callbacks {
+		if err := listener(oldCfg, newCfg); err != nil {
+			logrus.Errorf("应用新配置失败: %v", err)
+			lastErr = err
+		}
+	}
+
+	info := CurrentVersion()
+	logrus.WithFields(logrus.Fields{
+		"version":  info.Version,
+		"checksum": info.Checksum,
+	}).Info("配置已重新加载")
+	return lastErr
+}
+
+// Watch 监听配置文件变化并自动重新加载
+func Watch() {
+	path := CurrentVersion().Path
+
+	var mutex sync.Mutex
+	var timer *time.Timer
+
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.OnConfigChange(func(e fsnotify.Event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if timer != nil {
+			timer.Stop()
+		}
+		timer = time.AfterFunc(watchDebounce, func() {
+			logrus.WithField("path", e.Name).Info("检测到配置文件变化，正在重新加载")
+			if err := Reload(); err != nil {
+				logrus.Errorf("重新加载配置失败: %v", err)
+			}
+		})
+	})
+	v.WatchConfig()
+
+	logrus.WithField("path", path).Info("已开始监听配置文件变化")
+}
+
+// warnRestartRequired 提示只能在重启后生效的配置项
+func warnRestartRequired(oldCfg, newCfg *Config) {
+	checks := []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"telegram.token", oldCfg.Telegram.Token, newCfg.Telegram.Token},
+		{"queue", oldCfg.Queue, newCfg.Queue},
+		{"log", oldCfg.Log, newCfg.Log},
+		{"metrics", oldCfg.Metrics, newCfg.Metrics},
+		{"retry", oldCfg.Retry, newCfg.Retry},
+		{"encryption", oldCfg.Encryption, newCfg.Encryption},
+	}
+	for _, c := range checks {
+		if !reflect.DeepEqual(c.old, c.new) {
+			logrus.Warnf("配置项 %s 已修改，需要重启服务才能生效", c.name)
+		}
+	}
+}
+
+// configChecksum 计算解析后配置的摘要
+func configChecksum(cfg *Config) string {
+	data, _ := json.Marshal(cfg)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:12]
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 短于该长度的值不作为密钥替换，避免误伤日志中的普通文本
+const minSecretLength = 6
+
+var (
+	secretsMutex   sync.RWMutex
+	knownSecrets   = make(map[string]struct{})
+	secretReplacer *strings.Replacer
+	secretHookOnce sync.Once
+)
+
+// MaskSecret 隐藏敏感信息，只保留首尾各 4 个字符
+func MaskSecret(s string) string {
+	if len(s) <= 8 {
+		return "***"
+	}
+	return s[:4] + "..." + s[len(s)-4:]
+}
+
+// MaskURL 隐藏 URL 中的路径、查询参数和用户信息，只保留协议和主机
+// Webhook 地址的令牌和签名通常位于路径或查询参数中
+func MaskURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return MaskSecret(raw)
+	}
+	if u.Path == "" && u.RawQuery == "" && u.User == nil {
+		return u.Scheme + "://" + u.Host
+	}
+	return u.Scheme + "://" + u.Host + "/***"
+}
+
+// registerSecrets 收集配置中标记为 secret 的字段，日志输出前统一替换
+// 历史配置中的密钥同样保留，重新加载后仍在处理的消息也不会泄露旧密钥
+func registerSecrets(cfg *Config) {
+	secretsMutex.Lock()
+	defer secretsMutex.Unlock()
+
+	collectSecrets(reflect.ValueOf(cfg), false, knownSecrets)
+
+	secrets := make([]string, 0, len(knownSecrets))
+	for secret := range knownSecrets {
+		secrets = append(secrets, secret)
+	}
+	// 较长的密钥优先替换，避免包含关系导致只替换一部分
+	sort.Slice(secrets, func(i, j int) bool {
+		if len(secrets[i]) != len(secrets[j]) {
+			return len(secrets[i]) > len(secrets[j])
+		}
+		return secrets[i] < secrets[j]
+	})
+
+	pairs := make([]string, 0, len(secrets)*2)
+	for _, secret := range secrets {
+		pairs = append(pairs, secret, MaskSecret(secret))
+	}
+	secretReplacer = strings.NewReplacer(pairs...)
+
+	secretHookOnce.Do(func() {
+		logrus.AddHook(secretHook{})
+	})
+}
+
+// collectSecrets 递归查找带有 secret:"true" 标签的字符串字段
+func collectSecrets(v reflect.Value, secret bool, out map[string]struct{}) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			collectSecrets(v.Elem(), secret, out)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			collectSecrets(v.Field(i), t.Field(i).Tag.Get("secret") == "true", out)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			collectSecrets(v.Index(i), secret, out)
+		}
+	case reflect.String:
+		if secret && len(v.String()) >= minSecretLength {
+			out[v.String()] = struct{}{}
+		}
+	}
+}
+
+// RedactSecrets 将文本中出现的已知密钥替换为隐藏后的形式
+func RedactSecrets(s string) string {
+	secretsMutex.RLock()
+	replacer := secretReplacer
+	secretsMutex.RUnlock()
+	if replacer == nil {
+		return s
+	}
+	return replacer.Replace(s)
+}
+
+// secretHook 在日志输出前替换消息和字段中出现的密钥
+type secretHook struct{}
+
+// Levels 实现 logrus.Hook 接口
+func (secretHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire 实现 logrus.Hook 接口
+func (secretHook) Fire(entry *logrus.Entry) error {
+	entry.Message = RedactSecrets(entry.Message)
+	for key, value := range entry.Data {
+		switch v := value.(type) {
+		case string:
+			entry.Data[key] = RedactSecrets(v)
+		case error:
+			entry.Data[key] = RedactSecrets(v.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,565 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 投递目标类型与对应的配置段，同一投递目标只能配置与类型匹配的配置段
+var sinkSections = map[string]func(*SinkConfig) bool{
+	"telegram":   func(c *SinkConfig) bool { return c.Telegram != nil },
+	"matrix":     func(c *SinkConfig) bool { return c.Matrix != nil },
+	"mattermost": func(c *SinkConfig) bool { return c.Mattermost != nil },
+	"rocketchat": func(c *SinkConfig) bool { return c.RocketChat != nil },
+	"mqtt":       func(c *SinkConfig) bool { return c.MQTT != nil },
+	"redis":      func(c *SinkConfig) bool { return c.Redis != nil },
+	"file":       func(c *SinkConfig) bool { return c.File != nil },
+}
+
+// API Key 支持的权限范围，与 internal/auth 中的定义保持一致
+var apiScopes = map[string]bool{
+	"history:read":   true,
+	"history:export": true,
+	"queue:admin":    true,
+	"history:admin":  true,
+	"backup:admin":   true,
+	"send":           true,
+	"metrics:read":   true,
+	"*":              true,
+}
+
+// apiKeyHashPattern API Key 哈希格式
+var apiKeyHashPattern = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// sinkTypes 返回排序后的投递目标类型，保证错误信息顺序稳定
+func sinkTypes() []string {
+	types := make([]string, 0, len(sinkSections))
+	for t := range sinkSections {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// ValidationError 配置校验错误，汇总全部问题后一次性报告
+type ValidationError struct {
+	Problems []string // 问题列表，格式为 "配置项: 原因"
+}
+
+// Error 实现 error 接口
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "配置校验失败，共 %d 个问题:", len(e.Problems))
+	for _, problem := range e.Problems {
+		b.WriteString("\n  - ")
+		b.WriteString(problem)
+	}
+	return b.String()
+}
+
+// validationErrors 收集校验问题
+type validationErrors []string
+
+// add 记录一个问题
+func (v *validationErrors) add(field, format string, args ...interface{}) {
+	*v = append(*v, field+": "+fmt.Sprintf(format, args...))
+}
+
+// err 没有问题时返回 nil
+func (v validationErrors) err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: v}
+}
+
+// Validate 校验配置，返回汇总了所有问题的 *ValidationError
+func (c *Config) Validate() error {
+	return c.validate().err()
+}
+
+// validate 校验配置，调用前需已调用 applyDefaults
+func (c *Config) validate() validationErrors {
+	var errs validationErrors
+
+	// Telegram
+	if c.Telegram.Token == "" {
+		errs.add("telegram.token", "不能为空")
+	}
+	if len(c.Telegram.ChatIDs) == 0 {
+		logrus.Warn("未配置任何 Telegram 聊天 ID，机器人将不会转发任何消息")
+	}
+
+	// 日志
+	if _, err := logrus.ParseLevel(c.Log.Level); err != nil {
+		errs.add("log.level", "无效的日志级别 %q", c.Log.Level)
+	}
+	if c.Log.MaxSize < 0 {
+		errs.add("log.max_size", "不能为负数")
+	}
+	if c.Log.MaxFiles < 0 {
+		errs.add("log.max_files", "不能为负数")
+	}
+
+	// 钉钉
+	if c.DingTalk.Enabled {
+		validateURL(&errs, "dingtalk.webhook_url", c.DingTalk.WebhookURL, true)
+	}
+	if c.DingTalk.EnableAt && c.DingTalk.IsAtAll && len(c.DingTalk.AtMobiles) > 0 {
+		errs.add("dingtalk.is_at_all", "不能与 at_mobiles 同时配置")
+	}
+
+	// 飞书
+	if c.Feishu.Enabled {
+		validateURL(&errs, "feishu.webhook_url", c.Feishu.WebhookURL, true)
+	}
+	switch c.Feishu.MsgType {
+	case "", "interactive", "post":
+	default:
+		errs.add("feishu.msg_type", "不支持的消息类型 %q，支持 interactive、post", c.Feishu.MsgType)
+	}
+	if (c.Feishu.AppID == "") != (c.Feishu.AppSecret == "") {
+		errs.add("feishu.app_id", "app_id 和 app_secret 必须同时配置")
+	}
+	if c.Feishu.EnableAt && c.Feishu.IsAtAll && len(c.Feishu.AtUserIDs) > 0 {
+		errs.add("feishu.is_at_all", "不能与 at_user_ids 同时配置")
+	}
+
+	// Bark
+	if c.Bark.Enabled && len(c.Bark.Keys) == 0 {
+		errs.add("bark.keys", "启用 Bark 时至少配置一个设备密钥")
+	}
+	if c.Bark.Icon != "" {
+		validateURL(&errs, "bark.icon", c.Bark.Icon, false)
+	}
+
+	// HarmonyOS_MeoW
+	if c.Harmony.Enabled && len(c.Harmony.UserIDs) == 0 {
+		errs.add("harmony.user_ids", "启用 HarmonyOS_MeoW 时至少配置一个用户 ID")
+	}
+	validateURL(&errs, "harmony.base_url", c.Harmony.BaseURL, false)
+	switch strings.ToLower(c.Harmony.Method) {
+	case "", "auto", "post", "get":
+	default:
+		errs.add("harmony.method", "不支持的请求方式 %q，支持 auto、post、get", c.Harmony.Method)
+	}
+
+	// 队列
+	switch c.Queue.Type {
+	case "memory":
+	case "leveldb":
+		if c.Queue.Path == "" {
+			errs.add("queue.path", "使用 LevelDB 队列时必须配置存储路径")
+		}
+	default:
+		errs.add("queue.type", "不支持的队列类型 %q，支持 memory、leveldb", c.Queue.Type)
+	}
+
+	// 重试
+	if c.Retry.MaxAttempts < 0 {
+		errs.add("retry.max_attempts", "不能为负数")
+	}
+	if c.Retry.Interval < 0 {
+		errs.add("retry.interval", "不能为负数")
+	}
+
+	// 指标
+	if c.Metrics.Interval < 0 {
+		errs.add("metrics.interval", "不能为负数")
+	}
+	if c.Metrics.HTTP.Enabled {
+		c.validateMetricsHTTP(&errs)
+	}
+
+	// S3
+	if c.S3.Endpoint != "" {
+		if strings.Contains(c.S3.Endpoint, "://") {
+			errs.add("s3.endpoint", "只需填写主机名和端口，不要包含协议，是否使用 HTTPS 由 use_ssl 控制")
+		}
+		if c.S3.Bucket == "" {
+			errs.add("s3.bucket", "配置 S3 端点时不能为空")
+		}
+	}
+	if c.S3.PublicBaseURL != "" {
+		validateURL(&errs, "s3.public_base_url", c.S3.PublicBaseURL, false)
+	}
+
+	// 聊天记录保留策略
+	c.validateRetention(&errs)
+
+	// 静态加密
+	c.validateEncryption(&errs)
+
+	// 在线备份
+	c.validateBackup(&errs)
+
+	// HTTP API 认证
+	c.validateAPIAuth(&errs)
+
+	// 投递目标与路由
+	sinkNames := c.validateSinks(&errs)
+	c.validateRoutes(&errs, sinkNames)
+
+	// 异步导出任务，通知目标需要在投递目标校验之后检查
+	c.validateExport(&errs, sinkNames)
+
+	return errs
+}
+
+// validateMetricsHTTP 校验指标 HTTP 服务配置
+func (c *Config) validateMetricsHTTP(errs *validationErrors) {
+	httpCfg := c.Metrics.HTTP
+	validatePort(errs, "metrics.http.port", httpCfg.Port)
+	if !strings.HasPrefix(httpCfg.Path, "/") {
+		errs.add("metrics.http.path", "必须以 / 开头")
+	}
+	if httpCfg.Auth && httpCfg.APIKey == "" && len(c.API.Auth.Keys) == 0 {
+		errs.add("metrics.http.api_key", "启用认证时不能为空，或在 api.auth.keys 中配置带 metrics:read 权限的 API Key")
+	}
+
+	tls := httpCfg.TLS
+	if !tls.Enabled {
+		if tls.ForceHTTPS {
+			errs.add("metrics.http.tls.force_https", "需要同时启用 tls.enabled")
+		}
+		return
+	}
+	if tls.CertFile == "" {
+		errs.add("metrics.http.tls.cert_file", "启用 HTTPS 时不能为空")
+	}
+	if tls.KeyFile == "" {
+		errs.add("metrics.http.tls.key_file", "启用 HTTPS 时不能为空")
+	}
+	validatePort(errs, "metrics.http.tls.port", tls.Port)
+	if tls.Port == httpCfg.Port {
+		errs.add("metrics.http.tls.port", "不能与 metrics.http.port 相同")
+	}
+}
+
+// validateRetention 校验聊天记录保留策略
+func (c *Config) validateRetention(errs *validationErrors) {
+	r := c.ChatHistory.Retention
+	if r.Interval < 0 {
+		errs.add("chat_history.retention.interval", "不能为负数")
+	}
+	if r.MaxAgeDays < 0 {
+		errs.add("chat_history.retention.max_age_days", "不能为负数")
+	}
+	if r.MaxMessages < 0 {
+		errs.add("chat_history.retention.max_messages", "不能为负数")
+	}
+	if r.DeleteMedia && c.S3.Endpoint == "" {
+		errs.add("chat_history.retention.delete_media", "需要配置 s3.endpoint")
+	}
+
+	chats := make(map[int64]bool)
+	for i, chat := range r.Chats {
+		field := fmt.Sprintf("chat_history.retention.chats[%d]", i)
+		if chat == nil {
+			errs.add(field, "配置为空")
+			continue
+		}
+		if chat.ChatID == 0 {
+			errs.add(field+".chat_id", "不能为空")
+		} else if chats[chat.ChatID] {
+			errs.add(field+".chat_id", "群组 %d 重复配置", chat.ChatID)
+		}
+		chats[chat.ChatID] = true
+		if chat.MaxAgeDays < 0 {
+			errs.add(field+".max_age_days", "不能为负数")
+		}
+		if chat.MaxMessages < 0 {
+			errs.add(field+".max_messages", "不能为负数")
+		}
+	}
+	for _, id := range r.LegalHold {
+		if chats[id] {
+			logrus.Warnf("群组 %d 处于法律保全中，chat_history.retention.chats 中的策略不会生效", id)
+		}
+	}
+}
+
+// validateEncryption 校验静态加密配置，未启用时不检查密钥，便于先配置好密钥再启用
+func (c *Config) validateEncryption(errs *validationErrors) {
+	e := c.Encryption
+	if !e.Enabled {
+		return
+	}
+	if _, err := decodeKey(e.Key); err != nil {
+		errs.add("encryption.key", "%v", err)
+	}
+	for i, k := range e.PreviousKeys {
+		if _, err := decodeKey(k); err != nil {
+			errs.add(fmt.Sprintf("encryption.previous_keys[%d]", i), "%v", err)
+		}
+	}
+}
+
+// validateBackup 校验在线备份配置
+func (c *Config) validateBackup(errs *validationErrors) {
+	b := c.Backup
+	if b.Interval < 0 {
+		errs.add("backup.interval", "不能为负数")
+	}
+	if b.Keep < 0 {
+		errs.add("backup.keep", "不能为负数")
+	}
+	if b.Upload && c.S3.Endpoint == "" {
+		errs.add("backup.upload", "需要配置 s3.endpoint")
+	}
+}
+
+// validateExport 校验异步导出任务配置
+func (c *Config) validateExport(errs *validationErrors, enabledSinks map[string]bool) {
+	e := c.ChatHistory.Export
+	switch e.Storage {
+	case "local":
+	case "s3":
+		if c.S3.Endpoint == "" {
+			errs.add("chat_history.export.storage", "使用 s3 时需要配置 s3.endpoint")
+		}
+		// S3 预签名链接最长有效 7 天
+		if e.LinkTTL > 7*24*3600 {
+			errs.add("chat_history.export.link_ttl", "使用 s3 时不能超过 604800（7 天）")
+		}
+	default:
+		errs.add("chat_history.export.storage", "不支持 %q，支持 local、s3", e.Storage)
+	}
+	validateURL(errs, "chat_history.export.public_url", e.PublicURL, false)
+	if e.MaxConcurrent < 0 {
+		errs.add("chat_history.export.max_concurrent", "不能为负数")
+	}
+	if e.LinkTTL < 0 {
+		errs.add("chat_history.export.link_ttl", "不能为负数")
+	}
+	if e.NotifySink != "" && !enabledSinks[e.NotifySink] {
+		errs.add("chat_history.export.notify_sink", "投递目标 %s 不存在或未启用", e.NotifySink)
+	}
+}
+
+// validateAPIAuth 校验 HTTP API 认证配置
+func (c *Config) validateAPIAuth(errs *validationErrors) {
+	authCfg := c.API.Auth
+	if authCfg.Enabled && len(authCfg.Keys) == 0 {
+		logrus.Warn("已启用 API 认证但未配置任何 API Key，所有 API 请求都将被拒绝")
+	}
+
+	names := make(map[string]bool)
+	for i, key := range authCfg.Keys {
+		field := fmt.Sprintf("api.auth.keys[%d]", i)
+		if key == nil {
+			errs.add(field, "配置为空")
+			continue
+		}
+		if key.Name == "" {
+			errs.add(field+".name", "不能为空")
+		} else {
+			field = fmt.Sprintf("api.auth.keys[%s]", key.Name)
+			if names[key.Name] {
+				errs.add(field+".name", "名称重复")
+			}
+			names[key.Name] = true
+		}
+		if !apiKeyHashPattern.MatchString(key.Hash) {
+			errs.add(field+".hash", "格式应为 sha256:<64 位十六进制>，请使用 tgforward apikey generate 生成")
+		}
+		if len(key.Scopes) == 0 {
+			errs.add(field+".scopes", "至少配置一个权限范围")
+		}
+		for _, scope := range key.Scopes {
+			if !apiScopes[scope] {
+				errs.add(field+".scopes", "不支持的权限范围 %q，支持 history:read、history:export、history:admin、backup:admin、queue:admin、send、metrics:read、*", scope)
+			}
+		}
+	}
+}
+
+// validateSinks 校验投递目标配置，返回已启用的投递目标名称
+func (c *Config) validateSinks(errs *validationErrors) map[string]bool {
+	names := make(map[string]bool)
+	enabled := make(map[string]bool)
+
+	for i, s := range c.Sinks {
+		field := fmt.Sprintf("sinks[%d]", i)
+		if s == nil {
+			errs.add(field, "配置为空")
+			continue
+		}
+		if s.Name == "" {
+			errs.add(field+".name", "不能为空")
+		} else {
+			field = fmt.Sprintf("sinks[%s]", s.Name)
+			if names[s.Name] {
+				errs.add(field+".name", "名称重复")
+			}
+			names[s.Name] = true
+		}
+		if s.Enabled {
+			enabled[s.Name] = true
+		}
+
+		hasSection, ok := sinkSections[s.Type]
+		if !ok {
+			errs.add(field+".type", "不支持的投递目标类型 %q", s.Type)
+			continue
+		}
+		if !hasSection(s) {
+			errs.add(field+"."+s.Type, "类型为 %s 时必须配置 %s 配置段", s.Type, s.Type)
+			continue
+		}
+		for _, other := range sinkTypes() {
+			if other != s.Type && sinkSections[other](s) {
+				errs.add(field+"."+other, "类型为 %s 时不能配置 %s 配置段", s.Type, other)
+			}
+		}
+
+		validateSinkSection(errs, field, s)
+	}
+
+	return enabled
+}
+
+// validateSinkSection 校验投递目标类型对应的配置段
+func validateSinkSection(errs *validationErrors, field string, s *SinkConfig) {
+	switch s.Type {
+	case "telegram":
+		if s.Telegram.ChatID == 0 {
+			errs.add(field+".telegram.chat_id", "不能为空")
+		}
+		switch s.Telegram.Mode {
+		case "", "forward", "copy", "render":
+		default:
+			errs.add(field+".telegram.mode", "不支持的转发方式 %q，支持 forward、copy、render", s.Telegram.Mode)
+		}
+	case "matrix":
+		validateURL(errs, field+".matrix.homeserver_url", s.Matrix.HomeserverURL, true)
+		if s.Matrix.AccessToken == "" {
+			errs.add(field+".matrix.access_token", "不能为空")
+		}
+		if !strings.HasPrefix(s.Matrix.RoomID, "!") {
+			errs.add(field+".matrix.room_id", "必须是以 ! 开头的房间ID")
+		}
+	case "mattermost":
+		validateURL(errs, field+".mattermost.webhook_url", s.Mattermost.WebhookURL, true)
+	case "rocketchat":
+		validateURL(errs, field+".rocketchat.webhook_url", s.RocketChat.WebhookURL, true)
+	case "mqtt":
+		if s.MQTT.Broker == "" {
+			errs.add(field+".mqtt.broker", "不能为空")
+		} else if u, err := url.Parse(s.MQTT.Broker); err != nil || u.Host == "" {
+			errs.add(field+".mqtt.broker", "无效的地址 %q，格式如 tcp://127.0.0.1:1883", s.MQTT.Broker)
+		} else {
+			switch u.Scheme {
+			case "tcp", "ssl", "tls", "mqtt", "mqtts", "ws", "wss":
+			default:
+				errs.add(field+".mqtt.broker", "不支持的协议 %q", u.Scheme)
+			}
+		}
+		if s.MQTT.QoS != nil && *s.MQTT.QoS > 2 {
+			errs.add(field+".mqtt.qos", "只支持 0、1、2")
+		}
+	case "redis":
+		if _, port, err := net.SplitHostPort(s.Redis.Addr); err != nil || port == "" {
+			errs.add(field+".redis.addr", "无效的地址 %q，格式如 127.0.0.1:6379", s.Redis.Addr)
+		}
+		if s.Redis.DB < 0 {
+			errs.add(field+".redis.db", "不能为负数")
+		}
+		if s.Redis.MaxLen < 0 {
+			errs.add(field+".redis.max_len", "不能为负数")
+		}
+	case "file":
+		if s.File.Dir == "" {
+			errs.add(field+".file.dir", "不能为空")
+		}
+		switch s.File.Durability {
+		case "", "none", "interval", "always":
+		default:
+			errs.add(field+".file.durability", "不支持的落盘策略 %q，支持 none、interval、always", s.File.Durability)
+		}
+		if s.File.SyncInterval < 0 {
+			errs.add(field+".file.sync_interval", "不能为负数")
+		}
+		if s.File.SyncInterval > 0 && s.File.Durability != "" && s.File.Durability != "interval" {
+			errs.add(field+".file.sync_interval", "只在 durability 为 interval 时生效")
+		}
+	}
+}
+
+// validateRoutes 校验路由配置，引用的投递目标必须存在且已启用
+func (c *Config) validateRoutes(errs *validationErrors, enabledSinks map[string]bool) {
+	declared := make(map[string]bool)
+	for _, s := range c.Sinks {
+		if s != nil {
+			declared[s.Name] = true
+		}
+	}
+	watched := make(map[int64]bool)
+	for _, id := range c.Telegram.ChatIDs {
+		watched[id] = true
+	}
+
+	for i, r := range c.Routes {
+		field := fmt.Sprintf("routes[%d]", i)
+		if r == nil {
+			errs.add(field, "配置为空")
+			continue
+		}
+		if r.Name != "" {
+			field = fmt.Sprintf("routes[%s]", r.Name)
+		}
+
+		if len(r.Sinks) == 0 {
+			errs.add(field+".sinks", "至少引用一个投递目标")
+		}
+		for _, name := range r.Sinks {
+			switch {
+			case !declared[name]:
+				errs.add(field+".sinks", "引用了不存在的投递目标 %q", name)
+			case !enabledSinks[name]:
+				logrus.Warnf("路由 %s 引用的投递目标 %s 未启用", field, name)
+			}
+		}
+
+		for _, id := range r.ChatIDs {
+			if !watched[id] {
+				errs.add(field+".chat_ids", "聊天 %d 不在 telegram.chat_ids 中，不会收到消息", id)
+			}
+		}
+
+		if r.Redact != nil {
+			for _, pattern := range r.Redact.Patterns {
+				if _, err := regexp.Compile(pattern); err != nil {
+					errs.add(field+".redact.patterns", "无效的正则表达式 %q: %v", pattern, err)
+				}
+			}
+		}
+	}
+}
+
+// validateURL 校验 HTTP(S) 地址
+func validateURL(errs *validationErrors, field, value string, required bool) {
+	if value == "" {
+		if required {
+			errs.add(field, "不能为空")
+		}
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		errs.add(field, "无效的 URL %q，必须以 http:// 或 https:// 开头", value)
+	}
+}
+
+// validatePort 校验端口范围
+func validatePort(errs *validationErrors, field string, port int) {
+	if port < 1 || port > 65535 {
+		errs.add(field, "无效的端口 %d，范围为 1-65535", port)
+	}
+}
@@ -0,0 +1,158 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/user/tg-forward-to-xx/internal/config"
+)
+
+// 加密值的格式：1 字节标记 + 4 字节数据密钥ID + 12 字节随机数 + 密文（含 16 字节认证标签）
+// 明文值都是 JSON，不会以标记字节开头
+const (
+	valueMarker byte = 0xE1
+	nonceSize        = 12
+	headerSize       = 1 + 4 + nonceSize
+	tagSize          = 16
+)
+
+var (
+	// ErrKeyRequired 数据库已加密，但没有启用加密或没有配置主密钥
+	ErrKeyRequired = errors.New("数据库已加密，需要启用 encryption 并配置主密钥")
+	// ErrWrongKey 配置的主密钥都无法解开数据库中的数据密钥
+	ErrWrongKey = errors.New("主密钥与加密数据库时使用的主密钥不一致，轮换主密钥时需要将旧密钥加入 encryption.previous_keys")
+	// ErrNotEncrypted 已加密的数据库中出现了明文值
+	ErrNotEncrypted = errors.New("数据未加密")
+)
+
+// MasterKeys 主密钥，用于加密保存在数据库中的数据密钥
+type MasterKeys struct {
+	Current  []byte
+	Previous [][]byte // 轮换前的主密钥，只用于解开旧的数据密钥
+}
+
+// KeysFromConfig 根据配置读取主密钥，未启用加密时返回 nil
+func KeysFromConfig(cfg *config.EncryptionConfig) (*MasterKeys, error) {
+	if cfg == nil || !cfg.Enabled {
+		return nil, nil
+	}
+	current, previous, err := cfg.MasterKeys()
+	if err != nil {
+		return nil, err
+	}
+	return &MasterKeys{Current: current, Previous: previous}, nil
+}
+
+// GenerateKey 生成 base64 编码的 32 字节随机密钥
+func GenerateKey() string {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// fingerprint 返回主密钥的指纹，保存在数据库中用于判断主密钥是否已轮换，不泄露密钥本身
+func fingerprint(master []byte) string {
+	sum := sha256.Sum256(append([]byte("tgforward-master-key:"), master...))
+	return hex.EncodeToString(sum[:8])
+}
+
+// newAEAD 创建 AES-256-GCM
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("创建加密器失败: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// 带密钥哈希的长度，哈希的碰撞概率可以忽略
+const hashSize = 16
+
+// Cipher 一个数据库的值加解密器，持有该数据库全部的数据密钥
+// 为 nil 时表示数据库未加密，Seal、Open 和 Hash 原样返回
+type Cipher struct {
+	aeads   map[uint32]cipher.AEAD
+	active  uint32 // 加密新值使用的数据密钥
+	hashKey []byte // 由当前数据密钥派生的 HMAC 密钥
+}
+
+// deriveHashKey 由数据密钥派生 Hash 使用的 HMAC 密钥，与加密使用的密钥互相独立
+func deriveHashKey(dek []byte) []byte {
+	mac := hmac.New(sha256.New, dek)
+	mac.Write([]byte("tgforward-hash-key"))
+	return mac.Sum(nil)
+}
+
+// Hash 返回 data 的带密钥哈希（HMAC-SHA256），用于在搜索索引、统计汇总等派生数据的键中代替明文
+// 相同的 data 总是得到相同的哈希，密钥由当前数据密钥派生，轮换数据密钥后随之改变
+func (c *Cipher) Hash(data []byte) []byte {
+	if c == nil {
+		return data
+	}
+	mac := hmac.New(sha256.New, c.hashKey)
+	mac.Write(data)
+	return mac.Sum(nil)[:hashSize]
+}
+
+// HashID 标识 Hash 使用的密钥，用于判断派生数据是否需要重建，不泄露密钥本身；为 nil 时返回空字符串
+func (c *Cipher) HashID() string {
+	if c == nil {
+		return ""
+	}
+	return fingerprint(c.hashKey)
+}
+
+// Seal 加密一个值，存储键作为附加认证数据，密文不能挪到其他键下使用
+func (c *Cipher) Seal(key, plaintext []byte) []byte {
+	if c == nil {
+		return plaintext
+	}
+	aead := c.aeads[c.active]
+	out := make([]byte, headerSize, headerSize+len(plaintext)+tagSize)
+	out[0] = valueMarker
+	binary.BigEndian.PutUint32(out[1:5], c.active)
+	rand.Read(out[5:headerSize])
+	return aead.Seal(out, out[5:headerSize], plaintext, key)
+}
+
+// Open 解密一个值
+func (c *Cipher) Open(key, value []byte) ([]byte, error) {
+	if c == nil {
+		if IsEncrypted(value) {
+			return nil, ErrKeyRequired
+		}
+		return value, nil
+	}
+	if !IsEncrypted(value) {
+		return nil, ErrNotEncrypted
+	}
+
+	id := binary.BigEndian.Uint32(value[1:5])
+	aead, ok := c.aeads[id]
+	if !ok {
+		return nil, fmt.Errorf("未知的数据密钥 %d", id)
+	}
+	plaintext, err := aead.Open(nil, value[5:headerSize], value[headerSize:], key)
+	if err != nil {
+		return nil, fmt.Errorf("解密失败，数据可能已损坏或被篡改: %w", err)
+	}
+	return plaintext, nil
+}
+
+// keyID 返回加密值使用的数据密钥
+func keyID(value []byte) uint32 {
+	return binary.BigEndian.Uint32(value[1:5])
+}
+
+// IsEncrypted 判断值是否为加密格式
+func IsEncrypted(value []byte) bool {
+	return len(value) >= headerSize+tagSize && value[0] == valueMarker
+}
@@ -0,0 +1,394 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// KeyringKey 保存数据密钥的键，与 schema:version 一样不加密
+const KeyringKey = "encryption:keyring"
+
+// rewriteBatchSize 加密、解密和重新加密时每批写入的数量
+const rewriteBatchSize = 1000
+
+// Getter 读取数据库的接口，由 LevelDB 数据库和事务实现
+type Getter interface {
+	Get(key []byte, ro *opt.ReadOptions) ([]byte, error)
+}
+
+// keyring 数据库中保存的数据密钥
+type keyring struct {
+	Master  string            `json:"master"`            // 加密数据密钥的主密钥指纹
+	Active  uint32            `json:"active"`            // 加密新值使用的数据密钥
+	Keys    map[uint32]string `json:"keys"`              // 数据密钥ID → base64(随机数 + 用主密钥加密的数据密钥)
+	Partial bool              `json:"partial,omitempty"` // 加密或解密全部数据尚未完成，可能同时存在明文和密文
+}
+
+// readKeyring 读取数据密钥，数据库未加密时返回 nil
+func readKeyring(r Getter) (*keyring, error) {
+	data, err := r.Get([]byte(KeyringKey), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取数据密钥失败: %w", err)
+	}
+	var ring keyring
+	if err := json.Unmarshal(data, &ring); err != nil {
+		return nil, fmt.Errorf("解析数据密钥失败: %w", err)
+	}
+	return &ring, nil
+}
+
+// writeKeyring 保存数据密钥
+func writeKeyring(db *leveldb.DB, ring *keyring) error {
+	data, err := json.Marshal(ring)
+	if err != nil {
+		return fmt.Errorf("序列化数据密钥失败: %w", err)
+	}
+	if err := db.Put([]byte(KeyringKey), data, &opt.WriteOptions{Sync: true}); err != nil {
+		return fmt.Errorf("保存数据密钥失败: %w", err)
+	}
+	return nil
+}
+
+// addKey 生成新的数据密钥并设为当前密钥
+func (ring *keyring) addKey(master []byte) error {
+	var id uint32
+	for existing := range ring.Keys {
+		id = max(id, existing)
+	}
+	id++
+
+	dek := make([]byte, 32)
+	rand.Read(dek)
+	wrapped, err := wrapKey(master, id, dek)
+	if err != nil {
+		return err
+	}
+	if ring.Keys == nil {
+		ring.Keys = make(map[uint32]string)
+	}
+	ring.Keys[id] = wrapped
+	ring.Active = id
+	return nil
+}
+
+// unlock 用当前或轮换前的主密钥解开全部数据密钥，返回解开时使用的主密钥
+func (ring *keyring) unlock(keys *MasterKeys) (*Cipher, []byte, error) {
+	candidates := append([][]byte{keys.Current}, keys.Previous...)
+	for _, master := range candidates {
+		if fingerprint(master) != ring.Master {
+			continue
+		}
+		c := &Cipher{aeads: make(map[uint32]cipher.AEAD), active: ring.Active}
+		for id, wrapped := range ring.Keys {
+			dek, err := unwrapKey(master, id, wrapped)
+			if err != nil {
+				return nil, nil, err
+			}
+			if c.aeads[id], err = newAEAD(dek); err != nil {
+				return nil, nil, err
+			}
+			if id == ring.Active {
+				c.hashKey = deriveHashKey(dek)
+			}
+		}
+		if _, ok := c.aeads[c.active]; !ok {
+			return nil, nil, fmt.Errorf("缺少当前数据密钥 %d", c.active)
+		}
+		return c, master, nil
+	}
+	return nil, nil, ErrWrongKey
+}
+
+// rewrap 用新的主密钥重新加密全部数据密钥
+func (ring *keyring) rewrap(from, to []byte) error {
+	for id, wrapped := range ring.Keys {
+		dek, err := unwrapKey(from, id, wrapped)
+		if err != nil {
+			return err
+		}
+		if ring.Keys[id], err = wrapKey(to, id, dek); err != nil {
+			return err
+		}
+	}
+	ring.Master = fingerprint(to)
+	return nil
+}
+
+// wrapKey 用主密钥加密数据密钥，数据密钥ID作为附加认证数据
+func wrapKey(master []byte, id uint32, dek []byte) (string, error) {
+	aead, err := newAEAD(master)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, nonceSize)
+	rand.Read(nonce)
+	sealed := aead.Seal(nonce, nonce, dek, []byte("dek:"+strconv.FormatUint(uint64(id), 10)))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// unwrapKey 解开 wrapKey 加密的数据密钥
+func unwrapKey(master []byte, id uint32, wrapped string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil || len(data) < nonceSize+tagSize {
+		return nil, fmt.Errorf("数据密钥 %d 格式无效", id)
+	}
+	aead, err := newAEAD(master)
+	if err != nil {
+		return nil, err
+	}
+	dek, err := aead.Open(nil, data[:nonceSize], data[nonceSize:], []byte("dek:"+strconv.FormatUint(uint64(id), 10)))
+	if err != nil {
+		return nil, fmt.Errorf("解开数据密钥 %d 失败: %w", id, err)
+	}
+	return dek, nil
+}
+
+// Open 在服务启动时打开数据库的加密，name 用于日志，isData 判断需要加密的业务数据键
+// 数据库未加密且未启用加密时返回 nil；首次启用时生成数据密钥并加密已有的明文数据；
+// 主密钥轮换后（旧密钥在 previous_keys 中）用新主密钥重新保存数据密钥
+func Open(db *leveldb.DB, name string, isData func(key []byte) bool, keys *MasterKeys) (*Cipher, error) {
+	ring, err := readKeyring(db)
+	if err != nil {
+		return nil, err
+	}
+	if ring == nil {
+		if keys == nil {
+			return nil, nil
+		}
+		ring = &keyring{Master: fingerprint(keys.Current), Partial: true}
+		if err := ring.addKey(keys.Current); err != nil {
+			return nil, err
+		}
+		if err := writeKeyring(db, ring); err != nil {
+			return nil, err
+		}
+		logrus.WithField("store", name).Info("已启用静态加密，开始加密已有数据")
+	}
+	if keys == nil {
+		return nil, fmt.Errorf("%s: %w，如需关闭加密请先停止服务并运行 migrate decrypt", name, ErrKeyRequired)
+	}
+
+	c, master, err := ring.unlock(keys)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if !bytes.Equal(master, keys.Current) {
+		if err := ring.rewrap(master, keys.Current); err != nil {
+			return nil, err
+		}
+		if err := writeKeyring(db, ring); err != nil {
+			return nil, err
+		}
+		logrus.WithField("store", name).Info("主密钥已轮换，数据密钥已改用新主密钥保存，可以从 previous_keys 中移除旧密钥")
+	}
+
+	if ring.Partial {
+		// 首次加密或上次解密中断，加密剩余的明文数据
+		count, err := rewrite(db, isData, func(key, value []byte) ([]byte, error) {
+			if IsEncrypted(value) {
+				return nil, nil
+			}
+			return c.Seal(key, value), nil
+		}, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: 加密已有数据失败: %w", name, err)
+		}
+		ring.Partial = false
+		if err := writeKeyring(db, ring); err != nil {
+			return nil, err
+		}
+		logrus.WithFields(logrus.Fields{"store": name, "count": count}).Info("已有数据加密完成")
+	}
+	return c, nil
+}
+
+// Load 只读取数据库的加密状态，不做任何写入，供迁移工具解密迁移中读取的值
+// 数据库未加密时返回 nil
+func Load(r Getter, keys *MasterKeys) (*Cipher, error) {
+	ring, err := readKeyring(r)
+	if err != nil || ring == nil {
+		return nil, err
+	}
+	if ring.Partial {
+		return nil, fmt.Errorf("数据库的加密或解密没有完成，请先启动一次服务完成加密或重新运行 migrate decrypt")
+	}
+	if keys == nil {
+		return nil, ErrKeyRequired
+	}
+	c, _, err := ring.unlock(keys)
+	return c, err
+}
+
+// Verify 检查配置的主密钥能否解开数据库的数据密钥，数据库未加密时返回 nil
+func Verify(r Getter, keys *MasterKeys) error {
+	ring, err := readKeyring(r)
+	if err != nil || ring == nil {
+		return err
+	}
+	if keys == nil {
+		return ErrKeyRequired
+	}
+	_, _, err = ring.unlock(keys)
+	return err
+}
+
+// Encrypted 判断数据库是否已加密
+func Encrypted(r Getter) (bool, error) {
+	ring, err := readKeyring(r)
+	return ring != nil, err
+}
+
+// Rotate 生成新的数据密钥，用它重新加密全部数据后删除旧的数据密钥，数据密钥改用当前主密钥保存
+// 中途中断时旧的数据密钥仍然保留，数据可以正常读取，重新运行即可
+func Rotate(db *leveldb.DB, isData func(key []byte) bool, keys *MasterKeys, progress func(done int)) (int, error) {
+	ring, err := readKeyring(db)
+	if err != nil {
+		return 0, err
+	}
+	if ring == nil {
+		return 0, fmt.Errorf("数据库未加密，启用 encryption 后启动服务即可加密")
+	}
+	if keys == nil {
+		return 0, ErrKeyRequired
+	}
+
+	c, master, err := ring.unlock(keys)
+	if err != nil {
+		return 0, err
+	}
+	if err := ring.rewrap(master, keys.Current); err != nil {
+		return 0, err
+	}
+	if err := ring.addKey(keys.Current); err != nil {
+		return 0, err
+	}
+	if err := writeKeyring(db, ring); err != nil {
+		return 0, err
+	}
+	if c, _, err = ring.unlock(keys); err != nil {
+		return 0, err
+	}
+
+	count, err := rewrite(db, isData, func(key, value []byte) ([]byte, error) {
+		if !IsEncrypted(value) {
+			return c.Seal(key, value), nil
+		}
+		if keyID(value) == ring.Active {
+			return nil, nil
+		}
+		plaintext, err := c.Open(key, value)
+		if err != nil {
+			return nil, fmt.Errorf("解密 %x 失败: %w", key, err)
+		}
+		return c.Seal(key, plaintext), nil
+	}, progress)
+	if err != nil {
+		return count, err
+	}
+
+	for id := range ring.Keys {
+		if id != ring.Active {
+			delete(ring.Keys, id)
+		}
+	}
+	ring.Partial = false
+	return count, writeKeyring(db, ring)
+}
+
+// Decrypt 解密全部数据并删除数据密钥，关闭加密前运行
+// 中途中断时数据库标记为部分加密，可以重新运行，也可以重新启用加密后启动服务恢复加密
+func Decrypt(db *leveldb.DB, isData func(key []byte) bool, keys *MasterKeys, progress func(done int)) (int, error) {
+	ring, err := readKeyring(db)
+	if err != nil || ring == nil {
+		return 0, err
+	}
+	if keys == nil {
+		return 0, ErrKeyRequired
+	}
+	c, _, err := ring.unlock(keys)
+	if err != nil {
+		return 0, err
+	}
+
+	ring.Partial = true
+	if err := writeKeyring(db, ring); err != nil {
+		return 0, err
+	}
+	count, err := rewrite(db, isData, func(key, value []byte) ([]byte, error) {
+		if !IsEncrypted(value) {
+			return nil, nil
+		}
+		plaintext, err := c.Open(key, value)
+		if err != nil {
+			return nil, fmt.Errorf("解密 %x 失败: %w", key, err)
+		}
+		return plaintext, nil
+	}, progress)
+	if err != nil {
+		return count, err
+	}
+
+	if err := db.Delete([]byte(KeyringKey), &opt.WriteOptions{Sync: true}); err != nil {
+		return count, fmt.Errorf("删除数据密钥失败: %w", err)
+	}
+	return count, nil
+}
+
+// rewrite 分批改写业务数据，fn 返回 nil 表示该值无需改写，返回改写的数量
+func rewrite(db *leveldb.DB, isData func(key []byte) bool, fn func(key, value []byte) ([]byte, error), progress func(done int)) (int, error) {
+	// 迭代器读取的是快照，改写不影响遍历
+	iter := db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	count := 0
+	b := new(leveldb.Batch)
+	flush := func() error {
+		if b.Len() == 0 {
+			return nil
+		}
+		if err := db.Write(b, nil); err != nil {
+			return fmt.Errorf("写入数据失败: %w", err)
+		}
+		count += b.Len()
+		b.Reset()
+		if progress != nil {
+			progress(count)
+		}
+		return nil
+	}
+
+	for iter.Next() {
+		if !isData(iter.Key()) {
+			continue
+		}
+		value, err := fn(iter.Key(), iter.Value())
+		if err != nil {
+			return count, err
+		}
+		if value == nil {
+			continue
+		}
+		b.Put(append([]byte{}, iter.Key()...), value)
+		if b.Len() >= rewriteBatchSize {
+			if err := flush(); err != nil {
+				return count, err
+			}
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return count, fmt.Errorf("遍历数据库失败: %w", err)
+	}
+	return count, flush()
+}
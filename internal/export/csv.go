@@ -0,0 +1,59 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// csvWriter CSV 格式，带 UTF-8 BOM 以便 Excel 正确识别编码
+type csvWriter struct {
+	out     io.Writer
+	w       *csv.Writer
+	started bool
+}
+
+func newCSVWriter(w io.Writer, _ *Info) Writer {
+	return &csvWriter{out: w, w: csv.NewWriter(w)}
+}
+
+// start 写入 BOM 和表头
+func (c *csvWriter) start() error {
+	if c.started {
+		return nil
+	}
+	c.started = true
+	if _, err := io.WriteString(c.out, "\xEF\xBB\xBF"); err != nil {
+		return err
+	}
+	headers := []string{"消息ID", "群组ID", "群组名称", "用户名", "消息内容", "时间"}
+	return c.w.Write(headers)
+}
+
+func (c *csvWriter) Write(message *models.ChatHistory) error {
+	if err := c.start(); err != nil {
+		return err
+	}
+	record := []string{
+		fmt.Sprintf("%d", message.ID),
+		fmt.Sprintf("%d", message.ChatID),
+		message.GroupName,
+		message.FromUser,
+		message.Text,
+		formatTime(message.Timestamp),
+	}
+	if err := c.w.Write(record); err != nil {
+		return fmt.Errorf("写入CSV数据失败: %w", err)
+	}
+	return nil
+}
+
+func (c *csvWriter) Close() error {
+	if err := c.start(); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
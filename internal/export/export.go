@@ -0,0 +1,153 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/user/tg-forward-to-xx/internal/models"
+	"github.com/user/tg-forward-to-xx/internal/storage"
+)
+
+// 导出格式
+const (
+	FormatCSV      = "csv"
+	FormatJSON     = "json"
+	FormatNDJSON   = "ndjson"
+	FormatMarkdown = "markdown"
+	FormatHTML     = "html"
+	FormatXLSX     = "xlsx"
+)
+
+// Info 导出的附加信息，用于 Markdown 和 HTML 的标题
+type Info struct {
+	ChatID     int64
+	Start      time.Time // 零值表示不限制
+	End        time.Time // 零值表示不限制
+	ExportedAt time.Time
+}
+
+// Writer 逐条写出聊天记录，不在内存中保存全部记录
+type Writer interface {
+	Write(message *models.ChatHistory) error
+	// Close 写出结尾部分，不关闭底层的 io.Writer
+	Close() error
+}
+
+// format 导出格式的描述
+type format struct {
+	contentType string
+	extension   string
+	newWriter   func(w io.Writer, info *Info) Writer
+}
+
+var formats = map[string]*format{
+	FormatCSV:      {"text/csv; charset=utf-8", "csv", newCSVWriter},
+	FormatJSON:     {"application/json", "json", newJSONWriter},
+	FormatNDJSON:   {"application/x-ndjson", "ndjson", newNDJSONWriter},
+	FormatMarkdown: {"text/markdown; charset=utf-8", "md", newMarkdownWriter},
+	FormatHTML:     {"text/html; charset=utf-8", "html", newHTMLWriter},
+	FormatXLSX:     {"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "xlsx", newXLSXWriter},
+}
+
+// aliases 格式的别名
+var aliases = map[string]string{
+	"md":    FormatMarkdown,
+	"jsonl": FormatNDJSON,
+}
+
+// Formats 返回支持的导出格式
+func Formats() []string {
+	return []string{FormatCSV, FormatJSON, FormatNDJSON, FormatMarkdown, FormatHTML, FormatXLSX}
+}
+
+// Normalize 解析格式名称，空字符串表示 csv，不支持的格式返回 false
+func Normalize(name string) (string, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return FormatCSV, true
+	}
+	if alias, ok := aliases[name]; ok {
+		name = alias
+	}
+	_, ok := formats[name]
+	return name, ok
+}
+
+// ContentType 返回格式的 MIME 类型
+func ContentType(name string) string {
+	return formats[name].contentType
+}
+
+// FileName 返回导出文件的默认名称，例如 chat_history_-100123_20250101_120000.csv
+func FileName(chatID int64, name string, t time.Time) string {
+	return fmt.Sprintf("chat_history_%d_%s.%s", chatID, t.Format("20060102_150405"), formats[name].extension)
+}
+
+// NewWriter 创建指定格式的写出器，name 需要先经过 Normalize
+func NewWriter(w io.Writer, name string, info *Info) Writer {
+	return formats[name].newWriter(w, info)
+}
+
+// Export 按查询条件边遍历边写出聊天记录，返回导出的数量
+// 查询的 Limit 和 Cursor 会被忽略，导出全部满足条件的记录
+func Export(w io.Writer, name string, history *storage.ChatHistoryStorage, q *storage.HistoryQuery, info *Info) (int, error) {
+	query := *q
+	query.Limit = 0
+	query.Cursor = ""
+
+	writer := NewWriter(w, name, info)
+	count := 0
+	_, err := history.Iterate(&query, func(message *models.ChatHistory) error {
+		count++
+		return writer.Write(message)
+	})
+	if err != nil {
+		return count, fmt.Errorf("导出聊天记录失败: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return count, fmt.Errorf("导出聊天记录失败: %w", err)
+	}
+	return count, nil
+}
+
+// formatTime 导出文件中统一使用的时间格式
+func formatTime(t time.Time) string {
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// describeRange 描述导出的时间范围
+func describeRange(info *Info) string {
+	start, end := "最早", "现在"
+	if !info.Start.IsZero() {
+		start = formatTime(info.Start)
+	}
+	if !info.End.IsZero() {
+		end = formatTime(info.End)
+	}
+	return start + " 至 " + end
+}
+
+// groupName 返回聊天记录的群组名称，旧记录没有名称时使用群组ID
+func groupName(message *models.ChatHistory) string {
+	if message.GroupName != "" {
+		return message.GroupName
+	}
+	return fmt.Sprintf("群组(%d)", message.ChatID)
+}
+
+// errWriter 记录底层写入的第一个错误，缓冲写出时可以及早发现客户端断开等错误并停止遍历
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	n, err := e.w.Write(p)
+	e.err = err
+	return n, err
+}
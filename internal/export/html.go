@@ -0,0 +1,121 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+
+	"github.com/sirupsen/logrus"
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// htmlStyle 内联样式，导出的文件不依赖外部资源即可打开
+const htmlStyle = `body{font-family:-apple-system,"PingFang SC","Microsoft YaHei",sans-serif;background:#f4f4f5;color:#222;margin:0}
+main{max-width:760px;margin:0 auto;padding:24px 16px}
+header{margin-bottom:16px}
+header h1{font-size:22px;margin:0 0 8px}
+header p{color:#666;font-size:13px;margin:2px 0}
+.day{text-align:center;color:#888;font-size:13px;margin:20px 0 8px}
+.msg{background:#fff;border-radius:8px;padding:8px 12px;margin:6px 0;box-shadow:0 1px 2px rgba(0,0,0,.06)}
+.msg:target{outline:2px solid #3390ec}
+.meta{font-size:12px;color:#888;margin-bottom:4px}
+.meta b{color:#3390ec}
+.reply{display:block;border-left:3px solid #3390ec;padding:2px 8px;margin:4px 0;color:#555;font-size:13px;text-decoration:none}
+.text{white-space:pre-wrap;word-break:break-word}
+.thumb{max-width:240px;max-height:240px;border-radius:6px;margin:4px 4px 0 0}
+.file{display:inline-block;margin-top:4px;font-size:13px}
+footer{color:#999;font-size:12px;text-align:center;margin-top:24px}`
+
+// htmlWriter 单文件 HTML 聊天记录，回复带有原消息的引用和跳转链接
+// 图片缩小后以 data: URI 内嵌，离线也能查看；无法下载或超出大小限制的图片和其他附件显示为链接
+type htmlWriter struct {
+	out     *errWriter
+	w       *bufio.Writer
+	info    *Info
+	started bool
+	day     string
+	count   int
+	replies *replyIndex
+	thumbs  thumbnails
+}
+
+func newHTMLWriter(w io.Writer, info *Info) Writer {
+	out := &errWriter{w: w}
+	return &htmlWriter{out: out, w: bufio.NewWriter(out), info: info, replies: newReplyIndex()}
+}
+
+// start 写入页面头部，群组名称取自第一条记录
+func (h *htmlWriter) start(message *models.ChatHistory) {
+	if h.started {
+		return
+	}
+	h.started = true
+	title := fmt.Sprintf("群组(%d)", h.info.ChatID)
+	if message != nil {
+		title = groupName(message)
+	}
+	title = html.EscapeString(title)
+
+	fmt.Fprintf(h.w, "<!DOCTYPE html>\n<html lang=\"zh-CN\">\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(h.w, "<meta name=\"viewport\" content=\"width=device-width, initial-scale=1\">\n")
+	fmt.Fprintf(h.w, "<title>%s 聊天记录</title>\n<style>\n%s\n</style>\n</head>\n<body>\n<main>\n", title, htmlStyle)
+	fmt.Fprintf(h.w, "<header>\n<h1>%s</h1>\n", title)
+	fmt.Fprintf(h.w, "<p>群组ID：%d</p>\n", h.info.ChatID)
+	fmt.Fprintf(h.w, "<p>时间范围：%s</p>\n", html.EscapeString(describeRange(h.info)))
+	fmt.Fprintf(h.w, "<p>导出时间：%s</p>\n</header>\n", formatTime(h.info.ExportedAt))
+}
+
+func (h *htmlWriter) Write(message *models.ChatHistory) error {
+	h.start(message)
+	h.count++
+
+	if day := message.Timestamp.Format("2006-01-02"); day != h.day {
+		h.day = day
+		fmt.Fprintf(h.w, "<div class=\"day\">%s</div>\n", day)
+	}
+
+	fmt.Fprintf(h.w, "<div class=\"msg\" id=\"msg-%d\">\n", message.ID)
+	fmt.Fprintf(h.w, "<div class=\"meta\"><b>%s</b> · <a href=\"#msg-%d\">%s</a></div>\n",
+		html.EscapeString(senderName(message)), message.ID, message.Timestamp.Format("15:04:05"))
+
+	if message.ReplyToID != 0 {
+		quote := fmt.Sprintf("回复消息 #%d", message.ReplyToID)
+		if ref, ok := h.replies.get(message.ReplyToID); ok {
+			quote = fmt.Sprintf("回复 %s：%s", ref.sender, ref.text)
+		}
+		fmt.Fprintf(h.w, "<a class=\"reply\" href=\"#msg-%d\">%s</a>\n", message.ReplyToID, html.EscapeString(quote))
+	}
+
+	if message.Text != "" {
+		fmt.Fprintf(h.w, "<div class=\"text\">%s</div>\n", html.EscapeString(message.Text))
+	}
+	for _, a := range message.Attachments {
+		if a.URL == "" {
+			continue
+		}
+		src := html.EscapeString(a.URL)
+		name := html.EscapeString(mediaName(a))
+		if !a.IsImage() {
+			fmt.Fprintf(h.w, "<a class=\"file\" href=\"%s\">📎 %s</a>\n", src, name)
+			continue
+		}
+		thumb, err := h.thumbs.dataURI(a.URL)
+		if err != nil {
+			logrus.Debugf("HTML 导出未能内嵌图片 %s，改为链接: %v", mediaName(a), err)
+			fmt.Fprintf(h.w, "<a class=\"file\" href=\"%s\">🖼 %s</a>\n", src, name)
+			continue
+		}
+		fmt.Fprintf(h.w, "<a href=\"%s\"><img class=\"thumb\" src=\"%s\" alt=\"%s\"></a>\n", src, thumb, name)
+	}
+	io.WriteString(h.w, "</div>\n")
+
+	h.replies.add(message)
+	return h.out.err
+}
+
+func (h *htmlWriter) Close() error {
+	h.start(nil)
+	fmt.Fprintf(h.w, "<footer>共 %d 条消息</footer>\n</main>\n</body>\n</html>\n", h.count)
+	return h.w.Flush()
+}
@@ -0,0 +1,713 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/models"
+	"github.com/user/tg-forward-to-xx/internal/storage"
+)
+
+// 导出任务状态
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// 导出任务相关错误
+var (
+	ErrJobNotFound = errors.New("导出任务不存在或已过期")
+	ErrJobNotReady = errors.New("导出任务尚未完成")
+	ErrTooManyJobs = errors.New("排队中的导出任务过多，请稍后重试")
+)
+
+const (
+	maxPendingJobs       = 100             // 排队中的任务上限
+	progressSaveInterval = 2 * time.Second // 任务进度写入数据库的最小间隔
+	expireCheckInterval  = time.Minute     // 清理过期任务的间隔
+	downloadPath         = "/api/chat/export/download"
+)
+
+// JobParams 创建导出任务的参数
+type JobParams struct {
+	ChatID      int64     `json:"chat_id"`
+	Format      string    `json:"format"`
+	Start       time.Time `json:"start_time,omitzero"`
+	End         time.Time `json:"end_time,omitzero"`
+	FromUser    string    `json:"user,omitempty"`
+	MessageType string    `json:"type,omitempty"`
+	HasMedia    *bool     `json:"has_media,omitempty"`
+	Text        string    `json:"q,omitempty"`
+	Descending  bool      `json:"descending,omitempty"`
+	Notify      string    `json:"notify,omitempty"` // 任务结束后通知的投递目标
+}
+
+// NewJobParams 从查询条件创建任务参数，format 需要先经过 Normalize
+func NewJobParams(q *storage.HistoryQuery, format string) JobParams {
+	return JobParams{
+		ChatID:      q.ChatID,
+		Format:      format,
+		Start:       q.Start,
+		End:         q.End,
+		FromUser:    q.FromUser,
+		MessageType: q.MessageType,
+		HasMedia:    q.HasMedia,
+		Text:        q.Text,
+		Descending:  q.Descending,
+	}
+}
+
+// query 转换为聊天记录查询条件
+func (p *JobParams) query() *storage.HistoryQuery {
+	return &storage.HistoryQuery{
+		ChatID:      p.ChatID,
+		Start:       p.Start,
+		End:         p.End,
+		FromUser:    p.FromUser,
+		MessageType: p.MessageType,
+		HasMedia:    p.HasMedia,
+		Text:        p.Text,
+		Descending:  p.Descending,
+	}
+}
+
+// Job 导出任务
+type Job struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	JobParams
+	CreatedBy   string    `json:"created_by,omitempty"`   // 创建任务的 API Key 名称
+	GroupName   string    `json:"group_name,omitempty"`   // 群组名称，取自导出的第一条记录
+	Total       int       `json:"total"`                  // 满足条件的记录数，开始运行后统计
+	Exported    int       `json:"exported"`               // 已导出的记录数
+	Progress    float64   `json:"progress"`               // 进度百分比
+	FileName    string    `json:"file_name,omitempty"`    // 下载时的文件名
+	Size        int64     `json:"size,omitempty"`         // 导出文件大小（字节）
+	Storage     string    `json:"storage,omitempty"`      // 导出结果的存储位置：local 或 s3
+	DownloadURL string    `json:"download_url,omitempty"` // 有时效的下载链接
+	ExpiresAt   time.Time `json:"expires_at,omitzero"`    // 下载链接过期时间，过期后删除导出文件和任务记录
+	Error       string    `json:"error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	StartedAt   time.Time `json:"started_at,omitzero"`
+	FinishedAt  time.Time `json:"finished_at,omitzero"`
+}
+
+// jobRecord 持久化的任务记录，包含不对外返回的字段
+type jobRecord struct {
+	Job    Job    `json:"job"`
+	Object string `json:"object,omitempty"` // 本地文件路径或 S3 对象名称
+	Token  string `json:"token,omitempty"`  // 本地下载链接的令牌
+}
+
+// Notifier 任务结束后按投递目标名称发送通知
+type Notifier interface {
+	SendTo(sinkName string, msg *models.Message) error
+}
+
+// JobManager 管理异步导出任务，任务记录保存在 <queue.path>/export_jobs，重启后继续执行未完成的任务
+type JobManager struct {
+	db       *leveldb.DB
+	history  *storage.ChatHistoryStorage
+	notifier Notifier
+
+	mutex   sync.Mutex
+	jobs    map[string]*jobRecord
+	pending []string
+	cancels map[string]context.CancelFunc
+
+	wake     chan struct{}
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewJobManager 打开任务数据库并加载已有任务，运行中的任务重新排队
+func NewJobManager(history *storage.ChatHistoryStorage, notifier Notifier) (*JobManager, error) {
+	dbPath := filepath.Join(config.Current().Queue.Path, "export_jobs")
+	if err := os.MkdirAll(dbPath, 0755); err != nil {
+		return nil, fmt.Errorf("创建导出任务数据库目录失败: %w", err)
+	}
+	db, err := leveldb.OpenFile(dbPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("打开导出任务数据库失败: %w", err)
+	}
+
+	m := &JobManager{
+		db:       db,
+		history:  history,
+		notifier: notifier,
+		jobs:     make(map[string]*jobRecord),
+		cancels:  make(map[string]context.CancelFunc),
+		stopChan: make(chan struct{}),
+	}
+	if err := m.load(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// load 加载任务记录，未完成的任务按创建时间重新排队
+func (m *JobManager) load() error {
+	iter := m.db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	var pending []*jobRecord
+	for iter.Next() {
+		rec := &jobRecord{}
+		if err := json.Unmarshal(iter.Value(), rec); err != nil {
+			logrus.Errorf("解析导出任务 %s 失败，已跳过: %v", iter.Key(), err)
+			continue
+		}
+		m.jobs[rec.Job.ID] = rec
+		if rec.Job.Status == JobPending || rec.Job.Status == JobRunning {
+			pending = append(pending, rec)
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("加载导出任务失败: %w", err)
+	}
+
+	slices.SortFunc(pending, func(a, b *jobRecord) int {
+		return a.Job.CreatedAt.Compare(b.Job.CreatedAt)
+	})
+	for _, rec := range pending {
+		if rec.Job.Status == JobRunning {
+			// 上次运行被中断，从头开始
+			rec.Job.Status = JobPending
+			rec.Job.Exported = 0
+			rec.Job.Progress = 0
+			if err := m.save(rec); err != nil {
+				return err
+			}
+		}
+		m.pending = append(m.pending, rec.Job.ID)
+	}
+	if len(m.pending) > 0 {
+		logrus.Infof("恢复 %d 个未完成的导出任务", len(m.pending))
+	}
+	return nil
+}
+
+// Start 启动任务执行协程，数量由 chat_history.export.max_concurrent 决定
+func (m *JobManager) Start() {
+	workers := config.Current().ChatHistory.Export.MaxConcurrent
+	if workers <= 0 {
+		workers = 1
+	}
+	m.wake = make(chan struct{}, workers)
+	for i := 0; i < workers; i++ {
+		m.wg.Add(1)
+		go m.worker()
+	}
+	m.wg.Add(1)
+	go m.expireLoop()
+
+	// 唤醒协程处理恢复的任务
+	for i := 0; i < min(workers, len(m.pending)); i++ {
+		m.wake <- struct{}{}
+	}
+}
+
+// Stop 中断正在运行的任务并关闭数据库，被中断的任务在下次启动时重新执行
+func (m *JobManager) Stop() {
+	close(m.stopChan)
+	m.mutex.Lock()
+	for _, cancel := range m.cancels {
+		cancel()
+	}
+	m.mutex.Unlock()
+
+	m.wg.Wait()
+	if err := m.db.Close(); err != nil {
+		logrus.Errorf("关闭导出任务数据库失败: %v", err)
+	}
+}
+
+// Create 创建导出任务并排队
+func (m *JobManager) Create(params JobParams, createdBy string) (Job, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if len(m.pending) >= maxPendingJobs {
+		return Job{}, ErrTooManyJobs
+	}
+
+	rec := &jobRecord{Job: Job{
+		ID:        randomHex(8),
+		Status:    JobPending,
+		JobParams: params,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+	}}
+	if err := m.save(rec); err != nil {
+		return Job{}, err
+	}
+	m.jobs[rec.Job.ID] = rec
+	m.pending = append(m.pending, rec.Job.ID)
+
+	select {
+	case m.wake <- struct{}{}:
+	default:
+		// 所有协程都在运行任务，完成后会继续处理队列
+	}
+	return rec.Job, nil
+}
+
+// Get 返回任务的当前状态
+func (m *JobManager) Get(id string) (Job, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	rec, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	return rec.Job, nil
+}
+
+// List 返回满足条件的任务，按创建时间倒序
+func (m *JobManager) List(filter func(*Job) bool) []Job {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	jobs := make([]Job, 0, len(m.jobs))
+	for _, rec := range m.jobs {
+		if filter == nil || filter(&rec.Job) {
+			jobs = append(jobs, rec.Job)
+		}
+	}
+	slices.SortFunc(jobs, func(a, b Job) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return jobs
+}
+
+// Delete 取消并删除任务，已完成的任务同时删除导出文件
+func (m *JobManager) Delete(id string) error {
+	m.mutex.Lock()
+	rec, ok := m.jobs[id]
+	if !ok {
+		m.mutex.Unlock()
+		return ErrJobNotFound
+	}
+	delete(m.jobs, id)
+	m.pending = slices.DeleteFunc(m.pending, func(p string) bool { return p == id })
+	cancel, running := m.cancels[id]
+	err := m.db.Delete([]byte(id), nil)
+	m.mutex.Unlock()
+
+	if running {
+		// 运行中的任务由执行协程在退出时清理临时文件
+		cancel()
+	} else {
+		m.removeResult(rec)
+	}
+	if err != nil {
+		return fmt.Errorf("删除导出任务失败: %w", err)
+	}
+	return nil
+}
+
+// Open 校验下载令牌并打开本地保存的导出文件
+func (m *JobManager) Open(id, token string) (*os.File, Job, error) {
+	m.mutex.Lock()
+	rec, ok := m.jobs[id]
+	var job Job
+	var path string
+	if ok {
+		job, path = rec.Job, rec.Object
+		ok = rec.Token != "" && subtle.ConstantTimeCompare([]byte(rec.Token), []byte(token)) == 1
+	}
+	m.mutex.Unlock()
+
+	if !ok || time.Now().After(job.ExpiresAt) {
+		return nil, Job{}, ErrJobNotFound
+	}
+	if job.Status != JobSucceeded {
+		return nil, Job{}, ErrJobNotReady
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, Job{}, fmt.Errorf("打开导出文件失败: %w", err)
+	}
+	return file, job, nil
+}
+
+// worker 从队列中取出任务并执行
+func (m *JobManager) worker() {
+	defer m.wg.Done()
+	for {
+		select {
+		case <-m.stopChan:
+			return
+		default:
+		}
+
+		rec, ctx, ok := m.next()
+		if !ok {
+			select {
+			case <-m.wake:
+			case <-m.stopChan:
+				return
+			}
+			continue
+		}
+		m.run(ctx, rec)
+	}
+}
+
+// next 取出下一个排队的任务并标记为运行中，返回任务记录的副本
+func (m *JobManager) next() (*jobRecord, context.Context, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for len(m.pending) > 0 {
+		id := m.pending[0]
+		m.pending = m.pending[1:]
+		rec, ok := m.jobs[id]
+		if !ok {
+			continue
+		}
+
+		rec.Job.Status = JobRunning
+		rec.Job.StartedAt = time.Now()
+		if err := m.save(rec); err != nil {
+			logrus.Errorf("保存导出任务 %s 失败: %v", id, err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		m.cancels[id] = cancel
+		copied := *rec
+		return &copied, ctx, true
+	}
+	return nil, nil, false
+}
+
+// run 执行任务并保存结果
+func (m *JobManager) run(ctx context.Context, rec *jobRecord) {
+	id := rec.Job.ID
+	err := m.export(ctx, rec)
+	if err != nil && rec.Object != "" {
+		// 上传后生成链接失败时删除已上传的对象
+		m.removeResult(rec)
+		rec.Object = ""
+	}
+
+	m.mutex.Lock()
+	cancel := m.cancels[id]
+	delete(m.cancels, id)
+	current, exists := m.jobs[id]
+	stopping := false
+	select {
+	case <-m.stopChan:
+		stopping = true
+	default:
+	}
+
+	switch {
+	case !exists:
+		// 任务在运行中被删除
+		m.mutex.Unlock()
+		cancel()
+		if err == nil {
+			m.removeResult(rec)
+		}
+		return
+
+	case err != nil && stopping && ctx.Err() != nil:
+		// 服务停止，下次启动时重新执行
+		current.Job.Status = JobPending
+		current.Job.Exported = 0
+		current.Job.Progress = 0
+		if err := m.save(current); err != nil {
+			logrus.Errorf("保存导出任务 %s 失败: %v", id, err)
+		}
+		m.mutex.Unlock()
+		cancel()
+		return
+	}
+
+	ttl := time.Duration(config.Current().ChatHistory.Export.LinkTTL) * time.Second
+	rec.Job.FinishedAt = time.Now()
+	rec.Job.ExpiresAt = rec.Job.FinishedAt.Add(ttl)
+	if err != nil {
+		rec.Job.Status = JobFailed
+		rec.Job.Error = err.Error()
+	} else {
+		rec.Job.Status = JobSucceeded
+		rec.Job.Progress = 100
+	}
+	*current = *rec
+	if err := m.save(current); err != nil {
+		logrus.Errorf("保存导出任务 %s 失败: %v", id, err)
+	}
+	job := current.Job
+	m.mutex.Unlock()
+	cancel()
+
+	fields := logrus.Fields{"job": id, "chat_id": job.ChatID, "format": job.Format, "exported": job.Exported}
+	if err != nil {
+		logrus.WithFields(fields).Errorf("导出任务失败: %v", err)
+	} else {
+		logrus.WithFields(fields).Info("导出任务完成")
+	}
+	m.notify(&job)
+}
+
+// export 导出到本地文件，按配置上传到 S3 并生成下载链接，结果写入 rec
+func (m *JobManager) export(ctx context.Context, rec *jobRecord) error {
+	cfg := config.Current().ChatHistory.Export
+	job := &rec.Job
+	query := job.query()
+
+	total, err := m.history.Count(query)
+	if err != nil {
+		return err
+	}
+	job.Total = total
+	m.progress(rec, true)
+
+	dir := cfg.Dir
+	if dir == "" {
+		dir = filepath.Join(config.Current().Queue.Path, "exports")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("创建导出目录失败: %w", err)
+	}
+	path := filepath.Join(dir, job.ID+"."+formats[job.Format].extension)
+	tmpPath := path + ".tmp"
+
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("创建导出文件失败: %w", err)
+	}
+	defer os.Remove(tmpPath)
+	defer file.Close()
+
+	info := &Info{ChatID: job.ChatID, Start: job.Start, End: job.End, ExportedAt: job.StartedAt}
+	buf := bufio.NewWriter(file)
+	writer := NewWriter(buf, job.Format, info)
+	lastSave := time.Now()
+	_, err = m.history.Iterate(query, func(message *models.ChatHistory) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if job.Exported == 0 {
+			job.GroupName = message.GroupName
+		}
+		if err := writer.Write(message); err != nil {
+			return err
+		}
+		job.Exported++
+		if job.Exported%500 == 0 {
+			save := time.Since(lastSave) >= progressSaveInterval
+			if save {
+				lastSave = time.Now()
+			}
+			m.progress(rec, save)
+		}
+		return nil
+	})
+	if err == nil {
+		err = writer.Close()
+	}
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("导出聊天记录失败: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("保存导出文件失败: %w", err)
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("读取导出文件失败: %w", err)
+	}
+	job.Size = stat.Size()
+	job.FileName = FileName(job.ChatID, job.Format, job.StartedAt)
+	job.Storage = cfg.Storage
+	ttl := time.Duration(cfg.LinkTTL) * time.Second
+
+	if cfg.Storage != "s3" {
+		rec.Object = path
+		rec.Token = randomHex(16)
+		job.DownloadURL = fmt.Sprintf("%s%s?id=%s&token=%s", strings.TrimRight(cfg.PublicURL, "/"), downloadPath, job.ID, rec.Token)
+		return nil
+	}
+
+	// 上传到 S3 后删除本地文件
+	defer os.Remove(path)
+	client, err := storage.NewS3Client()
+	if err != nil {
+		return err
+	}
+	objectName := fmt.Sprintf("exports/%s/%s", job.ID, job.FileName)
+	if err := uploadFile(client, path, objectName, ContentType(job.Format)); err != nil {
+		return err
+	}
+	rec.Object = objectName
+	job.DownloadURL, err = client.PresignedURL(ctx, objectName, job.FileName, ttl)
+	return err
+}
+
+// uploadFile 上传导出文件到 S3
+func uploadFile(client *storage.S3Client, path, objectName, contentType string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("打开导出文件失败: %w", err)
+	}
+	defer file.Close()
+	_, err = client.UploadFile(file, objectName, contentType)
+	return err
+}
+
+// progress 更新任务进度，save 为 true 时同时写入数据库
+func (m *JobManager) progress(rec *jobRecord, save bool) {
+	job := &rec.Job
+	if job.Total > 0 {
+		// 导出过程中新增的记录会让已导出数量超过统计的总数
+		job.Progress = min(float64(job.Exported*10000/job.Total)/100, 99.99)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	current, ok := m.jobs[job.ID]
+	if !ok {
+		return
+	}
+	current.Job.Total = job.Total
+	current.Job.Exported = job.Exported
+	current.Job.Progress = job.Progress
+	current.Job.GroupName = job.GroupName
+	if save {
+		if err := m.save(current); err != nil {
+			logrus.Errorf("保存导出任务 %s 失败: %v", job.ID, err)
+		}
+	}
+}
+
+// notify 任务结束后通知投递目标
+func (m *JobManager) notify(job *Job) {
+	if job.Notify == "" || m.notifier == nil {
+		return
+	}
+
+	group := job.GroupName
+	if group == "" {
+		group = fmt.Sprintf("群组(%d)", job.ChatID)
+	}
+	var text string
+	if job.Status == JobSucceeded {
+		text = fmt.Sprintf("聊天记录导出完成\n群组：%s\n格式：%s\n记录数：%d\n下载地址：%s\n有效期至：%s",
+			group, job.Format, job.Exported, job.DownloadURL, formatTime(job.ExpiresAt))
+	} else {
+		text = fmt.Sprintf("聊天记录导出失败\n群组：%s\n任务：%s\n错误：%s", group, job.ID, job.Error)
+	}
+
+	msg := models.NewMessage(text, models.Sender{DisplayName: "导出任务"}, models.Chat{ID: job.ChatID, Title: group})
+	msg.MessageType = models.MessageTypeText
+	if err := m.notifier.SendTo(job.Notify, msg); err != nil {
+		logrus.Errorf("发送导出任务 %s 的通知到 %s 失败: %v", job.ID, job.Notify, err)
+	}
+}
+
+// expireLoop 定期删除过期的任务和导出文件
+func (m *JobManager) expireLoop() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(expireCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stopChan:
+			return
+		case now := <-ticker.C:
+			m.expire(now)
+		}
+	}
+}
+
+// expire 删除过期时间早于 now 的任务
+func (m *JobManager) expire(now time.Time) {
+	var expired []*jobRecord
+	m.mutex.Lock()
+	for id, rec := range m.jobs {
+		if rec.Job.ExpiresAt.IsZero() || rec.Job.ExpiresAt.After(now) {
+			continue
+		}
+		if err := m.db.Delete([]byte(id), nil); err != nil {
+			logrus.Errorf("删除过期导出任务 %s 失败: %v", id, err)
+			continue
+		}
+		delete(m.jobs, id)
+		expired = append(expired, rec)
+	}
+	m.mutex.Unlock()
+
+	for _, rec := range expired {
+		m.removeResult(rec)
+	}
+	if len(expired) > 0 {
+		logrus.Infof("已删除 %d 个过期的导出任务", len(expired))
+	}
+}
+
+// removeResult 删除任务的导出文件
+func (m *JobManager) removeResult(rec *jobRecord) {
+	if rec.Object == "" {
+		return
+	}
+	if rec.Job.Storage == "s3" {
+		client, err := storage.NewS3Client()
+		if err == nil {
+			err = client.DeleteObject(context.Background(), rec.Object)
+		}
+		if err != nil {
+			logrus.Errorf("删除导出任务 %s 的 S3 对象失败: %v", rec.Job.ID, err)
+		}
+		return
+	}
+	if err := os.Remove(rec.Object); err != nil && !os.IsNotExist(err) {
+		logrus.Errorf("删除导出任务 %s 的文件失败: %v", rec.Job.ID, err)
+	}
+}
+
+// save 写入任务记录，调用方需要持有 m.mutex
+func (m *JobManager) save(rec *jobRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("编码导出任务失败: %w", err)
+	}
+	if err := m.db.Put([]byte(rec.Job.ID), data, nil); err != nil {
+		return fmt.Errorf("保存导出任务失败: %w", err)
+	}
+	return nil
+}
+
+// randomHex 生成 n 字节的随机十六进制字符串
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
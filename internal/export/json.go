@@ -0,0 +1,61 @@
+package export
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// jsonWriter JSON 数组格式
+type jsonWriter struct {
+	w     io.Writer
+	count int
+}
+
+func newJSONWriter(w io.Writer, _ *Info) Writer {
+	return &jsonWriter{w: w}
+}
+
+func (j *jsonWriter) Write(message *models.ChatHistory) error {
+	data, err := json.MarshalIndent(message, "  ", "  ")
+	if err != nil {
+		return err
+	}
+	sep := ",\n  "
+	if j.count == 0 {
+		sep = "[\n  "
+	}
+	j.count++
+	if _, err := io.WriteString(j.w, sep); err != nil {
+		return err
+	}
+	_, err = j.w.Write(data)
+	return err
+}
+
+func (j *jsonWriter) Close() error {
+	tail := "\n]\n"
+	if j.count == 0 {
+		tail = "[]\n"
+	}
+	_, err := io.WriteString(j.w, tail)
+	return err
+}
+
+// ndjsonWriter 每行一条 JSON 记录，适合用 jq 或日志系统逐行处理
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func newNDJSONWriter(w io.Writer, _ *Info) Writer {
+	return &ndjsonWriter{enc: json.NewEncoder(w)}
+}
+
+func (n *ndjsonWriter) Write(message *models.ChatHistory) error {
+	return n.enc.Encode(message)
+}
+
+func (n *ndjsonWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,167 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// 回复引用中保留的最近消息数量和引用文本的最大长度
+const (
+	replyWindow        = 5000
+	replyPreviewLength = 80
+)
+
+// replyRef 被回复消息的摘要
+type replyRef struct {
+	sender string
+	text   string
+}
+
+// replyIndex 记录最近导出的消息，用于在回复中引用原消息
+// 只保留最近 replyWindow 条，导出大量记录时内存占用保持稳定
+type replyIndex struct {
+	refs  map[int64]replyRef
+	order []int64
+}
+
+func newReplyIndex() *replyIndex {
+	return &replyIndex{refs: make(map[int64]replyRef)}
+}
+
+// add 记录一条已导出的消息
+func (r *replyIndex) add(message *models.ChatHistory) {
+	if _, ok := r.refs[message.ID]; !ok {
+		r.order = append(r.order, message.ID)
+	}
+	r.refs[message.ID] = replyRef{sender: senderName(message), text: preview(message.Text)}
+	if len(r.order) > replyWindow {
+		delete(r.refs, r.order[0])
+		r.order = r.order[1:]
+	}
+}
+
+// get 查找被回复的消息，不在导出范围内时返回 false
+func (r *replyIndex) get(id int64) (replyRef, bool) {
+	ref, ok := r.refs[id]
+	return ref, ok
+}
+
+// preview 截取单行的引用文本
+func preview(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= replyPreviewLength {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:replyPreviewLength]) + "…"
+}
+
+// mediaName 返回附件的显示名称，没有文件名时从地址中取
+func mediaName(a models.Attachment) string {
+	if a.FileName != "" {
+		return a.FileName
+	}
+	name := path.Base(a.URL)
+	if name == "." || name == "/" {
+		return a.URL
+	}
+	return name
+}
+
+// markdownWriter Markdown 格式，按日期分节
+type markdownWriter struct {
+	out     *errWriter
+	w       *bufio.Writer
+	info    *Info
+	started bool
+	day     string
+	replies *replyIndex
+}
+
+func newMarkdownWriter(w io.Writer, info *Info) Writer {
+	out := &errWriter{w: w}
+	return &markdownWriter{out: out, w: bufio.NewWriter(out), info: info, replies: newReplyIndex()}
+}
+
+// start 写入标题，群组名称取自第一条记录
+func (m *markdownWriter) start(message *models.ChatHistory) {
+	if m.started {
+		return
+	}
+	m.started = true
+	title := fmt.Sprintf("群组(%d)", m.info.ChatID)
+	if message != nil {
+		title = groupName(message)
+	}
+	fmt.Fprintf(m.w, "# %s 聊天记录\n\n", escapeMarkdown(title))
+	fmt.Fprintf(m.w, "- 群组ID：%d\n", m.info.ChatID)
+	fmt.Fprintf(m.w, "- 时间范围：%s\n", describeRange(m.info))
+	fmt.Fprintf(m.w, "- 导出时间：%s\n", formatTime(m.info.ExportedAt))
+}
+
+func (m *markdownWriter) Write(message *models.ChatHistory) error {
+	m.start(message)
+
+	if day := message.Timestamp.Format("2006-01-02"); day != m.day {
+		m.day = day
+		fmt.Fprintf(m.w, "\n## %s\n", day)
+	}
+
+	fmt.Fprintf(m.w, "\n**%s** · %s\n\n", escapeMarkdown(senderName(message)), message.Timestamp.Format("15:04:05"))
+
+	if message.ReplyToID != 0 {
+		if ref, ok := m.replies.get(message.ReplyToID); ok {
+			fmt.Fprintf(m.w, "> 回复 **%s**：%s\n\n", escapeMarkdown(ref.sender), escapeMarkdown(ref.text))
+		} else {
+			fmt.Fprintf(m.w, "> 回复消息 #%d\n\n", message.ReplyToID)
+		}
+	}
+
+	if message.Text != "" {
+		// Markdown 中单个换行不换行，行尾加两个空格保留原文的换行
+		lines := strings.Split(message.Text, "\n")
+		fmt.Fprintf(m.w, "%s\n", strings.Join(lines, "  \n"))
+	}
+	for _, a := range message.Attachments {
+		if a.URL == "" {
+			continue
+		}
+		if a.IsImage() {
+			fmt.Fprintf(m.w, "\n![%s](%s)\n", escapeMarkdown(mediaName(a)), a.URL)
+		} else {
+			fmt.Fprintf(m.w, "\n[%s](%s)\n", escapeMarkdown(mediaName(a)), a.URL)
+		}
+	}
+
+	m.replies.add(message)
+	return m.out.err
+}
+
+func (m *markdownWriter) Close() error {
+	m.start(nil)
+	return m.w.Flush()
+}
+
+// senderName 返回发送者名称，旧记录可能没有用户名
+func senderName(message *models.ChatHistory) string {
+	if message.FromUser != "" {
+		return message.FromUser
+	}
+	return "未知用户"
+}
+
+// markdownEscaper 转义 Markdown 中有特殊含义的字符
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`, "<", "&lt;", ">", "&gt;",
+)
+
+// escapeMarkdown 转义标题、发送者等短文本，正文保持原样
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
@@ -0,0 +1,111 @@
+package export
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	_ "image/gif" // 注册 GIF 解码器，动图取第一帧
+	"image/jpeg"
+	_ "image/png" // 注册 PNG 解码器
+	"io"
+	"net/http"
+
+	"github.com/user/tg-forward-to-xx/internal/utils"
+)
+
+// HTML 导出内嵌缩略图的限制，超出限制或无法解码的图片显示为链接
+const (
+	thumbMaxSide      = 480      // 缩略图最长边（像素），页面按 240 显示，兼顾高分屏
+	thumbQuality      = 75       // JPEG 质量
+	thumbMaxSource    = 20 << 20 // 原图大小上限
+	thumbMaxEncoded   = 96 << 10 // 单张缩略图编码后的大小上限
+	thumbMaxEmbedded  = 32 << 20 // 单个导出文件内嵌缩略图的总大小上限
+	thumbDataURIStart = "data:image/jpeg;base64,"
+)
+
+// thumbnails 为 HTML 导出生成内嵌缩略图，记录已内嵌的总大小
+type thumbnails struct {
+	embedded int
+}
+
+// dataURI 下载图片并生成缩略图的 data: URI，失败或超出大小限制时返回错误
+func (t *thumbnails) dataURI(url string) (string, error) {
+	if t.embedded >= thumbMaxEmbedded {
+		return "", fmt.Errorf("内嵌缩略图总大小超过 %d 字节", thumbMaxEmbedded)
+	}
+
+	resp, err := utils.HTTPClient.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("下载图片失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("下载图片失败，状态码: %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, thumbMaxSource+1))
+	if err != nil {
+		return "", fmt.Errorf("读取图片失败: %w", err)
+	}
+	if len(data) > thumbMaxSource {
+		return "", fmt.Errorf("图片超过 %d 字节", thumbMaxSource)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("解码图片失败: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, downscale(img, thumbMaxSide), &jpeg.Options{Quality: thumbQuality}); err != nil {
+		return "", fmt.Errorf("编码缩略图失败: %w", err)
+	}
+	if buf.Len() > thumbMaxEncoded {
+		return "", fmt.Errorf("缩略图超过 %d 字节", thumbMaxEncoded)
+	}
+
+	uri := thumbDataURIStart + base64.StdEncoding.EncodeToString(buf.Bytes())
+	t.embedded += len(uri)
+	return uri, nil
+}
+
+// downscale 按区域平均将图片缩小到最长边不超过 maxSide，透明部分以白色填充
+func downscale(src image.Image, maxSide int) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tw, th := w, h
+	if w > maxSide || h > maxSide {
+		if w >= h {
+			tw, th = maxSide, max(1, h*maxSide/w)
+		} else {
+			tw, th = max(1, w*maxSide/h), maxSide
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := b.Min.Y+y*h/th, b.Min.Y+(y+1)*h/th
+		for x := 0; x < tw; x++ {
+			x0, x1 := b.Min.X+x*w/tw, b.Min.X+(x+1)*w/tw
+
+			var r, g, bl, n uint64
+			for sy := y0; sy < max(y1, y0+1); sy++ {
+				for sx := x0; sx < max(x1, x0+1); sx++ {
+					// RGBA 返回预乘透明度的值，叠加到白色背景上
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr + 0xffff - ca)
+					g += uint64(cg + 0xffff - ca)
+					bl += uint64(cb + 0xffff - ca)
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(bl / n >> 8)
+			dst.Pix[i+3] = 0xff
+		}
+	}
+	return dst
+}
@@ -0,0 +1,187 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// Excel 的限制：单个工作表的最大行数和单元格的最大字符数
+const (
+	xlsxMaxRows      = 1048576
+	xlsxMaxCellChars = 32767
+)
+
+// xlsxColumns 表头
+var xlsxColumns = []string{"消息ID", "群组ID", "群组名称", "用户名", "时间", "消息类型", "消息内容", "回复消息ID", "媒体"}
+
+// xlsxStatic 工作簿中除工作表以外的固定部分
+var xlsxStatic = []struct{ name, content string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="聊天记录" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+		`</Relationships>`},
+	{"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+		`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+		`<cellXfs count="3"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+		`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>` +
+		`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0" applyAlignment="1"><alignment wrapText="1" vertical="top"/></xf></cellXfs>` +
+		`</styleSheet>`},
+}
+
+// 样式编号，对应 styles.xml 中 cellXfs 的顺序
+const (
+	xlsxStyleHeader = 1 // 表头加粗
+	xlsxStyleWrap   = 2 // 自动换行
+)
+
+// xlsxWriter XLSX 格式，边遍历边写入 zip，工作表使用内联字符串，不需要在内存中维护共享字符串表
+type xlsxWriter struct {
+	out   *errWriter
+	zip   *zip.Writer
+	sheet *bufio.Writer
+	rows  int
+	err   error
+}
+
+func newXLSXWriter(w io.Writer, _ *Info) Writer {
+	out := &errWriter{w: w}
+	x := &xlsxWriter{out: out, zip: zip.NewWriter(out)}
+	x.err = x.start()
+	return x
+}
+
+// start 写入固定部分和工作表的开头
+func (x *xlsxWriter) start() error {
+	for _, part := range xlsxStatic {
+		f, err := x.zip.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return err
+		}
+	}
+
+	f, err := x.zip.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	x.sheet = bufio.NewWriter(f)
+	io.WriteString(x.sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	// 冻结表头
+	io.WriteString(x.sheet, `<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	io.WriteString(x.sheet, `<cols><col min="1" max="2" width="16" customWidth="1"/><col min="3" max="4" width="18" customWidth="1"/>`+
+		`<col min="5" max="5" width="20" customWidth="1"/><col min="7" max="7" width="80" customWidth="1"/><col min="9" max="9" width="40" customWidth="1"/></cols>`)
+	io.WriteString(x.sheet, `<sheetData>`)
+
+	x.row(xlsxStyleHeader, stringCells(xlsxColumns)...)
+	return x.out.err
+}
+
+// xlsxCell 单元格，number 为 true 时按数字写入
+type xlsxCell struct {
+	value  string
+	number bool
+}
+
+func stringCells(values []string) []xlsxCell {
+	cells := make([]xlsxCell, len(values))
+	for i, v := range values {
+		cells[i] = xlsxCell{value: v}
+	}
+	return cells
+}
+
+// row 写入一行
+func (x *xlsxWriter) row(style int, cells ...xlsxCell) {
+	x.rows++
+	fmt.Fprintf(x.sheet, `<row r="%d">`, x.rows)
+	for i, c := range cells {
+		if c.value == "" {
+			continue
+		}
+		ref := string(rune('A'+i)) + strconv.Itoa(x.rows)
+		if c.number {
+			fmt.Fprintf(x.sheet, `<c r="%s" s="%d"><v>%s</v></c>`, ref, style, c.value)
+			continue
+		}
+		fmt.Fprintf(x.sheet, `<c r="%s" s="%d" t="inlineStr"><is><t xml:space="preserve">`, ref, style)
+		xml.EscapeText(x.sheet, []byte(truncateCell(c.value)))
+		io.WriteString(x.sheet, `</t></is></c>`)
+	}
+	io.WriteString(x.sheet, `</row>`)
+}
+
+func (x *xlsxWriter) Write(message *models.ChatHistory) error {
+	if x.err != nil {
+		return x.err
+	}
+	if x.rows >= xlsxMaxRows {
+		return fmt.Errorf("XLSX 单个工作表最多 %d 行，请缩小时间范围或使用 csv、ndjson 格式", xlsxMaxRows)
+	}
+
+	replyTo := ""
+	if message.ReplyToID != 0 {
+		replyTo = strconv.FormatInt(message.ReplyToID, 10)
+	}
+	x.row(xlsxStyleWrap,
+		xlsxCell{value: strconv.FormatInt(message.ID, 10), number: true},
+		xlsxCell{value: strconv.FormatInt(message.ChatID, 10), number: true},
+		xlsxCell{value: message.GroupName},
+		xlsxCell{value: message.FromUser},
+		xlsxCell{value: formatTime(message.Timestamp)},
+		xlsxCell{value: message.MessageType},
+		xlsxCell{value: message.Text},
+		xlsxCell{value: replyTo, number: replyTo != ""},
+		xlsxCell{value: strings.Join(message.MediaURLs(), "\n")},
+	)
+	return x.out.err
+}
+
+func (x *xlsxWriter) Close() error {
+	if x.err != nil {
+		return x.err
+	}
+	io.WriteString(x.sheet, `</sheetData></worksheet>`)
+	if err := x.sheet.Flush(); err != nil {
+		return err
+	}
+	return x.zip.Close()
+}
+
+// truncateCell 截断超过 Excel 单元格上限的文本
+func truncateCell(s string) string {
+	if utf8.RuneCountInString(s) <= xlsxMaxCellChars {
+		return s
+	}
+	return string([]rune(s)[:xlsxMaxCellChars-1]) + "…"
+}
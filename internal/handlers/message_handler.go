@@ -1,9 +1,12 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -12,6 +15,7 @@ import (
 	"github.com/user/tg-forward-to-xx/internal/metrics"
 	"github.com/user/tg-forward-to-xx/internal/models"
 	"github.com/user/tg-forward-to-xx/internal/queue"
+	"github.com/user/tg-forward-to-xx/internal/sink"
 	"github.com/user/tg-forward-to-xx/internal/storage"
 	"github.com/user/tg-forward-to-xx/internal/notifier"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -32,36 +36,69 @@ type MessageHandler struct {
 	storage         *storage.ChatHistoryStorage
 	stopped         bool
 	harmony         *bot.HarmonyClient
+	router          *sink.Router
+	outputMutex     sync.RWMutex // 保护通知客户端和投递目标路由，重新加载配置时替换
+	mediaGroups     map[string]*mediaGroup
+	mediaGroupMutex sync.Mutex
+}
+
+// 相册消息聚合等待时间
+const mediaGroupWait = 2 * time.Second
+
+// 重试时 RetryOnly 中使用的输出名称，通用投递目标为 outputSinkPrefix 加 sink.DispatchTarget 名称
+const (
+	outputDingTalk   = "dingtalk"
+	outputSinkPrefix = "sink:"
+)
+
+// mediaGroup 正在聚合的相册消息，字段由 mediaGroupMutex 保护
+type mediaGroup struct {
+	msg     *models.Message
+	timer   *time.Timer
+	pending int // 正在上传媒体文件、尚未加入相册的消息数
+	gen     int // 计时器序号，只有最近一次启动的计时器可以发送相册
 }
 
 // NewMessageHandler 创建一个新的消息处理器
 func NewMessageHandler(q queue.Queue, storage *storage.ChatHistoryStorage) (*MessageHandler, error) {
+	cfg := config.Current()
 	handler := &MessageHandler{
 		dingTalk:      bot.NewDingTalkClient(),
 		bark:          bot.NewBarkClient(),
-		feishu:        notifier.NewFeishuNotifier(config.AppConfig.Feishu),
+		feishu:        notifier.NewFeishuNotifier(cfg.Feishu),
 		messageQueue:  q,
-		maxAttempts:   config.AppConfig.Retry.MaxAttempts,
-		retryInterval: time.Duration(config.AppConfig.Retry.Interval) * time.Second,
+		maxAttempts:   cfg.Retry.MaxAttempts,
+		retryInterval: time.Duration(cfg.Retry.Interval) * time.Second,
 		stopChan:      make(chan struct{}),
 		msgChan:       make(chan *models.Message, 100),
 		storage:       storage,
 		stopped:       false,
 		harmony:       bot.NewHarmonyClient(),
+		mediaGroups:   make(map[string]*mediaGroup),
 	}
 
 	// 如果启用了指标收集，创建指标报告器
-	if config.AppConfig.Metrics.Enabled {
-		interval := time.Duration(config.AppConfig.Metrics.Interval) * time.Second
-		handler.metricsReporter = metrics.NewReporter(q, interval, config.AppConfig.Metrics.OutputFile)
+	if cfg.Metrics.Enabled {
+		interval := time.Duration(cfg.Metrics.Interval) * time.Second
+		handler.metricsReporter = metrics.NewReporter(q, interval, cfg.Metrics.OutputFile)
 	}
 
-	bot, err := tgbotapi.NewBotAPI(config.AppConfig.Telegram.Token)
+	bot, err := tgbotapi.NewBotAPI(cfg.Telegram.Token)
 	if err != nil {
 		return nil, fmt.Errorf("创建 Telegram 客户端失败: %w", err)
 	}
 	handler.bot = bot
 
+	// 创建通用投递目标路由
+	router, err := sink.NewRouter(cfg.Sinks, cfg.Routes)
+	if err != nil {
+		return nil, fmt.Errorf("创建投递目标路由失败: %w", err)
+	}
+	handler.router = router
+
+	// 配置重新加载后重建通知客户端和投递目标
+	config.OnReload(handler.ApplyConfig)
+
 	return handler, nil
 }
 
@@ -89,9 +126,10 @@ func (h *MessageHandler) Start() error {
 	// 如果启用了指标收集，启动指标报告器
 	if h.metricsReporter != nil {
 		h.metricsReporter.Start()
+		metricsCfg := config.Current().Metrics
 		logrus.WithFields(logrus.Fields{
-			"interval": config.AppConfig.Metrics.Interval,
-			"path":     config.AppConfig.Metrics.OutputFile,
+			"interval": metricsCfg.Interval,
+			"path":     metricsCfg.OutputFile,
 		}).Info("📊 指标收集已启动")
 	}
 
@@ -110,6 +148,12 @@ func (h *MessageHandler) Stop() {
 		logrus.Errorf("关闭消息队列失败: %v", err)
 	}
 
+	h.outputMutex.Lock()
+	if err := h.router.Close(); err != nil {
+		logrus.Errorf("关闭投递目标失败: %v", err)
+	}
+	h.outputMutex.Unlock()
+
 	// 停止指标报告器
 	if h.metricsReporter != nil {
 		h.metricsReporter.Stop()
@@ -117,6 +161,38 @@ func (h *MessageHandler) Stop() {
 	}
 }
 
+// ApplyConfig 使用新配置重建通知客户端和投递目标路由，Telegram 连接和消息队列保持不变
+// 等待正在发送的消息完成后再替换，新路由创建失败时恢复旧路由
+func (h *MessageHandler) ApplyConfig(oldCfg, newCfg *config.Config) error {
+	h.outputMutex.Lock()
+	defer h.outputMutex.Unlock()
+
+	h.dingTalk = bot.NewDingTalkClient()
+	h.bark = bot.NewBarkClient()
+	h.feishu = notifier.NewFeishuNotifier(newCfg.Feishu)
+	h.harmony = bot.NewHarmonyClient()
+
+	// 投递目标可能独占本地资源（例如 LevelDB 映射库），必须先关闭旧路由
+	if err := h.router.Close(); err != nil {
+		logrus.Errorf("关闭旧投递目标失败: %v", err)
+	}
+
+	router, err := sink.NewRouter(newCfg.Sinks, newCfg.Routes)
+	if err == nil {
+		h.router = router
+		logrus.WithField("sinks", len(newCfg.Sinks)).Info("投递目标已按新配置重建")
+		return nil
+	}
+
+	restored, restoreErr := sink.NewRouter(oldCfg.Sinks, oldCfg.Routes)
+	if restoreErr != nil {
+		logrus.Errorf("恢复旧投递目标失败，暂停投递到通用投递目标: %v", restoreErr)
+		restored, _ = sink.NewRouter(nil, nil)
+	}
+	h.router = restored
+	return fmt.Errorf("创建投递目标路由失败: %w", err)
+}
+
 // 处理消息队列中的消息
 func (h *MessageHandler) processQueueMessages() {
 	logrus.Info("消息处理协程开始运行")
@@ -129,8 +205,8 @@ func (h *MessageHandler) processQueueMessages() {
 		case msg := <-h.msgChan:
 			logrus.WithFields(logrus.Fields{
 				"message_id": msg.ID,
-				"from":      msg.From,
-				"chat_id":   msg.ChatID,
+				"from":      msg.Sender.Name(),
+				"chat_id":   msg.Chat.ID,
 			}).Info("收到新消息，准备发送到钉钉")
 
 			startTime := time.Now()
@@ -182,6 +258,11 @@ func (h *MessageHandler) processTelegramUpdates(updates tgbotapi.UpdatesChannel)
 	for {
 		select {
 		case update := <-updates:
+			if update.EditedMessage != nil {
+				h.handleEditedMessage(update.EditedMessage)
+				continue
+			}
+
 			if update.Message == nil {
 				logrus.Debug("收到非消息更新，已忽略")
 				continue
@@ -205,161 +286,25 @@ func (h *MessageHandler) processTelegramUpdates(updates tgbotapi.UpdatesChannel)
 				continue
 			}
 
-			// 获取群组名称
-			groupName := h.getGroupName(update.Message.Chat)
-
-			// 保存聊天记录
-			history := &models.ChatHistory{
-				ID:        int64(update.Message.MessageID),
-				ChatID:    update.Message.Chat.ID,
-				Text:      update.Message.Text,
-				FromUser:  update.Message.From.UserName,
-				GroupName: groupName,
-				Timestamp: time.Unix(int64(update.Message.Date), 0),
+			// 上传媒体文件期间暂停相册的聚合计时，避免上传较慢时相册被拆开
+			if update.Message.MediaGroupID != "" {
+				h.holdMediaGroup(update.Message.MediaGroupID)
 			}
 
-			if err := h.storage.SaveMessage(history); err != nil {
-				logrus.WithError(err).Error("保存聊天记录失败")
-			}
+			// 构建结构化消息，媒体文件上传到 S3 后作为附件
+			msg := h.buildMessage(update.Message, true)
 
-			// 构建消息内容
-			var content string
-			var fileURL string
-
-			// 处理不同类型的消息
-			switch {
-			case len(update.Message.Photo) > 0:
-				logrus.Debug("处理图片消息")
-				// 获取最大尺寸的图片
-				photo := update.Message.Photo[len(update.Message.Photo)-1]
-				file, err := h.bot.GetFile(tgbotapi.FileConfig{FileID: photo.FileID})
-				if err != nil {
-					logrus.WithError(err).Error("获取图片文件信息失败")
-				} else {
-					// 下载文件并上传到 S3
-					fileURL, err = h.downloadAndUploadToS3(file, "photos", "image.jpg")
-					if err != nil {
-						logrus.WithError(err).Error("处理图片文件失败")
-					} else {
-						logrus.WithField("s3_url", fileURL).Debug("获取到 S3 图片 URL")
-					}
-				}
-				content = "[图片]"
-				if update.Message.Caption != "" {
-					content = fmt.Sprintf("[图片] %s", update.Message.Caption)
-				}
-
-			case update.Message.Document != nil:
-				logrus.Debug("处理文档消息")
-				file, err := h.bot.GetFile(tgbotapi.FileConfig{FileID: update.Message.Document.FileID})
-				if err != nil {
-					logrus.WithError(err).Error("获取文档文件信息失败")
-				} else {
-					// 下载文件并上传到 S3
-					fileURL, err = h.downloadAndUploadToS3(file, "documents", update.Message.Document.FileName)
-					if err != nil {
-						logrus.WithError(err).Error("处理文档文件失败")
-					} else {
-						logrus.WithField("s3_url", fileURL).Debug("获取到 S3 文档 URL")
-					}
-				}
-				content = fmt.Sprintf("[文档: %s]", update.Message.Document.FileName)
-				if update.Message.Caption != "" {
-					content = fmt.Sprintf("[文档: %s] %s", update.Message.Document.FileName, update.Message.Caption)
-				}
-
-			case update.Message.Video != nil:
-				logrus.Debug("处理视频消息")
-				file, err := h.bot.GetFile(tgbotapi.FileConfig{FileID: update.Message.Video.FileID})
-				if err != nil {
-					logrus.WithError(err).Error("获取视频文件信息失败")
-				} else {
-					// 下载文件并上传到 S3
-					fileURL, err = h.downloadAndUploadToS3(file, "videos", "video.mp4")
-					if err != nil {
-						logrus.WithError(err).Error("处理视频文件失败")
-					} else {
-						logrus.WithField("s3_url", fileURL).Debug("获取到 S3 视频 URL")
-					}
-				}
-				content = "[视频]"
-				if update.Message.Caption != "" {
-					content = fmt.Sprintf("[视频] %s", update.Message.Caption)
-				}
+			// 保存聊天记录，与后续转发是否成功无关
+			h.saveHistory(update.Message, msg)
 
-			case update.Message.Audio != nil:
-				logrus.Debug("处理音频消息")
-				file, err := h.bot.GetFile(tgbotapi.FileConfig{FileID: update.Message.Audio.FileID})
-				if err != nil {
-					logrus.WithError(err).Error("获取音频文件信息失败")
-				} else {
-					// 下载文件并上传到 S3
-					fileURL, err = h.downloadAndUploadToS3(file, "audios", "audio.mp3")
-					if err != nil {
-						logrus.WithError(err).Error("处理音频文件失败")
-					} else {
-						logrus.WithField("s3_url", fileURL).Debug("获取到 S3 音频 URL")
-					}
-				}
-				content = "[音频]"
-				if update.Message.Caption != "" {
-					content = fmt.Sprintf("[音频] %s", update.Message.Caption)
-				}
-
-			case update.Message.Text != "":
-				content = update.Message.Text
-			default:
-				content = "[不支持的消息类型]"
-			}
-
-			// 构建发送者信息
-			var sender string
-			if update.Message.From.UserName != "" {
-				sender = "@" + update.Message.From.UserName
-			} else {
-				sender = update.Message.From.FirstName
-				if update.Message.From.LastName != "" {
-					sender += " " + update.Message.From.LastName
-				}
-			}
-
-			// 如果有文件 URL，使用 markdown 格式
-			if fileURL != "" {
-				content = fmt.Sprintf("### 【%s】[%s]\n%s\n![预览](%s)", 
-					groupName, 
-					sender, 
-					content,
-					fileURL,
-				)
-			} else {
-				content = fmt.Sprintf("【%s】[%s]\n%s", groupName, sender, content)
-			}
-
-			// 创建消息对象
-			msg := &models.Message{
-				ID:        int64(update.Message.MessageID),
-				Content:   content,
-				From:     sender,
-				ChatID:   update.Message.Chat.ID,
-				ChatTitle: groupName,
-				CreatedAt: time.Now(),
-				IsMarkdown: fileURL != "",
+			// 相册中的每张图片是一条独立的消息，聚合后再发送
+			if update.Message.MediaGroupID != "" {
+				h.bufferMediaGroup(update.Message.MediaGroupID, msg)
+				continue
 			}
 
 			// 发送到消息通道
-			select {
-			case h.msgChan <- msg:
-				logrus.WithFields(logrus.Fields{
-					"message_id": msg.ID,
-					"chat_id":   msg.ChatID,
-					"has_file":  fileURL != "",
-				}).Debug("消息已加入处理队列")
-			default:
-				logrus.WithFields(logrus.Fields{
-					"message_id": msg.ID,
-					"chat_id":   msg.ChatID,
-				}).Warn("消息通道已满，消息可能丢失")
-			}
+			h.enqueueMessage(msg)
 
 		case <-h.stopChan:
 			logrus.Info("收到停止信号，停止处理 Telegram 更新")
@@ -368,73 +313,374 @@ func (h *MessageHandler) processTelegramUpdates(updates tgbotapi.UpdatesChannel)
 	}
 }
 
-// isTargetChat 检查是否是目标群组
-func (h *MessageHandler) isTargetChat(chatID int64) bool {
-	for _, id := range config.AppConfig.Telegram.ChatIDs {
-		if id == chatID {
-			return true
-		}
+// saveHistory 保存聊天记录，每条 Telegram 消息只在收到时写入一次
+// 键由群组、消息发送时间和消息ID组成，编辑后再次写入会覆盖原记录
+func (h *MessageHandler) saveHistory(message *tgbotapi.Message, msg *models.Message) {
+	fromUser := msg.Sender.Username
+	if fromUser == "" {
+		fromUser = msg.Sender.DisplayName
+	}
+
+	history := &models.ChatHistory{
+		ID:          int64(message.MessageID),
+		ChatID:      message.Chat.ID,
+		Text:        msg.Summary(),
+		FromUser:    fromUser,
+		GroupName:   h.getGroupName(message.Chat),
+		Timestamp:   time.Unix(int64(message.Date), 0),
+		MessageType: msg.MessageType,
+		HasMedia:    msg.HasMedia(),
+	}
+	if msg.ReplyTo != nil {
+		history.ReplyToID = int64(msg.ReplyTo.MessageID)
+	}
+	history.Attachments = append(history.Attachments, msg.Attachments...)
+
+	if err := h.storage.SaveMessage(history); err != nil {
+		logrus.WithError(err).Error("保存聊天记录失败")
 	}
-	return false
 }
 
-// forwardToDingTalk 转发消息到钉钉
-func (h *MessageHandler) forwardToDingTalk(message *tgbotapi.Message) error {
-	// 构建发送者信息
-	var sender string
-	if message.From.UserName != "" {
-		sender = "@" + message.From.UserName
+// enqueueMessage 将消息发送到处理通道
+func (h *MessageHandler) enqueueMessage(msg *models.Message) {
+	select {
+	case h.msgChan <- msg:
+		logrus.WithFields(logrus.Fields{
+			"message_id":   msg.ID,
+			"chat_id":      msg.Chat.ID,
+			"message_type": msg.MessageType,
+			"attachments":  len(msg.Attachments),
+		}).Debug("消息已加入处理队列")
+	default:
+		logrus.WithFields(logrus.Fields{
+			"message_id": msg.ID,
+			"chat_id":    msg.Chat.ID,
+		}).Warn("消息通道已满，消息可能丢失")
+	}
+}
+
+// Submit 提交一条由 API 构造的消息，与 Telegram 消息走相同的处理流程
+func (h *MessageHandler) Submit(msg *models.Message) error {
+	select {
+	case h.msgChan <- msg:
+		return nil
+	default:
+		return fmt.Errorf("消息通道已满，请稍后重试")
+	}
+}
+
+// SendTo 直接发送消息到指定名称的投递目标，不经过路由规则和重试队列
+func (h *MessageHandler) SendTo(sinkName string, msg *models.Message) error {
+	h.outputMutex.RLock()
+	defer h.outputMutex.RUnlock()
+
+	s, ok := h.router.Sink(sinkName)
+	if !ok {
+		return fmt.Errorf("%w: %s", sink.ErrSinkNotFound, sinkName)
+	}
+	return s.Send(msg)
+}
+
+// Retract 将源消息的删除同步到支持删除的投递目标
+func (h *MessageHandler) Retract(chatID int64, messageID int) error {
+	h.outputMutex.RLock()
+	defer h.outputMutex.RUnlock()
+
+	return h.router.DispatchDelete(chatID, messageID)
+}
+
+// holdMediaGroup 在相册的一条消息上传媒体文件前调用，暂停聚合计时直到对应的 bufferMediaGroup
+// 已经发送的相册不再接收新消息，之后到达的消息开始一个新的相册
+func (h *MessageHandler) holdMediaGroup(groupID string) {
+	h.mediaGroupMutex.Lock()
+	defer h.mediaGroupMutex.Unlock()
+
+	group, ok := h.mediaGroups[groupID]
+	if !ok {
+		group = &mediaGroup{}
+		h.mediaGroups[groupID] = group
+	}
+	group.pending++
+	// 已经触发但还在等待锁的计时器会发现 pending 不为 0 而放弃发送
+	if group.timer != nil {
+		group.timer.Stop()
+	}
+}
+
+// bufferMediaGroup 聚合同一相册的消息，所有消息上传完成后等待 mediaGroupWait 再作为一条相册消息发送
+func (h *MessageHandler) bufferMediaGroup(groupID string, msg *models.Message) {
+	h.mediaGroupMutex.Lock()
+	defer h.mediaGroupMutex.Unlock()
+
+	group, ok := h.mediaGroups[groupID]
+	if !ok {
+		group = &mediaGroup{}
+		h.mediaGroups[groupID] = group
+	} else if group.pending > 0 {
+		group.pending--
+	}
+
+	if group.msg == nil {
+		msg.MessageType = models.MessageTypeAlbum
+		group.msg = msg
 	} else {
-		// 如果没有用户名，使用姓名
-		sender = message.From.FirstName
-		if message.From.LastName != "" {
-			sender += " " + message.From.LastName
+		base := group.msg
+		base.Attachments = append(base.Attachments, msg.Attachments...)
+		// 相册的说明文字只附在其中一条消息上
+		if base.Text == "" {
+			base.Text = msg.Text
+			base.Entities = msg.Entities
 		}
 	}
+	if group.pending > 0 {
+		return
+	}
 
-	// 获取群组名称
-	groupName := h.getGroupName(message.Chat)
+	// 每次重新启动一个计时器，旧计时器即使已经触发也会因为序号不匹配而放弃
+	group.gen++
+	gen := group.gen
+	if group.timer != nil {
+		group.timer.Stop()
+	}
+	group.timer = time.AfterFunc(mediaGroupWait, func() {
+		h.flushMediaGroup(groupID, group, gen)
+	})
+}
+
+// flushMediaGroup 计时结束后发送相册，相册已被发送、仍有消息在上传或计时器已被替换时直接返回
+func (h *MessageHandler) flushMediaGroup(groupID string, group *mediaGroup, gen int) {
+	h.mediaGroupMutex.Lock()
+	if h.mediaGroups[groupID] != group || group.pending > 0 || group.gen != gen {
+		h.mediaGroupMutex.Unlock()
+		return
+	}
+	delete(h.mediaGroups, groupID)
+	h.mediaGroupMutex.Unlock()
+
+	logrus.WithFields(logrus.Fields{
+		"media_group_id": groupID,
+		"count":          len(group.msg.Attachments),
+	}).Debug("相册消息聚合完成")
+	h.enqueueMessage(group.msg)
+}
+
+// handleEditedMessage 将源消息的编辑同步到支持编辑的投递目标
+func (h *MessageHandler) handleEditedMessage(message *tgbotapi.Message) {
+	if !h.isTargetChat(message.Chat.ID) {
+		return
+	}
+
+	// 编辑只同步文本，不重新上传媒体文件
+	msg := h.buildMessage(message, false)
+	msg.Edited = true
 
-	// 构建消息内容
-	var content string
+	// 编辑后的消息与原消息写入同一个键，覆盖原记录
+	h.saveHistory(message, msg)
+
+	logrus.WithFields(logrus.Fields{
+		"message_id": message.MessageID,
+		"chat_id":    message.Chat.ID,
+	}).Debug("收到编辑后的消息，同步到投递目标")
+
+	h.outputMutex.RLock()
+	defer h.outputMutex.RUnlock()
+
+	if err := h.router.DispatchEdit(msg); err != nil {
+		logrus.Errorf("同步编辑消息失败: %v", err)
+	}
+}
+
+// buildMessage 将 Telegram 消息转换为结构化消息，uploadMedia 为 true 时将媒体文件上传到 S3
+func (h *MessageHandler) buildMessage(message *tgbotapi.Message, uploadMedia bool) *models.Message {
+	msg := models.NewMessage(message.Text, newSender(message.From), newChat(message.Chat))
+	msg.ID = int64(message.MessageID)
+	msg.MessageID = message.MessageID
+	msg.Entities = convertEntities(message.Entities)
 
-	// 如果是回复消息，添加回复信息
 	if message.ReplyToMessage != nil {
-		var replyTo string
-		if message.ReplyToMessage.From.UserName != "" {
-			replyTo = "@" + message.ReplyToMessage.From.UserName
-		} else {
-			replyTo = message.ReplyToMessage.From.FirstName
-			if message.ReplyToMessage.From.LastName != "" {
-				replyTo += " " + message.ReplyToMessage.From.LastName
-			}
+		reply := message.ReplyToMessage
+		replyText := reply.Text
+		if replyText == "" {
+			replyText = reply.Caption
 		}
+		msg.ReplyTo = &models.ReplyRef{
+			MessageID: reply.MessageID,
+			Sender:    newSender(reply.From).Name(),
+			Text:      replyText,
+		}
+	}
 
-		// 添加回复的原始消息（最多显示100个字符，避免太长）
-		replyText := message.ReplyToMessage.Text
-		if len(replyText) > 100 {
-			replyText = replyText[:97] + "..."
+	// 媒体消息使用说明文字作为正文
+	if message.Text == "" && message.Caption != "" {
+		msg.Text = message.Caption
+		msg.Entities = convertEntities(message.CaptionEntities)
+	}
+
+	var attachment *models.Attachment
+	var fileID, category string
+
+	// 处理不同类型的消息
+	switch {
+	case len(message.Photo) > 0:
+		logrus.Debug("处理图片消息")
+		msg.MessageType = models.MessageTypePhoto
+		// 获取最大尺寸的图片
+		photo := message.Photo[len(message.Photo)-1]
+		fileID, category = photo.FileID, "photos"
+		attachment = &models.Attachment{
+			Kind:         models.MessageTypePhoto,
+			MIMEType:     "image/jpeg",
+			Size:         int64(photo.FileSize),
+			Width:        photo.Width,
+			Height:       photo.Height,
+			FileName:     "image.jpg",
+			FileUniqueID: photo.FileUniqueID,
 		}
 
-		content = fmt.Sprintf("【%s】[%s 回复 %s]\n▶ %s\n-------------------\n%s",
-			groupName,
-			sender,
-			replyTo,
-			replyText,
-			message.Text)
-	} else {
-		// 普通消息
-		content = fmt.Sprintf("【%s】[%s]\n%s", groupName, sender, message.Text)
+	case message.Document != nil:
+		logrus.Debug("处理文档消息")
+		msg.MessageType = models.MessageTypeDocument
+		doc := message.Document
+		fileID, category = doc.FileID, "documents"
+		attachment = &models.Attachment{
+			Kind:         models.MessageTypeDocument,
+			MIMEType:     doc.MimeType,
+			Size:         int64(doc.FileSize),
+			FileName:     doc.FileName,
+			FileUniqueID: doc.FileUniqueID,
+		}
+
+	case message.Video != nil:
+		logrus.Debug("处理视频消息")
+		msg.MessageType = models.MessageTypeVideo
+		video := message.Video
+		fileID, category = video.FileID, "videos"
+		attachment = &models.Attachment{
+			Kind:         models.MessageTypeVideo,
+			MIMEType:     video.MimeType,
+			Size:         int64(video.FileSize),
+			Width:        video.Width,
+			Height:       video.Height,
+			FileName:     defaultString(video.FileName, "video.mp4"),
+			FileUniqueID: video.FileUniqueID,
+		}
+
+	case message.Audio != nil:
+		logrus.Debug("处理音频消息")
+		msg.MessageType = models.MessageTypeAudio
+		audio := message.Audio
+		fileID, category = audio.FileID, "audios"
+		attachment = &models.Attachment{
+			Kind:         models.MessageTypeAudio,
+			MIMEType:     audio.MimeType,
+			Size:         int64(audio.FileSize),
+			FileName:     defaultString(audio.FileName, "audio.mp3"),
+			FileUniqueID: audio.FileUniqueID,
+		}
+
+	case message.Text != "":
+		msg.MessageType = models.MessageTypeText
+
+	default:
+		msg.MessageType = models.MessageTypeOther
 	}
 
-	// 转换为钉钉消息格式
-	msg := &models.Message{
-		ID:      int64(message.MessageID),
-		ChatID:  message.Chat.ID,
-		From:    sender,
-		Content: content,
+	if attachment == nil || !uploadMedia {
+		return msg
+	}
+
+	file, err := h.bot.GetFile(tgbotapi.FileConfig{FileID: fileID})
+	if err != nil {
+		logrus.WithError(err).Errorf("获取 %s 文件信息失败", msg.MessageType)
+		return msg
 	}
 
+	// 下载文件并上传到 S3
+	attachment.URL, attachment.Object, err = h.downloadAndUploadToS3(file, category, attachment.FileName)
+	if err != nil {
+		logrus.WithError(err).Errorf("处理 %s 文件失败", msg.MessageType)
+		return msg
+	}
+	if attachment.Size == 0 {
+		attachment.Size = int64(file.FileSize)
+	}
+	logrus.WithField("s3_url", attachment.URL).Debug("获取到 S3 文件 URL")
+
+	msg.Attachments = append(msg.Attachments, *attachment)
+	return msg
+}
+
+// newSender 构建发送者信息
+func newSender(user *tgbotapi.User) models.Sender {
+	if user == nil {
+		return models.Sender{}
+	}
+	name := user.FirstName
+	if user.LastName != "" {
+		name += " " + user.LastName
+	}
+	return models.Sender{
+		ID:          user.ID,
+		Username:    user.UserName,
+		DisplayName: name,
+	}
+}
+
+// newChat 构建聊天信息
+func newChat(chat *tgbotapi.Chat) models.Chat {
+	if chat == nil {
+		return models.Chat{}
+	}
+	return models.Chat{
+		ID:    chat.ID,
+		Title: chat.Title,
+		Type:  chat.Type,
+	}
+}
+
+// convertEntities 转换 Telegram 格式实体
+func convertEntities(entities []tgbotapi.MessageEntity) []models.Entity {
+	if len(entities) == 0 {
+		return nil
+	}
+	result := make([]models.Entity, 0, len(entities))
+	for _, e := range entities {
+		result = append(result, models.Entity{
+			Type:   e.Type,
+			Offset: e.Offset,
+			Length: e.Length,
+			URL:    e.URL,
+		})
+	}
+	return result
+}
+
+// defaultString 值为空时返回默认值
+func defaultString(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+// isTargetChat 检查是否是目标群组
+func (h *MessageHandler) isTargetChat(chatID int64) bool {
+	for _, id := range config.Current().Telegram.ChatIDs {
+		if id == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardToDingTalk 转发消息到钉钉
+func (h *MessageHandler) forwardToDingTalk(message *tgbotapi.Message) error {
+	// 回复信息由消息的 ReplyTo 字段渲染
+	msg := h.buildMessage(message, false)
+
+	h.outputMutex.RLock()
+	defer h.outputMutex.RUnlock()
+
 	// 发送到钉钉
 	return h.dingTalk.SendMessage(msg)
 }
@@ -493,7 +739,7 @@ func (h *MessageHandler) processQueuedMessages() {
 
 		// 检查重试次数
 		if msg.Attempts >= h.maxAttempts {
-			logrus.Warnf("消息 %s 已达到最大重试次数 (%d)，放弃重试", msg.ID, h.maxAttempts)
+			logrus.Warnf("消息 %d 已达到最大重试次数 (%d)，放弃重试", msg.ID, h.maxAttempts)
 			continue
 		}
 
@@ -515,7 +761,7 @@ func (h *MessageHandler) processQueuedMessages() {
 			// 增加重试计数
 			metrics.IncrementRetryCount()
 		} else {
-			logrus.Infof("成功重试处理消息: %s (尝试次数: %d)", msg.ID, msg.Attempts)
+			logrus.Infof("成功重试处理消息: %d (尝试次数: %d)", msg.ID, msg.Attempts)
 			// 增加处理成功消息计数
 			metrics.IncrementProcessedMessages()
 		}
@@ -527,131 +773,84 @@ func (h *MessageHandler) processQueuedMessages() {
 // processMessage 处理单个消息
 func (h *MessageHandler) processMessage(msg *models.Message) error {
 	// 获取聊天信息
-	chat, err := h.bot.GetChat(tgbotapi.ChatInfoConfig{ChatConfig: tgbotapi.ChatConfig{ChatID: msg.ChatID}})
+	chat, err := h.bot.GetChat(tgbotapi.ChatInfoConfig{ChatConfig: tgbotapi.ChatConfig{ChatID: msg.Chat.ID}})
 	if err != nil {
 		logrus.Errorf("获取聊天信息失败: %v", err)
 		return err
 	}
 
 	// 更新消息的聊天标题
-	msg.ChatTitle = chat.Title
+	msg.Chat.Title = chat.Title
+
+	cfg := config.Current()
+
+	h.outputMutex.RLock()
+	defer h.outputMutex.RUnlock()
 
-	// 发送钉钉消息
-	if h.dingTalk != nil && config.AppConfig.DingTalk.Enabled {
-		if err := h.dingTalk.SendMessage(msg); err != nil {
+	// 重试时只发送上次暂时失败的输出，其他输出已经发送过
+	retryOnly := msg.RetryOnly
+	var retry []string
+	var retryErr error
+
+	// 发送钉钉消息，限流时不等待，返回错误由重试队列稍后重发
+	if h.dingTalk != nil && cfg.DingTalk.Enabled && (len(retryOnly) == 0 || slices.Contains(retryOnly, outputDingTalk)) {
+		if err := h.dingTalk.SendMessage(msg); errors.Is(err, bot.ErrDingTalkRateLimited) {
+			retry = append(retry, outputDingTalk)
+			retryErr = err
+		} else if err != nil {
 			logrus.Errorf("发送钉钉消息失败: %v", err)
 		}
 	}
 
-	// 发送飞书消息
-	if h.feishu != nil && config.AppConfig.Feishu.Enabled {
-		isFile := msg.IsMarkdown
-		fileURL := ""
-		if isFile {
-			// 从消息内容中提取文件URL
-			lines := strings.Split(msg.Content, "\n")
-			for _, line := range lines {
-				if strings.HasPrefix(line, "http") {
-					fileURL = line
-					break
-				}
+	// 飞书、Bark 和 HarmonyOS_MeoW 失败时不重试，重试时跳过
+	if len(retryOnly) == 0 {
+		// 发送飞书消息
+		if h.feishu != nil && cfg.Feishu.Enabled {
+			if err := h.feishu.Send(msg); err != nil {
+				logrus.Errorf("发送飞书消息失败: %v", err)
 			}
 		}
-		if err := h.feishu.Send(msg.ChatTitle, msg.Content, isFile, fileURL); err != nil {
-			logrus.Errorf("发送飞书消息失败: %v", err)
+
+		// 发送到 Bark
+		if err := h.bark.SendMessage(chat.Title, msg); err != nil {
+			logrus.Errorf("发送到 Bark 失败: %v", err)
 		}
-	}
 
-	// 发送到 Bark
-	if err := h.bark.SendMessage(chat.Title, msg); err != nil {
-		logrus.Errorf("发送到 Bark 失败: %v", err)
+		// 发送到 HarmonyOS_MeoW
+		if err := h.harmony.SendMessage(chat.Title, msg); err != nil {
+			logrus.Errorf("发送到 HarmonyOS_MeoW 失败: %v", err)
+		}
 	}
 
-	// 发送到 HarmonyOS_MeoW
-	var harmonyContent string
-	
-	// 检查是否为图片消息
-	isImageMessage := false
-	if msg.IsMarkdown && config.AppConfig.S3 != nil {
-		lines := strings.Split(msg.Content, "\n")
-		for _, line := range lines {
-			if strings.Contains(line, "https://s3.cloudhkcdn.com/") {
-				// 提取实际的 S3 URL
-				start := strings.Index(line, "https://s3.cloudhkcdn.com/")
-				if start != -1 {
-					end := strings.Index(line[start:], ")")
-					if end != -1 {
-						s3URL := line[start : start+end]
-						harmonyContent = fmt.Sprintf("图片通知?url=%s", s3URL)
-						isImageMessage = true
-						
-						// 打印调试信息
-						logrus.WithFields(logrus.Fields{
-							"message_type": "image",
-							"extracted_url": s3URL,
-							"harmony_content": harmonyContent,
-						}).Debug("构建 HarmonyOS_MeoW 图片通知内容")
-						break
-					}
-				}
-			}
+	// 按路由投递到通用投递目标，暂时失败的目标由重试队列稍后重发
+	var targets []string
+	for _, output := range retryOnly {
+		if target, ok := strings.CutPrefix(output, outputSinkPrefix); ok {
+			targets = append(targets, target)
 		}
 	}
-	
-	if !isImageMessage {
-		// 如果是文本消息，直接使用内容
-		content := msg.Content
-		if msg.IsMarkdown && strings.HasPrefix(content, "###") {
-			// 移除 markdown 标题和格式
-			lines := strings.Split(content, "\n")
-			if len(lines) > 1 {
-				// 提取实际的消息内容（去掉标题行）
-				content = strings.Join(lines[1:], "\n")
+	if len(retryOnly) == 0 || len(targets) > 0 {
+		err := h.router.Dispatch(msg, targets...)
+		var dispatchErr *sink.DispatchError
+		if errors.As(err, &dispatchErr) && len(dispatchErr.Retry) > 0 {
+			for _, target := range dispatchErr.Retry {
+				retry = append(retry, outputSinkPrefix+target)
 			}
+			retryErr = err
+		} else if err != nil {
+			logrus.Errorf("投递到通用投递目标失败: %v", err)
 		}
-		// 提取纯文本内容（移除 markdown 格式）
-		content = strings.TrimPrefix(content, "【")
-		content = strings.TrimSuffix(content, "】")
-		if idx := strings.Index(content, "】["); idx != -1 {
-			content = content[idx+2:]
-		}
-		if idx := strings.Index(content, "]\n"); idx != -1 {
-			content = content[idx+2:]
-		}
-		harmonyContent = content
-		
-		// 打印调试信息
-		logrus.WithFields(logrus.Fields{
-			"message_type": "text",
-			"original_content": msg.Content,
-			"harmony_content": harmonyContent,
-		}).Debug("构建 HarmonyOS_MeoW 文本通知内容")
-	}
-	
-	if err := h.harmony.SendMessage(chat.Title, harmonyContent, ""); err != nil {
-		logrus.Errorf("发送到 HarmonyOS_MeoW 失败: %v", err)
 	}
 
-	// 保存聊天记录
-	history := &models.ChatHistory{
-		ID:        msg.ID,
-		ChatID:    msg.ChatID,
-		Text:      msg.Content,
-		FromUser:  msg.From,
-		GroupName: chat.Title,
-		Timestamp: msg.CreatedAt,
+	if len(retry) > 0 {
+		msg.RetryOnly = retry
+		return retryErr
 	}
-
-	if err := h.storage.SaveMessage(history); err != nil {
-		logrus.Errorf("保存聊天记录失败: %v", err)
-		return err
-	}
-
 	return nil
 }
 
-// 添加 downloadAndUploadToS3 函数
-func (h *MessageHandler) downloadAndUploadToS3(file tgbotapi.File, category, filename string) (string, error) {
+// downloadAndUploadToS3 下载 Telegram 文件并上传到 S3，返回 S3 地址和对象名称
+func (h *MessageHandler) downloadAndUploadToS3(file tgbotapi.File, category, filename string) (string, string, error) {
 	logrus.WithFields(logrus.Fields{
 		"file_id":   file.FileID,
 		"category":  category,
@@ -661,19 +860,19 @@ func (h *MessageHandler) downloadAndUploadToS3(file tgbotapi.File, category, fil
 	// 创建 S3 客户端
 	s3Client, err := storage.NewS3Client()
 	if err != nil {
-		return "", fmt.Errorf("创建 S3 客户端失败: %w", err)
+		return "", "", fmt.Errorf("创建 S3 客户端失败: %w", err)
 	}
 
 	// 下载文件
-	fileURL := file.Link(config.AppConfig.Telegram.Token)
+	fileURL := file.Link(config.Current().Telegram.Token)
 	resp, err := http.Get(fileURL)
 	if err != nil {
-		return "", fmt.Errorf("下载文件失败: %w", err)
+		return "", "", fmt.Errorf("下载文件失败: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("下载文件失败，状态码: %d", resp.StatusCode)
+		return "", "", fmt.Errorf("下载文件失败，状态码: %d", resp.StatusCode)
 	}
 
 	// 生成唯一的对象名称
@@ -683,7 +882,7 @@ func (h *MessageHandler) downloadAndUploadToS3(file tgbotapi.File, category, fil
 	// 上传到 S3
 	s3URL, err := s3Client.UploadFile(resp.Body, objectName, resp.Header.Get("Content-Type"))
 	if err != nil {
-		return "", fmt.Errorf("上传到 S3 失败: %w", err)
+		return "", "", fmt.Errorf("上传到 S3 失败: %w", err)
 	}
 
 	logrus.WithFields(logrus.Fields{
@@ -692,5 +891,5 @@ func (h *MessageHandler) downloadAndUploadToS3(file tgbotapi.File, category, fil
 		"s3_url":      s3URL,
 	}).Debug("文件已成功上传到 S3")
 
-	return s3URL, nil
+	return s3URL, objectName, nil
 }
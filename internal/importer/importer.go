@@ -0,0 +1,291 @@
+package importer
+
+import (
+	"fmt"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/user/tg-forward-to-xx/internal/models"
+	"github.com/user/tg-forward-to-xx/internal/storage"
+)
+
+// 每批写入的消息数量
+const importBatchSize = 500
+
+// Options 导入选项
+type Options struct {
+	ChatID      int64             // 只导入指定群组（Bot API 格式的ID），0 表示导入文件中的全部聊天
+	UploadMedia bool              // 将导出目录中的媒体文件上传到 S3
+	DryRun      bool              // 只统计，不写入聊天记录也不上传
+	Progress    func(stats Stats) // 每写入一批后回调
+}
+
+// Stats 导入统计
+type Stats struct {
+	Chats         int `json:"chats"`          // 导入的聊天数
+	Messages      int `json:"messages"`       // 读取的普通消息数
+	Imported      int `json:"imported"`       // 新写入的消息数
+	Duplicates    int `json:"duplicates"`     // 已存在而跳过的消息数
+	Service       int `json:"service"`        // 跳过的系统消息数
+	MediaUploaded int `json:"media_uploaded"` // 上传到 S3 的媒体文件数
+	MediaMissing  int `json:"media_missing"`  // 导出时未下载或文件不存在的媒体数
+}
+
+// importer 一次导入的状态
+type importer struct {
+	history *storage.ChatHistoryStorage
+	s3      *storage.S3Client
+	baseDir string // result.json 所在目录，媒体路径相对于此目录
+	opts    Options
+	stats   Stats
+	batch   []*pendingMessage
+	chats   map[int64]bool
+}
+
+// pendingMessage 等待写入的消息及其本地媒体文件
+type pendingMessage struct {
+	history    *models.ChatHistory
+	media      string             // 导出目录中的相对路径，为空表示没有媒体
+	attachment *models.Attachment // 媒体的元数据，上传后补充地址和对象名称
+}
+
+// ImportFile 导入 Telegram Desktop 导出的 result.json，可以重复运行，已存在的消息会被跳过
+func ImportFile(history *storage.ChatHistoryStorage, file string, opts Options) (Stats, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return Stats{}, fmt.Errorf("打开导出文件失败: %w", err)
+	}
+	defer f.Close()
+
+	im := &importer{
+		history: history,
+		baseDir: filepath.Dir(file),
+		opts:    opts,
+		chats:   make(map[int64]bool),
+	}
+	if opts.UploadMedia && !opts.DryRun {
+		if im.s3, err = storage.NewS3Client(); err != nil {
+			return Stats{}, err
+		}
+	}
+
+	err = readExport(f, im.add)
+	if err == nil {
+		err = im.flush()
+	}
+	im.stats.Chats = len(im.chats)
+	return im.stats, err
+}
+
+// add 转换一条消息并加入待写入的批次
+func (im *importer) add(chat *desktopChat, message *desktopMessage) error {
+	chatID := chat.BotChatID()
+	if im.opts.ChatID != 0 && chatID != im.opts.ChatID {
+		return nil
+	}
+	if message.Type != "message" {
+		im.stats.Service++
+		return nil
+	}
+	im.chats[chatID] = true
+	im.stats.Messages++
+
+	pending, err := convert(chat, message)
+	if err != nil {
+		return fmt.Errorf("转换消息 %d 失败: %w", message.ID, err)
+	}
+	im.batch = append(im.batch, pending)
+	if len(im.batch) >= importBatchSize {
+		return im.flush()
+	}
+	return nil
+}
+
+// flush 跳过已存在的消息，上传媒体后写入一批消息
+func (im *importer) flush() error {
+	if len(im.batch) == 0 {
+		return nil
+	}
+	batch := im.batch
+	im.batch = nil
+
+	var histories []*models.ChatHistory
+	for _, p := range batch {
+		h := p.history
+		exists, err := im.history.Exists(h.ChatID, h.Timestamp, h.ID)
+		if err != nil {
+			return err
+		}
+		if exists {
+			im.stats.Duplicates++
+			continue
+		}
+
+		if p.media != "" {
+			ok, err := im.media(p)
+			if err != nil {
+				return err
+			}
+			if ok {
+				h.Attachments = []models.Attachment{*p.attachment}
+			}
+		}
+		histories = append(histories, h)
+	}
+
+	if im.opts.DryRun {
+		im.stats.Imported += len(histories)
+	} else {
+		imported, err := im.history.ImportMessages(histories)
+		if err != nil {
+			return err
+		}
+		im.stats.Imported += imported
+	}
+
+	if im.opts.Progress != nil {
+		im.opts.Progress(im.stats)
+	}
+	return nil
+}
+
+// media 补充附件的地址，上传到 S3 时为 S3 地址和对象名称，否则为导出目录中的相对路径
+// 导出中没有媒体文件时返回 false
+func (im *importer) media(p *pendingMessage) (bool, error) {
+	// 导出时未勾选下载媒体的文件路径为 "(File not included. ...)" 之类的说明
+	if strings.HasPrefix(p.media, "(") {
+		im.stats.MediaMissing++
+		return false, nil
+	}
+	local := filepath.Join(im.baseDir, filepath.FromSlash(p.media))
+	if im.s3 == nil {
+		if _, err := os.Stat(local); err != nil {
+			im.stats.MediaMissing++
+		}
+		p.attachment.URL = p.media
+		return true, nil
+	}
+
+	f, err := os.Open(local)
+	if err != nil {
+		im.stats.MediaMissing++
+		return false, nil
+	}
+	defer f.Close()
+
+	// 对象名称由群组和消息ID决定，重复导入时覆盖同一个对象
+	h := p.history
+	objectName := fmt.Sprintf("%s/import_%d_%d_%s", mediaCategory(h.MessageType), h.ChatID, h.ID, path.Base(p.media))
+	url, err := im.s3.UploadFile(f, objectName, p.attachment.MIMEType)
+	if err != nil {
+		return false, err
+	}
+	im.stats.MediaUploaded++
+	p.attachment.URL, p.attachment.Object = url, objectName
+	if p.attachment.Size == 0 {
+		if info, err := f.Stat(); err == nil {
+			p.attachment.Size = info.Size()
+		}
+	}
+	return true, nil
+}
+
+// convert 将 Telegram Desktop 的消息转换为聊天记录，消息类型和正文与 Bot 保存的记录保持一致
+func convert(chat *desktopChat, message *desktopMessage) (*pendingMessage, error) {
+	timestamp, err := message.Time()
+	if err != nil {
+		return nil, err
+	}
+
+	// 只有显示名称，已注销的账号没有名称
+	sender := message.From
+	if sender == "" {
+		sender = string(message.FromID)
+	}
+
+	msg := &models.Message{Text: string(message.Text)}
+	pending := &pendingMessage{}
+	switch {
+	case message.Photo != "":
+		msg.MessageType = models.MessageTypePhoto
+		pending.media = message.Photo
+		msg.Attachments = []models.Attachment{{
+			Kind:     models.MessageTypePhoto,
+			MIMEType: "image/jpeg",
+			Size:     message.PhotoFileSize,
+			Width:    message.Width,
+			Height:   message.Height,
+		}}
+
+	case message.File != "":
+		// 与 Bot 一致：语音、圆形视频和贴纸不作为媒体保存，动图属于文档
+		switch message.MediaType {
+		case "video_file":
+			msg.MessageType = models.MessageTypeVideo
+		case "audio_file":
+			msg.MessageType = models.MessageTypeAudio
+		case "voice_message", "video_message", "sticker":
+			msg.MessageType = models.MessageTypeOther
+		default:
+			msg.MessageType = models.MessageTypeDocument
+		}
+		if msg.MessageType == models.MessageTypeOther {
+			break
+		}
+		pending.media = message.File
+		mimeType := message.MimeType
+		if mimeType == "" {
+			mimeType = mime.TypeByExtension(path.Ext(message.File))
+		}
+		fileName := message.FileName
+		if fileName == "" && !strings.HasPrefix(message.File, "(") {
+			fileName = path.Base(message.File)
+		}
+		msg.Attachments = []models.Attachment{{
+			Kind:     msg.MessageType,
+			MIMEType: mimeType,
+			Size:     message.FileSize,
+			Width:    message.Width,
+			Height:   message.Height,
+			FileName: fileName,
+		}}
+
+	case msg.Text != "":
+		msg.MessageType = models.MessageTypeText
+
+	default:
+		msg.MessageType = models.MessageTypeOther
+	}
+
+	if len(msg.Attachments) > 0 {
+		pending.attachment = &msg.Attachments[0]
+	}
+	pending.history = &models.ChatHistory{
+		ID:          message.ID,
+		ChatID:      chat.BotChatID(),
+		Text:        msg.Summary(),
+		FromUser:    sender,
+		GroupName:   chat.Name,
+		Timestamp:   timestamp,
+		MessageType: msg.MessageType,
+		HasMedia:    msg.HasMedia(),
+		ReplyToID:   message.ReplyToMessageID,
+	}
+	return pending, nil
+}
+
+// mediaCategory 返回媒体在 S3 中的目录，与 Bot 上传的目录相同
+func mediaCategory(messageType string) string {
+	switch messageType {
+	case models.MessageTypePhoto:
+		return "photos"
+	case models.MessageTypeVideo:
+		return "videos"
+	case models.MessageTypeAudio:
+		return "audios"
+	}
+	return "documents"
+}
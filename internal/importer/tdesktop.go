@@ -0,0 +1,244 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// desktopChat Telegram Desktop 导出的一个聊天
+type desktopChat struct {
+	Name string
+	Type string
+	ID   int64
+}
+
+// BotChatID 将 Telegram Desktop 导出的聊天ID转换为 Bot API 使用的ID
+// 超级群组和频道在 Bot API 中带有 -100 前缀，普通群组为负数
+func (c *desktopChat) BotChatID() int64 {
+	switch c.Type {
+	case "private_supergroup", "public_supergroup", "private_channel", "public_channel":
+		return -1000000000000 - c.ID
+	case "private_group":
+		return -c.ID
+	}
+	return c.ID
+}
+
+// desktopMessage Telegram Desktop 导出的一条消息，只包含需要的字段
+type desktopMessage struct {
+	ID               int64       `json:"id"`
+	Type             string      `json:"type"` // message 或 service（入群、置顶等系统消息）
+	Date             string      `json:"date"` // 本地时间，例如 2024-01-01T12:00:00
+	DateUnixtime     string      `json:"date_unixtime"`
+	From             string      `json:"from"`
+	FromID           desktopID   `json:"from_id"`
+	ReplyToMessageID int64       `json:"reply_to_message_id"`
+	Text             desktopText `json:"text"`
+	Photo            string      `json:"photo"`
+	PhotoFileSize    int64       `json:"photo_file_size"`
+	Width            int         `json:"width"`
+	Height           int         `json:"height"`
+	File             string      `json:"file"`
+	FileSize         int64       `json:"file_size"`
+	FileName         string      `json:"file_name"`
+	MediaType        string      `json:"media_type"`
+	MimeType         string      `json:"mime_type"`
+}
+
+// Time 返回消息的发送时间，旧版本导出没有 date_unixtime 时按本地时区解析 date
+func (m *desktopMessage) Time() (time.Time, error) {
+	if m.DateUnixtime != "" {
+		sec, err := strconv.ParseInt(m.DateUnixtime, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("无效的消息时间 %q", m.DateUnixtime)
+		}
+		return time.Unix(sec, 0), nil
+	}
+	t, err := time.ParseInLocation("2006-01-02T15:04:05", m.Date, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("无效的消息时间 %q", m.Date)
+	}
+	return t, nil
+}
+
+// desktopID 发送者ID，新版本为 "user123" 形式的字符串，旧版本为数字
+type desktopID string
+
+func (id *desktopID) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*id = desktopID(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("无法解析发送者ID: %w", err)
+	}
+	*id = desktopID(n.String())
+	return nil
+}
+
+// desktopText 消息正文，纯文本时为字符串，带格式时为字符串和 {"type": ..., "text": ...} 混合的数组
+type desktopText string
+
+func (t *desktopText) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*t = desktopText(s)
+		return nil
+	}
+
+	var parts []json.RawMessage
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return fmt.Errorf("无法解析消息正文: %w", err)
+	}
+	var b strings.Builder
+	for _, part := range parts {
+		var text string
+		if err := json.Unmarshal(part, &text); err == nil {
+			b.WriteString(text)
+			continue
+		}
+		var entity struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(part, &entity); err != nil {
+			return fmt.Errorf("无法解析消息正文: %w", err)
+		}
+		b.WriteString(entity.Text)
+	}
+	*t = desktopText(b.String())
+	return nil
+}
+
+// readExport 流式读取 result.json，逐条回调消息，不将整个文件读入内存
+// 支持导出单个聊天（顶层即为聊天）和导出全部数据（聊天位于 chats.list 和 left_chats.list）
+func readExport(r io.Reader, fn func(chat *desktopChat, message *desktopMessage) error) error {
+	dec := json.NewDecoder(bufio.NewReaderSize(r, 1<<20))
+	if err := readChat(dec, fn, true); err != nil {
+		return fmt.Errorf("解析导出文件失败: %w", err)
+	}
+	return nil
+}
+
+// readChat 读取一个聊天对象，top 为 true 时同时处理全部数据导出中的聊天列表
+func readChat(dec *json.Decoder, fn func(*desktopChat, *desktopMessage) error, top bool) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+
+	chat := &desktopChat{}
+	for dec.More() {
+		key, err := readKey(dec)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case key == "name":
+			err = dec.Decode(&chat.Name)
+		case key == "type":
+			err = dec.Decode(&chat.Type)
+		case key == "id":
+			err = dec.Decode(&chat.ID)
+		case key == "messages":
+			err = readMessages(dec, chat, fn)
+		case top && (key == "chats" || key == "left_chats"):
+			err = readChatList(dec, fn)
+		default:
+			err = skipValue(dec)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+// readChatList 读取 {"about": ..., "list": [...]} 中的聊天
+func readChatList(dec *json.Decoder, fn func(*desktopChat, *desktopMessage) error) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		key, err := readKey(dec)
+		if err != nil {
+			return err
+		}
+		if key != "list" {
+			if err := skipValue(dec); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := expectDelim(dec, '['); err != nil {
+			return err
+		}
+		for dec.More() {
+			if err := readChat(dec, fn, false); err != nil {
+				return err
+			}
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+// readMessages 逐条读取消息数组
+func readMessages(dec *json.Decoder, chat *desktopChat, fn func(*desktopChat, *desktopMessage) error) error {
+	if chat.ID == 0 {
+		return fmt.Errorf("聊天 %q 的消息出现在聊天ID之前，无法确定消息所属的群组", chat.Name)
+	}
+	if err := expectDelim(dec, '['); err != nil {
+		return err
+	}
+	for dec.More() {
+		var message desktopMessage
+		if err := dec.Decode(&message); err != nil {
+			return err
+		}
+		if err := fn(chat, &message); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, ']')
+}
+
+// readKey 读取对象的下一个键
+func readKey(dec *json.Decoder) (string, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return "", err
+	}
+	key, ok := tok.(string)
+	if !ok {
+		return "", fmt.Errorf("意外的 JSON 内容 %v", tok)
+	}
+	return key, nil
+}
+
+// expectDelim 读取指定的分隔符
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != delim {
+		return fmt.Errorf("意外的 JSON 内容 %v，应为 %v", tok, delim)
+	}
+	return nil
+}
+
+// skipValue 跳过不需要的值
+func skipValue(dec *json.Decoder) error {
+	var raw json.RawMessage
+	return dec.Decode(&raw)
+}
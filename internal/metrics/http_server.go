@@ -9,7 +9,8 @@ import (
 	"time"
 
 	"github.com/sirupsen/logrus"
-	"github.com/user/tg-forward-to-xx/config"
+	"github.com/user/tg-forward-to-xx/internal/auth"
+	"github.com/user/tg-forward-to-xx/internal/config"
 )
 
 // HTTPServer 指标 HTTP 服务
@@ -22,8 +23,7 @@ type HTTPServer struct {
 	stopChan   chan struct{}
 	wg         sync.WaitGroup
 	auth       bool
-	apiKey     string
-	headerName string
+	authn      *auth.Authenticator
 	tls        bool
 	certFile   string
 	keyFile    string
@@ -32,46 +32,33 @@ type HTTPServer struct {
 
 // NewHTTPServer 创建新的 HTTP 服务
 func NewHTTPServer(port int, path string) *HTTPServer {
+	cfg := config.Current()
+	httpCfg := cfg.Metrics.HTTP
+
+	// 与 HTTP API 共用认证中间件，api.auth.keys 中带 metrics:read 权限的 API Key 同样可以访问
+	authn := auth.New(httpCfg.Auth, httpCfg.HeaderName)
+	authn.SetKeys(cfg.API.Auth.Keys)
+	if httpCfg.APIKey != "" {
+		authn.AddKey("metrics", httpCfg.APIKey, auth.ScopeMetrics)
+	}
+
 	return &HTTPServer{
 		port:       port,
 		path:       path,
 		stopChan:   make(chan struct{}),
-		auth:       config.AppConfig.Metrics.HTTP.Auth,
-		apiKey:     config.AppConfig.Metrics.HTTP.APIKey,
-		headerName: config.AppConfig.Metrics.HTTP.HeaderName,
-		tls:        config.AppConfig.Metrics.HTTP.TLS.Enabled,
-		certFile:   config.AppConfig.Metrics.HTTP.TLS.CertFile,
-		keyFile:    config.AppConfig.Metrics.HTTP.TLS.KeyFile,
-		tlsPort:    config.AppConfig.Metrics.HTTP.TLS.Port,
-		forceHTTPS: config.AppConfig.Metrics.HTTP.TLS.ForceHTTPS,
+		auth:       httpCfg.Auth,
+		authn:      authn,
+		tls:        httpCfg.TLS.Enabled,
+		certFile:   httpCfg.TLS.CertFile,
+		keyFile:    httpCfg.TLS.KeyFile,
+		tlsPort:    httpCfg.TLS.Port,
+		forceHTTPS: httpCfg.TLS.ForceHTTPS,
 	}
 }
 
 // authMiddleware 认证中间件
 func (s *HTTPServer) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// 如果未启用认证，直接放行
-		if !s.auth {
-			next(w, r)
-			return
-		}
-
-		// 检查 API Key
-		apiKey := r.Header.Get(s.headerName)
-		if apiKey == "" {
-			http.Error(w, "未提供认证信息", http.StatusUnauthorized)
-			logrus.Warnf("访问被拒绝：未提供认证信息，来自 %s", r.RemoteAddr)
-			return
-		}
-
-		if apiKey != s.apiKey {
-			http.Error(w, "认证失败", http.StatusUnauthorized)
-			logrus.Warnf("访问被拒绝：认证失败，来自 %s", r.RemoteAddr)
-			return
-		}
-
-		next(w, r)
-	}
+	return s.authn.Require(auth.ScopeMetrics, next)
 }
 
 // redirectToHTTPS 重定向到 HTTPS
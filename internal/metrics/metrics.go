@@ -16,11 +16,12 @@ type QueueMetrics struct {
 	StartTime         time.Time // 启动时间
 
 	// 新增指标
-	TotalProcessingTime time.Duration   // 总处理时间
-	MessageLatencies    []time.Duration // 最近100条消息的处理延迟
-	LastMinuteMessages  int64           // 最近一分钟处理的消息数
-	LastMinuteTime      time.Time       // 最近一分钟的开始时间
-	TotalRetryCount     int64           // 总重试次数
+	TotalProcessingTime time.Duration     // 总处理时间
+	MessageLatencies    []time.Duration   // 最近100条消息的处理延迟
+	LastMinuteMessages  int64             // 最近一分钟处理的消息数
+	LastMinuteTime      time.Time         // 最近一分钟的开始时间
+	TotalRetryCount     int64             // 总重试次数
+	ArchiveFiles        map[string]string // 各归档投递目标当前写入的文件路径
 }
 
 var (
@@ -36,6 +37,7 @@ func NewQueueMetrics() *QueueMetrics {
 		StartTime:        now,
 		LastMinuteTime:   now,
 		MessageLatencies: make([]time.Duration, 0, 100),
+		ArchiveFiles:     make(map[string]string),
 	}
 }
 
@@ -154,6 +156,13 @@ func (m *QueueMetrics) GetQueuePressure() float64 {
 	return float64(m.QueueSize) / float64(m.LastMinuteMessages)
 }
 
+// SetArchiveFile 记录归档投递目标当前写入的文件路径
+func (m *QueueMetrics) SetArchiveFile(sinkName, path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ArchiveFiles[sinkName] = path
+}
+
 // IncrementRetryCount 增加重试计数
 func (m *QueueMetrics) IncrementRetryCount() {
 	m.mu.Lock()
@@ -176,6 +185,11 @@ func (m *QueueMetrics) GetMetrics() map[string]interface{} {
 		p95Latency = m.MessageLatencies[idx]
 	}
 
+	archiveFiles := make(map[string]string, len(m.ArchiveFiles))
+	for name, path := range m.ArchiveFiles {
+		archiveFiles[name] = path
+	}
+
 	return map[string]interface{}{
 		"queue_size":         m.QueueSize,
 		"processed_messages": m.ProcessedMessages,
@@ -190,6 +204,7 @@ func (m *QueueMetrics) GetMetrics() map[string]interface{} {
 		"avg_retry_count":    m.GetAverageRetryCount(),
 		"queue_pressure":     m.GetQueuePressure(),
 		"total_retry_count":  m.TotalRetryCount,
+		"archive_files":      archiveFiles,
 	}
 }
 
@@ -242,3 +257,8 @@ func AddMessageLatency(latency time.Duration) {
 func IncrementRetryCount() {
 	DefaultMetrics.IncrementRetryCount()
 }
+
+// SetArchiveFile 记录全局归档文件路径
+func SetArchiveFile(sinkName, path string) {
+	DefaultMetrics.SetArchiveFile(sinkName, path)
+}
@@ -7,7 +7,7 @@ import (
 	"time"
 
 	"github.com/sirupsen/logrus"
-	"github.com/user/tg-forward-to-xx/config"
+	"github.com/user/tg-forward-to-xx/internal/config"
 	"github.com/user/tg-forward-to-xx/internal/queue"
 )
 
@@ -30,11 +30,8 @@ func NewReporter(q queue.Queue, interval time.Duration, metricsFile string) *Rep
 	}
 
 	// 如果启用了 HTTP 服务，创建 HTTP 服务器
-	if config.AppConfig.Metrics.HTTP.Enabled {
-		reporter.httpServer = NewHTTPServer(
-			config.AppConfig.Metrics.HTTP.Port,
-			config.AppConfig.Metrics.HTTP.Path,
-		)
+	if httpCfg := config.Current().Metrics.HTTP; httpCfg.Enabled {
+		reporter.httpServer = NewHTTPServer(httpCfg.Port, httpCfg.Path)
 	}
 
 	return reporter
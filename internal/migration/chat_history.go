@@ -0,0 +1,296 @@
+package migration
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/user/tg-forward-to-xx/internal/models"
+	"github.com/user/tg-forward-to-xx/internal/utils"
+)
+
+// 聊天记录键的长度
+const (
+	legacyHistoryKeyLength = 16 // 版本 3 之前：8 字节群组ID + 8 字节时间戳
+	historyKeyLength       = 24 // 8 字节群组ID + 8 字节时间戳 + 8 字节消息ID
+)
+
+// ChatHistory 聊天记录数据库（<queue.path>/chat_history）
+var ChatHistory = &Store{
+	Name:   "chat_history",
+	IsData: isChatHistoryKey,
+	Migrations: []Migration{
+		{Version: 1, Name: "backfill_group_name", Up: backfillGroupNameUp, Down: backfillGroupNameDown},
+		{Version: 2, Name: "sanitize_text", Up: sanitizeTextUp},
+		{Version: 3, Name: "rekey_dedup", Up: rekeyDedupUp},
+		{Version: 4, Name: "media_attachments", Up: mediaAttachmentsUp, Down: mediaAttachmentsDown},
+	},
+}
+
+// isChatHistoryKey 判断是否为聊天记录键
+func isChatHistoryKey(key []byte) bool {
+	return len(key) == legacyHistoryKeyLength || len(key) == historyKeyLength
+}
+
+// isLegacyHistoryKey 判断是否为版本 3 之前的聊天记录键
+func isLegacyHistoryKey(key []byte) bool {
+	return len(key) == legacyHistoryKeyLength
+}
+
+// defaultGroupName 缺少群组名称时使用的占位名称
+func defaultGroupName(chatID int64) string {
+	return fmt.Sprintf("群组(%d)", chatID)
+}
+
+// forEachHistory 遍历聊天记录，无法解析的记录保持不变
+func forEachHistory(r Reader, fn func(key, value []byte, history *models.ChatHistory) error) error {
+	return ForEach(r, isChatHistoryKey, func(key, value []byte) error {
+		history, err := models.FromJSONHistory(value)
+		if err != nil {
+			logrus.Errorf("解析聊天记录失败 [key=%x]: %v", key, err)
+			return nil
+		}
+		return fn(key, value, history)
+	})
+}
+
+// putHistory 记录聊天记录的更新
+func putHistory(p *Plan, key, old []byte, history *models.ChatHistory) error {
+	value, err := json.Marshal(history)
+	if err != nil {
+		return fmt.Errorf("序列化聊天记录 %d 失败: %w", history.ID, err)
+	}
+	p.Put(key, old, value)
+	return nil
+}
+
+// backfillGroupNameUp 为缺少群组名称的记录补充占位名称
+func backfillGroupNameUp(r Reader, p *Plan) error {
+	return forEachHistory(r, func(key, value []byte, history *models.ChatHistory) error {
+		if history.GroupName != "" {
+			return nil
+		}
+		history.GroupName = defaultGroupName(history.ChatID)
+		return putHistory(p, key, value, history)
+	})
+}
+
+// backfillGroupNameDown 清除迁移补充的占位名称
+func backfillGroupNameDown(r Reader, p *Plan) error {
+	return forEachHistory(r, func(key, value []byte, history *models.ChatHistory) error {
+		if history.GroupName != defaultGroupName(history.ChatID) {
+			return nil
+		}
+		history.GroupName = ""
+		return putHistory(p, key, value, history)
+	})
+}
+
+// sanitizeTextUp 替换包含无法解析字符的消息内容，与写入时的处理保持一致
+// 原始内容已损坏，无法回滚
+func sanitizeTextUp(r Reader, p *Plan) error {
+	return forEachHistory(r, func(key, value []byte, history *models.ChatHistory) error {
+		sanitized := utils.SanitizeMessage(history.Text)
+		if sanitized == history.Text {
+			return nil
+		}
+		history.Text = sanitized
+		return putHistory(p, key, value, history)
+	})
+}
+
+// 旧版本转发时会再保存一份聊天记录，其 ID 是纳秒时间戳而不是 Telegram 消息ID
+const generatedIDThreshold = 1_000_000_000_000
+
+// 生成的副本与原记录的最大时间差，超过时视为不同的消息
+const duplicateWindow = 10 * time.Minute
+
+// historyRecord 迁移中的一条聊天记录
+type historyRecord struct {
+	key     []byte
+	value   []byte
+	history *models.ChatHistory // 无法解析时为 nil
+	merged  bool                // 是否合并了重复记录的字段，需要重新序列化
+}
+
+// rekeyDedupUp 将键改为 群组ID + 时间戳 + 消息ID，并删除重复保存的记录
+// 同一秒内的消息在旧键下会互相覆盖，已丢失的记录无法恢复；删除的副本同样无法回滚
+func rekeyDedupUp(r Reader, p *Plan) error {
+	var records []historyRecord
+	err := ForEach(r, isLegacyHistoryKey, func(key, value []byte) error {
+		// 键按群组排序，群组变化时处理上一个群组的记录
+		if len(records) > 0 && !bytes.Equal(records[0].key[:8], key[:8]) {
+			if err := dedupChat(records, p); err != nil {
+				return err
+			}
+			records = records[:0]
+		}
+
+		record := historyRecord{key: clone(key), value: clone(value)}
+		history, err := models.FromJSONHistory(value)
+		if err != nil {
+			logrus.Errorf("解析聊天记录失败，将原样保留 [key=%x]: %v", key, err)
+		} else {
+			record.history = history
+		}
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return dedupChat(records, p)
+}
+
+// dedupChat 处理同一个群组的记录，records 按时间升序排列
+func dedupChat(records []historyRecord, p *Plan) error {
+	var kept, generated []*historyRecord
+	byID := make(map[int64]*historyRecord)
+	byText := make(map[string][]*historyRecord)
+
+	for i := range records {
+		record := &records[i]
+		switch {
+		case record.history == nil:
+			kept = append(kept, record)
+		case record.history.ID >= generatedIDThreshold:
+			generated = append(generated, record)
+		default:
+			// 同一消息ID的多条记录只保留最早的一条，即按 Telegram 发送时间保存的记录
+			if first, ok := byID[record.history.ID]; ok {
+				mergeHistory(first.history, record.history)
+				first.merged = true
+				continue
+			}
+			byID[record.history.ID] = record
+			byText[record.history.Text] = append(byText[record.history.Text], record)
+			kept = append(kept, record)
+		}
+	}
+
+	// 生成 ID 的副本与内容相同、时间相近的原记录重复
+	for _, record := range generated {
+		if !hasOriginal(byText[record.history.Text], record.history.Timestamp) {
+			kept = append(kept, record)
+		}
+	}
+
+	for _, record := range records {
+		p.Delete(record.key, record.value)
+	}
+	for _, record := range kept {
+		value := record.value
+		if record.merged {
+			data, err := json.Marshal(record.history)
+			if err != nil {
+				return fmt.Errorf("序列化聊天记录 %d 失败: %w", record.history.ID, err)
+			}
+			value = data
+		}
+		p.Put(rekey(record), nil, value)
+	}
+	return nil
+}
+
+// mergeHistory 用重复记录补全缺失的字段，例如媒体消息的说明文字
+func mergeHistory(dst, src *models.ChatHistory) {
+	if dst.Text == "" {
+		dst.Text = src.Text
+	}
+	if dst.GroupName == "" {
+		dst.GroupName = src.GroupName
+	}
+	if dst.MessageType == "" {
+		dst.MessageType = src.MessageType
+	}
+	dst.HasMedia = dst.HasMedia || src.HasMedia
+}
+
+// hasOriginal 判断候选记录中是否有在 ts 之前不久保存的原记录
+func hasOriginal(candidates []*historyRecord, ts time.Time) bool {
+	for _, c := range candidates {
+		delta := ts.Sub(c.history.Timestamp)
+		if delta >= 0 && delta <= duplicateWindow {
+			return true
+		}
+	}
+	return false
+}
+
+// rekey 生成新格式的键，无法解析的记录消息ID记为 0
+func rekey(record *historyRecord) []byte {
+	var messageID int64
+	if record.history != nil {
+		messageID = record.history.ID
+	}
+	key := make([]byte, historyKeyLength)
+	copy(key, record.key)
+	binary.BigEndian.PutUint64(key[legacyHistoryKeyLength:], uint64(messageID))
+	return key
+}
+
+// legacyMediaHistory 版本 4 之前的聊天记录，媒体只保存地址
+type legacyMediaHistory struct {
+	*models.ChatHistory
+	MediaURLs []string `json:"media_urls,omitempty"`
+}
+
+// mediaAttachmentsUp 将媒体地址转换为附件
+// 类型和 MIME 按消息类型和扩展名推断，对象名称留空，清理时从地址解析
+func mediaAttachmentsUp(r Reader, p *Plan) error {
+	return forEachHistory(r, func(key, value []byte, history *models.ChatHistory) error {
+		var legacy struct {
+			MediaURLs []string `json:"media_urls"`
+		}
+		if err := json.Unmarshal(value, &legacy); err != nil || len(legacy.MediaURLs) == 0 {
+			return nil
+		}
+		for _, url := range legacy.MediaURLs {
+			history.Attachments = append(history.Attachments, legacyAttachment(history.MessageType, url))
+		}
+		return putHistory(p, key, value, history)
+	})
+}
+
+// mediaAttachmentsDown 将附件还原为媒体地址，对象名称等元数据会丢失
+func mediaAttachmentsDown(r Reader, p *Plan) error {
+	return forEachHistory(r, func(key, value []byte, history *models.ChatHistory) error {
+		if len(history.Attachments) == 0 {
+			return nil
+		}
+		legacy := legacyMediaHistory{ChatHistory: history, MediaURLs: history.MediaURLs()}
+		history.Attachments = nil
+		data, err := json.Marshal(legacy)
+		if err != nil {
+			return fmt.Errorf("序列化聊天记录 %d 失败: %w", history.ID, err)
+		}
+		p.Put(key, value, data)
+		return nil
+	})
+}
+
+// legacyAttachment 推断旧媒体地址的附件类型，相册和旧记录按扩展名区分图片、视频和音频
+func legacyAttachment(messageType, url string) models.Attachment {
+	a := models.Attachment{Kind: messageType, URL: url, MIMEType: mime.TypeByExtension(path.Ext(url))}
+	switch messageType {
+	case models.MessageTypePhoto, models.MessageTypeDocument, models.MessageTypeVideo, models.MessageTypeAudio:
+		return a
+	}
+	switch {
+	case strings.HasPrefix(a.MIMEType, "image/"):
+		a.Kind = models.MessageTypePhoto
+	case strings.HasPrefix(a.MIMEType, "video/"):
+		a.Kind = models.MessageTypeVideo
+	case strings.HasPrefix(a.MIMEType, "audio/"):
+		a.Kind = models.MessageTypeAudio
+	default:
+		a.Kind = models.MessageTypeDocument
+	}
+	return a
+}
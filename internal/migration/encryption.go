@@ -0,0 +1,136 @@
+package migration
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"github.com/user/tg-forward-to-xx/internal/encryption"
+)
+
+// decryptReader 为已加密的数据库解密业务数据，迁移函数读到的始终是明文
+type decryptReader struct {
+	Reader
+	cipher *encryption.Cipher
+	isData func(key []byte) bool
+}
+
+// Get 读取并解密一个值
+func (r *decryptReader) Get(key []byte, ro *opt.ReadOptions) ([]byte, error) {
+	value, err := r.Reader.Get(key, ro)
+	if err != nil || !r.isData(key) {
+		return value, err
+	}
+	return r.cipher.Open(key, value)
+}
+
+// NewIterator 返回解密业务数据的迭代器
+func (r *decryptReader) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	return &decryptIterator{Iterator: r.Reader.NewIterator(slice, ro), reader: r}
+}
+
+// decryptIterator 解密失败时记录错误，遍历结束后由 Error 返回
+type decryptIterator struct {
+	iterator.Iterator
+	reader *decryptReader
+	err    error
+}
+
+// Value 返回解密后的值
+func (it *decryptIterator) Value() []byte {
+	value := it.Iterator.Value()
+	if !it.reader.isData(it.Key()) {
+		return value
+	}
+	plaintext, err := it.reader.cipher.Open(it.Key(), value)
+	if err != nil {
+		if it.err == nil {
+			it.err = fmt.Errorf("解密 %s 失败: %w", formatKey(it.Key()), err)
+		}
+		return nil
+	}
+	return plaintext
+}
+
+// Error 返回解密或遍历中的错误
+func (it *decryptIterator) Error() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.Iterator.Error()
+}
+
+// Reencrypt 生成新的数据密钥重新加密全部数据，之后删除旧的数据密钥，数据密钥改用当前主密钥保存
+// 与迁移一样会先备份数据库目录，返回改写的记录数和备份路径；预览时只校验主密钥并统计记录数
+func Reencrypt(store *Store, path string, keys *encryption.MasterKeys, opts Options) (int, string, error) {
+	return rewriteEncryption(store, path, keys, opts, func(db *leveldb.DB, progress func(int)) (int, error) {
+		return encryption.Rotate(db, store.IsData, keys, progress)
+	})
+}
+
+// Decrypt 解密全部数据并删除数据密钥，关闭静态加密前运行
+// 与迁移一样会先备份数据库目录，返回改写的记录数和备份路径；预览时只校验主密钥并统计记录数
+func Decrypt(store *Store, path string, keys *encryption.MasterKeys, opts Options) (int, string, error) {
+	return rewriteEncryption(store, path, keys, opts, func(db *leveldb.DB, progress func(int)) (int, error) {
+		return encryption.Decrypt(db, store.IsData, keys, progress)
+	})
+}
+
+// rewriteEncryption 校验数据库已加密且主密钥正确，备份后执行 fn
+func rewriteEncryption(store *Store, path string, keys *encryption.MasterKeys, opts Options, fn func(db *leveldb.DB, progress func(int)) (int, error)) (int, string, error) {
+	db, err := open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer func() {
+		if db != nil {
+			db.Close()
+		}
+	}()
+
+	encrypted, err := encryption.Encrypted(db)
+	if err != nil {
+		return 0, "", err
+	}
+	if !encrypted {
+		return 0, "", fmt.Errorf("数据库未加密")
+	}
+	if err := encryption.Verify(db, keys); err != nil {
+		return 0, "", err
+	}
+
+	if opts.DryRun {
+		count := 0
+		err := ForEach(db, store.IsData, func(key, value []byte) error {
+			count++
+			return nil
+		})
+		return count, "", err
+	}
+
+	var backupPath string
+	if !opts.NoBackup {
+		version, _, err := readVersion(db)
+		if err != nil {
+			return 0, "", err
+		}
+		db.Close()
+		db = nil
+		if backupPath, err = backup(path, version); err != nil {
+			return 0, "", err
+		}
+		if db, err = open(path); err != nil {
+			return 0, backupPath, err
+		}
+	}
+
+	count, err := fn(db, func(done int) {
+		if done%10000 == 0 {
+			logrus.WithFields(logrus.Fields{"store": store.Name, "count": done}).Info("正在改写数据")
+		}
+	})
+	return count, backupPath, err
+}
@@ -0,0 +1,162 @@
+package migration
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// SchemaVersionKey 记录数据库结构版本的键，每个 LevelDB 各自保存
+const SchemaVersionKey = "schema:version"
+
+var (
+	// ErrNotMigrated 数据库结构版本低于程序要求，需要先执行迁移
+	ErrNotMigrated = errors.New("数据库尚未迁移到最新版本")
+	// ErrTooNew 数据库结构版本高于程序支持的版本
+	ErrTooNew = errors.New("数据库版本高于程序支持的版本")
+)
+
+// Reader 迁移读取数据的接口，由 LevelDB 事务实现，可以读到之前迁移步骤的写入
+type Reader interface {
+	Get(key []byte, ro *opt.ReadOptions) ([]byte, error)
+	NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator
+}
+
+// Func 计算一次迁移需要的变更，只能通过 Plan 记录变更，不能直接写库
+type Func func(r Reader, p *Plan) error
+
+// Migration 编号迁移，Down 为 nil 表示不可回滚
+type Migration struct {
+	Version int
+	Name    string
+	Up      Func
+	Down    Func
+}
+
+// ID 返回迁移的展示名称，例如 001_backfill_group_name
+func (m Migration) ID() string {
+	return fmt.Sprintf("%03d_%s", m.Version, m.Name)
+}
+
+// Store 一个需要迁移的 LevelDB 数据库
+type Store struct {
+	Name       string
+	IsData     func(key []byte) bool // 判断是否为业务数据键，用于识别新建的空库
+	Migrations []Migration
+}
+
+// Latest 返回最新的迁移版本
+func (s *Store) Latest() int {
+	latest := 0
+	for _, m := range s.Migrations {
+		if m.Version > latest {
+			latest = m.Version
+		}
+	}
+	return latest
+}
+
+// sorted 按版本号升序返回迁移列表
+func (s *Store) sorted() []Migration {
+	list := append([]Migration(nil), s.Migrations...)
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list
+}
+
+// Check 在服务启动时检查数据库版本
+// 新建的空库直接标记为最新版本；已有数据但版本落后时返回 ErrNotMigrated，拒绝启动
+func Check(db *leveldb.DB, store *Store) error {
+	version, found, err := readVersion(db)
+	if err != nil {
+		return err
+	}
+
+	latest := store.Latest()
+	if !found {
+		empty, err := isEmpty(db, store)
+		if err != nil {
+			return err
+		}
+		if empty {
+			return writeVersion(db, latest)
+		}
+	}
+
+	switch {
+	case version < latest:
+		return fmt.Errorf("%w: %s 当前版本 %d，需要版本 %d，请先停止服务并运行 migrate up", ErrNotMigrated, store.Name, version, latest)
+	case version > latest:
+		return fmt.Errorf("%w: %s 当前版本 %d，程序支持的最高版本 %d，请升级程序", ErrTooNew, store.Name, version, latest)
+	}
+	return nil
+}
+
+// Version 返回数据库当前版本，found 为 false 表示尚未记录版本
+func Version(db *leveldb.DB) (version int, found bool, err error) {
+	return readVersion(db)
+}
+
+// readVersion 读取版本键
+func readVersion(r Reader) (int, bool, error) {
+	data, err := r.Get([]byte(SchemaVersionKey), nil)
+	if err == leveldb.ErrNotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("读取数据库版本失败: %w", err)
+	}
+	version, err := strconv.Atoi(string(data))
+	if err != nil {
+		return 0, false, fmt.Errorf("解析数据库版本 %q 失败: %w", data, err)
+	}
+	return version, true, nil
+}
+
+// writeVersion 写入版本键
+func writeVersion(db *leveldb.DB, version int) error {
+	if err := db.Put([]byte(SchemaVersionKey), []byte(strconv.Itoa(version)), nil); err != nil {
+		return fmt.Errorf("写入数据库版本失败: %w", err)
+	}
+	return nil
+}
+
+// isEmpty 判断数据库中是否没有业务数据
+func isEmpty(r Reader, store *Store) (bool, error) {
+	iter := r.NewIterator(nil, nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		if store.IsData(iter.Key()) {
+			return false, nil
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return false, fmt.Errorf("遍历数据库失败: %w", err)
+	}
+	return true, nil
+}
+
+// ForEach 遍历 isData 认定的业务数据，回调中的键和值在返回后失效，需要保留时应复制
+func ForEach(r Reader, isData func(key []byte) bool, fn func(key, value []byte) error) error {
+	iter := r.NewIterator(nil, nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		if !isData(iter.Key()) {
+			continue
+		}
+		if err := fn(iter.Key(), iter.Value()); err != nil {
+			return err
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("遍历数据库失败: %w", err)
+	}
+	return nil
+}
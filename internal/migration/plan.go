@@ -0,0 +1,91 @@
+package migration
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"unicode"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/user/tg-forward-to-xx/internal/encryption"
+)
+
+// Change 迁移产生的一项变更
+type Change struct {
+	Key []byte
+	Old []byte // 为 nil 表示新增
+	New []byte // 为 nil 表示删除
+}
+
+// Plan 一次迁移需要写入的变更，计算完成后整体写入，保证每个迁移步骤是原子的
+type Plan struct {
+	Changes []Change
+}
+
+// Put 记录写入，old 为原值，新增时传 nil；参数会被复制，可以直接传入迭代器的键值
+func (p *Plan) Put(key, old, value []byte) {
+	p.Changes = append(p.Changes, Change{Key: clone(key), Old: clone(old), New: clone(value)})
+}
+
+// Delete 记录删除
+func (p *Plan) Delete(key, old []byte) {
+	p.Changes = append(p.Changes, Change{Key: clone(key), Old: clone(old)})
+}
+
+// batch 将变更转换为 LevelDB 批量写入，已加密的数据库中业务数据写入前重新加密
+func (p *Plan) batch(cipher *encryption.Cipher, isData func(key []byte) bool) *leveldb.Batch {
+	b := new(leveldb.Batch)
+	for _, c := range p.Changes {
+		switch {
+		case c.New == nil:
+			b.Delete(c.Key)
+		case isData(c.Key):
+			b.Put(c.Key, cipher.Seal(c.Key, c.New))
+		default:
+			b.Put(c.Key, c.New)
+		}
+	}
+	return b
+}
+
+// WriteDiff 输出变更内容，limit 大于 0 时最多输出 limit 项
+func (p *Plan) WriteDiff(w io.Writer, limit int) {
+	for i, c := range p.Changes {
+		if limit > 0 && i >= limit {
+			fmt.Fprintf(w, "  ... 其余 %d 项变更未显示\n", len(p.Changes)-limit)
+			return
+		}
+		switch {
+		case c.Old == nil:
+			fmt.Fprintf(w, "  + %s\n", formatKey(c.Key))
+		case c.New == nil:
+			fmt.Fprintf(w, "  - %s\n", formatKey(c.Key))
+		default:
+			fmt.Fprintf(w, "  ~ %s\n", formatKey(c.Key))
+		}
+		if c.Old != nil {
+			fmt.Fprintf(w, "    - %s\n", c.Old)
+		}
+		if c.New != nil {
+			fmt.Fprintf(w, "    + %s\n", c.New)
+		}
+	}
+}
+
+// formatKey 可打印的键原样输出，二进制键以十六进制输出
+func formatKey(key []byte) string {
+	for _, r := range string(key) {
+		if r > unicode.MaxASCII || !unicode.IsPrint(r) {
+			return "0x" + hex.EncodeToString(key)
+		}
+	}
+	return string(key)
+}
+
+// clone 复制字节切片，nil 保持为 nil
+func clone(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
@@ -0,0 +1,51 @@
+package migration
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// queueMessagePrefix 队列消息键前缀
+var queueMessagePrefix = []byte("msg:")
+
+// Queue LevelDB 重试队列数据库（<queue.path>）
+var Queue = &Store{
+	Name:   "queue",
+	IsData: isQueueMessageKey,
+	Migrations: []Migration{
+		{Version: 1, Name: "structured_message", Up: structuredMessageUp},
+	},
+}
+
+// isQueueMessageKey 判断是否为队列消息键
+func isQueueMessageKey(key []byte) bool {
+	return bytes.HasPrefix(key, queueMessagePrefix)
+}
+
+// structuredMessageUp 将 markdown 内容的旧版消息转换为结构化消息
+// 旧结构中的部分信息在转换时已丢失，无法回滚
+func structuredMessageUp(r Reader, p *Plan) error {
+	return ForEach(r, isQueueMessageKey, func(key, value []byte) error {
+		msg, upgraded, err := models.UpgradeMessageJSON(value)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"key":   string(key),
+				"error": err,
+			}).Warn("无法解析队列中的消息，跳过迁移")
+			return nil
+		}
+		if !upgraded {
+			return nil
+		}
+
+		data, err := msg.ToJSON()
+		if err != nil {
+			return fmt.Errorf("序列化迁移后的消息失败: %w", err)
+		}
+		p.Put(key, value, data)
+		return nil
+	})
+}
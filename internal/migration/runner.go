@@ -0,0 +1,307 @@
+package migration
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/user/tg-forward-to-xx/internal/encryption"
+)
+
+// 迁移方向
+const (
+	DirectionUp   = "up"
+	DirectionDown = "down"
+)
+
+// Options 执行迁移的选项
+type Options struct {
+	Direction string    // up 或 down
+	Target    int       // 目标版本，小于 0 时 up 迁移到最新版本，down 回滚一个版本
+	DryRun    bool      // 只输出变更，不写入数据库
+	NoBackup  bool      // 跳过迁移前的自动备份
+	Diff      io.Writer // 预览时输出变更内容，为 nil 时只输出统计
+	DiffLimit int       // 每个迁移最多输出的变更数量，0 表示不限制
+
+	Keys *encryption.MasterKeys // 已加密的数据库需要主密钥，迁移读到明文，写入时重新加密
+}
+
+// Step 执行或预览的一个迁移步骤
+type Step struct {
+	Migration Migration
+	Direction string
+	Changes   int
+	version   int // 该步骤完成后的数据库版本
+}
+
+// Status 数据库的迁移状态
+type Status struct {
+	Path      string
+	Version   int
+	Found     bool // 是否已记录版本
+	Empty     bool // 是否没有业务数据
+	Encrypted bool // 是否已启用静态加密
+	Latest    int
+	Pending   []Migration
+}
+
+// GetStatus 读取数据库的迁移状态
+func GetStatus(store *Store, path string) (*Status, error) {
+	db, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	version, found, err := readVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	empty, err := isEmpty(db, store)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := encryption.Encrypted(db)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &Status{Path: path, Version: version, Found: found, Empty: empty, Encrypted: encrypted, Latest: store.Latest()}
+	for _, m := range store.sorted() {
+		if m.Version > version {
+			status.Pending = append(status.Pending, m)
+		}
+	}
+	return status, nil
+}
+
+// Run 按选项执行迁移，每个迁移步骤连同版本号在一个事务中提交
+// 实际写入前会先备份整个数据库目录，返回备份路径
+func Run(store *Store, path string, opts Options) ([]Step, string, error) {
+	db, err := open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() {
+		if db != nil {
+			db.Close()
+		}
+	}()
+
+	current, found, err := readVersion(db)
+	if err != nil {
+		return nil, "", err
+	}
+	if !found {
+		empty, err := isEmpty(db, store)
+		if err != nil {
+			return nil, "", err
+		}
+		if empty {
+			logrus.WithField("store", store.Name).Info("数据库中没有数据，直接标记为最新版本")
+			if opts.DryRun {
+				return nil, "", nil
+			}
+			return nil, "", writeVersion(db, store.Latest())
+		}
+	}
+
+	steps, err := planSteps(store, current, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(steps) == 0 {
+		return nil, "", nil
+	}
+
+	// 已加密的数据库在备份前校验主密钥
+	cipher, err := encryption.Load(db, opts.Keys)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var backupPath string
+	if !opts.DryRun && !opts.NoBackup {
+		// 复制文件前关闭数据库，确保所有数据已落盘
+		db.Close()
+		db = nil
+		if backupPath, err = backup(path, current); err != nil {
+			return nil, "", err
+		}
+		if db, err = open(path); err != nil {
+			return nil, backupPath, err
+		}
+	}
+
+	tx, err := db.OpenTransaction()
+	if err != nil {
+		return nil, backupPath, fmt.Errorf("开启事务失败: %w", err)
+	}
+	for i := range steps {
+		var reader Reader = tx
+		if cipher != nil {
+			reader = &decryptReader{Reader: tx, cipher: cipher, isData: store.IsData}
+		}
+		step := &steps[i]
+		fn := step.Migration.Up
+		if step.Direction == DirectionDown {
+			fn = step.Migration.Down
+		}
+
+		plan := &Plan{}
+		if err := fn(reader, plan); err != nil {
+			tx.Discard()
+			return steps[:i], backupPath, fmt.Errorf("执行迁移 %s (%s) 失败: %w", step.Migration.ID(), step.Direction, err)
+		}
+		step.Changes = len(plan.Changes)
+
+		batch := plan.batch(cipher, store.IsData)
+		batch.Put([]byte(SchemaVersionKey), []byte(strconv.Itoa(step.version)))
+		if err := tx.Write(batch, nil); err != nil {
+			tx.Discard()
+			return steps[:i], backupPath, fmt.Errorf("写入迁移 %s 的变更失败: %w", step.Migration.ID(), err)
+		}
+
+		if opts.DryRun {
+			// 预览时后续步骤仍在同一事务中计算，能看到前面步骤的结果
+			if opts.Diff != nil {
+				fmt.Fprintf(opts.Diff, "== %s %s (%s): %d 项变更\n", store.Name, step.Migration.ID(), step.Direction, step.Changes)
+				plan.WriteDiff(opts.Diff, opts.DiffLimit)
+			}
+			continue
+		}
+
+		if err := tx.Commit(); err != nil {
+			return steps[:i], backupPath, fmt.Errorf("提交迁移 %s 失败: %w", step.Migration.ID(), err)
+		}
+		logrus.WithFields(logrus.Fields{
+			"store":     store.Name,
+			"migration": step.Migration.ID(),
+			"direction": step.Direction,
+			"changes":   step.Changes,
+		}).Info("迁移已提交")
+
+		if i+1 < len(steps) {
+			if tx, err = db.OpenTransaction(); err != nil {
+				return steps[:i+1], backupPath, fmt.Errorf("开启事务失败: %w", err)
+			}
+		}
+	}
+	if opts.DryRun {
+		tx.Discard()
+	}
+
+	return steps, backupPath, nil
+}
+
+// planSteps 根据当前版本和目标版本计算需要执行的迁移步骤
+func planSteps(store *Store, current int, opts Options) ([]Step, error) {
+	latest := store.Latest()
+	target := opts.Target
+
+	var steps []Step
+	switch opts.Direction {
+	case DirectionUp, "":
+		if target < 0 {
+			target = latest
+		}
+		if target > latest {
+			return nil, fmt.Errorf("目标版本 %d 超出最新版本 %d", target, latest)
+		}
+		for _, m := range store.sorted() {
+			if m.Version > current && m.Version <= target {
+				steps = append(steps, Step{Migration: m, Direction: DirectionUp, version: m.Version})
+			}
+		}
+
+	case DirectionDown:
+		if target < 0 {
+			target = current - 1
+		}
+		if target < 0 {
+			return nil, nil
+		}
+		list := store.sorted()
+		for i := len(list) - 1; i >= 0; i-- {
+			m := list[i]
+			if m.Version <= current && m.Version > target {
+				if m.Down == nil {
+					return nil, fmt.Errorf("迁移 %s 不支持回滚，请使用迁移前的备份恢复", m.ID())
+				}
+				previous := 0
+				if i > 0 {
+					previous = list[i-1].Version
+				}
+				steps = append(steps, Step{Migration: m, Direction: DirectionDown, version: previous})
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("不支持的迁移方向: %s", opts.Direction)
+	}
+	return steps, nil
+}
+
+// open 打开已有的数据库，不存在时返回错误
+func open(path string) (*leveldb.DB, error) {
+	db, err := leveldb.OpenFile(path, &opt.Options{ErrorIfMissing: true})
+	if err != nil {
+		return nil, fmt.Errorf("打开数据库 %s 失败（服务是否仍在运行？）: %w", path, err)
+	}
+	return db, nil
+}
+
+// backup 将数据库目录复制到同级的备份目录，例如 chat_history.bak-v1-20250101-120000
+func backup(path string, version int) (string, error) {
+	clean := filepath.Clean(path)
+	backupPath := fmt.Sprintf("%s.bak-v%d-%s", clean, version, time.Now().Format("20060102-150405"))
+	if err := os.MkdirAll(backupPath, 0755); err != nil {
+		return "", fmt.Errorf("创建备份目录失败: %w", err)
+	}
+
+	entries, err := os.ReadDir(clean)
+	if err != nil {
+		return "", fmt.Errorf("读取数据库目录失败: %w", err)
+	}
+	for _, entry := range entries {
+		// 只复制 LevelDB 的数据文件，子目录属于其他数据库
+		if !entry.Type().IsRegular() || entry.Name() == "LOCK" {
+			continue
+		}
+		if err := copyFile(filepath.Join(clean, entry.Name()), filepath.Join(backupPath, entry.Name())); err != nil {
+			return "", fmt.Errorf("备份 %s 失败: %w", entry.Name(), err)
+		}
+	}
+
+	logrus.WithField("path", backupPath).Info("数据库已备份")
+	return backupPath, nil
+}
+
+// copyFile 复制单个文件并同步到磁盘
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -13,6 +13,22 @@ type ChatHistory struct {
 	FromUser  string    `json:"from_user"`  // 发送者用户名
 	GroupName string    `json:"group_name"` // 群组名称
 	Timestamp time.Time `json:"timestamp"`  // 消息时间戳
+
+	MessageType string       `json:"message_type,omitempty"` // 消息类型，旧记录为空
+	HasMedia    bool         `json:"has_media,omitempty"`    // 是否包含图片、视频等媒体
+	Attachments []Attachment `json:"attachments,omitempty"`  // 上传到 S3 的媒体附件，清理聊天记录时可一并删除；从 Telegram Desktop 导入且未上传时 URL 为导出目录中的相对路径
+	ReplyToID   int64        `json:"reply_to_id,omitempty"`  // 被回复消息的 Telegram 消息ID
+}
+
+// MediaURLs 返回全部附件的地址
+func (ch *ChatHistory) MediaURLs() []string {
+	var urls []string
+	for _, a := range ch.Attachments {
+		if a.URL != "" {
+			urls = append(urls, a.URL)
+		}
+	}
+	return urls
 }
 
 // ToJSON 将聊天记录转换为JSON
@@ -27,4 +43,4 @@ func FromJSONHistory(data []byte) (*ChatHistory, error) {
 		return nil, err
 	}
 	return &ch, nil
-} 
\ No newline at end of file
+}
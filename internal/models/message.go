@@ -2,35 +2,222 @@ package models
 
 import (
 	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
-// Message 表示从 Telegram 转发到钉钉的消息
+// 消息类型
+const (
+	MessageTypeText     = "text"     // 文本
+	MessageTypePhoto    = "photo"    // 图片
+	MessageTypeDocument = "document" // 文档
+	MessageTypeVideo    = "video"    // 视频
+	MessageTypeAudio    = "audio"    // 音频
+	MessageTypeAlbum    = "album"    // 相册（多张图片或视频）
+	MessageTypeOther    = "other"    // 其他不支持的类型
+)
+
+// MessageSchemaVersion 当前消息结构版本，队列迁移时据此识别旧格式
+const MessageSchemaVersion = 2
+
+// Sender 消息发送者
+type Sender struct {
+	ID          int64  `json:"id"`           // Telegram 用户ID
+	Username    string `json:"username"`     // 用户名，不含 @
+	DisplayName string `json:"display_name"` // 显示名称（名 + 姓）
+}
+
+// Name 返回发送者的展示名称，有用户名时使用 @用户名
+func (s Sender) Name() string {
+	if s.Username != "" {
+		return "@" + s.Username
+	}
+	if s.DisplayName != "" {
+		return s.DisplayName
+	}
+	return "未知用户"
+}
+
+// Chat 消息所在的聊天
+type Chat struct {
+	ID    int64  `json:"id"`    // 聊天ID
+	Title string `json:"title"` // 聊天标题
+	Type  string `json:"type"`  // 聊天类型：private、group、supergroup、channel
+}
+
+// Name 返回聊天的展示名称，标题为空时使用聊天ID
+func (c Chat) Name() string {
+	if c.Title != "" {
+		return c.Title
+	}
+	return fmt.Sprintf("群组(%d)", c.ID)
+}
+
+// Entity 消息文本中的格式实体，偏移量和长度与 Telegram 一致按 UTF-16 计算
+type Entity struct {
+	Type   string `json:"type"`          // 实体类型，例如 bold、url、text_link、mention
+	Offset int    `json:"offset"`        // 起始偏移
+	Length int    `json:"length"`        // 长度
+	URL    string `json:"url,omitempty"` // text_link 的链接地址
+}
+
+// ReplyRef 被回复消息的引用
+type ReplyRef struct {
+	MessageID int    `json:"message_id"` // 被回复消息的 Telegram 消息ID
+	Sender    string `json:"sender"`     // 被回复消息的发送者
+	Text      string `json:"text"`       // 被回复消息的文本
+}
+
+// Attachment 消息附带的媒体文件
+type Attachment struct {
+	Kind         string `json:"kind"`                     // 类型，取值与消息类型相同：photo、document、video、audio
+	URL          string `json:"url"`                      // S3 地址
+	Object       string `json:"object,omitempty"`         // S3 对象名称，升级前保存的记录为空
+	MIMEType     string `json:"mime_type,omitempty"`      // MIME 类型
+	Size         int64  `json:"size,omitempty"`           // 文件大小（字节）
+	Width        int    `json:"width,omitempty"`          // 宽度（图片和视频）
+	Height       int    `json:"height,omitempty"`         // 高度（图片和视频）
+	FileName     string `json:"file_name,omitempty"`      // 文件名
+	FileUniqueID string `json:"file_unique_id,omitempty"` // Telegram 文件唯一ID，同一文件在不同消息中相同
+}
+
+// IsImage 判断附件是否为图片
+func (a Attachment) IsImage() bool {
+	return a.Kind == MessageTypePhoto || strings.HasPrefix(a.MIMEType, "image/")
+}
+
+// Message 表示从 Telegram 转发到各投递目标的消息
 type Message struct {
-	ID          int64     `json:"id"`           // 唯一标识符
-	Content     string    `json:"content"`      // 消息内容
-	From        string    `json:"from"`         // 发送者
-	ChatID      int64     `json:"chat_id"`      // 聊天ID
-	ChatTitle   string    `json:"chat_title"`   // 聊天标题
-	CreatedAt   time.Time `json:"created_at"`   // 创建时间
-	Attempts    int       `json:"attempts"`     // 尝试次数
-	LastAttempt time.Time `json:"last_attempt"` // 最后一次尝试时间
-	IsMarkdown  bool      `json:"is_markdown"`  // 是否为 markdown 格式
+	Version     int          `json:"version"`               // 消息结构版本
+	ID          int64        `json:"id"`                    // 唯一标识符
+	MessageID   int          `json:"message_id"`            // Telegram 原始消息ID
+	Chat        Chat         `json:"chat"`                  // 所在聊天
+	Sender      Sender       `json:"sender"`                // 发送者
+	MessageType string       `json:"message_type"`          // 消息类型
+	Text        string       `json:"text"`                  // 消息文本或媒体说明
+	Entities    []Entity     `json:"entities,omitempty"`    // 文本格式实体
+	ReplyTo     *ReplyRef    `json:"reply_to,omitempty"`    // 回复的消息
+	Attachments []Attachment `json:"attachments,omitempty"` // 媒体附件
+	CreatedAt   time.Time    `json:"created_at"`            // 创建时间
+	Attempts    int          `json:"attempts"`              // 尝试次数
+	LastAttempt time.Time    `json:"last_attempt"`          // 最后一次尝试时间
+	RetryOnly   []string     `json:"retry_only,omitempty"`  // 重试时只发送到这些输出，为空表示全部输出
+	Edited      bool         `json:"edited"`                // 是否为编辑后的消息
+	Redacted    bool         `json:"redacted"`              // 是否已按路由规则脱敏
 }
 
 // NewMessage 创建一个新的消息
-func NewMessage(content, from string, chatID int64, chatTitle string) *Message {
+func NewMessage(text string, sender Sender, chat Chat) *Message {
 	return &Message{
-		ID:        time.Now().UnixNano(),  // 使用纳秒时间戳作为唯一标识符
-		Content:   content,
-		From:      from,
-		ChatID:    chatID,
-		ChatTitle: chatTitle,
+		Version:   MessageSchemaVersion,
+		ID:        time.Now().UnixNano(), // 使用纳秒时间戳作为唯一标识符
+		Text:      text,
+		Sender:    sender,
+		Chat:      chat,
 		CreatedAt: time.Now(),
 		Attempts:  0,
 	}
 }
 
+// HasMedia 判断是否为媒体消息，附件上传失败时同样返回 true
+func (m *Message) HasMedia() bool {
+	switch m.MessageType {
+	case MessageTypePhoto, MessageTypeDocument, MessageTypeVideo, MessageTypeAudio, MessageTypeAlbum:
+		return true
+	}
+	return len(m.Attachments) > 0
+}
+
+// PrimaryAttachment 返回第一个附件，没有附件时返回 nil
+func (m *Message) PrimaryAttachment() *Attachment {
+	if len(m.Attachments) == 0 {
+		return nil
+	}
+	return &m.Attachments[0]
+}
+
+// Images 返回所有图片附件
+func (m *Message) Images() []Attachment {
+	var images []Attachment
+	for _, a := range m.Attachments {
+		if a.IsImage() {
+			images = append(images, a)
+		}
+	}
+	return images
+}
+
+// Summary 返回消息正文，媒体消息带类型标签，例如 "[图片] 说明文字"
+func (m *Message) Summary() string {
+	var label string
+	switch m.MessageType {
+	case MessageTypePhoto:
+		label = "[图片]"
+	case MessageTypeDocument:
+		label = "[文档]"
+		if a := m.PrimaryAttachment(); a != nil && a.FileName != "" {
+			label = fmt.Sprintf("[文档: %s]", a.FileName)
+		}
+	case MessageTypeVideo:
+		label = "[视频]"
+	case MessageTypeAudio:
+		label = "[音频]"
+	case MessageTypeAlbum:
+		label = fmt.Sprintf("[相册: %d 个文件]", len(m.Attachments))
+	case MessageTypeOther:
+		if m.Text == "" {
+			return "[不支持的消息类型]"
+		}
+	}
+
+	switch {
+	case label == "":
+		return m.Text
+	case m.Text == "":
+		return label
+	default:
+		return label + " " + m.Text
+	}
+}
+
+// PlainText 渲染为纯文本：消息头、回复引用和正文，不包含附件地址
+func (m *Message) PlainText() string {
+	var b strings.Builder
+	if m.ReplyTo != nil {
+		replyText := []rune(m.ReplyTo.Text)
+		// 回复的原始消息最多显示 100 个字符，避免太长
+		if len(replyText) > 100 {
+			replyText = append(replyText[:97], []rune("...")...)
+		}
+		fmt.Fprintf(&b, "【%s】[%s 回复 %s]\n▶ %s\n-------------------\n",
+			m.Chat.Name(), m.Sender.Name(), m.ReplyTo.Sender, string(replyText))
+	} else {
+		fmt.Fprintf(&b, "【%s】[%s]\n", m.Chat.Name(), m.Sender.Name())
+	}
+	b.WriteString(m.Summary())
+	return b.String()
+}
+
+// Markdown 渲染为 markdown：纯文本内容之后附上图片预览和文件链接
+func (m *Message) Markdown() string {
+	var b strings.Builder
+	b.WriteString(m.PlainText())
+	for _, a := range m.Attachments {
+		if a.IsImage() {
+			fmt.Fprintf(&b, "\n![预览](%s)", a.URL)
+			continue
+		}
+		name := a.FileName
+		if name == "" {
+			name = "查看文件"
+		}
+		fmt.Fprintf(&b, "\n[%s](%s)", name, a.URL)
+	}
+	return b.String()
+}
+
 // ToJSON 将消息转换为 JSON 字符串
 func (m *Message) ToJSON() ([]byte, error) {
 	return json.Marshal(m)
@@ -45,6 +232,18 @@ func FromJSON(data []byte) (*Message, error) {
 	return &msg, nil
 }
 
+// TelegramLink 返回源消息在 Telegram 中的链接，只有超级群组和频道才能生成
+func (m *Message) TelegramLink() string {
+	if m.MessageID == 0 {
+		return ""
+	}
+	id := strconv.FormatInt(m.Chat.ID, 10)
+	if !strings.HasPrefix(id, "-100") {
+		return ""
+	}
+	return fmt.Sprintf("https://t.me/c/%s/%d", strings.TrimPrefix(id, "-100"), m.MessageID)
+}
+
 // 生成唯一ID
 func generateID() string {
 	return time.Now().Format("20060102150405") + "-" + randomString(8)
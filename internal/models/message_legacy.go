@@ -0,0 +1,133 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// legacyMessage 第一版消息结构，内容以 markdown 形式拼接在 Content 中
+type legacyMessage struct {
+	ID          int64     `json:"id"`
+	Content     string    `json:"content"`
+	From        string    `json:"from"`
+	ChatID      int64     `json:"chat_id"`
+	ChatTitle   string    `json:"chat_title"`
+	CreatedAt   time.Time `json:"created_at"`
+	Attempts    int       `json:"attempts"`
+	LastAttempt time.Time `json:"last_attempt"`
+	IsMarkdown  bool      `json:"is_markdown"`
+	MessageID   int       `json:"message_id"`
+	FileURL     string    `json:"file_url"`
+	MessageType string    `json:"message_type"`
+	AlbumURLs   []string  `json:"album_urls"`
+	Edited      bool      `json:"edited"`
+	Redacted    bool      `json:"redacted"`
+}
+
+// 旧格式中的媒体标签前缀，迁移时从正文中去掉
+var legacyLabels = []string{"[图片]", "[视频]", "[音频]", "[文档: ", "[文件: "}
+
+// UpgradeMessageJSON 将旧版本的消息 JSON 转换为当前结构
+// 返回的 bool 表示数据是否为旧格式并已被转换
+func UpgradeMessageJSON(data []byte) (*Message, bool, error) {
+	var probe struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, false, err
+	}
+	if probe.Version >= MessageSchemaVersion {
+		msg, err := FromJSON(data)
+		return msg, false, err
+	}
+
+	var old legacyMessage
+	if err := json.Unmarshal(data, &old); err != nil {
+		return nil, false, err
+	}
+	return old.upgrade(), true, nil
+}
+
+// upgrade 将旧版消息转换为结构化消息
+func (old *legacyMessage) upgrade() *Message {
+	msg := &Message{
+		Version:     MessageSchemaVersion,
+		ID:          old.ID,
+		MessageID:   old.MessageID,
+		Chat:        Chat{ID: old.ChatID, Title: old.ChatTitle},
+		Sender:      legacySender(old.From),
+		MessageType: old.MessageType,
+		Text:        legacyText(old.Content),
+		CreatedAt:   old.CreatedAt,
+		Attempts:    old.Attempts,
+		LastAttempt: old.LastAttempt,
+		Edited:      old.Edited,
+		Redacted:    old.Redacted,
+	}
+
+	urls := old.AlbumURLs
+	if len(urls) == 0 && old.FileURL != "" {
+		urls = []string{old.FileURL}
+	}
+	kind := old.MessageType
+	if kind == MessageTypeAlbum || kind == "" {
+		kind = MessageTypePhoto
+	}
+	for _, u := range urls {
+		msg.Attachments = append(msg.Attachments, Attachment{Kind: kind, URL: u})
+	}
+
+	if msg.MessageType == "" {
+		if len(msg.Attachments) > 0 {
+			msg.MessageType = MessageTypePhoto
+		} else {
+			msg.MessageType = MessageTypeText
+		}
+	}
+	return msg
+}
+
+// legacySender 从旧版的发送者名称还原发送者
+func legacySender(from string) Sender {
+	if strings.HasPrefix(from, "@") {
+		return Sender{Username: strings.TrimPrefix(from, "@")}
+	}
+	return Sender{DisplayName: from}
+}
+
+// legacyText 从旧版拼接的内容中提取正文：去掉消息头、媒体标签和预览图片
+func legacyText(content string) string {
+	lines := strings.Split(content, "\n")
+	kept := make([]string, 0, len(lines))
+	for i, line := range lines {
+		if i == 0 {
+			line = strings.TrimPrefix(line, "### ")
+			// "【群组】[发送者]" 消息头
+			if strings.HasPrefix(line, "【") && strings.HasSuffix(line, "]") {
+				continue
+			}
+		}
+		if strings.HasPrefix(line, "![预览](") {
+			continue
+		}
+		kept = append(kept, line)
+	}
+
+	text := strings.TrimSpace(strings.Join(kept, "\n"))
+	for _, label := range legacyLabels {
+		if !strings.HasPrefix(text, label) {
+			continue
+		}
+		if strings.HasSuffix(label, ": ") {
+			// 带文件名的标签以 "]" 结束
+			if end := strings.Index(text, "]"); end != -1 {
+				text = text[end+1:]
+			}
+		} else {
+			text = strings.TrimPrefix(text, label)
+		}
+		break
+	}
+	return strings.TrimSpace(text)
+}
@@ -7,49 +7,89 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"mime/multipart"
 	"net/http"
+	"path"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/models"
+	"github.com/user/tg-forward-to-xx/internal/utils"
 )
 
+// 飞书开放平台接口地址
+const (
+	feishuTenantTokenURL = "https://open.feishu.cn/open-apis/auth/v3/tenant_access_token/internal"
+	feishuImageUploadURL = "https://open.feishu.cn/open-apis/im/v1/images"
+)
+
+// 飞书消息类型
+const (
+	FeishuMsgTypeInteractive = "interactive" // 消息卡片
+	FeishuMsgTypePost        = "post"        // 富文本
+)
+
+// feishuHeaderTemplates 卡片标题颜色，按聊天ID固定选取，便于区分不同群组
+var feishuHeaderTemplates = []string{
+	"blue", "wathet", "turquoise", "green", "yellow", "orange",
+	"red", "carmine", "violet", "purple", "indigo",
+}
+
 // FeishuNotifier 飞书通知器
 type FeishuNotifier struct {
-	config *config.FeishuConfig
+	config     *config.FeishuConfig
+	httpClient *http.Client
+
+	tokenMutex  sync.Mutex
+	tenantToken string
+	tokenExpire time.Time
 }
 
 // NewFeishuNotifier 创建飞书通知器
 func NewFeishuNotifier(cfg *config.FeishuConfig) *FeishuNotifier {
 	logrus.WithFields(logrus.Fields{
-		"webhook_url": cfg.WebhookURL,
-		"enable_at":   cfg.EnableAt,
-		"is_at_all":   cfg.IsAtAll,
-		"at_user_ids": cfg.AtUserIDs,
+		"webhook_url":  config.MaskURL(cfg.WebhookURL),
+		"enable_at":    cfg.EnableAt,
+		"is_at_all":    cfg.IsAtAll,
+		"at_user_ids":  cfg.AtUserIDs,
+		"msg_type":     cfg.MsgType,
+		"image_upload": cfg.AppID != "" && cfg.AppSecret != "",
 	}).Info("初始化飞书通知器")
 
 	return &FeishuNotifier{
 		config: cfg,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
 	}
 }
 
 // FeishuMessage 飞书消息结构
 type FeishuMessage struct {
-	Timestamp string      `json:"timestamp"` // 时间戳
-	Sign      string      `json:"sign"`      // 签名
-	MsgType   string      `json:"msg_type"`  // 消息类型
-	Content   interface{} `json:"content"`   // 消息内容
+	Timestamp string      `json:"timestamp"`         // 时间戳
+	Sign      string      `json:"sign"`              // 签名
+	MsgType   string      `json:"msg_type"`          // 消息类型
+	Content   interface{} `json:"content,omitempty"` // 消息内容（post）
+	Card      interface{} `json:"card,omitempty"`    // 卡片内容（interactive）
+}
+
+// feishuResponse 飞书接口响应，兼容新旧两种 Webhook 响应格式
+type feishuResponse struct {
+	Code          *int   `json:"code"`
+	Msg           string `json:"msg"`
+	StatusCode    *int   `json:"StatusCode"`
+	StatusMessage string `json:"StatusMessage"`
 }
 
 // genSign 生成签名
 func (n *FeishuNotifier) genSign(timestamp string) (string, error) {
-	logrus.WithField("timestamp", timestamp).Debug("开始生成签名")
-
-	// 签名拼接格式：timestamp + "\n" + secret
+	// 签名拼接格式：timestamp + "\n" + secret，包含密钥，不能输出到日志
 	stringToSign := timestamp + "\n" + n.config.Secret
-	logrus.WithField("string_to_sign", stringToSign).Debug("待签名字符串")
 
 	// SHA256 计算 HMAC
 	h := hmac.New(sha256.New, []byte(n.config.Secret))
@@ -60,22 +100,22 @@ func (n *FeishuNotifier) genSign(timestamp string) (string, error) {
 
 	// Base64 编码
 	signature := base64.StdEncoding.EncodeToString(h.Sum(nil))
-	logrus.WithField("signature", signature).Debug("签名生成完成")
+	logrus.WithField("timestamp", timestamp).Debug("签名生成完成")
 	return signature, nil
 }
 
 // Send 发送消息到飞书
-func (n *FeishuNotifier) Send(title, content string, isFile bool, fileURL string) error {
+func (n *FeishuNotifier) Send(msg *models.Message) error {
 	if !n.config.Enabled {
 		logrus.Info("飞书通知已禁用")
 		return nil
 	}
 
 	logrus.WithFields(logrus.Fields{
-		"title":    title,
-		"content":  content,
-		"is_file":  isFile,
-		"file_url": fileURL,
+		"message_id": msg.ID,
+		"chat_id":     msg.Chat.ID,
+		"title":       msg.Chat.Title,
+		"attachments": len(msg.Attachments),
 	}).Info("准备发送飞书消息")
 
 	// 获取当前时间戳（秒级）
@@ -88,27 +128,154 @@ func (n *FeishuNotifier) Send(title, content string, isFile bool, fileURL string
 		return fmt.Errorf("生成签名失败: %v", err)
 	}
 
+	feishuMsg := FeishuMessage{
+		Timestamp: timestamp,
+		Sign:      sign,
+	}
+
+	if n.config.MsgType == FeishuMsgTypePost {
+		feishuMsg.MsgType = FeishuMsgTypePost
+		feishuMsg.Content = n.buildPost(msg)
+	} else {
+		feishuMsg.MsgType = FeishuMsgTypeInteractive
+		feishuMsg.Card = n.buildCard(msg, n.imageKeyFor(msg))
+	}
+
+	jsonData, err := json.Marshal(feishuMsg)
+	if err != nil {
+		logrus.WithError(err).Error("JSON编码失败")
+		return fmt.Errorf("JSON编码失败: %v", err)
+	}
+	logrus.WithField("json_data", string(jsonData)).Debug("消息JSON数据")
+
+	req, err := http.NewRequest("POST", n.config.WebhookURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		logrus.WithError(err).Error("创建HTTP请求失败")
+		return fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	body, err := n.do(req)
+	if err != nil {
+		logrus.WithError(err).Error("飞书请求失败")
+		return err
+	}
+
+	if n.config.NotifyVerbose {
+		logrus.WithFields(logrus.Fields{
+			"message_id": msg.ID,
+			"msg_type":   feishuMsg.MsgType,
+			"response":   string(body),
+		}).Info("飞书消息发送成功")
+	}
+
+	return nil
+}
+
+// buildCard 构建消息卡片：按聊天着色的标题、发送者、正文、图片和跳转按钮
+func (n *FeishuNotifier) buildCard(msg *models.Message, imageKey string) map[string]interface{} {
+	elements := []interface{}{
+		map[string]interface{}{
+			"tag": "div",
+			"fields": []interface{}{
+				map[string]interface{}{
+					"is_short": true,
+					"text": map[string]interface{}{
+						"tag":     "lark_md",
+						"content": "**发送者**\n" + msg.Sender.Name(),
+					},
+				},
+				map[string]interface{}{
+					"is_short": true,
+					"text": map[string]interface{}{
+						"tag":     "lark_md",
+						"content": "**时间**\n" + msg.CreatedAt.Format("2006-01-02 15:04:05"),
+					},
+				},
+			},
+		},
+		map[string]interface{}{
+			"tag": "div",
+			"text": map[string]interface{}{
+				"tag":     "plain_text",
+				"content": msg.PlainText(),
+			},
+		},
+	}
+
+	if imageKey != "" {
+		elements = append(elements, map[string]interface{}{
+			"tag":     "img",
+			"img_key": imageKey,
+			"alt": map[string]interface{}{
+				"tag":     "plain_text",
+				"content": "图片",
+			},
+		})
+	}
+
+	var actions []interface{}
+	if link := msg.TelegramLink(); link != "" {
+		actions = append(actions, feishuButton("在 Telegram 中打开", link, "primary"))
+	}
+	for i, attachment := range msg.Attachments {
+		// 已作为图片嵌入卡片的附件不再显示按钮
+		if i == 0 && imageKey != "" {
+			continue
+		}
+		actions = append(actions, feishuButton(attachmentTitle(attachment), attachment.URL, "default"))
+	}
+	if len(actions) > 0 {
+		elements = append(elements, map[string]interface{}{
+			"tag":     "action",
+			"actions": actions,
+		})
+	}
+
+	if at := n.atMarkdown(); at != "" {
+		elements = append(elements, map[string]interface{}{
+			"tag": "div",
+			"text": map[string]interface{}{
+				"tag":     "lark_md",
+				"content": at,
+			},
+		})
+	}
+
+	return map[string]interface{}{
+		"config": map[string]interface{}{
+			"wide_screen_mode": true,
+		},
+		"header": map[string]interface{}{
+			"template": headerTemplate(msg.Chat.ID),
+			"title": map[string]interface{}{
+				"tag":     "plain_text",
+				"content": msg.Chat.Name(),
+			},
+		},
+		"elements": elements,
+	}
+}
+
+// buildPost 构建富文本消息
+func (n *FeishuNotifier) buildPost(msg *models.Message) map[string]interface{} {
+	title := msg.Chat.Name()
+
 	// 构建消息内容
 	contentBlocks := make([][]map[string]interface{}, 1)
 	contentBlocks[0] = make([]map[string]interface{}, 0)
 
-	if isFile {
-		// 文件消息
-		contentBlocks[0] = append(contentBlocks[0], map[string]interface{}{
-			"tag":  "text",
-			"text": title + ": ",
-		})
-		// 如果是图片或文件，使用 tag=a 和实际的 S3 地址
+	contentBlocks[0] = append(contentBlocks[0], map[string]interface{}{
+		"tag":  "text",
+		"text": title + ": " + msg.PlainText(),
+	})
+
+	for _, attachment := range msg.Attachments {
+		// 图片或文件使用 tag=a 和实际的 S3 地址
 		contentBlocks[0] = append(contentBlocks[0], map[string]interface{}{
 			"tag":  "a",
-			"text": "查看图片",
-			"href": fileURL,
-		})
-	} else {
-		// 纯文本消息
-		contentBlocks[0] = append(contentBlocks[0], map[string]interface{}{
-			"tag":  "text",
-			"text": title + ": " + content,
+			"text": attachmentTitle(attachment),
+			"href": attachment.URL,
 		})
 	}
 
@@ -129,80 +296,222 @@ func (n *FeishuNotifier) Send(title, content string, isFile bool, fileURL string
 		}
 	}
 
-	msg := FeishuMessage{
-		Timestamp: timestamp,
-		Sign:      sign,
-		MsgType:   "post",
-		Content: map[string]interface{}{
-			"post": map[string]interface{}{
-				"zh_cn": map[string]interface{}{
-					"title":   title, // 使用群组名或用户名作为标题
-					"content": contentBlocks,
-				},
+	return map[string]interface{}{
+		"post": map[string]interface{}{
+			"zh_cn": map[string]interface{}{
+				"title":   title, // 使用群组名或用户名作为标题
+				"content": contentBlocks,
 			},
 		},
 	}
+}
+
+// atMarkdown 构建卡片中的 @ 内容
+func (n *FeishuNotifier) atMarkdown() string {
+	if !n.config.EnableAt {
+		return ""
+	}
+	if n.config.IsAtAll {
+		return "<at id=all></at>"
+	}
+
+	var parts []string
+	for _, userID := range n.config.AtUserIDs {
+		parts = append(parts, fmt.Sprintf("<at id=%s></at>", userID))
+	}
+	return strings.Join(parts, " ")
+}
+
+// attachmentTitle 返回附件按钮或链接的文字
+func attachmentTitle(attachment models.Attachment) string {
+	if attachment.IsImage() {
+		return "查看图片"
+	}
+	if attachment.FileName != "" {
+		return "查看文件: " + attachment.FileName
+	}
+	return "查看文件"
+}
 
-	jsonData, err := json.Marshal(msg)
+// imageKeyFor 配置了应用凭证且首个附件为图片时将其上传到飞书，失败时返回空字符串并退回链接按钮
+func (n *FeishuNotifier) imageKeyFor(msg *models.Message) string {
+	attachment := msg.PrimaryAttachment()
+	if attachment == nil || !attachment.IsImage() || n.config.AppID == "" || n.config.AppSecret == "" {
+		return ""
+	}
+
+	imageKey, err := n.uploadImage(attachment.URL)
 	if err != nil {
-		logrus.WithError(err).Error("JSON编码失败")
-		return fmt.Errorf("JSON编码失败: %v", err)
+		logrus.WithFields(logrus.Fields{
+			"file_url": attachment.URL,
+			"error":    err,
+		}).Warn("上传图片到飞书失败，使用链接代替")
+		return ""
 	}
-	logrus.WithField("json_data", string(jsonData)).Debug("消息JSON数据")
+	return imageKey
+}
 
-	req, err := http.NewRequest("POST", n.config.WebhookURL, bytes.NewBuffer(jsonData))
+// uploadImage 下载图片并上传到飞书，返回 image_key
+func (n *FeishuNotifier) uploadImage(fileURL string) (string, error) {
+	resp, err := utils.HTTPClient.Get(fileURL)
 	if err != nil {
-		logrus.WithError(err).Error("创建HTTP请求失败")
-		return fmt.Errorf("创建请求失败: %v", err)
+		return "", fmt.Errorf("下载图片失败: %w", err)
 	}
+	defer resp.Body.Close()
 
-	req.Header.Set("Content-Type", "application/json")
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("下载图片失败，状态码: %d", resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" && !strings.HasPrefix(contentType, "image/") {
+		return "", fmt.Errorf("文件不是图片: %s", contentType)
+	}
 
-	logrus.WithFields(logrus.Fields{
-		"url":     n.config.WebhookURL,
-		"headers": req.Header,
-	}).Debug("发送HTTP请求")
+	token, err := n.getTenantToken()
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	if err := writer.WriteField("image_type", "message"); err != nil {
+		return "", fmt.Errorf("构建上传请求失败: %w", err)
+	}
+	part, err := writer.CreateFormFile("image", path.Base(resp.Request.URL.Path))
+	if err != nil {
+		return "", fmt.Errorf("构建上传请求失败: %w", err)
+	}
+	if _, err := io.Copy(part, resp.Body); err != nil {
+		return "", fmt.Errorf("读取图片内容失败: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("构建上传请求失败: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", feishuImageUploadURL, &buf)
+	if err != nil {
+		return "", fmt.Errorf("创建上传请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
 
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+	body, err := n.do(req)
+	if err != nil {
+		return "", fmt.Errorf("上传图片失败: %w", err)
+	}
+
+	var result struct {
+		Data struct {
+			ImageKey string `json:"image_key"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("解析上传响应失败: %w", err)
+	}
+	if result.Data.ImageKey == "" {
+		return "", fmt.Errorf("上传响应缺少 image_key: %s", string(body))
+	}
+
+	logrus.WithField("image_key", result.Data.ImageKey).Debug("图片已上传到飞书")
+	return result.Data.ImageKey, nil
+}
+
+// getTenantToken 获取 tenant_access_token，过期前复用缓存
+func (n *FeishuNotifier) getTenantToken() (string, error) {
+	n.tokenMutex.Lock()
+	defer n.tokenMutex.Unlock()
+
+	if n.tenantToken != "" && time.Now().Before(n.tokenExpire) {
+		return n.tenantToken, nil
 	}
 
-	resp, err := client.Do(req)
+	jsonData, err := json.Marshal(map[string]string{
+		"app_id":     n.config.AppID,
+		"app_secret": n.config.AppSecret,
+	})
 	if err != nil {
-		logrus.WithError(err).Error("发送HTTP请求失败")
-		return fmt.Errorf("发送请求失败: %v", err)
+		return "", fmt.Errorf("序列化请求失败: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", feishuTenantTokenURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", fmt.Errorf("创建请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	body, err := n.do(req)
+	if err != nil {
+		return "", fmt.Errorf("获取 tenant_access_token 失败: %w", err)
+	}
+
+	var result struct {
+		TenantAccessToken string `json:"tenant_access_token"`
+		Expire            int    `json:"expire"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("解析 tenant_access_token 响应失败: %w", err)
+	}
+
+	// 提前 5 分钟刷新，避免使用即将过期的令牌
+	n.tenantToken = result.TenantAccessToken
+	n.tokenExpire = time.Now().Add(time.Duration(result.Expire)*time.Second - 5*time.Minute)
+	return n.tenantToken, nil
+}
+
+// do 发送请求并检查 HTTP 状态码和响应中的 code 字段
+func (n *FeishuNotifier) do(req *http.Request) ([]byte, error) {
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("发送请求失败: %v", err)
 	}
 	defer resp.Body.Close()
 
 	// 读取响应内容
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		logrus.WithError(err).Error("读取响应内容失败")
-		return fmt.Errorf("读取响应内容失败: %v", err)
+		return nil, fmt.Errorf("读取响应内容失败: %v", err)
 	}
 
 	logrus.WithFields(logrus.Fields{
+		"url":           req.URL.Path,
 		"status_code":   resp.StatusCode,
 		"response_body": string(body),
-	}).Info("收到飞书响应")
+	}).Debug("收到飞书响应")
 
 	if resp.StatusCode != http.StatusOK {
-		logrus.WithFields(logrus.Fields{
-			"status_code": resp.StatusCode,
-			"response":    string(body),
-		}).Error("飞书请求失败")
-		return fmt.Errorf("请求失败，状态码: %d, 响应: %s", resp.StatusCode, string(body))
+		return body, fmt.Errorf("请求失败，状态码: %d, 响应: %s", resp.StatusCode, string(body))
 	}
 
-	if n.config.NotifyVerbose {
-		logrus.WithFields(logrus.Fields{
-			"title":    title,
-			"content":  content,
-			"is_file":  isFile,
-			"file_url": fileURL,
-			"response": string(body),
-		}).Info("飞书消息发送成功")
+	var result feishuResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return body, fmt.Errorf("解析响应失败: %v, 响应: %s", err, string(body))
+	}
+	if result.Code != nil && *result.Code != 0 {
+		return body, fmt.Errorf("飞书返回错误: code=%d, msg=%s", *result.Code, result.Msg)
+	}
+	if result.StatusCode != nil && *result.StatusCode != 0 {
+		return body, fmt.Errorf("飞书返回错误: code=%d, msg=%s", *result.StatusCode, result.StatusMessage)
 	}
 
-	return nil
-} 
\ No newline at end of file
+	return body, nil
+}
+
+// feishuButton 构建卡片跳转按钮
+func feishuButton(text, url, buttonType string) map[string]interface{} {
+	return map[string]interface{}{
+		"tag": "button",
+		"text": map[string]interface{}{
+			"tag":     "plain_text",
+			"content": text,
+		},
+		"type": buttonType,
+		"url":  url,
+	}
+}
+
+// headerTemplate 根据聊天ID选取卡片标题颜色
+func headerTemplate(chatID int64) string {
+	if chatID < 0 {
+		chatID = -chatID
+	}
+	return feishuHeaderTemplates[chatID%int64(len(feishuHeaderTemplates))]
+}
@@ -12,6 +12,8 @@ import (
 	"github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/encryption"
+	"github.com/user/tg-forward-to-xx/internal/migration"
 	"github.com/user/tg-forward-to-xx/internal/models"
 	"github.com/sirupsen/logrus"
 )
@@ -19,6 +21,7 @@ import (
 // LevelDBQueue 基于 LevelDB 的持久化队列实现
 type LevelDBQueue struct {
 	db        *leveldb.DB
+	cipher    *encryption.Cipher // 未启用静态加密时为 nil
 	mutex     sync.Mutex
 	indexKey  []byte
 	closed    bool
@@ -52,7 +55,7 @@ func NewLevelDBQueue() (Queue, error) {
 
 // 创建 LevelDB 队列
 func createLevelDBQueue() (Queue, error) {
-	queuePath := config.AppConfig.Queue.Path
+	queuePath := config.Current().Queue.Path
 	logrus.Debugf("开始创建 LevelDB 队列，路径: %s", queuePath)
 
 	// 检查目录是否存在
@@ -96,6 +99,25 @@ func createLevelDBQueue() (Queue, error) {
 		}
 	}
 
+	return openLevelDBQueue(queuePath)
+}
+
+// OpenLevelDBQueue 打开指定路径下已有的 LevelDB 队列，供命令行工具在服务停止后离线使用
+// 与服务启动时不同，不会清理锁文件，服务仍在运行时返回错误
+func OpenLevelDBQueue(queuePath string) (*LevelDBQueue, error) {
+	if _, err := os.Stat(queuePath); err != nil {
+		return nil, fmt.Errorf("检查队列目录状态失败: %w", err)
+	}
+
+	q, err := openLevelDBQueue(queuePath)
+	if err != nil {
+		return nil, fmt.Errorf("%w（服务是否仍在运行？）", err)
+	}
+	return q, nil
+}
+
+// openLevelDBQueue 打开 LevelDB 数据库并初始化索引、迁移旧版消息
+func openLevelDBQueue(queuePath string) (*LevelDBQueue, error) {
 	// 打开 LevelDB 数据库，添加更多选项以提高稳定性
 	options := &opt.Options{
 		ErrorIfExist:   false,
@@ -137,10 +159,136 @@ func createLevelDBQueue() (Queue, error) {
 		}
 	}
 
+	// 检查数据库结构版本，旧版本需要先运行迁移工具
+	if err := migration.Check(db, migration.Queue); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// 启用静态加密后首次启动时会加密队列中已有的消息
+	keys, err := encryption.KeysFromConfig(config.Current().Encryption)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if queue.cipher, err = encryption.Open(db, migration.Queue.Name, migration.Queue.IsData, keys); err != nil {
+		db.Close()
+		return nil, err
+	}
+
 	logrus.Info("LevelDB 队列创建成功")
 	return queue, nil
 }
 
+// Entry 队列中的一条消息及其存储键
+type Entry struct {
+	Key     string
+	Message *models.Message
+}
+
+// Entries 按入队顺序返回队列中的全部消息，无法解析的消息 Message 为 nil
+func (q *LevelDBQueue) Entries() ([]Entry, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		return nil, ErrQueueClosed
+	}
+
+	keys, err := q.getMessageKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(keys))
+	for _, key := range keys {
+		data, err := q.db.Get([]byte(key), nil)
+		if err != nil {
+			return nil, fmt.Errorf("读取消息 %s 失败: %w", key, err)
+		}
+		msg, err := q.decode(key, data)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"key":   key,
+				"error": err,
+			}).Warn("无法解析队列中的消息")
+			msg = nil
+		}
+		entries = append(entries, Entry{Key: key, Message: msg})
+	}
+	return entries, nil
+}
+
+// Get 按存储键读取一条消息
+func (q *LevelDBQueue) Get(key string) (*models.Message, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		return nil, ErrQueueClosed
+	}
+
+	data, err := q.db.Get([]byte(key), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("获取消息失败: %w", err)
+	}
+	return q.decode(key, data)
+}
+
+// Update 按存储键覆盖一条消息，保持其在队列中的位置
+func (q *LevelDBQueue) Update(key string, msg *models.Message) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+
+	if ok, err := q.db.Has([]byte(key), nil); err != nil {
+		return fmt.Errorf("检查消息失败: %w", err)
+	} else if !ok {
+		return ErrMessageNotFound
+	}
+
+	data, err := msg.ToJSON()
+	if err != nil {
+		return fmt.Errorf("序列化消息失败: %w", err)
+	}
+	if err := q.db.Put([]byte(key), q.cipher.Seal([]byte(key), data), nil); err != nil {
+		return fmt.Errorf("存储消息失败: %w", err)
+	}
+	return nil
+}
+
+// Delete 删除指定存储键的消息，返回实际删除的数量
+func (q *LevelDBQueue) Delete(keys ...string) (int, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		return 0, ErrQueueClosed
+	}
+
+	batch := new(leveldb.Batch)
+	for _, key := range keys {
+		if ok, err := q.db.Has([]byte(key), nil); err != nil {
+			return 0, fmt.Errorf("检查消息失败: %w", err)
+		} else if ok {
+			batch.Delete([]byte(key))
+		}
+	}
+	if batch.Len() == 0 {
+		return 0, nil
+	}
+	if err := q.db.Write(batch, nil); err != nil {
+		return 0, fmt.Errorf("删除消息失败: %w", err)
+	}
+	return batch.Len(), nil
+}
+
 // Push 将消息添加到队列
 func (q *LevelDBQueue) Push(msg *models.Message) error {
 	q.mutex.Lock()
@@ -171,7 +319,7 @@ func (q *LevelDBQueue) Push(msg *models.Message) error {
 	msgKey := fmt.Sprintf("msg:%d", index)
 
 	// 存储消息
-	if err := q.db.Put([]byte(msgKey), msgBytes, nil); err != nil {
+	if err := q.db.Put([]byte(msgKey), q.cipher.Seal([]byte(msgKey), msgBytes), nil); err != nil {
 		return fmt.Errorf("存储消息失败: %w", err)
 	}
 
@@ -210,7 +358,7 @@ func (q *LevelDBQueue) Pop() (*models.Message, error) {
 	}
 
 	// 解析消息
-	msg, err := models.FromJSON(msgBytes)
+	msg, err := q.decode(keys[0], msgBytes)
 	if err != nil {
 		return nil, fmt.Errorf("解析消息失败: %w", err)
 	}
@@ -249,7 +397,7 @@ func (q *LevelDBQueue) Peek() (*models.Message, error) {
 	}
 
 	// 解析消息
-	msg, err := models.FromJSON(msgBytes)
+	msg, err := q.decode(keys[0], msgBytes)
 	if err != nil {
 		return nil, fmt.Errorf("解析消息失败: %w", err)
 	}
@@ -257,6 +405,30 @@ func (q *LevelDBQueue) Peek() (*models.Message, error) {
 	return msg, nil
 }
 
+// Snapshot 返回队列数据库的一致性快照，用于在线备份，使用完毕后需要 Release
+func (q *LevelDBQueue) Snapshot() (*leveldb.Snapshot, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		return nil, ErrQueueClosed
+	}
+	snapshot, err := q.db.GetSnapshot()
+	if err != nil {
+		return nil, fmt.Errorf("创建队列快照失败: %w", err)
+	}
+	return snapshot, nil
+}
+
+// decode 解密并解析一条消息
+func (q *LevelDBQueue) decode(key string, data []byte) (*models.Message, error) {
+	plaintext, err := q.cipher.Open([]byte(key), data)
+	if err != nil {
+		return nil, err
+	}
+	return models.FromJSON(plaintext)
+}
+
 // Size 返回队列中的消息数量
 func (q *LevelDBQueue) Size() (int, error) {
 	q.mutex.Lock()
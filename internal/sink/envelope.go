@@ -0,0 +1,75 @@
+package sink
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// EnvelopeVersion 发布到 MQTT 和 Redis 的消息格式版本，字段有不兼容变更时递增
+const EnvelopeVersion = 1
+
+// Envelope 发布到 MQTT 和 Redis 的消息格式
+// 与队列中保存的 models.Message 分开定义，重试次数等内部字段不会对外发布
+type Envelope struct {
+	Version     int                  `json:"version"`               // 格式版本
+	ID          int64                `json:"id"`                    // 唯一标识符，重试时不变，可用于去重
+	MessageID   int                  `json:"message_id"`            // Telegram 原始消息ID
+	Chat        models.Chat          `json:"chat"`                  // 所在聊天
+	Sender      models.Sender        `json:"sender"`                // 发送者
+	MessageType string               `json:"message_type"`          // 消息类型
+	Text        string               `json:"text"`                  // 消息文本或媒体说明
+	Entities    []models.Entity      `json:"entities,omitempty"`    // 文本格式实体
+	ReplyTo     *models.ReplyRef     `json:"reply_to,omitempty"`    // 回复的消息
+	Attachments []EnvelopeAttachment `json:"attachments,omitempty"` // 媒体附件
+	CreatedAt   time.Time            `json:"created_at"`            // 创建时间
+	Edited      bool                 `json:"edited"`                // 是否为编辑后的消息
+	Redacted    bool                 `json:"redacted"`              // 是否已按路由规则脱敏
+}
+
+// EnvelopeAttachment 发布格式中的媒体附件，不包含 S3 对象名称等内部字段
+type EnvelopeAttachment struct {
+	Kind     string `json:"kind"`                // 类型：photo、document、video、audio
+	URL      string `json:"url"`                 // S3 地址
+	MIMEType string `json:"mime_type,omitempty"` // MIME 类型
+	Size     int64  `json:"size,omitempty"`      // 文件大小（字节）
+	Width    int    `json:"width,omitempty"`     // 宽度（图片和视频）
+	Height   int    `json:"height,omitempty"`    // 高度（图片和视频）
+	FileName string `json:"file_name,omitempty"` // 文件名
+}
+
+// newEnvelope 根据消息构建发布格式
+func newEnvelope(msg *models.Message) *Envelope {
+	env := &Envelope{
+		Version:     EnvelopeVersion,
+		ID:          msg.ID,
+		MessageID:   msg.MessageID,
+		Chat:        msg.Chat,
+		Sender:      msg.Sender,
+		MessageType: msg.MessageType,
+		Text:        msg.Text,
+		Entities:    msg.Entities,
+		ReplyTo:     msg.ReplyTo,
+		CreatedAt:   msg.CreatedAt,
+		Edited:      msg.Edited,
+		Redacted:    msg.Redacted,
+	}
+	for _, a := range msg.Attachments {
+		env.Attachments = append(env.Attachments, EnvelopeAttachment{
+			Kind:     a.Kind,
+			URL:      a.URL,
+			MIMEType: a.MIMEType,
+			Size:     a.Size,
+			Width:    a.Width,
+			Height:   a.Height,
+			FileName: a.FileName,
+		})
+	}
+	return env
+}
+
+// marshalEnvelope 将消息序列化为发布格式的 JSON
+func marshalEnvelope(msg *models.Message) ([]byte, error) {
+	return json.Marshal(newEnvelope(msg))
+}
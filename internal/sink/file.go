@@ -0,0 +1,353 @@
+package sink
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/metrics"
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// 归档落盘策略
+const (
+	DurabilityNone     = "none"     // 不主动 fsync，由操作系统决定
+	DurabilityInterval = "interval" // 按固定间隔 fsync
+	DurabilityAlways   = "always"   // 每条消息写入后立即 fsync
+)
+
+// 归档文件日期格式
+const archiveDayFormat = "2006-01-02"
+
+// 注册文件归档投递目标
+func init() {
+	Register("file", newFileSink)
+}
+
+// archiveRecord 归档文件中的一行记录
+type archiveRecord struct {
+	ArchivedAt time.Time       `json:"archived_at"` // 归档时间
+	Message    *models.Message `json:"message"`     // 消息内容
+}
+
+// archiveFile 某个聊天当天正在写入的归档文件
+type archiveFile struct {
+	day   string
+	path  string
+	file  *os.File
+	dirty bool
+}
+
+// FileSink 按天和聊天拆分的 JSON Lines 归档投递目标
+type FileSink struct {
+	name       string
+	dir        string
+	gzip       bool
+	durability string
+	mutex      sync.Mutex
+	files      map[int64]*archiveFile
+	stopChan   chan struct{}
+	wg         sync.WaitGroup
+}
+
+// newFileSink 创建文件归档投递目标
+func newFileSink(cfg *config.SinkConfig) (Sink, error) {
+	fileCfg := cfg.File
+	if fileCfg == nil || fileCfg.Dir == "" {
+		return nil, fmt.Errorf("投递目标 %s 未配置归档目录 dir", cfg.Name)
+	}
+
+	durability := fileCfg.Durability
+	if durability == "" {
+		durability = DurabilityInterval
+	}
+	if durability != DurabilityNone && durability != DurabilityInterval && durability != DurabilityAlways {
+		return nil, fmt.Errorf("不支持的落盘策略: %s，支持的策略: none, interval, always", durability)
+	}
+
+	if err := os.MkdirAll(fileCfg.Dir, 0750); err != nil {
+		return nil, fmt.Errorf("创建归档目录失败: %w", err)
+	}
+
+	s := &FileSink{
+		name:       cfg.Name,
+		dir:        fileCfg.Dir,
+		gzip:       fileCfg.Gzip,
+		durability: durability,
+		files:      make(map[int64]*archiveFile),
+		stopChan:   make(chan struct{}),
+	}
+
+	if durability == DurabilityInterval {
+		interval := time.Duration(fileCfg.SyncInterval) * time.Second
+		if interval <= 0 {
+			interval = time.Second
+		}
+		s.wg.Add(1)
+		go s.syncLoop(interval)
+	}
+
+	s.wg.Add(1)
+	go s.rotateLoop()
+
+	if s.gzip {
+		s.wg.Add(1)
+		go s.compressStale()
+	}
+
+	return s, nil
+}
+
+// Name 返回投递目标名称
+func (s *FileSink) Name() string {
+	return s.name
+}
+
+// Send 将消息追加到对应聊天当天的归档文件
+func (s *FileSink) Send(msg *models.Message) error {
+	now := time.Now()
+	line, err := json.Marshal(&archiveRecord{ArchivedAt: now, Message: msg})
+	if err != nil {
+		return fmt.Errorf("序列化归档记录失败: %w", err)
+	}
+	line = append(line, '\n')
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	af, err := s.fileFor(msg.Chat.ID, now.Format(archiveDayFormat))
+	if err != nil {
+		return err
+	}
+
+	if _, err := af.file.Write(line); err != nil {
+		return fmt.Errorf("写入归档文件失败: %w", err)
+	}
+
+	if s.durability == DurabilityAlways {
+		if err := af.file.Sync(); err != nil {
+			return fmt.Errorf("同步归档文件失败: %w", err)
+		}
+	} else {
+		af.dirty = true
+	}
+
+	metrics.SetArchiveFile(s.name, af.path)
+	return nil
+}
+
+// Close 同步并关闭所有归档文件
+func (s *FileSink) Close() error {
+	close(s.stopChan)
+	s.wg.Wait()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var lastErr error
+	for chatID, af := range s.files {
+		if err := s.closeFile(af); err != nil {
+			logrus.Errorf("关闭归档文件 %s 失败: %v", af.path, err)
+			lastErr = err
+		}
+		delete(s.files, chatID)
+	}
+	return lastErr
+}
+
+// fileFor 获取聊天当天的归档文件，日期变化时轮转，调用方需持有锁
+func (s *FileSink) fileFor(chatID int64, day string) (*archiveFile, error) {
+	if af, ok := s.files[chatID]; ok {
+		if af.day == day {
+			return af, nil
+		}
+		s.rotate(af)
+		delete(s.files, chatID)
+	}
+
+	chatDir := filepath.Join(s.dir, strconv.FormatInt(chatID, 10))
+	if err := os.MkdirAll(chatDir, 0750); err != nil {
+		return nil, fmt.Errorf("创建归档目录失败: %w", err)
+	}
+
+	path := filepath.Join(chatDir, day+".jsonl")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("打开归档文件失败: %w", err)
+	}
+
+	af := &archiveFile{day: day, path: path, file: file}
+	s.files[chatID] = af
+
+	logrus.WithFields(logrus.Fields{
+		"sink": s.name,
+		"path": path,
+	}).Debug("打开归档文件")
+	return af, nil
+}
+
+// rotate 关闭旧的归档文件，按配置在后台压缩
+func (s *FileSink) rotate(af *archiveFile) {
+	if err := s.closeFile(af); err != nil {
+		logrus.Errorf("关闭归档文件 %s 失败: %v", af.path, err)
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"sink": s.name,
+		"path": af.path,
+	}).Info("归档文件已轮转")
+
+	if !s.gzip {
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := gzipFile(af.path); err != nil {
+			logrus.Errorf("压缩归档文件 %s 失败: %v", af.path, err)
+		}
+	}()
+}
+
+// closeFile 同步并关闭归档文件
+func (s *FileSink) closeFile(af *archiveFile) error {
+	if s.durability != DurabilityNone {
+		if err := af.file.Sync(); err != nil {
+			af.file.Close()
+			return err
+		}
+	}
+	return af.file.Close()
+}
+
+// syncLoop 按固定间隔同步有新写入的归档文件
+func (s *FileSink) syncLoop(interval time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+			s.mutex.Lock()
+			for _, af := range s.files {
+				if !af.dirty {
+					continue
+				}
+				if err := af.file.Sync(); err != nil {
+					logrus.Errorf("同步归档文件 %s 失败: %v", af.path, err)
+					continue
+				}
+				af.dirty = false
+			}
+			s.mutex.Unlock()
+		}
+	}
+}
+
+// rotateLoop 每天零点轮转前一天的归档文件，不必等到该聊天的下一条消息
+func (s *FileSink) rotateLoop() {
+	defer s.wg.Done()
+
+	for {
+		timer := time.NewTimer(untilNextDay(time.Now()))
+		select {
+		case <-s.stopChan:
+			timer.Stop()
+			return
+		case <-timer.C:
+			s.rotateStale(time.Now().Format(archiveDayFormat))
+		}
+	}
+}
+
+// rotateStale 轮转日期不是 today 的全部归档文件
+func (s *FileSink) rotateStale(today string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for chatID, af := range s.files {
+		if af.day == today {
+			continue
+		}
+		s.rotate(af)
+		delete(s.files, chatID)
+	}
+}
+
+// untilNextDay 返回距离下一个本地零点的时间
+func untilNextDay(now time.Time) time.Duration {
+	y, m, d := now.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, now.Location()).Sub(now)
+}
+
+// compressStale 压缩上次运行遗留的历史归档文件
+func (s *FileSink) compressStale() {
+	defer s.wg.Done()
+
+	today := time.Now().Format(archiveDayFormat)
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*", "*.jsonl"))
+	if err != nil {
+		logrus.Errorf("查找历史归档文件失败: %v", err)
+		return
+	}
+
+	for _, path := range paths {
+		if filepath.Base(path) == today+".jsonl" {
+			continue
+		}
+		if err := gzipFile(path); err != nil {
+			logrus.Errorf("压缩归档文件 %s 失败: %v", path, err)
+		}
+	}
+}
+
+// gzipFile 将文件压缩为 .gz 并删除原文件
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	gzPath := path + ".gz"
+	dst, err := os.OpenFile(gzPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	zw.Name = filepath.Base(path)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		os.Remove(gzPath)
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(gzPath)
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
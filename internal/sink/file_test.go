@@ -0,0 +1,100 @@
+package sink
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/user/tg-forward-to-xx/internal/config"
+)
+
+func newTestFileSink(t *testing.T, gzip bool) (*FileSink, string) {
+	t.Helper()
+	dir := t.TempDir()
+	s, err := newFileSink(&config.SinkConfig{
+		Name: "archive",
+		Type: "file",
+		File: &config.FileSinkConfig{Dir: dir, Gzip: gzip, Durability: DurabilityNone},
+	})
+	if err != nil {
+		t.Fatalf("newFileSink: %v", err)
+	}
+	return s.(*FileSink), dir
+}
+
+func TestFileSinkRotatesAtDayBoundary(t *testing.T) {
+	s, dir := newTestFileSink(t, true)
+
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	today := time.Now().Format(archiveDayFormat)
+	path := filepath.Join(dir, "-100123", today+".jsonl")
+
+	// 零点后没有新消息，文件同样会被关闭并压缩
+	s.rotateStale("2099-01-01")
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if len(s.files) != 0 {
+		t.Errorf("open files = %d, want 0", len(s.files))
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("%s should have been compressed", path)
+	}
+
+	f, err := os.Open(path + ".gz")
+	if err != nil {
+		t.Fatalf("open gzip: %v", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("read gzip: %v", err)
+	}
+	if !strings.Contains(string(data), "部署完成") {
+		t.Errorf("archive = %s", data)
+	}
+}
+
+func TestFileSinkKeepsCurrentDay(t *testing.T) {
+	s, dir := newTestFileSink(t, true)
+	defer s.Close()
+
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	today := time.Now().Format(archiveDayFormat)
+	s.rotateStale(today)
+	if len(s.files) != 1 {
+		t.Fatalf("open files = %d, want 1", len(s.files))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "-100123", today+".jsonl")); err != nil {
+		t.Errorf("current file: %v", err)
+	}
+}
+
+func TestUntilNextDay(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*3600)
+	tests := []struct {
+		now  time.Time
+		want time.Duration
+	}{
+		{time.Date(2026, 3, 1, 23, 59, 30, 0, loc), 30 * time.Second},
+		{time.Date(2026, 3, 1, 0, 0, 0, 0, loc), 24 * time.Hour},
+		{time.Date(2026, 12, 31, 12, 0, 0, 0, loc), 12 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := untilNextDay(tt.now); got != tt.want {
+			t.Errorf("untilNextDay(%v) = %v, want %v", tt.now, got, tt.want)
+		}
+	}
+}
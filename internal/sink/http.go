@@ -0,0 +1,111 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/utils"
+)
+
+// 投递请求超时时间
+const requestTimeout = 10 * time.Second
+
+// newHTTPClient 创建投递目标使用的 HTTP 客户端
+func newHTTPClient() *http.Client {
+	return &http.Client{Timeout: requestTimeout}
+}
+
+// doJSON 发送 JSON 请求并返回响应内容，非 2xx 状态码视为失败
+func doJSON(client *http.Client, method, endpoint string, headers map[string]string, payload interface{}) ([]byte, error) {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("序列化请求失败: %w", err)
+	}
+	return doRequest(client, method, endpoint, headers, jsonData)
+}
+
+// doRequest 发送 JSON 请求体，非 2xx 状态码视为失败
+// 请求在持有输出锁时发送，这里不等待重试：连接失败、5xx 和 429 响应返回 ErrTemporary，由重试队列稍后重发
+func doRequest(client *http.Client, method, endpoint string, headers map[string]string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: 发送请求失败: %v", ErrTemporary, err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应失败: %w", err)
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return respBody, nil
+	}
+	err = fmt.Errorf("返回错误状态码: %d, 响应: %s", resp.StatusCode, string(respBody))
+	if !retryable(resp.StatusCode) {
+		return respBody, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"url":         config.MaskURL(endpoint),
+		"status":      resp.StatusCode,
+		"retry_after": resp.Header.Get("Retry-After"),
+	}).Warn("投递请求暂时失败，稍后通过重试队列重发")
+	return respBody, fmt.Errorf("%w: %v", ErrTemporary, err)
+}
+
+// retryable 判断状态码是否值得重试：限流和服务端错误
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// downloadedFile 下载到内存的媒体文件
+type downloadedFile struct {
+	Data        []byte
+	ContentType string
+	FileName    string
+}
+
+// downloadFile 下载媒体文件，用于上传到目标平台
+func downloadFile(fileURL string) (*downloadedFile, error) {
+	resp, err := utils.HTTPClient.Get(fileURL)
+	if err != nil {
+		return nil, fmt.Errorf("下载文件失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("下载文件失败，状态码: %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取文件内容失败: %w", err)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+
+	return &downloadedFile{
+		Data:        data,
+		ContentType: contentType,
+		FileName:    path.Base(resp.Request.URL.Path),
+	}, nil
+}
@@ -0,0 +1,67 @@
+package sink
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDoJSONReturnsBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	body, err := doJSON(newHTTPClient(), http.MethodPost, server.URL, nil, map[string]string{"text": "hi"})
+	if err != nil {
+		t.Fatalf("doJSON: %v", err)
+	}
+	if string(body) != `{"ok":true}` {
+		t.Errorf("body = %s", body)
+	}
+}
+
+func TestDoJSONTemporaryErrors(t *testing.T) {
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable} {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(status)
+		}))
+
+		_, err := doJSON(newHTTPClient(), http.MethodPost, server.URL, nil, nil)
+		server.Close()
+		if !errors.Is(err, ErrTemporary) {
+			t.Errorf("status %d: err = %v, want ErrTemporary", status, err)
+		}
+		// 不在持有输出锁时等待重试，由重试队列重发
+		if calls.Load() != 1 {
+			t.Errorf("status %d: calls = %d, want 1", status, calls.Load())
+		}
+	}
+}
+
+func TestDoJSONClientErrorsAreNotTemporary(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	_, err := doJSON(newHTTPClient(), http.MethodPost, server.URL, nil, nil)
+	if err == nil || errors.Is(err, ErrTemporary) {
+		t.Errorf("err = %v, want a permanent error", err)
+	}
+}
+
+func TestDoJSONConnectionErrorsAreTemporary(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := server.URL
+	server.Close()
+
+	if _, err := doJSON(newHTTPClient(), http.MethodPost, url, nil, nil); !errors.Is(err, ErrTemporary) {
+		t.Errorf("err = %v, want ErrTemporary", err)
+	}
+}
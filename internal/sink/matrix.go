@@ -0,0 +1,197 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// 注册 Matrix 投递目标
+func init() {
+	Register("matrix", newMatrixSink)
+}
+
+// MatrixSink 通过 Client-Server API 发送到 Matrix 房间的投递目标
+type MatrixSink struct {
+	name          string
+	homeserverURL string
+	accessToken   string
+	roomID        string
+	uploadMedia   bool
+	httpClient    *http.Client
+}
+
+// newMatrixSink 创建 Matrix 投递目标
+func newMatrixSink(cfg *config.SinkConfig) (Sink, error) {
+	mxCfg := cfg.Matrix
+	if mxCfg == nil {
+		return nil, fmt.Errorf("投递目标 %s 缺少 matrix 配置", cfg.Name)
+	}
+	if mxCfg.HomeserverURL == "" || mxCfg.AccessToken == "" || mxCfg.RoomID == "" {
+		return nil, fmt.Errorf("投递目标 %s 必须配置 homeserver_url、access_token 和 room_id", cfg.Name)
+	}
+
+	return &MatrixSink{
+		name:          cfg.Name,
+		homeserverURL: strings.TrimRight(mxCfg.HomeserverURL, "/"),
+		accessToken:   mxCfg.AccessToken,
+		roomID:        mxCfg.RoomID,
+		uploadMedia:   mxCfg.UploadMedia,
+		httpClient:    newHTTPClient(),
+	}, nil
+}
+
+// Name 返回投递目标名称
+func (s *MatrixSink) Name() string {
+	return s.name
+}
+
+// Send 发送消息到 Matrix 房间
+// 启用 upload_media 时图片会上传到 Homeserver 并额外发送一条 m.image 消息
+func (s *MatrixSink) Send(msg *models.Message) error {
+	textContent := map[string]interface{}{
+		"msgtype": "m.text",
+		"body":    msg.Markdown(),
+	}
+	if err := s.sendEvent(textContent, s.txnID(msg, "text")); err != nil {
+		return err
+	}
+
+	if !s.uploadMedia {
+		return nil
+	}
+
+	for i, image := range msg.Images() {
+		imageContent, err := s.buildImageContent(image.URL)
+		if err != nil {
+			// 文本中已经包含 S3 链接，上传失败时不影响消息送达
+			logrus.WithFields(logrus.Fields{
+				"sink":     s.name,
+				"file_url": image.URL,
+				"error":    err,
+			}).Warn("上传媒体到 Matrix 失败，仅发送文本")
+			continue
+		}
+		if err := s.sendEvent(imageContent, s.txnID(msg, fmt.Sprintf("image%d", i))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close 关闭投递目标
+func (s *MatrixSink) Close() error {
+	return nil
+}
+
+// txnID 生成事务ID，重试同一条消息时保持不变，由 Homeserver 负责去重
+func (s *MatrixSink) txnID(msg *models.Message, part string) string {
+	suffix := part
+	if msg.Edited {
+		suffix += "_edited"
+	}
+	return fmt.Sprintf("tgfwd_%d_%d_%s", msg.Chat.ID, msg.ID, suffix)
+}
+
+// sendEvent 向房间发送 m.room.message 事件
+func (s *MatrixSink) sendEvent(content map[string]interface{}, txnID string) error {
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		s.homeserverURL,
+		url.PathEscape(s.roomID),
+		url.PathEscape(txnID),
+	)
+
+	body, err := doJSON(s.httpClient, http.MethodPut, endpoint, s.authHeaders(), content)
+	if err != nil {
+		return fmt.Errorf("发送 Matrix 消息失败: %w", err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"sink":     s.name,
+		"room_id":  s.roomID,
+		"msgtype":  content["msgtype"],
+		"response": string(body),
+	}).Debug("Matrix 消息发送成功")
+	return nil
+}
+
+// buildImageContent 下载图片并上传到 Homeserver，构建 m.image 消息内容
+func (s *MatrixSink) buildImageContent(fileURL string) (map[string]interface{}, error) {
+	file, err := downloadFile(fileURL)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(file.ContentType, "image/") {
+		return nil, fmt.Errorf("不支持的媒体类型: %s", file.ContentType)
+	}
+
+	contentURI, err := s.uploadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"msgtype": "m.image",
+		"body":    file.FileName,
+		"url":     contentURI,
+		"info": map[string]interface{}{
+			"mimetype": file.ContentType,
+			"size":     len(file.Data),
+		},
+	}, nil
+}
+
+// uploadFile 上传文件到 Homeserver 媒体仓库，返回 mxc:// 地址
+func (s *MatrixSink) uploadFile(file *downloadedFile) (string, error) {
+	endpoint := fmt.Sprintf("%s/_matrix/media/v3/upload?filename=%s",
+		s.homeserverURL,
+		url.QueryEscape(file.FileName),
+	)
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(file.Data))
+	if err != nil {
+		return "", fmt.Errorf("创建上传请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", file.ContentType)
+	for k, v := range s.authHeaders() {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("上传媒体失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("读取上传响应失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("上传媒体失败，状态码: %d, 响应: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		ContentURI string `json:"content_uri"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("解析上传响应失败: %w", err)
+	}
+	if result.ContentURI == "" {
+		return "", fmt.Errorf("上传响应缺少 content_uri: %s", string(body))
+	}
+	return result.ContentURI, nil
+}
+
+// authHeaders 返回认证请求头
+func (s *MatrixSink) authHeaders() map[string]string {
+	return map[string]string{"Authorization": "Bearer " + s.accessToken}
+}
@@ -0,0 +1,193 @@
+package sink
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// fakeHomeserver 记录收到的事件，同一事务ID只保存一次，与 Homeserver 的去重行为一致
+type fakeHomeserver struct {
+	mu      sync.Mutex
+	paths   []string
+	events  map[string]map[string]interface{}
+	uploads int
+	auth    []string
+}
+
+func newFakeHomeserver(t *testing.T) (*fakeHomeserver, *httptest.Server) {
+	t.Helper()
+	hs := &fakeHomeserver{events: make(map[string]map[string]interface{})}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_matrix/client/v3/rooms/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var content map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&content); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		hs.mu.Lock()
+		hs.paths = append(hs.paths, r.URL.EscapedPath())
+		hs.auth = append(hs.auth, r.Header.Get("Authorization"))
+		if _, ok := hs.events[r.URL.EscapedPath()]; !ok {
+			hs.events[r.URL.EscapedPath()] = content
+		}
+		hs.mu.Unlock()
+		w.Write([]byte(`{"event_id":"$1"}`))
+	})
+	mux.HandleFunc("/_matrix/media/v3/upload", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		hs.mu.Lock()
+		hs.uploads++
+		hs.mu.Unlock()
+		w.Write([]byte(`{"content_uri":"mxc://example.com/abc"}`))
+	})
+	mux.HandleFunc("/media/photo.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG\r\n\x1a\n"))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return hs, server
+}
+
+func newTestMatrixSink(t *testing.T, homeserver string, uploadMedia bool) Sink {
+	t.Helper()
+	s, err := newMatrixSink(&config.SinkConfig{
+		Name: "matrix-test",
+		Type: "matrix",
+		Matrix: &config.MatrixSinkConfig{
+			HomeserverURL: homeserver + "/",
+			AccessToken:   "syt_token",
+			RoomID:        "!room:example.com",
+			UploadMedia:   uploadMedia,
+		},
+	})
+	if err != nil {
+		t.Fatalf("newMatrixSink: %v", err)
+	}
+	return s
+}
+
+func testMessage() *models.Message {
+	msg := models.NewMessage("部署完成", models.Sender{Username: "alice"}, models.Chat{ID: -100123, Title: "运维群"})
+	msg.ID = 42
+	msg.MessageID = 42
+	msg.MessageType = models.MessageTypeText
+	return msg
+}
+
+func TestMatrixSendPath(t *testing.T) {
+	hs, server := newFakeHomeserver(t)
+	s := newTestMatrixSink(t, server.URL, false)
+
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	want := "/_matrix/client/v3/rooms/%21room:example.com/send/m.room.message/tgfwd_-100123_42_text"
+	if len(hs.paths) != 1 || hs.paths[0] != want {
+		t.Fatalf("paths = %v, want [%s]", hs.paths, want)
+	}
+	if hs.auth[0] != "Bearer syt_token" {
+		t.Errorf("Authorization = %q", hs.auth[0])
+	}
+	event := hs.events[want]
+	if event["msgtype"] != "m.text" {
+		t.Errorf("msgtype = %v", event["msgtype"])
+	}
+	if body, _ := event["body"].(string); !strings.Contains(body, "部署完成") || !strings.Contains(body, "运维群") {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestMatrixTxnIDIdempotent(t *testing.T) {
+	hs, server := newFakeHomeserver(t)
+	s := newTestMatrixSink(t, server.URL, false)
+
+	// 重试同一条消息使用相同的事务ID，Homeserver 只保存一次
+	msg := testMessage()
+	for i := 0; i < 2; i++ {
+		if err := s.Send(msg); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	if len(hs.paths) != 2 || hs.paths[0] != hs.paths[1] {
+		t.Fatalf("retries should reuse the txnID, paths = %v", hs.paths)
+	}
+	if len(hs.events) != 1 {
+		t.Errorf("events = %d, want 1", len(hs.events))
+	}
+
+	// 编辑后的消息是新的事件
+	msg.Edited = true
+	if err := s.Send(msg); err != nil {
+		t.Fatalf("Send edited: %v", err)
+	}
+	if len(hs.events) != 2 {
+		t.Errorf("edited message should use a new txnID, events = %d", len(hs.events))
+	}
+}
+
+func TestMatrixUploadsImages(t *testing.T) {
+	hs, server := newFakeHomeserver(t)
+	s := newTestMatrixSink(t, server.URL, true)
+
+	msg := testMessage()
+	msg.MessageType = models.MessageTypePhoto
+	msg.Attachments = []models.Attachment{{Kind: models.MessageTypePhoto, URL: server.URL + "/media/photo.png"}}
+	if err := s.Send(msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	if hs.uploads != 1 {
+		t.Fatalf("uploads = %d, want 1", hs.uploads)
+	}
+	event := hs.events["/_matrix/client/v3/rooms/%21room:example.com/send/m.room.message/tgfwd_-100123_42_image0"]
+	if event == nil {
+		t.Fatalf("m.image event not sent, paths = %v", hs.paths)
+	}
+	if event["msgtype"] != "m.image" || event["url"] != "mxc://example.com/abc" || event["body"] != "photo.png" {
+		t.Errorf("event = %v", event)
+	}
+}
+
+func TestMatrixRetriesWithSameTxnID(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		paths = append(paths, r.URL.EscapedPath())
+		if len(paths) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"errcode":"M_LIMIT_EXCEEDED"}`))
+			return
+		}
+		w.Write([]byte(`{"event_id":"$1"}`))
+	}))
+	defer server.Close()
+
+	s := newTestMatrixSink(t, server.URL, false)
+	// 限流时返回暂时失败，重试队列稍后重发同一条消息
+	if err := s.Send(testMessage()); !errors.Is(err, ErrTemporary) {
+		t.Fatalf("Send: %v, want ErrTemporary", err)
+	}
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if len(paths) != 2 || paths[0] != paths[1] {
+		t.Errorf("paths = %v, want the same path twice", paths)
+	}
+}
@@ -0,0 +1,138 @@
+package sink
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/sirupsen/logrus"
+	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// MQTT 默认配置
+const (
+	defaultMQTTTopic = "tgforward/{{.ChatID}}"
+	defaultMQTTQoS   = 1
+)
+
+// 注册 MQTT 投递目标
+func init() {
+	Register("mqtt", newMQTTSink)
+}
+
+// MQTTSink 将消息以 JSON 发布到 MQTT 主题的投递目标
+type MQTTSink struct {
+	name     string
+	client   mqtt.Client
+	topic    *nameTemplate
+	qos      byte
+	retained bool
+}
+
+// newMQTTSink 创建 MQTT 投递目标
+func newMQTTSink(cfg *config.SinkConfig) (Sink, error) {
+	mqttCfg := cfg.MQTT
+	if mqttCfg == nil || mqttCfg.Broker == "" {
+		return nil, fmt.Errorf("投递目标 %s 未配置 mqtt broker", cfg.Name)
+	}
+
+	topicText := mqttCfg.Topic
+	if topicText == "" {
+		topicText = defaultMQTTTopic
+	}
+	topic, err := newNameTemplate(topicText)
+	if err != nil {
+		return nil, err
+	}
+
+	qos := byte(defaultMQTTQoS)
+	if mqttCfg.QoS != nil {
+		qos = *mqttCfg.QoS
+	}
+	if qos > 2 {
+		return nil, fmt.Errorf("无效的 MQTT QoS: %d，支持 0、1、2", qos)
+	}
+
+	clientID := mqttCfg.ClientID
+	if clientID == "" {
+		hostname, _ := os.Hostname()
+		clientID = fmt.Sprintf("tgforward-%s-%d", hostname, os.Getpid())
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(mqttCfg.Broker).
+		SetClientID(clientID).
+		SetUsername(mqttCfg.Username).
+		SetPassword(mqttCfg.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectTimeout(requestTimeout).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			logrus.WithFields(logrus.Fields{
+				"sink":  cfg.Name,
+				"error": err,
+			}).Warn("MQTT 连接断开，正在重连")
+		})
+
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(requestTimeout) {
+		// SetConnectRetry 会在后台继续重连，这里只记录警告
+		logrus.WithField("sink", cfg.Name).Warn("连接 MQTT Broker 超时，将在后台重试")
+	} else if err := token.Error(); err != nil {
+		return nil, fmt.Errorf("连接 MQTT Broker 失败: %w", err)
+	}
+
+	return &MQTTSink{
+		name:     cfg.Name,
+		client:   client,
+		topic:    topic,
+		qos:      qos,
+		retained: mqttCfg.Retained,
+	}, nil
+}
+
+// Name 返回投递目标名称
+func (s *MQTTSink) Name() string {
+	return s.name
+}
+
+// Send 将消息发布到按聊天渲染的主题
+func (s *MQTTSink) Send(msg *models.Message) error {
+	topic, err := s.topic.render(msg)
+	if err != nil {
+		return err
+	}
+	// 主题中不允许出现通配符
+	topic = strings.NewReplacer("+", "_", "#", "_").Replace(topic)
+
+	payload, err := marshalEnvelope(msg)
+	if err != nil {
+		return fmt.Errorf("序列化消息失败: %w", err)
+	}
+
+	token := s.client.Publish(topic, s.qos, s.retained, payload)
+	if !token.WaitTimeout(requestTimeout) {
+		return fmt.Errorf("发布 MQTT 消息超时 (topic: %s)", topic)
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("发布 MQTT 消息失败 (topic: %s): %w", topic, err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"sink":       s.name,
+		"topic":      topic,
+		"qos":        s.qos,
+		"message_id": msg.ID,
+	}).Debug("MQTT 消息发布成功")
+	return nil
+}
+
+// Close 断开 MQTT 连接
+func (s *MQTTSink) Close() error {
+	// 等待最多 1 秒让未完成的发布完成
+	s.client.Disconnect(1000)
+	return nil
+}
@@ -0,0 +1,153 @@
+package sink
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// startBroker 启动内嵌的 MQTT Broker，返回订阅收到的消息
+func startBroker(t *testing.T) (string, <-chan packets.Packet) {
+	t.Helper()
+	server := mochi.New(&mochi.Options{
+		InlineClient: true,
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatalf("AddHook: %v", err)
+	}
+	tcp := listeners.NewTCP(listeners.Config{ID: "test", Address: "127.0.0.1:0"})
+	if err := server.AddListener(tcp); err != nil {
+		t.Fatalf("AddListener: %v", err)
+	}
+	if err := server.Serve(); err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	received := make(chan packets.Packet, 10)
+	err := server.Subscribe("#", 1, func(cl *mochi.Client, sub packets.Subscription, pk packets.Packet) {
+		received <- pk
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	return "tcp://" + tcp.Address(), received
+}
+
+func newTestMQTTSink(t *testing.T, broker, topic string, qos byte, retained bool) Sink {
+	t.Helper()
+	s, err := Create(&config.SinkConfig{
+		Name: "mqtt-test",
+		Type: "mqtt",
+		MQTT: &config.MQTTSinkConfig{Broker: broker, ClientID: t.Name(), Topic: topic, QoS: &qos, Retained: retained},
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func receive(t *testing.T, received <-chan packets.Packet) packets.Packet {
+	t.Helper()
+	select {
+	case pk := <-received:
+		return pk
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for MQTT message")
+		return packets.Packet{}
+	}
+}
+
+func TestMQTTPublish(t *testing.T) {
+	broker, received := startBroker(t)
+	s := newTestMQTTSink(t, broker, "tg/{{.ChatType}}/{{.ChatID}}/{{.MessageType}}", 2, true)
+
+	msg := testMessage()
+	msg.Chat.Type = "supergroup"
+	msg.Attempts = 3
+	msg.LastAttempt = time.Now()
+	msg.Attachments = []models.Attachment{{Kind: models.MessageTypePhoto, URL: "https://s3.example.com/a.jpg", Object: "media/a.jpg"}}
+	if err := s.Send(msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	pk := receive(t, received)
+	if pk.TopicName != "tg/supergroup/-100123/text" {
+		t.Errorf("topic = %q", pk.TopicName)
+	}
+	if pk.FixedHeader.Qos != 2 || !pk.FixedHeader.Retain {
+		t.Errorf("qos = %d, retain = %v, want 2 and true", pk.FixedHeader.Qos, pk.FixedHeader.Retain)
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(pk.Payload, &payload); err != nil {
+		t.Fatalf("payload is not JSON: %v", err)
+	}
+	if payload["version"] != float64(EnvelopeVersion) || payload["text"] != "部署完成" || payload["id"] != float64(msg.ID) {
+		t.Errorf("payload = %s", pk.Payload)
+	}
+	for _, field := range []string{"attempts", "last_attempt"} {
+		if _, ok := payload[field]; ok {
+			t.Errorf("payload should not contain %s: %s", field, pk.Payload)
+		}
+	}
+	attachment := payload["attachments"].([]interface{})[0].(map[string]interface{})
+	if _, ok := attachment["object"]; ok {
+		t.Errorf("attachment should not contain the S3 object key: %v", attachment)
+	}
+}
+
+func TestMQTTDefaultsAndWildcards(t *testing.T) {
+	broker, received := startBroker(t)
+	s, err := Create(&config.SinkConfig{
+		Name: "mqtt-default",
+		Type: "mqtt",
+		MQTT: &config.MQTTSinkConfig{Broker: broker},
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer s.Close()
+
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	pk := receive(t, received)
+	if pk.TopicName != "tgforward/-100123" || pk.FixedHeader.Qos != defaultMQTTQoS || pk.FixedHeader.Retain {
+		t.Errorf("topic = %q, qos = %d, retain = %v", pk.TopicName, pk.FixedHeader.Qos, pk.FixedHeader.Retain)
+	}
+
+	// 聊天标题中的通配符不能出现在主题中
+	s2 := newTestMQTTSink(t, broker, "chat/{{.ChatTitle}}", 0, false)
+	msg := testMessage()
+	msg.Chat.Title = "a+b#c"
+	if err := s2.Send(msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if pk := receive(t, received); pk.TopicName != "chat/a_b_c" {
+		t.Errorf("topic = %q, want chat/a_b_c", pk.TopicName)
+	}
+}
+
+func TestMQTTInvalidQoS(t *testing.T) {
+	qos := byte(3)
+	_, err := Create(&config.SinkConfig{
+		Name: "mqtt-invalid",
+		Type: "mqtt",
+		MQTT: &config.MQTTSinkConfig{Broker: "tcp://127.0.0.1:1", QoS: &qos},
+	})
+	if err == nil {
+		t.Fatal("expected error for qos 3")
+	}
+}
@@ -0,0 +1,89 @@
+package sink
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// 默认脱敏替换文本
+const (
+	defaultReplacement = "***"
+	anonymousSender    = "匿名用户"
+)
+
+// redactor 按路由规则对消息进行脱敏
+type redactor struct {
+	hideSender  bool
+	patterns    []*regexp.Regexp
+	replacement string
+}
+
+// newRedactor 根据配置创建脱敏器，未配置时返回 nil
+func newRedactor(cfg *config.RedactConfig) (*redactor, error) {
+	if cfg == nil || (!cfg.HideSender && len(cfg.Patterns) == 0) {
+		return nil, nil
+	}
+
+	r := &redactor{
+		hideSender:  cfg.HideSender,
+		replacement: cfg.Replacement,
+	}
+	if r.replacement == "" {
+		r.replacement = defaultReplacement
+	}
+
+	for _, pattern := range cfg.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("编译脱敏规则 %q 失败: %w", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+
+	return r, nil
+}
+
+// apply 返回脱敏后的消息副本，原消息保持不变
+func (r *redactor) apply(msg *models.Message) *models.Message {
+	if r == nil {
+		return msg
+	}
+
+	redacted := *msg
+	text := msg.Text
+	var replyText string
+	if msg.ReplyTo != nil {
+		reply := *msg.ReplyTo
+		redacted.ReplyTo = &reply
+		replyText = reply.Text
+	}
+
+	if r.hideSender {
+		for _, name := range []string{msg.Sender.Name(), msg.Sender.DisplayName} {
+			if name == "" {
+				continue
+			}
+			text = strings.ReplaceAll(text, name, anonymousSender)
+			replyText = strings.ReplaceAll(replyText, name, anonymousSender)
+		}
+		redacted.Sender = models.Sender{DisplayName: anonymousSender}
+	}
+
+	for _, re := range r.patterns {
+		text = re.ReplaceAllString(text, r.replacement)
+		replyText = re.ReplaceAllString(replyText, r.replacement)
+	}
+
+	redacted.Text = text
+	if redacted.ReplyTo != nil {
+		redacted.ReplyTo.Text = replyText
+	}
+	// 替换后原有实体的偏移量已失效
+	redacted.Entities = nil
+	redacted.Redacted = true
+	return &redacted
+}
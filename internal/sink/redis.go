@@ -0,0 +1,125 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// Redis 默认配置
+const defaultRedisStream = "tgforward:messages"
+
+// 注册 Redis Stream 投递目标
+func init() {
+	Register("redis", newRedisSink)
+}
+
+// RedisSink 将消息以 JSON 追加到 Redis Stream 的投递目标
+type RedisSink struct {
+	name   string
+	client *redis.Client
+	stream *nameTemplate
+	maxLen int64
+	approx bool
+}
+
+// newRedisSink 创建 Redis Stream 投递目标
+func newRedisSink(cfg *config.SinkConfig) (Sink, error) {
+	redisCfg := cfg.Redis
+	if redisCfg == nil || redisCfg.Addr == "" {
+		return nil, fmt.Errorf("投递目标 %s 未配置 redis addr", cfg.Name)
+	}
+	if redisCfg.MaxLen < 0 {
+		return nil, fmt.Errorf("无效的 Redis Stream max_len: %d", redisCfg.MaxLen)
+	}
+
+	streamText := redisCfg.Stream
+	if streamText == "" {
+		streamText = defaultRedisStream
+	}
+	stream, err := newNameTemplate(streamText)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:         redisCfg.Addr,
+		Password:     redisCfg.Password,
+		DB:           redisCfg.DB,
+		DialTimeout:  requestTimeout,
+		ReadTimeout:  requestTimeout,
+		WriteTimeout: requestTimeout,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		// 连接失败不阻止启动，发送时会自动重连
+		logrus.WithFields(logrus.Fields{
+			"sink":  cfg.Name,
+			"addr":  redisCfg.Addr,
+			"error": err,
+		}).Warn("连接 Redis 失败，将在发送时重试")
+	}
+
+	return &RedisSink{
+		name:   cfg.Name,
+		client: client,
+		stream: stream,
+		maxLen: redisCfg.MaxLen,
+		approx: redisCfg.ApproxMaxLen,
+	}, nil
+}
+
+// Name 返回投递目标名称
+func (s *RedisSink) Name() string {
+	return s.name
+}
+
+// Send 使用 XADD 将消息追加到 Stream
+func (s *RedisSink) Send(msg *models.Message) error {
+	stream, err := s.stream.render(msg)
+	if err != nil {
+		return err
+	}
+
+	payload, err := marshalEnvelope(msg)
+	if err != nil {
+		return fmt.Errorf("序列化消息失败: %w", err)
+	}
+
+	args := &redis.XAddArgs{
+		Stream: stream,
+		MaxLen: s.maxLen,
+		Approx: s.approx,
+		Values: map[string]interface{}{
+			"chat_id": strconv.FormatInt(msg.Chat.ID, 10),
+			"data":    payload,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	id, err := s.client.XAdd(ctx, args).Result()
+	if err != nil {
+		return fmt.Errorf("写入 Redis Stream 失败 (stream: %s): %w", stream, err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"sink":       s.name,
+		"stream":     stream,
+		"entry_id":   id,
+		"message_id": msg.ID,
+	}).Debug("Redis Stream 写入成功")
+	return nil
+}
+
+// Close 关闭 Redis 连接
+func (s *RedisSink) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,121 @@
+package sink
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/user/tg-forward-to-xx/internal/config"
+)
+
+func newTestRedisSink(t *testing.T, cfg *config.RedisSinkConfig) (*miniredis.Miniredis, Sink) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	cfg.Addr = mr.Addr()
+	s, err := Create(&config.SinkConfig{Name: "redis-test", Type: "redis", Redis: cfg})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return mr, s
+}
+
+// entryValues 将 Stream 条目的字段列表转换为 map
+func entryValues(entry miniredis.StreamEntry) map[string]string {
+	values := make(map[string]string)
+	for i := 0; i+1 < len(entry.Values); i += 2 {
+		values[entry.Values[i]] = entry.Values[i+1]
+	}
+	return values
+}
+
+func TestRedisXAdd(t *testing.T) {
+	mr, s := newTestRedisSink(t, &config.RedisSinkConfig{Stream: "tg:{{.ChatID}}:{{.MessageType}}"})
+
+	msg := testMessage()
+	msg.Attempts = 2
+	msg.LastAttempt = time.Now()
+	if err := s.Send(msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	entries, err := mr.Stream("tg:-100123:text")
+	if err != nil {
+		t.Fatalf("Stream: %v (keys: %v)", err, mr.Keys())
+	}
+	if len(entries) != 1 {
+		t.Fatalf("entries = %d, want 1", len(entries))
+	}
+	values := entryValues(entries[0])
+	if values["chat_id"] != "-100123" {
+		t.Errorf("chat_id = %q", values["chat_id"])
+	}
+
+	var env Envelope
+	if err := json.Unmarshal([]byte(values["data"]), &env); err != nil {
+		t.Fatalf("data is not JSON: %v", err)
+	}
+	if env.Version != EnvelopeVersion || env.ID != msg.ID || env.Chat.Title != "运维群" || env.Sender.Username != "alice" {
+		t.Errorf("envelope = %+v", env)
+	}
+	var raw map[string]interface{}
+	json.Unmarshal([]byte(values["data"]), &raw)
+	if _, ok := raw["attempts"]; ok {
+		t.Errorf("data should not contain attempts: %s", values["data"])
+	}
+}
+
+func TestRedisDefaultStream(t *testing.T) {
+	mr, s := newTestRedisSink(t, &config.RedisSinkConfig{})
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	entries, err := mr.Stream(defaultRedisStream)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("entries = %v, err = %v", entries, err)
+	}
+}
+
+func TestRedisMaxLen(t *testing.T) {
+	mr, s := newTestRedisSink(t, &config.RedisSinkConfig{Stream: "capped", MaxLen: 3})
+
+	var ids []int64
+	for i := 0; i < 5; i++ {
+		msg := testMessage()
+		msg.ID = int64(i + 1)
+		ids = append(ids, msg.ID)
+		if err := s.Send(msg); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+
+	entries, err := mr.Stream("capped")
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("entries = %d, want 3", len(entries))
+	}
+	// 裁剪时保留最新的条目
+	for i, entry := range entries {
+		var env Envelope
+		if err := json.Unmarshal([]byte(entryValues(entry)["data"]), &env); err != nil {
+			t.Fatalf("data is not JSON: %v", err)
+		}
+		if want := ids[i+2]; env.ID != want {
+			t.Errorf("entry %d id = %d, want %d", i, env.ID, want)
+		}
+	}
+}
+
+func TestRedisInvalidMaxLen(t *testing.T) {
+	_, err := Create(&config.SinkConfig{
+		Name:  "redis-invalid",
+		Type:  "redis",
+		Redis: &config.RedisSinkConfig{Addr: "127.0.0.1:1", MaxLen: -1},
+	})
+	if err == nil {
+		t.Fatal("expected error for negative max_len")
+	}
+}
@@ -0,0 +1,237 @@
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/sirupsen/logrus"
+	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// route 编译后的转发路由
+type route struct {
+	name     string
+	chatIDs  map[int64]bool
+	sinks    []Sink
+	redactor *redactor
+}
+
+// matches 判断路由是否匹配指定的源聊天
+func (r *route) matches(chatID int64) bool {
+	if len(r.chatIDs) == 0 {
+		return true
+	}
+	return r.chatIDs[chatID]
+}
+
+// Router 按路由规则将消息分发到投递目标
+type Router struct {
+	sinks  map[string]Sink
+	routes []*route
+}
+
+// NewRouter 根据配置创建路由器
+// 未配置任何路由时，所有启用的投递目标都会收到全部消息
+func NewRouter(sinkConfigs []*config.SinkConfig, routeConfigs []*config.RouteConfig) (*Router, error) {
+	router := &Router{
+		sinks: make(map[string]Sink),
+	}
+
+	var order []Sink
+	for _, cfg := range sinkConfigs {
+		if cfg == nil || !cfg.Enabled {
+			continue
+		}
+		if _, exists := router.sinks[cfg.Name]; exists {
+			router.Close()
+			return nil, fmt.Errorf("投递目标名称重复: %s", cfg.Name)
+		}
+
+		s, err := Create(cfg)
+		if err != nil {
+			router.Close()
+			return nil, fmt.Errorf("创建投递目标 %s 失败: %w", cfg.Name, err)
+		}
+		router.sinks[cfg.Name] = s
+		order = append(order, s)
+
+		logrus.WithFields(logrus.Fields{
+			"name": cfg.Name,
+			"type": cfg.Type,
+		}).Info("投递目标已创建")
+	}
+
+	if len(routeConfigs) == 0 {
+		if len(order) > 0 {
+			router.routes = append(router.routes, &route{name: "default", sinks: order})
+		}
+		return router, nil
+	}
+
+	for _, cfg := range routeConfigs {
+		if cfg == nil {
+			continue
+		}
+
+		r := &route{
+			name:    cfg.Name,
+			chatIDs: make(map[int64]bool),
+		}
+		for _, id := range cfg.ChatIDs {
+			r.chatIDs[id] = true
+		}
+
+		for _, name := range cfg.Sinks {
+			s, ok := router.sinks[name]
+			if !ok {
+				router.Close()
+				return nil, fmt.Errorf("路由 %s: %w: %s", cfg.Name, ErrSinkNotFound, name)
+			}
+			r.sinks = append(r.sinks, s)
+		}
+
+		redactor, err := newRedactor(cfg.Redact)
+		if err != nil {
+			router.Close()
+			return nil, fmt.Errorf("路由 %s 脱敏配置无效: %w", cfg.Name, err)
+		}
+		r.redactor = redactor
+
+		router.routes = append(router.routes, r)
+	}
+
+	return router, nil
+}
+
+// DispatchError 部分投递目标失败时 Dispatch 返回的错误
+type DispatchError struct {
+	Retry []string // 暂时失败、需要稍后重发的投递目标，格式为 DispatchTarget 返回的名称
+	Err   error    // 最后一个错误
+}
+
+func (e *DispatchError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DispatchError) Unwrap() error {
+	return e.Err
+}
+
+// DispatchTarget 返回路由中一个投递目标的名称，同一投递目标可以出现在多个路由中，重发时只发送失败的那一份
+func DispatchTarget(routeName, sinkName string) string {
+	return routeName + "/" + sinkName
+}
+
+// Dispatch 将消息投递到所有匹配路由的投递目标，only 不为空时只投递到其中列出的目标（DispatchTarget 名称）
+// 单个投递目标失败不会影响其他目标；有目标失败时返回 *DispatchError，其中列出返回 ErrTemporary 的目标
+func (r *Router) Dispatch(msg *models.Message, only ...string) error {
+	var lastErr error
+	var retry []string
+	for _, rt := range r.routes {
+		if !rt.matches(msg.Chat.ID) {
+			continue
+		}
+
+		var out *models.Message
+		for _, s := range rt.sinks {
+			target := DispatchTarget(rt.name, s.Name())
+			if len(only) > 0 && !slices.Contains(only, target) {
+				continue
+			}
+			if out == nil {
+				out = rt.redactor.apply(msg)
+			}
+			if err := s.Send(out); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"route":      rt.name,
+					"sink":       s.Name(),
+					"message_id": msg.ID,
+					"error":      err,
+				}).Error("投递消息失败")
+				lastErr = err
+				if errors.Is(err, ErrTemporary) {
+					retry = append(retry, target)
+				}
+			}
+		}
+	}
+	if lastErr != nil {
+		return &DispatchError{Retry: retry, Err: lastErr}
+	}
+	return nil
+}
+
+// DispatchEdit 将源消息的编辑同步到支持编辑的投递目标
+func (r *Router) DispatchEdit(msg *models.Message) error {
+	var lastErr error
+	for _, rt := range r.routes {
+		if !rt.matches(msg.Chat.ID) {
+			continue
+		}
+
+		out := rt.redactor.apply(msg)
+		for _, s := range rt.sinks {
+			editor, ok := s.(Editor)
+			if !ok {
+				continue
+			}
+			if err := editor.Edit(out); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"route":      rt.name,
+					"sink":       s.Name(),
+					"message_id": msg.MessageID,
+					"error":      err,
+				}).Error("同步编辑消息失败")
+				lastErr = err
+			}
+		}
+	}
+	return lastErr
+}
+
+// DispatchDelete 将源消息的删除同步到支持删除的投递目标
+func (r *Router) DispatchDelete(chatID int64, messageID int) error {
+	var lastErr error
+	for _, rt := range r.routes {
+		if !rt.matches(chatID) {
+			continue
+		}
+
+		for _, s := range rt.sinks {
+			editor, ok := s.(Editor)
+			if !ok {
+				continue
+			}
+			if err := editor.Delete(chatID, messageID); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"route":      rt.name,
+					"sink":       s.Name(),
+					"message_id": messageID,
+					"error":      err,
+				}).Error("同步删除消息失败")
+				lastErr = err
+			}
+		}
+	}
+	return lastErr
+}
+
+// Sink 按名称获取投递目标
+func (r *Router) Sink(name string) (Sink, bool) {
+	s, ok := r.sinks[name]
+	return s, ok
+}
+
+// Close 关闭所有投递目标
+func (r *Router) Close() error {
+	var lastErr error
+	for name, s := range r.sinks {
+		if err := s.Close(); err != nil {
+			logrus.Errorf("关闭投递目标 %s 失败: %v", name, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
@@ -0,0 +1,54 @@
+package sink
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// fakeSink 记录收到的消息，err 不为空时投递失败
+type fakeSink struct {
+	name string
+	err  error
+	sent int
+}
+
+func (s *fakeSink) Name() string { return s.name }
+
+func (s *fakeSink) Send(msg *models.Message) error {
+	s.sent++
+	return s.err
+}
+
+func (s *fakeSink) Close() error { return nil }
+
+func TestDispatchRetriesOnlyTemporaryFailures(t *testing.T) {
+	ok := &fakeSink{name: "ok"}
+	busy := &fakeSink{name: "busy", err: ErrTemporary}
+	broken := &fakeSink{name: "broken", err: errors.New("配置错误")}
+	router := &Router{routes: []*route{
+		{name: "main", sinks: []Sink{ok, busy, broken}},
+		{name: "audit", sinks: []Sink{busy}},
+	}}
+
+	err := router.Dispatch(testMessage())
+	var dispatchErr *DispatchError
+	if !errors.As(err, &dispatchErr) {
+		t.Fatalf("Dispatch: %v, want *DispatchError", err)
+	}
+	want := []string{"main/busy", "audit/busy"}
+	if !slices.Equal(dispatchErr.Retry, want) {
+		t.Errorf("Retry = %v, want %v", dispatchErr.Retry, want)
+	}
+
+	// 重发时只投递到失败的目标
+	busy.err = nil
+	if err := router.Dispatch(testMessage(), "audit/busy"); err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+	if ok.sent != 1 || broken.sent != 1 || busy.sent != 3 {
+		t.Errorf("sent ok=%d broken=%d busy=%d, want 1, 1, 3", ok.sent, broken.sent, busy.sent)
+	}
+}
@@ -0,0 +1,58 @@
+package sink
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// 投递目标相关错误
+var (
+	ErrUnsupportedSinkType = errors.New("不支持的投递目标类型")
+	ErrSinkNotFound        = errors.New("投递目标未找到")
+	// ErrTemporary 投递暂时失败（连接失败、限流或服务端错误），可以稍后重试
+	ErrTemporary = errors.New("投递暂时失败")
+)
+
+// Sink 定义消息投递目标接口
+type Sink interface {
+	// Name 返回投递目标名称
+	Name() string
+
+	// Send 投递一条消息
+	Send(msg *models.Message) error
+
+	// Close 释放投递目标占用的资源
+	Close() error
+}
+
+// Editor 支持同步编辑和删除的投递目标
+type Editor interface {
+	// Edit 将源消息的编辑同步到已投递的消息
+	Edit(msg *models.Message) error
+
+	// Delete 删除源消息对应的已投递消息
+	Delete(chatID int64, messageID int) error
+}
+
+// Factory 创建投递目标的工厂函数类型
+type Factory func(cfg *config.SinkConfig) (Sink, error)
+
+// 注册的投递目标工厂
+var sinkFactories = make(map[string]Factory)
+
+// Register 注册投递目标工厂
+func Register(sinkType string, factory Factory) {
+	sinkFactories[sinkType] = factory
+}
+
+// Create 根据配置创建投递目标
+func Create(cfg *config.SinkConfig) (Sink, error) {
+	factory, ok := sinkFactories[cfg.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedSinkType, cfg.Type)
+	}
+	return factory(cfg)
+}
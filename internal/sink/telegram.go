@@ -0,0 +1,302 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// Telegram 转发方式
+const (
+	TelegramModeForward = "forward" // 使用 forwardMessage 原样转发，保留来源
+	TelegramModeCopy    = "copy"    // 使用 copyMessage 复制，不显示来源
+	TelegramModeRender  = "render"  // 按转发格式重新渲染后发送
+)
+
+// 注册 Telegram 投递目标
+func init() {
+	Register("telegram", newTelegramSink)
+}
+
+// TelegramSink 转发到另一个 Telegram 聊天的投递目标
+type TelegramSink struct {
+	name                string
+	bot                 *tgbotapi.BotAPI
+	chatID              int64
+	mode                string
+	native              bool // 是否与监听机器人为同一个 Bot，只有同一个 Bot 才能 forward/copy 源消息
+	disableNotification bool
+	ids                 *messageIDMap
+}
+
+// newTelegramSink 创建 Telegram 投递目标
+func newTelegramSink(cfg *config.SinkConfig) (Sink, error) {
+	tgCfg := cfg.Telegram
+	if tgCfg == nil {
+		return nil, fmt.Errorf("投递目标 %s 缺少 telegram 配置", cfg.Name)
+	}
+	if tgCfg.ChatID == 0 {
+		return nil, fmt.Errorf("投递目标 %s 未配置 chat_id", cfg.Name)
+	}
+
+	mode := tgCfg.Mode
+	if mode == "" {
+		mode = TelegramModeCopy
+	}
+	if mode != TelegramModeForward && mode != TelegramModeCopy && mode != TelegramModeRender {
+		return nil, fmt.Errorf("不支持的 Telegram 转发方式: %s，支持的方式: forward, copy, render", mode)
+	}
+
+	appCfg := config.Current()
+	token := tgCfg.Token
+	native := token == "" || token == appCfg.Telegram.Token
+	if token == "" {
+		token = appCfg.Telegram.Token
+	}
+	if !native && mode != TelegramModeRender {
+		logrus.WithFields(logrus.Fields{
+			"sink": cfg.Name,
+			"mode": mode,
+		}).Warn("投递目标使用独立的 Bot Token，无法访问源消息，将使用 render 方式发送")
+		mode = TelegramModeRender
+	}
+
+	bot, err := tgbotapi.NewBotAPI(token)
+	if err != nil {
+		return nil, fmt.Errorf("创建 Telegram 客户端失败: %w", err)
+	}
+
+	ids, err := openMessageIDMap(filepath.Join(appCfg.Queue.Path, "telegram_sink", cfg.Name))
+	if err != nil {
+		return nil, err
+	}
+
+	return &TelegramSink{
+		name:                cfg.Name,
+		bot:                 bot,
+		chatID:              tgCfg.ChatID,
+		mode:                mode,
+		native:              native,
+		disableNotification: tgCfg.DisableNotification,
+		ids:                 ids,
+	}, nil
+}
+
+// Name 返回投递目标名称
+func (s *TelegramSink) Name() string {
+	return s.name
+}
+
+// Send 投递一条消息到目标 Telegram 聊天
+func (s *TelegramSink) Send(msg *models.Message) error {
+	mode := s.effectiveMode(msg)
+
+	var (
+		sentID int
+		err    error
+	)
+	switch mode {
+	case TelegramModeForward:
+		forward := tgbotapi.NewForward(s.chatID, msg.Chat.ID, msg.MessageID)
+		forward.DisableNotification = s.disableNotification
+		var sent tgbotapi.Message
+		sent, err = s.bot.Send(forward)
+		sentID = sent.MessageID
+	case TelegramModeCopy:
+		copyCfg := tgbotapi.NewCopyMessage(s.chatID, msg.Chat.ID, msg.MessageID)
+		copyCfg.DisableNotification = s.disableNotification
+		var sent tgbotapi.MessageID
+		sent, err = s.bot.CopyMessage(copyCfg)
+		sentID = sent.MessageID
+	default:
+		var sent tgbotapi.Message
+		sent, err = s.bot.Send(s.renderMessage(msg))
+		sentID = sent.MessageID
+	}
+	if err != nil {
+		return fmt.Errorf("发送 Telegram 消息失败 (mode: %s): %w", mode, err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"sink":           s.name,
+		"mode":           mode,
+		"source_chat_id": msg.Chat.ID,
+		"source_msg_id":  msg.MessageID,
+		"target_chat_id": s.chatID,
+		"target_msg_id":  sentID,
+	}).Debug("Telegram 消息投递成功")
+
+	if msg.MessageID == 0 {
+		return nil
+	}
+	if err := s.ids.put(msg.Chat.ID, msg.MessageID, &mappedMessage{MessageID: sentID, Mode: mode}); err != nil {
+		logrus.WithError(err).Warn("保存 Telegram 消息ID映射失败，后续编辑和删除将无法同步")
+	}
+	return nil
+}
+
+// Edit 将源消息的编辑同步到已投递的消息
+// render 方式直接编辑文本；forward/copy 方式无法修改，删除后重新投递
+func (s *TelegramSink) Edit(msg *models.Message) error {
+	mapped, err := s.ids.get(msg.Chat.ID, msg.MessageID)
+	if err != nil {
+		return err
+	}
+	if mapped == nil {
+		logrus.WithFields(logrus.Fields{
+			"sink":          s.name,
+			"source_msg_id": msg.MessageID,
+		}).Debug("未找到已投递的消息，忽略编辑")
+		return nil
+	}
+
+	if mapped.Mode == TelegramModeRender {
+		edit := tgbotapi.NewEditMessageText(s.chatID, mapped.MessageID, renderHTML(msg))
+		edit.ParseMode = tgbotapi.ModeHTML
+		if _, err := s.bot.Request(edit); err != nil {
+			if strings.Contains(err.Error(), "message is not modified") {
+				return nil
+			}
+			return fmt.Errorf("编辑 Telegram 消息失败: %w", err)
+		}
+		return nil
+	}
+
+	if _, err := s.bot.Request(tgbotapi.NewDeleteMessage(s.chatID, mapped.MessageID)); err != nil {
+		logrus.WithError(err).Warn("删除旧的 Telegram 消息失败，继续重新投递")
+	}
+	return s.Send(msg)
+}
+
+// Delete 删除源消息对应的已投递消息
+func (s *TelegramSink) Delete(chatID int64, messageID int) error {
+	mapped, err := s.ids.get(chatID, messageID)
+	if err != nil {
+		return err
+	}
+	if mapped == nil {
+		return nil
+	}
+
+	if _, err := s.bot.Request(tgbotapi.NewDeleteMessage(s.chatID, mapped.MessageID)); err != nil {
+		return fmt.Errorf("删除 Telegram 消息失败: %w", err)
+	}
+	return s.ids.delete(chatID, messageID)
+}
+
+// Close 关闭投递目标
+func (s *TelegramSink) Close() error {
+	return s.ids.close()
+}
+
+// effectiveMode 计算消息实际使用的转发方式
+// 脱敏后的消息或缺少源消息ID时只能重新渲染，避免泄露原始内容；
+// 相册由多条源消息聚合而成，转发单条会丢失其余图片，同样重新渲染
+func (s *TelegramSink) effectiveMode(msg *models.Message) string {
+	if !s.native || msg.Redacted || msg.MessageID == 0 || msg.MessageType == models.MessageTypeAlbum {
+		return TelegramModeRender
+	}
+	return s.mode
+}
+
+// renderMessage 构建重新渲染后的消息
+func (s *TelegramSink) renderMessage(msg *models.Message) tgbotapi.MessageConfig {
+	out := tgbotapi.NewMessage(s.chatID, renderHTML(msg))
+	out.ParseMode = tgbotapi.ModeHTML
+	out.DisableNotification = s.disableNotification
+	return out
+}
+
+// renderHTML 将消息渲染为 Telegram HTML，正文全部转义，附件以链接附在末尾
+func renderHTML(msg *models.Message) string {
+	var b strings.Builder
+	b.WriteString(html.EscapeString(msg.PlainText()))
+	for _, a := range msg.Attachments {
+		if a.URL == "" {
+			continue
+		}
+		name := a.FileName
+		switch {
+		case a.IsImage():
+			name = "查看图片"
+		case name == "":
+			name = "查看文件"
+		}
+		fmt.Fprintf(&b, "\n<a href=\"%s\">%s</a>", html.EscapeString(a.URL), html.EscapeString(name))
+	}
+	return b.String()
+}
+
+// mappedMessage 已投递消息的映射记录
+type mappedMessage struct {
+	MessageID int    `json:"message_id"` // 目标聊天中的消息ID
+	Mode      string `json:"mode"`       // 投递时使用的转发方式
+}
+
+// messageIDMap 源消息ID到目标消息ID的持久化映射
+type messageIDMap struct {
+	db *leveldb.DB
+}
+
+// openMessageIDMap 打开消息ID映射数据库
+func openMessageIDMap(path string) (*messageIDMap, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, fmt.Errorf("创建消息映射目录失败: %w", err)
+	}
+
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("打开消息映射数据库失败: %w", err)
+	}
+	return &messageIDMap{db: db}, nil
+}
+
+// mapKey 生成映射键
+func mapKey(chatID int64, messageID int) []byte {
+	return []byte(fmt.Sprintf("%d:%d", chatID, messageID))
+}
+
+// put 保存映射
+func (m *messageIDMap) put(chatID int64, messageID int, mapped *mappedMessage) error {
+	value, err := json.Marshal(mapped)
+	if err != nil {
+		return fmt.Errorf("序列化消息映射失败: %w", err)
+	}
+	return m.db.Put(mapKey(chatID, messageID), value, nil)
+}
+
+// get 查询映射，不存在时返回 nil
+func (m *messageIDMap) get(chatID int64, messageID int) (*mappedMessage, error) {
+	value, err := m.db.Get(mapKey(chatID, messageID), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询消息映射失败: %w", err)
+	}
+
+	var mapped mappedMessage
+	if err := json.Unmarshal(value, &mapped); err != nil {
+		return nil, fmt.Errorf("解析消息映射失败: %w", err)
+	}
+	return &mapped, nil
+}
+
+// delete 删除映射
+func (m *messageIDMap) delete(chatID int64, messageID int) error {
+	return m.db.Delete(mapKey(chatID, messageID), nil)
+}
+
+// close 关闭映射数据库
+func (m *messageIDMap) close() error {
+	return m.db.Close()
+}
@@ -0,0 +1,48 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// nameTemplate 按消息渲染主题或 Stream 名称的模板
+type nameTemplate struct {
+	tpl *template.Template
+}
+
+// templateData 名称模板可使用的字段
+type templateData struct {
+	ChatID      int64  // 聊天ID
+	ChatTitle   string // 聊天标题
+	ChatType    string // 聊天类型
+	SenderID    int64  // 发送者ID
+	MessageType string // 消息类型
+}
+
+// newNameTemplate 解析名称模板，可使用 {{.ChatID}}、{{.ChatTitle}} 等字段
+func newNameTemplate(text string) (*nameTemplate, error) {
+	tpl, err := template.New("name").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("解析名称模板 %q 失败: %w", text, err)
+	}
+	return &nameTemplate{tpl: tpl}, nil
+}
+
+// render 渲染名称
+func (t *nameTemplate) render(msg *models.Message) (string, error) {
+	var buf bytes.Buffer
+	data := &templateData{
+		ChatID:      msg.Chat.ID,
+		ChatTitle:   msg.Chat.Title,
+		ChatType:    msg.Chat.Type,
+		SenderID:    msg.Sender.ID,
+		MessageType: msg.MessageType,
+	}
+	if err := t.tpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("渲染名称模板失败: %w", err)
+	}
+	return buf.String(), nil
+}
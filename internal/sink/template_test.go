@@ -0,0 +1,48 @@
+package sink
+
+import (
+	"testing"
+
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+func TestNameTemplateRender(t *testing.T) {
+	msg := models.NewMessage("hi", models.Sender{ID: 7, Username: "alice"}, models.Chat{ID: -100123, Title: "运维群", Type: "supergroup"})
+	msg.MessageType = models.MessageTypePhoto
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"tgforward/{{.ChatID}}", "tgforward/-100123"},
+		{"tg:{{.ChatType}}:{{.MessageType}}", "tg:supergroup:photo"},
+		{"{{.ChatTitle}}/{{.SenderID}}", "运维群/7"},
+		{"static", "static"},
+	}
+	for _, tt := range tests {
+		tpl, err := newNameTemplate(tt.text)
+		if err != nil {
+			t.Fatalf("newNameTemplate(%q): %v", tt.text, err)
+		}
+		got, err := tpl.render(msg)
+		if err != nil {
+			t.Fatalf("render(%q): %v", tt.text, err)
+		}
+		if got != tt.want {
+			t.Errorf("render(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestNameTemplateErrors(t *testing.T) {
+	if _, err := newNameTemplate("{{.ChatID"); err == nil {
+		t.Error("expected parse error")
+	}
+	tpl, err := newNameTemplate("{{.Unknown}}")
+	if err != nil {
+		t.Fatalf("newNameTemplate: %v", err)
+	}
+	if _, err := tpl.render(models.NewMessage("hi", models.Sender{}, models.Chat{})); err == nil {
+		t.Error("expected render error for unknown field")
+	}
+}
@@ -0,0 +1,151 @@
+package sink
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// SignatureHeader 配置 secret 后携带请求体签名的请求头，值为 sha256=<十六进制 HMAC-SHA256>
+const SignatureHeader = "X-Tgforward-Signature"
+
+// 注册传入 Webhook 类投递目标
+func init() {
+	Register("mattermost", newMattermostSink)
+	Register("rocketchat", newRocketChatSink)
+}
+
+// webhookPayloadBuilder 构建不同平台的 Webhook 请求体
+type webhookPayloadBuilder func(cfg *config.WebhookSinkConfig, msg *models.Message) map[string]interface{}
+
+// WebhookSink 通过传入 Webhook 发送消息的投递目标，适用于 Mattermost 和 Rocket.Chat
+type WebhookSink struct {
+	name         string
+	platform     string
+	cfg          *config.WebhookSinkConfig
+	buildPayload webhookPayloadBuilder
+	httpClient   *http.Client
+}
+
+// newMattermostSink 创建 Mattermost 投递目标
+func newMattermostSink(cfg *config.SinkConfig) (Sink, error) {
+	return newWebhookSink(cfg.Name, "Mattermost", cfg.Mattermost, buildMattermostPayload)
+}
+
+// newRocketChatSink 创建 Rocket.Chat 投递目标
+func newRocketChatSink(cfg *config.SinkConfig) (Sink, error) {
+	return newWebhookSink(cfg.Name, "Rocket.Chat", cfg.RocketChat, buildRocketChatPayload)
+}
+
+// newWebhookSink 创建传入 Webhook 投递目标
+func newWebhookSink(name, platform string, cfg *config.WebhookSinkConfig, builder webhookPayloadBuilder) (Sink, error) {
+	if cfg == nil || cfg.WebhookURL == "" {
+		return nil, fmt.Errorf("投递目标 %s 未配置 %s webhook_url", name, platform)
+	}
+
+	return &WebhookSink{
+		name:         name,
+		platform:     platform,
+		cfg:          cfg,
+		buildPayload: builder,
+		httpClient:   newHTTPClient(),
+	}, nil
+}
+
+// Name 返回投递目标名称
+func (s *WebhookSink) Name() string {
+	return s.name
+}
+
+// Send 发送消息到传入 Webhook
+func (s *WebhookSink) Send(msg *models.Message) error {
+	payload, err := json.Marshal(s.buildPayload(s.cfg, msg))
+	if err != nil {
+		return fmt.Errorf("序列化 %s 消息失败: %w", s.platform, err)
+	}
+
+	var headers map[string]string
+	if s.cfg.Secret != "" {
+		headers = map[string]string{SignatureHeader: Sign(s.cfg.Secret, payload)}
+	}
+
+	body, err := doRequest(s.httpClient, http.MethodPost, s.cfg.WebhookURL, headers, payload)
+	if err != nil {
+		return fmt.Errorf("发送 %s 消息失败: %w", s.platform, err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"sink":       s.name,
+		"platform":   s.platform,
+		"message_id": msg.ID,
+		"response":   string(body),
+	}).Debug("Webhook 消息发送成功")
+	return nil
+}
+
+// Close 关闭投递目标
+func (s *WebhookSink) Close() error {
+	return nil
+}
+
+// Sign 计算请求体的签名，接收方用同一个密钥计算后与 SignatureHeader 比较
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// buildMattermostPayload 构建 Mattermost 请求体，图片以 markdown 内联显示
+func buildMattermostPayload(cfg *config.WebhookSinkConfig, msg *models.Message) map[string]interface{} {
+	payload := map[string]interface{}{
+		"text": msg.Markdown(),
+	}
+	addWebhookOverrides(payload, cfg, "username", "icon_url")
+	return payload
+}
+
+// buildRocketChatPayload 构建 Rocket.Chat 请求体，图片作为附件显示
+func buildRocketChatPayload(cfg *config.WebhookSinkConfig, msg *models.Message) map[string]interface{} {
+	payload := map[string]interface{}{
+		"text": msg.PlainText(),
+	}
+	if len(msg.Attachments) > 0 {
+		attachments := make([]map[string]interface{}, 0, len(msg.Attachments))
+		for _, a := range msg.Attachments {
+			attachment := map[string]interface{}{
+				"title":      a.FileName,
+				"title_link": a.URL,
+			}
+			if a.FileName == "" {
+				attachment["title"] = msg.Chat.Name()
+			}
+			if a.IsImage() {
+				attachment["image_url"] = a.URL
+			}
+			attachments = append(attachments, attachment)
+		}
+		payload["attachments"] = attachments
+	}
+	addWebhookOverrides(payload, cfg, "alias", "avatar")
+	return payload
+}
+
+// addWebhookOverrides 添加频道、名称和头像覆盖字段
+func addWebhookOverrides(payload map[string]interface{}, cfg *config.WebhookSinkConfig, nameField, iconField string) {
+	if cfg.Channel != "" {
+		payload["channel"] = cfg.Channel
+	}
+	if cfg.Username != "" {
+		payload[nameField] = cfg.Username
+	}
+	if cfg.IconURL != "" {
+		payload[iconField] = cfg.IconURL
+	}
+}
@@ -0,0 +1,133 @@
+package sink
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// capturedRequest 假 Webhook 收到的请求
+type capturedRequest struct {
+	body      []byte
+	signature string
+	payload   map[string]interface{}
+}
+
+func newFakeWebhook(t *testing.T) (*[]capturedRequest, *httptest.Server) {
+	t.Helper()
+	var requests []capturedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		req := capturedRequest{body: body, signature: r.Header.Get(SignatureHeader)}
+		if err := json.Unmarshal(body, &req.payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		requests = append(requests, req)
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(server.Close)
+	return &requests, server
+}
+
+func TestWebhookSignature(t *testing.T) {
+	requests, server := newFakeWebhook(t)
+	s, err := Create(&config.SinkConfig{
+		Name:       "mm",
+		Type:       "mattermost",
+		Mattermost: &config.WebhookSinkConfig{WebhookURL: server.URL, Secret: "s3cret"},
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	if len(*requests) != 1 {
+		t.Fatalf("requests = %d, want 1", len(*requests))
+	}
+	req := (*requests)[0]
+	want := Sign("s3cret", req.body)
+	if !hmac.Equal([]byte(req.signature), []byte(want)) {
+		t.Errorf("signature = %q, want %q", req.signature, want)
+	}
+	if Sign("other", req.body) == want {
+		t.Error("signature does not depend on the secret")
+	}
+}
+
+func TestWebhookWithoutSecretIsUnsigned(t *testing.T) {
+	requests, server := newFakeWebhook(t)
+	s, err := Create(&config.SinkConfig{
+		Name:       "rc",
+		Type:       "rocketchat",
+		RocketChat: &config.WebhookSinkConfig{WebhookURL: server.URL},
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if sig := (*requests)[0].signature; sig != "" {
+		t.Errorf("signature = %q, want none", sig)
+	}
+}
+
+func TestSignKnownValue(t *testing.T) {
+	// echo -n '{"text":"hi"}' | openssl dgst -sha256 -hmac key
+	got := Sign("key", []byte(`{"text":"hi"}`))
+	want := "sha256=4991614ca6c44269ceb8b70b29c9f2f01e282054edd8c25ef406431d3cb6e36c"
+	if got != want {
+		t.Errorf("Sign = %q, want %q", got, want)
+	}
+}
+
+func TestMattermostPayload(t *testing.T) {
+	cfg := &config.WebhookSinkConfig{Channel: "ops", Username: "tg-forward", IconURL: "https://example.com/icon.png"}
+	msg := testMessage()
+	msg.MessageType = models.MessageTypePhoto
+	msg.Attachments = []models.Attachment{{Kind: models.MessageTypePhoto, URL: "https://s3.example.com/a.jpg"}}
+
+	payload := buildMattermostPayload(cfg, msg)
+	if payload["text"] != msg.Markdown() {
+		t.Errorf("text = %v", payload["text"])
+	}
+	if payload["channel"] != "ops" || payload["username"] != "tg-forward" || payload["icon_url"] != "https://example.com/icon.png" {
+		t.Errorf("overrides = %v", payload)
+	}
+}
+
+func TestRocketChatPayload(t *testing.T) {
+	cfg := &config.WebhookSinkConfig{Username: "tg-forward", IconURL: "https://example.com/icon.png"}
+	msg := testMessage()
+	msg.Attachments = []models.Attachment{
+		{Kind: models.MessageTypePhoto, URL: "https://s3.example.com/a.jpg"},
+		{Kind: models.MessageTypeDocument, URL: "https://s3.example.com/b.pdf", FileName: "b.pdf"},
+	}
+
+	payload := buildRocketChatPayload(cfg, msg)
+	if payload["text"] != msg.PlainText() {
+		t.Errorf("text = %v", payload["text"])
+	}
+	if payload["alias"] != "tg-forward" || payload["avatar"] != "https://example.com/icon.png" {
+		t.Errorf("overrides = %v", payload)
+	}
+	attachments := payload["attachments"].([]map[string]interface{})
+	if len(attachments) != 2 {
+		t.Fatalf("attachments = %v", attachments)
+	}
+	if attachments[0]["image_url"] != "https://s3.example.com/a.jpg" || attachments[0]["title"] != "运维群" {
+		t.Errorf("image attachment = %v", attachments[0])
+	}
+	if _, ok := attachments[1]["image_url"]; ok || attachments[1]["title"] != "b.pdf" {
+		t.Errorf("file attachment = %v", attachments[1])
+	}
+}
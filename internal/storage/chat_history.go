@@ -2,29 +2,40 @@ package storage
 
 import (
 	"encoding/binary"
-	"encoding/csv"
 	"fmt"
 	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/util"
 	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/encryption"
+	"github.com/user/tg-forward-to-xx/internal/migration"
 	"github.com/user/tg-forward-to-xx/internal/models"
 	"github.com/user/tg-forward-to-xx/internal/utils"
 )
 
 // ChatHistoryStorage 聊天记录存储服务
 type ChatHistoryStorage struct {
-	db *leveldb.DB
+	db     *leveldb.DB
+	path   string
+	cipher *encryption.Cipher // 未启用静态加密时为 nil
+	index  *SearchIndex       // 全文搜索索引，随聊天记录一起更新，与聊天记录使用相同的加密
+	stats  *StatsIndex        // 统计汇总，随聊天记录一起更新，与聊天记录使用相同的加密
 }
 
+// indexHashIDKey 搜索索引和统计汇总中记录建立时加密状态（Cipher.HashID）的键，未加密时不存在
+var indexHashIDKey = []byte("k")
+
 // NewChatHistoryStorage 创建新的聊天记录存储服务
 func NewChatHistoryStorage() (*ChatHistoryStorage, error) {
+	cfg := config.Current()
+
 	// 确保数据目录存在
-	dbPath := filepath.Join(config.AppConfig.Queue.Path, "chat_history")
+	dbPath := filepath.Join(cfg.Queue.Path, "chat_history")
 	if err := os.MkdirAll(dbPath, 0755); err != nil {
 		return nil, fmt.Errorf("创建聊天记录数据库目录失败: %w", err)
 	}
@@ -43,182 +54,298 @@ func NewChatHistoryStorage() (*ChatHistoryStorage, error) {
 		return nil, fmt.Errorf("打开聊天记录数据库失败: %w", err)
 	}
 
-	return &ChatHistoryStorage{db: db}, nil
-}
+	// 检查数据库结构版本，旧版本需要先运行迁移工具
+	if err := migration.Check(db, migration.ChatHistory); err != nil {
+		db.Close()
+		return nil, err
+	}
 
-// SaveMessage 保存聊天记录
-func (s *ChatHistoryStorage) SaveMessage(history *models.ChatHistory) error {
-	// 处理消息内容中的表情
-	history.Text = utils.SanitizeMessage(history.Text)
+	// 启用静态加密后首次启动时会加密已有的聊天记录
+	keys, err := encryption.KeysFromConfig(cfg.Encryption)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	cipher, err := encryption.Open(db, migration.ChatHistory.Name, migration.ChatHistory.IsData, keys)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
 
-	// 使用时间戳作为键的一部分
-	key := makeKey(history.ChatID, history.Timestamp.UnixNano())
-	
-	// 序列化消息
-	value, err := history.ToJSON()
+	index, err := openSearchIndex(cfg.Queue.Path, cipher)
 	if err != nil {
-		return fmt.Errorf("序列化聊天记录失败: %w", err)
+		db.Close()
+		return nil, err
 	}
 
-	// 存储消息
-	if err := s.db.Put(key, value, nil); err != nil {
-		return fmt.Errorf("存储聊天记录失败: %w", err)
+	stats, err := openStatsIndex(cfg.Queue.Path, cipher)
+	if err != nil {
+		index.Close()
+		db.Close()
+		return nil, err
 	}
 
-	return nil
+	s := &ChatHistoryStorage{db: db, path: dbPath, cipher: cipher, index: index, stats: stats}
+	if err := s.syncIndexes(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := s.checkIndex(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
 }
 
-// QueryMessages 查询指定时间范围内的聊天记录
-func (s *ChatHistoryStorage) QueryMessages(chatID int64, start, end time.Time) ([]*models.ChatHistory, error) {
-	var messages []*models.ChatHistory
+// markIndexReady 记录派生数据的格式版本，启用静态加密时同时记录建立时使用的哈希密钥
+func markIndexReady(db *leveldb.DB, versionKey []byte, version int, cipher *encryption.Cipher) error {
+	b := new(leveldb.Batch)
+	b.Put(versionKey, []byte(fmt.Sprint(version)))
+	if id := cipher.HashID(); id != "" {
+		b.Put(indexHashIDKey, []byte(id))
+	} else {
+		b.Delete(indexHashIDKey)
+	}
+	return db.Write(b, nil)
+}
 
-	// 创建范围查询的起始和结束键
-	startKey := makeKey(chatID, start.UnixNano())
-	endKey := makeKey(chatID, end.UnixNano())
+// indexHashID 读取派生数据建立时的加密状态，未加密时返回空字符串
+func indexHashID(db *leveldb.DB) (string, error) {
+	data, err := db.Get(indexHashIDKey, nil)
+	if err == leveldb.ErrNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("读取索引加密状态失败: %w", err)
+	}
+	return string(data), nil
+}
 
-	// 创建范围迭代器
-	iter := s.db.NewIterator(&util.Range{
-		Start: startKey,
-		Limit: endKey,
-	}, nil)
-	defer iter.Release()
+// syncIndexes 搜索索引或统计汇总的加密状态与聊天记录不一致时（启用或关闭静态加密、轮换数据密钥后）重建
+// 与首次启用加密时加密已有聊天记录一样在启动时完成，重建后压缩数据库，旧的明文不会留在磁盘上
+func (s *ChatHistoryStorage) syncIndexes() error {
+	want := s.cipher.HashID()
+	indexes := []struct {
+		name    string
+		db      *leveldb.DB
+		rebuild func(progress func(int)) (int, error)
+	}{
+		{"搜索索引", s.index.db, s.RebuildIndex},
+		{"统计汇总", s.stats.db, s.RebuildStats},
+	}
+	for _, ix := range indexes {
+		id, err := indexHashID(ix.db)
+		if err != nil {
+			return err
+		}
+		if id == want {
+			continue
+		}
 
-	// 遍历结果
-	for iter.Next() {
-		value := iter.Value()
-		message, err := models.FromJSONHistory(value)
+		logrus.Infof("%s与聊天记录的加密状态不一致，开始重建", ix.name)
+		count, err := ix.rebuild(nil)
 		if err != nil {
-			return nil, fmt.Errorf("解析聊天记录失败: %w", err)
+			return fmt.Errorf("重建%s失败: %w", ix.name, err)
 		}
-		messages = append(messages, message)
+		if err := ix.db.CompactRange(util.Range{}); err != nil {
+			return fmt.Errorf("压缩%s失败: %w", ix.name, err)
+		}
+		logrus.WithField("count", count).Infof("%s重建完成", ix.name)
 	}
+	return nil
+}
 
-	if err := iter.Error(); err != nil {
-		return nil, fmt.Errorf("遍历聊天记录失败: %w", err)
+// checkIndex 检查搜索索引和统计汇总是否覆盖了已有的聊天记录
+// 新建的数据库直接标记为完整；已有聊天记录但不完整时只提示，搜索和统计结果会缺少旧消息
+func (s *ChatHistoryStorage) checkIndex() error {
+	if s.index.Ready() && s.stats.Ready() {
+		return nil
 	}
 
-	return messages, nil
+	iter := s.db.NewIterator(nil, nil)
+	defer iter.Release()
+	for iter.Next() {
+		if len(iter.Key()) == historyKeyLength {
+			logrus.Warn("搜索索引或统计汇总未覆盖已有的聊天记录，请停止服务后运行 tgforward history reindex 重建")
+			return nil
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("遍历聊天记录失败: %w", err)
+	}
+	if err := s.index.markReady(); err != nil {
+		return err
+	}
+	return s.stats.markReady()
 }
 
-// QueryMessagesByUser 查询指定用户在指定时间范围内的聊天记录
-func (s *ChatHistoryStorage) QueryMessagesByUser(chatID int64, username string, start, end time.Time) ([]*models.ChatHistory, error) {
-	messages, err := s.QueryMessages(chatID, start, end)
-	if err != nil {
-		return nil, err
-	}
+// SaveMessage 保存聊天记录
+func (s *ChatHistoryStorage) SaveMessage(history *models.ChatHistory) error {
+	// 处理消息内容中的表情
+	history.Text = utils.SanitizeMessage(history.Text)
+
+	// 同一条 Telegram 消息总是写入同一个键，重复写入（例如编辑）会覆盖原记录
+	key := makeKey(history.ChatID, history.Timestamp.UnixNano(), history.ID)
 
-	var userMessages []*models.ChatHistory
-	for _, msg := range messages {
-		if msg.FromUser == username {
-			userMessages = append(userMessages, msg)
+	// 编辑消息时不会重新上传媒体，保留原记录中的附件
+	if len(history.Attachments) == 0 && history.HasMedia {
+		if old, err := s.db.Get(key, nil); err == nil {
+			if previous, err := s.decode(key, old); err == nil {
+				history.Attachments = previous.Attachments
+			}
 		}
 	}
 
-	return userMessages, nil
-}
-
-// ExportToCSV 导出指定时间范围内的聊天记录到CSV文件
-func (s *ChatHistoryStorage) ExportToCSV(chatID int64, start, end time.Time, filePath string) error {
-	// 查询消息
-	messages, err := s.QueryMessages(chatID, start, end)
+	// 序列化消息
+	value, err := history.ToJSON()
 	if err != nil {
-		return fmt.Errorf("查询消息失败: %w", err)
+		return fmt.Errorf("序列化聊天记录失败: %w", err)
 	}
 
-	// 创建CSV文件
-	file, err := os.Create(filePath)
-	if err != nil {
-		return fmt.Errorf("创建CSV文件失败: %w", err)
+	// 存储消息
+	if err := s.db.Put(key, s.cipher.Seal(key, value), nil); err != nil {
+		return fmt.Errorf("存储聊天记录失败: %w", err)
 	}
-	defer file.Close()
 
-	// 写入UTF-8 BOM
-	file.Write([]byte{0xEF, 0xBB, 0xBF})
+	// 索引失败不影响聊天记录本身，可以通过 history reindex 修复
+	if err := s.index.Add(key, history); err != nil {
+		logrus.Errorf("更新搜索索引失败: %v", err)
+	}
+	if err := s.stats.Add(history); err != nil {
+		logrus.Errorf("更新统计汇总失败: %v", err)
+	}
 
-	// 创建CSV写入器
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+	return nil
+}
 
-	// 写入表头
-	headers := []string{"消息ID", "群组ID", "群组名称", "用户名", "消息内容", "时间"}
-	if err := writer.Write(headers); err != nil {
-		return fmt.Errorf("写入CSV表头失败: %w", err)
+// Exists 判断聊天记录是否已经保存
+func (s *ChatHistoryStorage) Exists(chatID int64, timestamp time.Time, messageID int64) (bool, error) {
+	exists, err := s.db.Has(makeKey(chatID, timestamp.UnixNano(), messageID), nil)
+	if err != nil {
+		return false, fmt.Errorf("查询聊天记录失败: %w", err)
 	}
+	return exists, nil
+}
 
-	// 写入数据
-	for _, msg := range messages {
-		record := []string{
-			fmt.Sprintf("%d", msg.ID),
-			fmt.Sprintf("%d", msg.ChatID),
-			msg.GroupName,
-			msg.FromUser,
-			msg.Text,
-			msg.Timestamp.Format("2006-01-02 15:04:05"),
+// ImportMessages 批量写入聊天记录，已存在的记录保持不变，返回实际写入的数量
+// 与 Bot 收到的同一条消息键相同，重复导入或导入 Bot 已保存的消息都会被跳过
+func (s *ChatHistoryStorage) ImportMessages(histories []*models.ChatHistory) (int, error) {
+	batch := new(leveldb.Batch)
+	seen := make(map[string]bool)
+	var keys [][]byte
+	var added []*models.ChatHistory
+	for _, history := range histories {
+		key := makeKey(history.ChatID, history.Timestamp.UnixNano(), history.ID)
+		if seen[string(key)] {
+			continue
 		}
-		if err := writer.Write(record); err != nil {
-			return fmt.Errorf("写入CSV数据失败: %w", err)
+		seen[string(key)] = true
+
+		exists, err := s.db.Has(key, nil)
+		if err != nil {
+			return 0, fmt.Errorf("查询聊天记录失败: %w", err)
+		}
+		if exists {
+			continue
 		}
-	}
 
-	return nil
-}
+		history.Text = utils.SanitizeMessage(history.Text)
+		value, err := history.ToJSON()
+		if err != nil {
+			return 0, fmt.Errorf("序列化聊天记录失败: %w", err)
+		}
+		batch.Put(key, s.cipher.Seal(key, value))
+		keys = append(keys, key)
+		added = append(added, history)
+	}
+	if batch.Len() == 0 {
+		return 0, nil
+	}
 
-// ExportUserToCSV 导出指定用户在指定时间范围内的聊天记录到CSV文件
-func (s *ChatHistoryStorage) ExportUserToCSV(chatID int64, username string, start, end time.Time, filePath string) error {
-	// 查询用户消息
-	messages, err := s.QueryMessagesByUser(chatID, username, start, end)
-	if err != nil {
-		return fmt.Errorf("查询用户消息失败: %w", err)
+	if err := s.db.Write(batch, nil); err != nil {
+		return 0, fmt.Errorf("存储聊天记录失败: %w", err)
+	}
+	for i, history := range added {
+		if err := s.index.Add(keys[i], history); err != nil {
+			logrus.Errorf("更新搜索索引失败: %v", err)
+		}
+		if err := s.stats.Add(history); err != nil {
+			logrus.Errorf("更新统计汇总失败: %v", err)
+		}
 	}
+	return len(added), nil
+}
 
-	// 创建CSV文件
-	file, err := os.Create(filePath)
+// decode 解密并解析一条聊天记录
+func (s *ChatHistoryStorage) decode(key, value []byte) (*models.ChatHistory, error) {
+	plaintext, err := s.cipher.Open(key, value)
 	if err != nil {
-		return fmt.Errorf("创建CSV文件失败: %w", err)
+		return nil, err
 	}
-	defer file.Close()
+	return models.FromJSONHistory(plaintext)
+}
 
-	// 写入UTF-8 BOM
-	file.Write([]byte{0xEF, 0xBB, 0xBF})
+// QueryMessages 查询指定时间范围内的聊天记录
+func (s *ChatHistoryStorage) QueryMessages(chatID int64, start, end time.Time) ([]*models.ChatHistory, error) {
+	return s.collect(&HistoryQuery{ChatID: chatID, Start: start, End: end})
+}
 
-	// 创建CSV写入器
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+// QueryMessagesByUser 查询指定用户在指定时间范围内的聊天记录
+func (s *ChatHistoryStorage) QueryMessagesByUser(chatID int64, username string, start, end time.Time) ([]*models.ChatHistory, error) {
+	return s.collect(&HistoryQuery{ChatID: chatID, Start: start, End: end, FromUser: username})
+}
 
-	// 写入表头
-	headers := []string{"消息ID", "群组ID", "群组名称", "用户名", "消息内容", "时间"}
-	if err := writer.Write(headers); err != nil {
-		return fmt.Errorf("写入CSV表头失败: %w", err)
+// collect 将查询结果全部读入内存，只用于数据量可控的场景
+func (s *ChatHistoryStorage) collect(q *HistoryQuery) ([]*models.ChatHistory, error) {
+	var messages []*models.ChatHistory
+	_, err := s.Iterate(q, func(message *models.ChatHistory) error {
+		messages = append(messages, message)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return messages, nil
+}
 
-	// 写入数据
-	for _, msg := range messages {
-		record := []string{
-			fmt.Sprintf("%d", msg.ID),
-			fmt.Sprintf("%d", msg.ChatID),
-			msg.GroupName,
-			msg.FromUser,
-			msg.Text,
-			msg.Timestamp.Format("2006-01-02 15:04:05"),
-		}
-		if err := writer.Write(record); err != nil {
-			return fmt.Errorf("写入CSV数据失败: %w", err)
-		}
+// Snapshot 返回聊天记录数据库的一致性快照，用于在线备份，使用完毕后需要 Release
+// 快照中的值保持存储格式，启用静态加密时仍是密文
+func (s *ChatHistoryStorage) Snapshot() (*leveldb.Snapshot, error) {
+	snapshot, err := s.db.GetSnapshot()
+	if err != nil {
+		return nil, fmt.Errorf("创建聊天记录快照失败: %w", err)
 	}
-
-	return nil
+	return snapshot, nil
 }
 
 // Close 关闭数据库连接
 func (s *ChatHistoryStorage) Close() error {
-	return s.db.Close()
+	indexErr := s.index.Close()
+	statsErr := s.stats.Close()
+	if err := s.db.Close(); err != nil {
+		return err
+	}
+	if indexErr != nil {
+		return indexErr
+	}
+	return statsErr
 }
 
+// 存储键长度：8 字节群组ID + 8 字节时间戳 + 8 字节消息ID
+const historyKeyLength = 24
+
 // makeKey 生成存储键
-// 格式: chat_id + timestamp，这样可以按时间顺序存储和查询
-func makeKey(chatID int64, timestamp int64) []byte {
-	key := make([]byte, 16)
+// 格式: chat_id + timestamp + message_id，按群组和时间顺序存储，
+// 同一秒内的多条消息由消息ID区分，不会互相覆盖
+func makeKey(chatID int64, timestamp int64, messageID int64) []byte {
+	key := make([]byte, historyKeyLength)
 	binary.BigEndian.PutUint64(key[:8], uint64(chatID))
-	binary.BigEndian.PutUint64(key[8:], uint64(timestamp))
+	binary.BigEndian.PutUint64(key[8:16], uint64(timestamp))
+	binary.BigEndian.PutUint64(key[16:], uint64(messageID))
 	return key
-} 
\ No newline at end of file
+}
+
+// rangeKey 生成范围查询的边界键，排在同一时间戳的所有记录之前
+func rangeKey(chatID int64, timestamp int64) []byte {
+	return makeKey(chatID, timestamp, 0)[:16]
+}
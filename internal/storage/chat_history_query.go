@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// ErrInvalidCursor 游标无法解析或不属于查询的群组
+var ErrInvalidCursor = errors.New("无效的游标")
+
+// HistoryQuery 聊天记录查询条件
+type HistoryQuery struct {
+	ChatID      int64
+	Start       time.Time // 包含，零值表示不限制
+	End         time.Time // 不包含，零值表示不限制
+	Cursor      string    // 上一页返回的 next_cursor
+	Limit       int       // 每页数量，0 表示不限制
+	Descending  bool      // 是否按时间倒序
+	FromUser    string    // 发送者用户名
+	MessageType string    // 消息类型，例如 text、photo
+	HasMedia    *bool     // 是否包含媒体
+	Text        string    // 消息内容包含的文本，不区分大小写
+}
+
+// match 判断聊天记录是否满足过滤条件
+func (q *HistoryQuery) match(h *models.ChatHistory) bool {
+	if q.FromUser != "" && h.FromUser != q.FromUser {
+		return false
+	}
+	if q.MessageType != "" && h.MessageType != q.MessageType {
+		return false
+	}
+	if q.HasMedia != nil && h.HasMedia != *q.HasMedia {
+		return false
+	}
+	if q.Text != "" && !strings.Contains(strings.ToLower(h.Text), strings.ToLower(q.Text)) {
+		return false
+	}
+	return true
+}
+
+// filtered 判断是否设置了需要解析记录内容才能判断的过滤条件
+func (q *HistoryQuery) filtered() bool {
+	return q.FromUser != "" || q.MessageType != "" || q.HasMedia != nil || q.Text != ""
+}
+
+// keyRange 计算查询的键范围，游标所在的记录不包含在内
+func (q *HistoryQuery) keyRange() (*util.Range, error) {
+	r := util.BytesPrefix(chatPrefix(q.ChatID))
+	if !q.Start.IsZero() {
+		r.Start = rangeKey(q.ChatID, q.Start.UnixNano())
+	}
+	if !q.End.IsZero() {
+		r.Limit = rangeKey(q.ChatID, q.End.UnixNano())
+	}
+
+	if q.Cursor != "" {
+		key, err := decodeCursor(q.Cursor)
+		if err != nil || len(key) != historyKeyLength || !bytes.HasPrefix(key, chatPrefix(q.ChatID)) {
+			return nil, ErrInvalidCursor
+		}
+		if q.Descending {
+			if r.Limit == nil || bytes.Compare(key, r.Limit) < 0 {
+				r.Limit = key
+			}
+		} else {
+			// 紧跟在游标之后的第一个键
+			next := append(append([]byte{}, key...), 0)
+			if bytes.Compare(next, r.Start) > 0 {
+				r.Start = next
+			}
+		}
+	}
+	return r, nil
+}
+
+// Iterate 按条件流式遍历聊天记录，fn 返回错误时停止遍历并返回该错误
+// 设置了 Limit 且后面仍有记录时返回下一页的游标，否则游标为空
+func (s *ChatHistoryStorage) Iterate(q *HistoryQuery, fn func(*models.ChatHistory) error) (string, error) {
+	r, err := q.keyRange()
+	if err != nil {
+		return "", err
+	}
+
+	iter := s.db.NewIterator(r, nil)
+	defer iter.Release()
+
+	first, next := iter.First, iter.Next
+	if q.Descending {
+		first, next = iter.Last, iter.Prev
+	}
+
+	count := 0
+	var last []byte
+	for ok := first(); ok; ok = next() {
+		if q.Limit > 0 && count >= q.Limit {
+			// 本页已满且后面还有记录，从本页最后一条之后继续
+			return encodeCursor(last), nil
+		}
+
+		message, err := s.decode(iter.Key(), iter.Value())
+		if err != nil {
+			return "", fmt.Errorf("解析聊天记录失败: %w", err)
+		}
+		if !q.match(message) {
+			continue
+		}
+		if err := fn(message); err != nil {
+			return "", err
+		}
+		last = append(last[:0], iter.Key()...)
+		count++
+	}
+
+	if err := iter.Error(); err != nil {
+		return "", fmt.Errorf("遍历聊天记录失败: %w", err)
+	}
+	return "", nil
+}
+
+// Count 统计满足条件的聊天记录数量，忽略 Cursor 和 Limit
+// 没有过滤条件时只遍历键，不解析记录内容
+func (s *ChatHistoryStorage) Count(q *HistoryQuery) (int, error) {
+	query := *q
+	query.Cursor = ""
+	query.Limit = 0
+
+	count := 0
+	if query.filtered() {
+		_, err := s.Iterate(&query, func(*models.ChatHistory) error {
+			count++
+			return nil
+		})
+		return count, err
+	}
+
+	r, err := query.keyRange()
+	if err != nil {
+		return 0, err
+	}
+	iter := s.db.NewIterator(r, nil)
+	defer iter.Release()
+	for iter.Next() {
+		count++
+	}
+	if err := iter.Error(); err != nil {
+		return 0, fmt.Errorf("遍历聊天记录失败: %w", err)
+	}
+	return count, nil
+}
+
+// chatPrefix 返回群组的键前缀
+func chatPrefix(chatID int64) []byte {
+	prefix := make([]byte, 8)
+	binary.BigEndian.PutUint64(prefix, uint64(chatID))
+	return prefix
+}
+
+// encodeCursor 将存储键编码为不透明的游标
+func encodeCursor(key []byte) string {
+	return base64.RawURLEncoding.EncodeToString(key)
+}
+
+// decodeCursor 解析游标
+func decodeCursor(cursor string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(cursor)
+}
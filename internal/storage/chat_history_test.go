@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/encryption"
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+const testChatID = -100123
+
+// openTestStorage 在 dir 中打开聊天记录存储，key 为空表示不加密
+func openTestStorage(t *testing.T, dir, key string) *ChatHistoryStorage {
+	t.Helper()
+	queue, encryptionCfg := config.AppConfig.Queue, config.AppConfig.Encryption
+	t.Cleanup(func() { config.AppConfig.Queue, config.AppConfig.Encryption = queue, encryptionCfg })
+
+	config.AppConfig.Queue = &config.QueueConfig{Path: dir}
+	config.AppConfig.Encryption = &config.EncryptionConfig{Enabled: key != "", Key: key}
+	s, err := NewChatHistoryStorage()
+	if err != nil {
+		t.Fatalf("NewChatHistoryStorage: %v", err)
+	}
+	return s
+}
+
+// testHistories 两个发送者的对话，包含回复、中文和英文
+func testHistories() []*models.ChatHistory {
+	base := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	message := func(id int64, minutes int, from, text string, replyTo int64) *models.ChatHistory {
+		return &models.ChatHistory{
+			ID:          id,
+			ChatID:      testChatID,
+			Text:        text,
+			FromUser:    from,
+			GroupName:   "运维群",
+			Timestamp:   base.Add(time.Duration(minutes) * time.Minute),
+			MessageType: models.MessageTypeText,
+			ReplyToID:   replyTo,
+		}
+	}
+	return []*models.ChatHistory{
+		message(1, 0, "alice", "今天晚上部署新版本", 0),
+		message(2, 5, "bob", "deploy 之前记得备份数据库", 1),
+		message(3, 30, "alice", "备份完成，开始 deployment", 2),
+		message(4, 90, "carol", "部署完成，发票系统正常", 1),
+		message(5, 95, "alice", "收到", 4),
+	}
+}
+
+// snapshot 搜索和统计的全部结果
+type snapshot struct {
+	Search    []*SearchResult
+	Activity  []ActivityBucket
+	Senders   []SenderStats
+	Total     int
+	Types     []TypeStats
+	Responses *ResponseTimeStats
+	Threads   []ThreadStats
+}
+
+func takeSnapshot(t *testing.T, s *ChatHistoryStorage) *snapshot {
+	t.Helper()
+	var snap snapshot
+	for _, q := range []*SearchQuery{
+		{Query: "部署"},
+		{Query: "deploy*"},
+		{Query: `"部署完成"`},
+		{Query: "备份", FromUser: "alice"},
+	} {
+		result, err := s.Search(q)
+		if err != nil {
+			t.Fatalf("Search(%q): %v", q.Query, err)
+		}
+		snap.Search = append(snap.Search, result)
+	}
+
+	q := &StatsQuery{ChatID: testChatID, Location: time.UTC}
+	var err error
+	if snap.Activity, err = s.Activity(q, IntervalHour); err != nil {
+		t.Fatalf("Activity: %v", err)
+	}
+	if snap.Senders, snap.Total, err = s.TopSenders(q, 10); err != nil {
+		t.Fatalf("TopSenders: %v", err)
+	}
+	if snap.Types, err = s.MessageTypes(q); err != nil {
+		t.Fatalf("MessageTypes: %v", err)
+	}
+	if snap.Responses, err = s.ResponseTimes(q); err != nil {
+		t.Fatalf("ResponseTimes: %v", err)
+	}
+	if snap.Threads, err = s.BusiestThreads(q, 10); err != nil {
+		t.Fatalf("BusiestThreads: %v", err)
+	}
+	return &snap
+}
+
+func TestEncryptedIndexesMatchPlaintext(t *testing.T) {
+	plainDir, encryptedDir := t.TempDir(), t.TempDir()
+	key := encryption.GenerateKey()
+
+	plain := openTestStorage(t, plainDir, "")
+	encrypted := openTestStorage(t, encryptedDir, key)
+	for _, h := range testHistories() {
+		if err := plain.SaveMessage(h); err != nil {
+			t.Fatalf("SaveMessage: %v", err)
+		}
+		if err := encrypted.SaveMessage(h); err != nil {
+			t.Fatalf("SaveMessage: %v", err)
+		}
+	}
+
+	want := takeSnapshot(t, plain)
+	if want.Search[1].Total != 2 || want.Total != 5 {
+		t.Fatalf("unexpected plaintext results: search %d, total %d", want.Search[1].Total, want.Total)
+	}
+	if got := takeSnapshot(t, encrypted); !reflect.DeepEqual(got, want) {
+		t.Errorf("encrypted results differ from plaintext:\ngot  %+v\nwant %+v", got, want)
+	}
+
+	// 加密后的索引和汇总中不出现明文的词元和发送者
+	for _, db := range []*leveldb.DB{encrypted.index.db, encrypted.stats.db} {
+		iter := db.NewIterator(nil, nil)
+		for iter.Next() {
+			for _, secret := range []string{"deploy", "alice", "部署"} {
+				if bytes.Contains(iter.Key(), []byte(secret)) || bytes.Contains(iter.Value(), []byte(secret)) {
+					t.Errorf("plaintext %q found in key %x", secret, iter.Key())
+				}
+			}
+		}
+		iter.Release()
+	}
+	plain.Close()
+	encrypted.Close()
+
+	// 已有明文索引的数据库启用加密后，启动时重建为加密索引，结果不变
+	reopened := openTestStorage(t, plainDir, key)
+	defer reopened.Close()
+	if got := takeSnapshot(t, reopened); !reflect.DeepEqual(got, want) {
+		t.Errorf("results after enabling encryption differ:\ngot  %+v\nwant %+v", got, want)
+	}
+}
@@ -0,0 +1,398 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"github.com/user/tg-forward-to-xx/internal/encryption"
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// 搜索索引格式版本，分词规则变化时递增，旧索引需要重建
+const searchIndexVersion = 1
+
+// 搜索索引的键
+// 倒排表：'p' + 词元 + 0x00 + 聊天记录键 → 词元在消息中的位置
+// 文档表：'d' + 聊天记录键 → 消息的词元列表，更新或删除消息时用于清理旧的倒排项
+// 词表：  'w' + 词元 → 词元，只在启用静态加密时维护，用于前缀搜索
+// 启用静态加密时键中的词元以 Cipher.Hash 代替，值用聊天记录的数据密钥加密
+var (
+	searchVersionKey = []byte("v")
+	searchCountKey   = []byte("n")
+)
+
+const (
+	postingPrefix    = 'p'
+	documentPrefix   = 'd'
+	vocabularyPrefix = 'w'
+)
+
+// rebuildBatchSize 重建索引时每批写入的消息数量
+const rebuildBatchSize = 500
+
+// SearchIndex 聊天记录全文索引（<queue.path>/chat_search）
+// 索引是可以从聊天记录重建的派生数据，不纳入迁移框架
+type SearchIndex struct {
+	db     *leveldb.DB
+	path   string
+	cipher *encryption.Cipher // 未启用静态加密时为 nil
+	mu     sync.Mutex         // 串行化更新，保证文档计数准确
+}
+
+// indexedDoc 文档表中保存的消息信息
+type indexedDoc struct {
+	Tokens   []string `json:"t"` // 去重后的词元
+	Length   int      `json:"l"` // 词元总数，用于长度归一化
+	FromUser string   `json:"u"` // 发送者，按发送者过滤时无需读取聊天记录
+}
+
+// openSearchIndex 打开搜索索引数据库，cipher 为聊天记录的加密器
+func openSearchIndex(queuePath string, cipher *encryption.Cipher) (*SearchIndex, error) {
+	dbPath := filepath.Join(queuePath, "chat_search")
+	if err := os.MkdirAll(dbPath, 0755); err != nil {
+		return nil, fmt.Errorf("创建搜索索引目录失败: %w", err)
+	}
+
+	db, err := leveldb.OpenFile(dbPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("打开搜索索引失败: %w", err)
+	}
+	return &SearchIndex{db: db, path: dbPath, cipher: cipher}, nil
+}
+
+// Ready 判断索引是否完整，版本不匹配或从未建立时需要运行 history reindex
+func (ix *SearchIndex) Ready() bool {
+	data, err := ix.db.Get(searchVersionKey, nil)
+	return err == nil && string(data) == fmt.Sprint(searchIndexVersion)
+}
+
+// markReady 记录索引版本和建立索引时的加密状态，表示索引覆盖了全部聊天记录
+func (ix *SearchIndex) markReady() error {
+	return markIndexReady(ix.db, searchVersionKey, searchIndexVersion, ix.cipher)
+}
+
+// term 返回键中使用的词元，启用静态加密时为带密钥哈希
+func (ix *SearchIndex) term(token string) []byte {
+	return ix.cipher.Hash([]byte(token))
+}
+
+// Count 返回已索引的消息数量
+func (ix *SearchIndex) Count() int64 {
+	data, err := ix.db.Get(searchCountKey, nil)
+	if err != nil || len(data) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(data))
+}
+
+// Add 索引一条聊天记录，同一个键已被索引时替换原有的索引项
+func (ix *SearchIndex) Add(key []byte, history *models.ChatHistory) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	b := new(leveldb.Batch)
+	delta := int64(1)
+	removed, err := ix.removeDoc(b, key)
+	if err != nil {
+		return err
+	}
+	if removed {
+		delta = 0
+	}
+	if err := ix.indexDoc(b, key, history); err != nil {
+		return err
+	}
+	ix.putCount(b, ix.Count()+delta)
+	return ix.write(b)
+}
+
+// Remove 删除聊天记录的索引项，未被索引的键直接忽略
+func (ix *SearchIndex) Remove(keys ...[]byte) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	b := new(leveldb.Batch)
+	count := 0
+	for _, key := range keys {
+		removed, err := ix.removeDoc(b, key)
+		if err != nil {
+			return err
+		}
+		if removed {
+			count++
+		}
+	}
+	if count == 0 {
+		return nil
+	}
+	ix.putCount(b, ix.Count()-int64(count))
+	return ix.write(b)
+}
+
+// removeDoc 将删除文档及其倒排项的操作加入 b，文档不存在时返回 false
+func (ix *SearchIndex) removeDoc(b *leveldb.Batch, key []byte) (bool, error) {
+	doc, err := ix.doc(key)
+	if err != nil || doc == nil {
+		return false, err
+	}
+	for _, t := range doc.Tokens {
+		b.Delete(postingKey(ix.term(t), key))
+	}
+	b.Delete(documentKey(key))
+	return true, nil
+}
+
+// doc 读取文档表，不存在时返回 nil
+func (ix *SearchIndex) doc(key []byte) (*indexedDoc, error) {
+	docKey := documentKey(key)
+	data, err := ix.db.Get(docKey, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取搜索索引失败: %w", err)
+	}
+	if data, err = ix.cipher.Open(docKey, data); err != nil {
+		return nil, fmt.Errorf("解密搜索索引失败: %w", err)
+	}
+	var doc indexedDoc
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("解析搜索索引失败: %w", err)
+	}
+	return &doc, nil
+}
+
+// putCount 将文档计数写入 b
+func (ix *SearchIndex) putCount(b *leveldb.Batch, count int64) {
+	if count < 0 {
+		count = 0
+	}
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(count))
+	b.Put(searchCountKey, data)
+}
+
+// write 写入批量变更
+func (ix *SearchIndex) write(b *leveldb.Batch) error {
+	if err := ix.db.Write(b, nil); err != nil {
+		return fmt.Errorf("更新搜索索引失败: %w", err)
+	}
+	return nil
+}
+
+// reset 清空索引
+func (ix *SearchIndex) reset() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	iter := ix.db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	b := new(leveldb.Batch)
+	for iter.Next() {
+		b.Delete(append([]byte{}, iter.Key()...))
+		if b.Len() >= rebuildBatchSize*10 {
+			if err := ix.write(b); err != nil {
+				return err
+			}
+			b.Reset()
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("遍历搜索索引失败: %w", err)
+	}
+	return ix.write(b)
+}
+
+// Close 关闭索引数据库
+func (ix *SearchIndex) Close() error {
+	return ix.db.Close()
+}
+
+// indexDoc 将一条聊天记录的倒排项和文档加入 b
+func (ix *SearchIndex) indexDoc(b *leveldb.Batch, key []byte, history *models.ChatHistory) error {
+	tokens := tokenize(history.Text, true)
+
+	// 同一个词元的全部位置保存在一个倒排项中
+	positions := make(map[string][]int)
+	var unique []string
+	for _, t := range tokens {
+		if _, ok := positions[t.Text]; !ok {
+			unique = append(unique, t.Text)
+		}
+		positions[t.Text] = append(positions[t.Text], t.Pos)
+	}
+
+	for _, t := range unique {
+		term := ix.term(t)
+		k := postingKey(term, key)
+		b.Put(k, ix.cipher.Seal(k, encodePositions(positions[t])))
+
+		// 哈希后的词元无法按前缀查找，启用静态加密时在词表中加密保存原词元
+		if ix.cipher != nil {
+			k = vocabularyKey(term)
+			exists, err := ix.db.Has(k, nil)
+			if err != nil {
+				return fmt.Errorf("读取搜索索引失败: %w", err)
+			}
+			if !exists {
+				b.Put(k, ix.cipher.Seal(k, []byte(t)))
+			}
+		}
+	}
+
+	data, err := json.Marshal(&indexedDoc{Tokens: unique, Length: len(tokens), FromUser: history.FromUser})
+	if err != nil {
+		return fmt.Errorf("序列化搜索索引失败: %w", err)
+	}
+	docKey := documentKey(key)
+	b.Put(docKey, ix.cipher.Seal(docKey, data))
+	return nil
+}
+
+// prefixTokens 返回词表中以 prefix 开头的词元，只在启用静态加密时使用
+// 词表只增不减，返回的词元可能已经没有倒排项
+func (ix *SearchIndex) prefixTokens(prefix string) ([]string, error) {
+	iter := ix.db.NewIterator(util.BytesPrefix([]byte{vocabularyPrefix}), nil)
+	defer iter.Release()
+
+	var tokens []string
+	for iter.Next() {
+		data, err := ix.cipher.Open(iter.Key(), iter.Value())
+		if err != nil {
+			return nil, fmt.Errorf("解密搜索索引失败: %w", err)
+		}
+		if strings.HasPrefix(string(data), prefix) {
+			tokens = append(tokens, string(data))
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("读取搜索索引失败: %w", err)
+	}
+	return tokens, nil
+}
+
+// RebuildIndex 清空并根据全部聊天记录重建搜索索引，progress 在每批写入后以已索引数量调用
+// 重建期间不应有新消息写入，离线执行（history reindex）
+func (s *ChatHistoryStorage) RebuildIndex(progress func(indexed int)) (int, error) {
+	if err := s.index.reset(); err != nil {
+		return 0, err
+	}
+
+	iter := s.db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	count := 0
+	b := new(leveldb.Batch)
+	flush := func() error {
+		s.index.putCount(b, int64(count))
+		if err := s.index.write(b); err != nil {
+			return err
+		}
+		b.Reset()
+		if progress != nil {
+			progress(count)
+		}
+		return nil
+	}
+
+	for iter.Next() {
+		if len(iter.Key()) != historyKeyLength {
+			continue
+		}
+		history, err := s.decode(iter.Key(), iter.Value())
+		if err != nil {
+			return count, fmt.Errorf("解析聊天记录失败 [key=%x]: %w", iter.Key(), err)
+		}
+		if err := s.index.indexDoc(b, iter.Key(), history); err != nil {
+			return count, err
+		}
+		count++
+		if count%rebuildBatchSize == 0 {
+			if err := flush(); err != nil {
+				return count, err
+			}
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return count, fmt.Errorf("遍历聊天记录失败: %w", err)
+	}
+	if err := flush(); err != nil {
+		return count, err
+	}
+	return count, s.index.markReady()
+}
+
+// postingKey 生成倒排项的键，term 为 SearchIndex.term 返回的词元
+func postingKey(term []byte, key []byte) []byte {
+	k := make([]byte, 0, 2+len(term)+len(key))
+	k = append(k, postingPrefix)
+	k = append(k, term...)
+	k = append(k, 0)
+	return append(k, key...)
+}
+
+// postingRange 返回词元（prefix 为 true 时为词元前缀）的倒排项范围
+func postingRange(term []byte, prefix bool) *util.Range {
+	k := append([]byte{postingPrefix}, term...)
+	if !prefix {
+		k = append(k, 0)
+	}
+	return util.BytesPrefix(k)
+}
+
+// splitPostingKey 从倒排项的键中解析词元和聊天记录键
+func splitPostingKey(k []byte) (string, []byte, bool) {
+	if len(k) < 2+historyKeyLength || k[0] != postingPrefix {
+		return "", nil, false
+	}
+	sep := len(k) - historyKeyLength - 1
+	if k[sep] != 0 {
+		return "", nil, false
+	}
+	return string(k[1:sep]), k[sep+1:], true
+}
+
+// vocabularyKey 生成词表的键
+func vocabularyKey(term []byte) []byte {
+	return append([]byte{vocabularyPrefix}, term...)
+}
+
+// documentKey 生成文档表的键
+func documentKey(key []byte) []byte {
+	return append([]byte{documentPrefix}, key...)
+}
+
+// encodePositions 将递增的位置列表编码为差值 varint
+func encodePositions(positions []int) []byte {
+	buf := make([]byte, 0, len(positions)*2)
+	prev := 0
+	for _, p := range positions {
+		buf = binary.AppendUvarint(buf, uint64(p-prev))
+		prev = p
+	}
+	return buf
+}
+
+// decodePositions 解析 encodePositions 编码的位置列表
+func decodePositions(data []byte) []int {
+	var positions []int
+	prev := 0
+	for len(data) > 0 {
+		delta, n := binary.Uvarint(data)
+		if n <= 0 {
+			break
+		}
+		prev += int(delta)
+		positions = append(positions, prev)
+		data = data[n:]
+	}
+	return positions
+}
@@ -0,0 +1,399 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"html"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// ErrEmptySearch 搜索词中没有可以检索的内容
+var ErrEmptySearch = errors.New("搜索词不能为空")
+
+// 摘要长度和匹配位置之前保留的字符数
+const (
+	snippetLength  = 160
+	snippetContext = 40
+)
+
+// SearchQuery 全文搜索条件
+// 查询语法：空格分隔的词需要全部出现；"双引号" 内为短语，按顺序相邻出现；词尾的 * 表示前缀匹配。
+// 中文等没有空格的文字按相邻字匹配，"发票号码" 只匹配连续出现的这四个字
+type SearchQuery struct {
+	Query    string
+	ChatIDs  []int64   // 为空表示全部群组
+	FromUser string    // 发送者用户名
+	Start    time.Time // 包含，零值表示不限制
+	End      time.Time // 不包含，零值表示不限制
+	Offset   int
+	Limit    int // 0 表示不限制
+}
+
+// SearchHit 一条搜索结果
+type SearchHit struct {
+	Message *models.ChatHistory `json:"message"`
+	Score   float64             `json:"score"`
+	Snippet string              `json:"snippet"` // HTML 转义后的摘要，匹配部分用 <mark> 标出
+}
+
+// SearchResult 搜索结果，按相关度降序，相关度相同时较新的消息在前
+type SearchResult struct {
+	Total int          `json:"total"`
+	Hits  []*SearchHit `json:"hits"`
+	// Incomplete 为 true 表示索引尚未覆盖全部历史记录，需要运行 history reindex
+	Incomplete bool `json:"incomplete,omitempty"`
+}
+
+// searchClause 查询中的一个词或短语，词元位置相对于查询开头
+type searchClause struct {
+	tokens []token
+	prefix bool // 最后一个词元按前缀匹配
+}
+
+// parseSearch 解析查询语法
+func parseSearch(query string) []*searchClause {
+	var clauses []*searchClause
+	add := func(text string, phrase bool) {
+		prefix := false
+		if !phrase && strings.HasSuffix(text, "*") {
+			text = strings.TrimRight(text, "*")
+			prefix = true
+		}
+		tokens := tokenize(text, false)
+		if len(tokens) == 0 {
+			return
+		}
+		clauses = append(clauses, &searchClause{tokens: tokens, prefix: prefix})
+	}
+
+	for query != "" {
+		query = strings.TrimLeftFunc(query, unicode.IsSpace)
+		if strings.HasPrefix(query, `"`) {
+			end := strings.Index(query[1:], `"`)
+			if end < 0 {
+				// 未闭合的引号视为到结尾的短语
+				add(query[1:], true)
+				break
+			}
+			add(query[1:end+1], true)
+			query = query[end+2:]
+			continue
+		}
+		end := strings.IndexFunc(query, unicode.IsSpace)
+		if end < 0 {
+			end = len(query)
+		}
+		add(query[:end], false)
+		query = query[end:]
+	}
+	return clauses
+}
+
+// matches 判断文档中的词元是否与子句的第 i 个词元匹配
+func (c *searchClause) matches(i int, text string) bool {
+	if c.prefix && i == len(c.tokens)-1 {
+		return strings.HasPrefix(text, c.tokens[i].Text)
+	}
+	return text == c.tokens[i].Text
+}
+
+// searchFilter 倒排项的过滤条件，在读取文档之前根据聊天记录键判断
+type searchFilter struct {
+	chats      map[int64]bool
+	start, end int64
+}
+
+// accept 判断聊天记录键是否满足群组和时间条件
+func (f *searchFilter) accept(key []byte) bool {
+	if f.chats != nil && !f.chats[int64(binary.BigEndian.Uint64(key[:8]))] {
+		return false
+	}
+	ts := int64(binary.BigEndian.Uint64(key[8:16]))
+	if f.start != 0 && ts < f.start {
+		return false
+	}
+	if f.end != 0 && ts >= f.end {
+		return false
+	}
+	return true
+}
+
+// postings 读取与子句第 i 个词元匹配的全部倒排项，返回 聊天记录键 → 位置
+func (s *ChatHistoryStorage) postings(c *searchClause, i int, filter *searchFilter) (map[string][]int, error) {
+	prefix := c.prefix && i == len(c.tokens)-1
+	ranges := []*util.Range{postingRange(s.index.term(c.tokens[i].Text), prefix)}
+	if prefix && s.index.cipher != nil {
+		// 键中的词元是哈希，先从词表中找出匹配前缀的词元，再逐个读取
+		tokens, err := s.index.prefixTokens(c.tokens[i].Text)
+		if err != nil {
+			return nil, err
+		}
+		ranges = ranges[:0]
+		for _, t := range tokens {
+			ranges = append(ranges, postingRange(s.index.term(t), false))
+		}
+	}
+
+	result := make(map[string][]int)
+	for _, r := range ranges {
+		if err := s.readPostings(r, filter, result); err != nil {
+			return nil, err
+		}
+	}
+
+	if prefix {
+		// 合并后的位置需要重新排序，单字和双字词元可能位于同一位置
+		for key, positions := range result {
+			sort.Ints(positions)
+			result[key] = slices.Compact(positions)
+		}
+	}
+	return result, nil
+}
+
+// readPostings 读取范围内满足过滤条件的倒排项，位置合并到 result
+func (s *ChatHistoryStorage) readPostings(r *util.Range, filter *searchFilter, result map[string][]int) error {
+	iter := s.index.db.NewIterator(r, nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		_, key, ok := splitPostingKey(iter.Key())
+		if !ok || !filter.accept(key) {
+			continue
+		}
+		value, err := s.index.cipher.Open(iter.Key(), iter.Value())
+		if err != nil {
+			return fmt.Errorf("解密搜索索引失败: %w", err)
+		}
+		// 前缀匹配时同一条消息可能有多个词元匹配，合并位置
+		result[string(key)] = append(result[string(key)], decodePositions(value)...)
+	}
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("读取搜索索引失败: %w", err)
+	}
+	return nil
+}
+
+// clauseMatches 返回匹配子句的消息及子句在其中出现的次数
+func (s *ChatHistoryStorage) clauseMatches(c *searchClause, filter *searchFilter, candidates map[string]bool) (map[string]int, error) {
+	lists := make([]map[string][]int, len(c.tokens))
+	for i := range c.tokens {
+		list, err := s.postings(c, i, filter)
+		if err != nil {
+			return nil, err
+		}
+		lists[i] = list
+	}
+
+	matches := make(map[string]int)
+	for key, first := range lists[0] {
+		if candidates != nil && !candidates[key] {
+			continue
+		}
+
+		// 逐个起始位置检查后续词元是否出现在对应的相对位置
+		tf := 0
+		for _, p := range first {
+			found := true
+			for i := 1; i < len(c.tokens) && found; i++ {
+				want := p + c.tokens[i].Pos - c.tokens[0].Pos
+				found = containsInt(lists[i][key], want)
+			}
+			if found {
+				tf++
+			}
+		}
+		if tf > 0 {
+			matches[key] = tf
+		}
+	}
+	return matches, nil
+}
+
+// Search 全文搜索聊天记录
+func (s *ChatHistoryStorage) Search(q *SearchQuery) (*SearchResult, error) {
+	clauses := parseSearch(q.Query)
+	if len(clauses) == 0 {
+		return nil, ErrEmptySearch
+	}
+
+	filter := &searchFilter{}
+	if len(q.ChatIDs) > 0 {
+		filter.chats = make(map[int64]bool)
+		for _, id := range q.ChatIDs {
+			filter.chats[id] = true
+		}
+	}
+	if !q.Start.IsZero() {
+		filter.start = q.Start.UnixNano()
+	}
+	if !q.End.IsZero() {
+		filter.end = q.End.UnixNano()
+	}
+
+	// 所有子句都需要匹配，前一个子句的结果作为后一个子句的候选
+	total := float64(s.index.Count())
+	scores := make(map[string]float64)
+	var candidates map[string]bool
+	for _, c := range clauses {
+		matches, err := s.clauseMatches(c, filter, candidates)
+		if err != nil {
+			return nil, err
+		}
+
+		idf := math.Log(1 + total/float64(len(matches)+1))
+		candidates = make(map[string]bool, len(matches))
+		next := make(map[string]float64, len(matches))
+		for key, tf := range matches {
+			candidates[key] = true
+			next[key] = scores[key] + (1+math.Log(float64(tf)))*idf
+		}
+		scores = next
+		if len(scores) == 0 {
+			break
+		}
+	}
+
+	type ranked struct {
+		key   string
+		score float64
+	}
+	hits := make([]ranked, 0, len(scores))
+	for key, score := range scores {
+		doc, err := s.index.doc([]byte(key))
+		if err != nil {
+			return nil, err
+		}
+		if doc == nil || (q.FromUser != "" && doc.FromUser != q.FromUser) {
+			continue
+		}
+		// 较短的消息中出现同样次数的词，相关度更高
+		hits = append(hits, ranked{key: key, score: score / math.Sqrt(float64(max(doc.Length, 1)))})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].score != hits[j].score {
+			return hits[i].score > hits[j].score
+		}
+		// 键的 8-16 字节为时间戳，较新的在前
+		return bytes.Compare([]byte(hits[i].key[8:]), []byte(hits[j].key[8:])) > 0
+	})
+
+	result := &SearchResult{Total: len(hits), Hits: []*SearchHit{}, Incomplete: !s.index.Ready()}
+	if q.Offset >= len(hits) {
+		return result, nil
+	}
+	hits = hits[q.Offset:]
+	if q.Limit > 0 && len(hits) > q.Limit {
+		hits = hits[:q.Limit]
+	}
+
+	for _, h := range hits {
+		value, err := s.db.Get([]byte(h.key), nil)
+		if err != nil {
+			// 聊天记录已删除而索引尚未更新
+			continue
+		}
+		message, err := s.decode([]byte(h.key), value)
+		if err != nil {
+			return nil, fmt.Errorf("解析聊天记录失败: %w", err)
+		}
+		result.Hits = append(result.Hits, &SearchHit{
+			Message: message,
+			Score:   math.Round(h.score*1000) / 1000,
+			Snippet: highlight(message.Text, clauses),
+		})
+	}
+	return result, nil
+}
+
+// highlight 生成带高亮的摘要，长消息截取第一个匹配附近的内容
+func highlight(text string, clauses []*searchClause) string {
+	// 找出与任一查询词元匹配的原文区间
+	var spans [][2]int
+	for _, t := range tokenize(text, true) {
+		for _, c := range clauses {
+			for i := range c.tokens {
+				if c.matches(i, t.Text) {
+					spans = append(spans, [2]int{t.Start, t.End})
+				}
+			}
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+
+	// 合并重叠的区间，例如相邻的两个中文双字词元
+	merged := spans[:0]
+	for _, span := range spans {
+		if n := len(merged); n > 0 && span[0] <= merged[n-1][1] {
+			merged[n-1][1] = max(merged[n-1][1], span[1])
+			continue
+		}
+		merged = append(merged, span)
+	}
+
+	// 计算摘要窗口
+	from, to := 0, len(text)
+	if utf8.RuneCountInString(text) > snippetLength {
+		if len(merged) > 0 {
+			from = backRunes(text, merged[0][0], snippetContext)
+		}
+		to = forwardRunes(text, from, snippetLength)
+	}
+
+	var sb strings.Builder
+	if from > 0 {
+		sb.WriteString("…")
+	}
+	pos := from
+	for _, span := range merged {
+		start, end := max(span[0], from), min(span[1], to)
+		if start >= end {
+			continue
+		}
+		sb.WriteString(html.EscapeString(text[pos:start]))
+		sb.WriteString("<mark>")
+		sb.WriteString(html.EscapeString(text[start:end]))
+		sb.WriteString("</mark>")
+		pos = end
+	}
+	sb.WriteString(html.EscapeString(text[pos:to]))
+	if to < len(text) {
+		sb.WriteString("…")
+	}
+	return sb.String()
+}
+
+// backRunes 返回从 offset 向前 n 个字符的字节偏移
+func backRunes(text string, offset, n int) int {
+	for ; n > 0 && offset > 0; n-- {
+		_, size := utf8.DecodeLastRuneInString(text[:offset])
+		offset -= size
+	}
+	return offset
+}
+
+// forwardRunes 返回从 offset 向后 n 个字符的字节偏移
+func forwardRunes(text string, offset, n int) int {
+	for ; n > 0 && offset < len(text); n-- {
+		_, size := utf8.DecodeRuneInString(text[offset:])
+		offset += size
+	}
+	return offset
+}
+
+// containsInt 判断有序列表中是否包含 v
+func containsInt(list []int, v int) bool {
+	i := sort.SearchInts(list, v)
+	return i < len(list) && list[i] == v
+}
@@ -0,0 +1,462 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"github.com/user/tg-forward-to-xx/internal/encryption"
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// 统计汇总格式版本，汇总方式变化时递增，旧数据需要重建
+const statsVersion = 1
+
+// 统计汇总的键，群组ID、小时（Unix 时间 / 3600）和消息ID均为 8 字节大端序
+// 小时汇总：'h' + 群组ID + 小时 → hourStats
+// 发送者：  'u' + 群组ID + 小时 + 发送者 → 消息数
+// 讨论串：  't' + 群组ID + 小时 + 根消息ID → 回复数
+// 消息：    'm' + 群组ID + 消息ID → statsMessage，用于去重以及计算回复的讨论串和响应时间
+// 聊天记录被清理后消息信息替换为空值作为墓碑，防止重新导入时重复计数
+// 启用静态加密时发送者以 Cipher.Hash 代替，发送者计数的值中附带发送者；全部值用聊天记录的数据密钥加密
+var statsVersionKey = []byte("v")
+
+const (
+	hourStatsPrefix    = 'h'
+	senderStatsPrefix  = 'u'
+	threadStatsPrefix  = 't'
+	messageStatsPrefix = 'm'
+)
+
+// 未记录消息类型的旧记录在统计中的类型
+const unknownMessageType = "unknown"
+
+// responseBounds 响应时间分布的区间上限（秒），最后一个区间不设上限
+var responseBounds = []int64{60, 300, 900, 1800, 3600, 7200, 14400, 43200, 86400}
+
+// StatsIndex 聊天记录统计汇总（<queue.path>/chat_stats）
+// 每条消息保存时增量更新按小时汇总的计数，查询只读取汇总，不扫描聊天记录
+// 汇总是可以从聊天记录重建的派生数据，不纳入迁移框架；保留策略删除聊天记录后汇总仍然保留
+type StatsIndex struct {
+	db     *leveldb.DB
+	path   string
+	cipher *encryption.Cipher // 未启用静态加密时为 nil
+	mu     sync.Mutex         // 串行化更新，保证读改写的计数准确
+}
+
+// hourStats 一个群组一小时内的汇总
+type hourStats struct {
+	Messages int            `json:"n"`
+	Media    int            `json:"m,omitempty"`
+	Replies  int            `json:"r,omitempty"`
+	Types    map[string]int `json:"t,omitempty"`
+	Response responseStats  `json:"rt,omitzero"`
+}
+
+// responseStats 响应时间汇总，响应时间为消息到第一条其他人回复之间的秒数
+type responseStats struct {
+	Count   int     `json:"n,omitempty"`
+	Sum     int64   `json:"s,omitempty"`
+	Min     int64   `json:"min,omitempty"`
+	Max     int64   `json:"max,omitempty"`
+	Buckets []int64 `json:"b,omitempty"` // 按 responseBounds 划分的数量，比 responseBounds 多一个区间
+}
+
+// add 记录一次响应
+func (r *responseStats) add(seconds int64) {
+	if r.Count == 0 || seconds < r.Min {
+		r.Min = seconds
+	}
+	if seconds > r.Max {
+		r.Max = seconds
+	}
+	r.Count++
+	r.Sum += seconds
+	if len(r.Buckets) != len(responseBounds)+1 {
+		r.Buckets = make([]int64, len(responseBounds)+1)
+	}
+	r.Buckets[responseBucket(seconds)]++
+}
+
+// merge 合并另一段时间的汇总
+func (r *responseStats) merge(other *responseStats) {
+	if other.Count == 0 {
+		return
+	}
+	if r.Count == 0 || other.Min < r.Min {
+		r.Min = other.Min
+	}
+	if other.Max > r.Max {
+		r.Max = other.Max
+	}
+	r.Count += other.Count
+	r.Sum += other.Sum
+	if len(r.Buckets) != len(responseBounds)+1 {
+		r.Buckets = make([]int64, len(responseBounds)+1)
+	}
+	for i, n := range other.Buckets {
+		if i < len(r.Buckets) {
+			r.Buckets[i] += n
+		}
+	}
+}
+
+// responseBucket 返回响应时间所在的区间
+func responseBucket(seconds int64) int {
+	for i, bound := range responseBounds {
+		if seconds <= bound {
+			return i
+		}
+	}
+	return len(responseBounds)
+}
+
+// statsMessage 统计用到的单条消息信息
+type statsMessage struct {
+	Time     int64  // 消息时间（纳秒），与聊天记录键中的时间戳相同
+	Root     int64  // 所在讨论串的根消息ID，不是回复时为 0
+	Answered bool   // 是否已经有其他人回复，只统计第一条回复的响应时间
+	Sender   string // 发送者，回复自己的消息不计入响应时间
+}
+
+// encode 编码为 8 字节时间 + 8 字节根消息ID + 1 字节回复标记 + 发送者
+func (m *statsMessage) encode() []byte {
+	data := make([]byte, 17, 17+len(m.Sender))
+	binary.BigEndian.PutUint64(data[:8], uint64(m.Time))
+	binary.BigEndian.PutUint64(data[8:16], uint64(m.Root))
+	if m.Answered {
+		data[16] = 1
+	}
+	return append(data, m.Sender...)
+}
+
+// decodeStatsMessage 解析 statsMessage.encode 编码的消息信息
+func decodeStatsMessage(data []byte) (*statsMessage, bool) {
+	if len(data) < 17 {
+		return nil, false
+	}
+	return &statsMessage{
+		Time:     int64(binary.BigEndian.Uint64(data[:8])),
+		Root:     int64(binary.BigEndian.Uint64(data[8:16])),
+		Answered: data[16] == 1,
+		Sender:   string(data[17:]),
+	}, true
+}
+
+// openStatsIndex 打开统计汇总数据库，cipher 为聊天记录的加密器
+func openStatsIndex(queuePath string, cipher *encryption.Cipher) (*StatsIndex, error) {
+	dbPath := filepath.Join(queuePath, "chat_stats")
+	if err := os.MkdirAll(dbPath, 0755); err != nil {
+		return nil, fmt.Errorf("创建统计数据目录失败: %w", err)
+	}
+
+	db, err := leveldb.OpenFile(dbPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("打开统计数据库失败: %w", err)
+	}
+	return &StatsIndex{db: db, path: dbPath, cipher: cipher}, nil
+}
+
+// Ready 判断汇总是否覆盖了全部聊天记录，版本不匹配或从未建立时需要运行 history reindex
+func (ix *StatsIndex) Ready() bool {
+	data, err := ix.db.Get(statsVersionKey, nil)
+	return err == nil && string(data) == fmt.Sprint(statsVersion)
+}
+
+// markReady 记录汇总版本和建立汇总时的加密状态，表示汇总覆盖了全部聊天记录
+func (ix *StatsIndex) markReady() error {
+	return markIndexReady(ix.db, statsVersionKey, statsVersion, ix.cipher)
+}
+
+// get 读取并解密一个值，不存在时返回 leveldb.ErrNotFound
+func (ix *StatsIndex) get(key []byte) ([]byte, error) {
+	data, err := ix.db.Get(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return ix.cipher.Open(key, data)
+}
+
+// put 将加密后的值写入 b
+func (ix *StatsIndex) put(b *leveldb.Batch, key, value []byte) {
+	b.Put(key, ix.cipher.Seal(key, value))
+}
+
+// Add 将一条聊天记录计入汇总，已经计入的消息（例如编辑或重复导入）直接忽略
+func (ix *StatsIndex) Add(history *models.ChatHistory) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	b := new(leveldb.Batch)
+	if err := ix.add(b, history); err != nil {
+		return err
+	}
+	return ix.write(b)
+}
+
+// add 将更新汇总的操作加入 b，调用方需要持有锁
+// 读取的汇总和消息必须已经写入数据库，批量重建时每条消息单独提交
+func (ix *StatsIndex) add(b *leveldb.Batch, history *models.ChatHistory) error {
+	messageKey := statsMessageKey(history.ChatID, history.ID)
+	exists, err := ix.db.Has(messageKey, nil)
+	if err != nil {
+		return fmt.Errorf("读取统计数据失败: %w", err)
+	}
+	if exists {
+		return nil
+	}
+
+	hour := unixHour(history.Timestamp.Unix())
+	hs, err := ix.hour(history.ChatID, hour)
+	if err != nil {
+		return err
+	}
+
+	messageType := history.MessageType
+	if messageType == "" {
+		messageType = unknownMessageType
+	}
+	hs.Messages++
+	hs.Types[messageType]++
+	if history.HasMedia {
+		hs.Media++
+	}
+
+	message := &statsMessage{Time: history.Timestamp.UnixNano(), Sender: history.FromUser}
+	if history.ReplyToID != 0 {
+		hs.Replies++
+		message.Root = history.ReplyToID
+
+		// 被回复的消息早于统计或已被清理时，以被回复的消息作为讨论串的根
+		targetKey := statsMessageKey(history.ChatID, history.ReplyToID)
+		if data, err := ix.get(targetKey); err == nil {
+			if target, ok := decodeStatsMessage(data); ok {
+				if target.Root != 0 {
+					message.Root = target.Root
+				}
+				seconds := (message.Time - target.Time) / 1e9
+				if !target.Answered && target.Sender != history.FromUser && seconds >= 0 {
+					hs.Response.add(seconds)
+					target.Answered = true
+					ix.put(b, targetKey, target.encode())
+				}
+			}
+		} else if err != leveldb.ErrNotFound {
+			return fmt.Errorf("读取统计数据失败: %w", err)
+		}
+
+		if err := ix.increment(b, threadStatsKey(history.ChatID, hour, message.Root), nil); err != nil {
+			return err
+		}
+	}
+
+	// 发送者的键是哈希时，统计结果中的发送者从值中读取
+	var sender []byte
+	if ix.cipher != nil {
+		sender = []byte(history.FromUser)
+	}
+	if err := ix.increment(b, senderStatsKey(history.ChatID, hour, ix.cipher.Hash([]byte(history.FromUser))), sender); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(hs)
+	if err != nil {
+		return fmt.Errorf("序列化统计数据失败: %w", err)
+	}
+	ix.put(b, hourStatsKey(history.ChatID, hour), data)
+	ix.put(b, messageKey, message.encode())
+	return nil
+}
+
+// Remove 将被清理的聊天记录的消息信息替换为墓碑，按小时汇总的计数保持不变
+// 墓碑只用于去重，重新导入同一条消息不会再次计数；回复它的消息按被回复的消息已清理处理
+func (ix *StatsIndex) Remove(keys ...[]byte) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	b := new(leveldb.Batch)
+	for _, key := range keys {
+		if len(key) != historyKeyLength {
+			continue
+		}
+		chatID := int64(binary.BigEndian.Uint64(key[:8]))
+		messageID := int64(binary.BigEndian.Uint64(key[16:]))
+		ix.put(b, statsMessageKey(chatID, messageID), nil)
+	}
+	return ix.write(b)
+}
+
+// hour 读取一小时的汇总，不存在时返回空汇总
+func (ix *StatsIndex) hour(chatID, hour int64) (*hourStats, error) {
+	hs := &hourStats{}
+	data, err := ix.get(hourStatsKey(chatID, hour))
+	if err == leveldb.ErrNotFound {
+		hs.Types = make(map[string]int)
+		return hs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取统计数据失败: %w", err)
+	}
+	if err := json.Unmarshal(data, hs); err != nil {
+		return nil, fmt.Errorf("解析统计数据失败: %w", err)
+	}
+	if hs.Types == nil {
+		hs.Types = make(map[string]int)
+	}
+	return hs, nil
+}
+
+// increment 将计数加一的操作加入 b，值为 varint 计数加上 suffix
+func (ix *StatsIndex) increment(b *leveldb.Batch, key, suffix []byte) error {
+	var count uint64
+	data, err := ix.get(key)
+	if err == nil {
+		count, _ = binary.Uvarint(data)
+	} else if err != leveldb.ErrNotFound {
+		return fmt.Errorf("读取统计数据失败: %w", err)
+	}
+	ix.put(b, key, append(binary.AppendUvarint(nil, count+1), suffix...))
+	return nil
+}
+
+// write 写入批量变更
+func (ix *StatsIndex) write(b *leveldb.Batch) error {
+	if b.Len() == 0 {
+		return nil
+	}
+	if err := ix.db.Write(b, nil); err != nil {
+		return fmt.Errorf("更新统计数据失败: %w", err)
+	}
+	return nil
+}
+
+// reset 清空汇总
+func (ix *StatsIndex) reset() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	iter := ix.db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	b := new(leveldb.Batch)
+	for iter.Next() {
+		b.Delete(append([]byte{}, iter.Key()...))
+		if b.Len() >= rebuildBatchSize*10 {
+			if err := ix.write(b); err != nil {
+				return err
+			}
+			b.Reset()
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("遍历统计数据失败: %w", err)
+	}
+	return ix.write(b)
+}
+
+// Close 关闭统计数据库
+func (ix *StatsIndex) Close() error {
+	return ix.db.Close()
+}
+
+// RebuildStats 清空并根据全部聊天记录重新计算统计汇总，progress 在每处理一批后以已处理数量调用
+// 已被保留策略清理的聊天记录不再计入，重建期间不应有新消息写入，离线执行（history reindex）
+func (s *ChatHistoryStorage) RebuildStats(progress func(processed int)) (int, error) {
+	if err := s.stats.reset(); err != nil {
+		return 0, err
+	}
+
+	iter := s.db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+
+	count := 0
+	for iter.Next() {
+		if len(iter.Key()) != historyKeyLength {
+			continue
+		}
+		history, err := s.decode(iter.Key(), iter.Value())
+		if err != nil {
+			return count, fmt.Errorf("解析聊天记录失败 [key=%x]: %w", iter.Key(), err)
+		}
+
+		// 同一小时的后续消息需要读到前一条消息更新后的汇总，逐条写入
+		b := new(leveldb.Batch)
+		if err := s.stats.add(b, history); err != nil {
+			return count, err
+		}
+		if err := s.stats.write(b); err != nil {
+			return count, err
+		}
+		count++
+		if progress != nil && count%rebuildBatchSize == 0 {
+			progress(count)
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return count, fmt.Errorf("遍历聊天记录失败: %w", err)
+	}
+	if progress != nil {
+		progress(count)
+	}
+	return count, s.stats.markReady()
+}
+
+// unixHour 返回 Unix 时间所在的小时
+func unixHour(sec int64) int64 {
+	hour := sec / 3600
+	if sec < 0 && sec%3600 != 0 {
+		hour--
+	}
+	return hour
+}
+
+// statsKey 生成 prefix + 群组ID + 若干 8 字节数值组成的键
+func statsKey(prefix byte, chatID int64, values ...int64) []byte {
+	key := make([]byte, 9+8*len(values))
+	key[0] = prefix
+	binary.BigEndian.PutUint64(key[1:9], uint64(chatID))
+	for i, v := range values {
+		binary.BigEndian.PutUint64(key[9+8*i:], uint64(v))
+	}
+	return key
+}
+
+// hourStatsKey 生成小时汇总的键
+func hourStatsKey(chatID, hour int64) []byte {
+	return statsKey(hourStatsPrefix, chatID, hour)
+}
+
+// senderStatsKey 生成发送者计数的键，sender 启用静态加密时为发送者的哈希
+func senderStatsKey(chatID, hour int64, sender []byte) []byte {
+	return append(statsKey(senderStatsPrefix, chatID, hour), sender...)
+}
+
+// threadStatsKey 生成讨论串回复数的键
+func threadStatsKey(chatID, hour, rootID int64) []byte {
+	return statsKey(threadStatsPrefix, chatID, hour, rootID)
+}
+
+// statsMessageKey 生成消息信息的键
+func statsMessageKey(chatID, messageID int64) []byte {
+	return statsKey(messageStatsPrefix, chatID, messageID)
+}
+
+// statsHourRange 返回 [startHour, endHour) 内指定前缀的键范围
+func statsHourRange(prefix byte, chatID, startHour, endHour int64) *util.Range {
+	r := &util.Range{Start: statsKey(prefix, chatID, startHour)}
+	if endHour == math.MaxInt64 {
+		r.Limit = util.BytesPrefix(statsKey(prefix, chatID)).Limit
+	} else {
+		r.Limit = statsKey(prefix, chatID, endHour)
+	}
+	return r
+}
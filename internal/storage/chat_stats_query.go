@@ -0,0 +1,406 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// ErrInvalidInterval 不支持的活跃度统计粒度
+var ErrInvalidInterval = errors.New("无效的统计粒度，支持 hour、day、hour_of_day、weekday")
+
+// 活跃度统计粒度
+const (
+	IntervalHour      = "hour"        // 按小时的时间序列
+	IntervalDay       = "day"         // 按天的时间序列
+	IntervalHourOfDay = "hour_of_day" // 一天中各小时（0-23）的合计
+	IntervalWeekday   = "weekday"     // 一周中各天（1-7，周一为 1）的合计
+)
+
+// StatsQuery 统计查询条件，汇总按小时保存，开始和结束时间按整点计算
+type StatsQuery struct {
+	ChatID   int64
+	Start    time.Time      // 包含，零值表示不限制
+	End      time.Time      // 不包含，零值表示不限制
+	Location *time.Location // 按天、小时分组使用的时区，为空时使用服务器时区
+}
+
+// hours 返回查询覆盖的小时范围 [start, end)，开始时间向前、结束时间向后取整
+func (q *StatsQuery) hours() (int64, int64) {
+	start, end := int64(0), int64(math.MaxInt64)
+	if !q.Start.IsZero() {
+		start = max(unixHour(q.Start.Unix()), 0)
+	}
+	if !q.End.IsZero() {
+		end = unixHour(q.End.Unix())
+		if q.End.Unix()%3600 != 0 {
+			end++
+		}
+	}
+	return start, end
+}
+
+// location 返回分组使用的时区
+func (q *StatsQuery) location() *time.Location {
+	if q.Location != nil {
+		return q.Location
+	}
+	return time.Local
+}
+
+// ActivityBucket 一个时间段内的消息数量
+type ActivityBucket struct {
+	Bucket   string `json:"bucket"` // 时间段：hour 为 RFC3339 时间，day 为日期，hour_of_day 为 0-23，weekday 为 1-7
+	Messages int    `json:"messages"`
+	Media    int    `json:"media"`
+	Replies  int    `json:"replies"`
+}
+
+// SenderStats 发送者的消息数量
+type SenderStats struct {
+	User     string  `json:"user"`
+	Messages int     `json:"messages"`
+	Share    float64 `json:"share"` // 占全部消息的比例
+}
+
+// TypeStats 一种消息类型的数量
+type TypeStats struct {
+	Type     string  `json:"type"`
+	Messages int     `json:"messages"`
+	Share    float64 `json:"share"`
+}
+
+// ResponseTimeStats 响应时间统计，单位为秒
+// 响应时间为消息到第一条其他人回复之间的时间，中位数和 P90 按分布区间插值，为近似值
+type ResponseTimeStats struct {
+	Replies int                  `json:"replies"`
+	Average float64              `json:"average"`
+	Median  float64              `json:"median"`
+	P90     float64              `json:"p90"`
+	Min     int64                `json:"min"`
+	Max     int64                `json:"max"`
+	Buckets []ResponseTimeBucket `json:"buckets"`
+}
+
+// ResponseTimeBucket 响应时间分布的一个区间
+type ResponseTimeBucket struct {
+	UpTo  int64 `json:"up_to"` // 区间上限（秒，包含），0 表示不设上限
+	Count int64 `json:"count"`
+}
+
+// ThreadStats 讨论串的回复数量，讨论串以最早被回复的消息为根
+type ThreadStats struct {
+	RootID    int64               `json:"root_id"`
+	Replies   int                 `json:"replies"`
+	LastReply time.Time           `json:"last_reply"`     // 最后一条回复所在的整点
+	Root      *models.ChatHistory `json:"root,omitempty"` // 根消息，已被清理或早于 Bot 入群时为空
+}
+
+// StatsReady 判断统计汇总是否覆盖了全部聊天记录
+func (s *ChatHistoryStorage) StatsReady() bool {
+	return s.stats.Ready()
+}
+
+// Activity 按粒度统计消息数量，hour 和 day 只返回有消息的时间段
+func (s *ChatHistoryStorage) Activity(q *StatsQuery, interval string) ([]ActivityBucket, error) {
+	loc := q.location()
+	var buckets []ActivityBucket
+	switch interval {
+	case IntervalHour, IntervalDay:
+	case IntervalHourOfDay:
+		buckets = make([]ActivityBucket, 24)
+	case IntervalWeekday:
+		buckets = make([]ActivityBucket, 7)
+	default:
+		return nil, ErrInvalidInterval
+	}
+	for i := range buckets {
+		buckets[i].Bucket = strconv.Itoa(i)
+		if interval == IntervalWeekday {
+			buckets[i].Bucket = strconv.Itoa(i + 1)
+		}
+	}
+
+	err := s.eachHour(q, func(hour int64, hs *hourStats) {
+		t := time.Unix(hour*3600, 0).In(loc)
+		var b *ActivityBucket
+		switch interval {
+		case IntervalHour, IntervalDay:
+			label := t.Format(time.RFC3339)
+			if interval == IntervalDay {
+				label = t.Format("2006-01-02")
+			}
+			// 小时按时间顺序遍历，同一时间段的小时相邻
+			if len(buckets) == 0 || buckets[len(buckets)-1].Bucket != label {
+				buckets = append(buckets, ActivityBucket{Bucket: label})
+			}
+			b = &buckets[len(buckets)-1]
+		case IntervalHourOfDay:
+			b = &buckets[t.Hour()]
+		case IntervalWeekday:
+			b = &buckets[(int(t.Weekday())+6)%7]
+		}
+		b.Messages += hs.Messages
+		b.Media += hs.Media
+		b.Replies += hs.Replies
+	})
+	if err != nil {
+		return nil, err
+	}
+	if buckets == nil {
+		buckets = []ActivityBucket{}
+	}
+	return buckets, nil
+}
+
+// TopSenders 返回消息最多的 limit 个发送者，以及时间范围内的消息总数
+func (s *ChatHistoryStorage) TopSenders(q *StatsQuery, limit int) ([]SenderStats, int, error) {
+	counts := make(map[string]int)
+	total := 0
+	start, end := q.hours()
+	iter := s.stats.db.NewIterator(statsHourRange(senderStatsPrefix, q.ChatID, start, end), nil)
+	defer iter.Release()
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) < 17 {
+			continue
+		}
+		value, err := s.stats.cipher.Open(key, iter.Value())
+		if err != nil {
+			return nil, 0, fmt.Errorf("解密统计数据失败: %w", err)
+		}
+		n, size := binary.Uvarint(value)
+		if size <= 0 {
+			continue
+		}
+		// 启用静态加密时键中是发送者的哈希，发送者保存在值中
+		user := string(key[17:])
+		if s.stats.cipher != nil {
+			user = string(value[size:])
+		}
+		counts[user] += int(n)
+		total += int(n)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, 0, fmt.Errorf("遍历统计数据失败: %w", err)
+	}
+
+	senders := make([]SenderStats, 0, len(counts))
+	for user, n := range counts {
+		senders = append(senders, SenderStats{User: user, Messages: n, Share: share(n, total)})
+	}
+	sort.Slice(senders, func(i, j int) bool {
+		if senders[i].Messages != senders[j].Messages {
+			return senders[i].Messages > senders[j].Messages
+		}
+		return senders[i].User < senders[j].User
+	})
+	if limit > 0 && len(senders) > limit {
+		senders = senders[:limit]
+	}
+	return senders, total, nil
+}
+
+// MessageTypes 按消息类型统计数量，按数量从多到少排序
+func (s *ChatHistoryStorage) MessageTypes(q *StatsQuery) ([]TypeStats, error) {
+	counts := make(map[string]int)
+	total := 0
+	err := s.eachHour(q, func(_ int64, hs *hourStats) {
+		for t, n := range hs.Types {
+			counts[t] += n
+		}
+		total += hs.Messages
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	types := make([]TypeStats, 0, len(counts))
+	for t, n := range counts {
+		types = append(types, TypeStats{Type: t, Messages: n, Share: share(n, total)})
+	}
+	sort.Slice(types, func(i, j int) bool {
+		if types[i].Messages != types[j].Messages {
+			return types[i].Messages > types[j].Messages
+		}
+		return types[i].Type < types[j].Type
+	})
+	return types, nil
+}
+
+// ResponseTimes 统计回复的响应时间
+func (s *ChatHistoryStorage) ResponseTimes(q *StatsQuery) (*ResponseTimeStats, error) {
+	var rt responseStats
+	err := s.eachHour(q, func(_ int64, hs *hourStats) {
+		rt.merge(&hs.Response)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ResponseTimeStats{Replies: rt.Count, Min: rt.Min, Max: rt.Max}
+	for i := range len(responseBounds) + 1 {
+		bucket := ResponseTimeBucket{}
+		if i < len(responseBounds) {
+			bucket.UpTo = responseBounds[i]
+		}
+		if i < len(rt.Buckets) {
+			bucket.Count = rt.Buckets[i]
+		}
+		result.Buckets = append(result.Buckets, bucket)
+	}
+	if rt.Count > 0 {
+		result.Average = math.Round(float64(rt.Sum)/float64(rt.Count)*10) / 10
+		result.Median = rt.quantile(0.5)
+		result.P90 = rt.quantile(0.9)
+	}
+	return result, nil
+}
+
+// quantile 在分布区间内线性插值估算分位数，结果限制在最小值和最大值之间
+func (r *responseStats) quantile(p float64) float64 {
+	target := p * float64(r.Count)
+	var seen float64
+	for i, n := range r.Buckets {
+		if n == 0 || seen+float64(n) < target {
+			seen += float64(n)
+			continue
+		}
+		lower := float64(r.Min)
+		if i > 0 {
+			lower = max(lower, float64(responseBounds[i-1]))
+		}
+		upper := float64(r.Max)
+		if i < len(responseBounds) {
+			upper = min(upper, float64(responseBounds[i]))
+		}
+		v := lower + (upper-lower)*(target-seen)/float64(n)
+		return math.Round(v*10) / 10
+	}
+	return float64(r.Max)
+}
+
+// BusiestThreads 返回回复最多的 limit 个讨论串，并读取根消息的内容
+func (s *ChatHistoryStorage) BusiestThreads(q *StatsQuery, limit int) ([]ThreadStats, error) {
+	type thread struct {
+		replies  int
+		lastHour int64
+	}
+	threads := make(map[int64]*thread)
+	start, end := q.hours()
+	iter := s.stats.db.NewIterator(statsHourRange(threadStatsPrefix, q.ChatID, start, end), nil)
+	defer iter.Release()
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) != 25 {
+			continue
+		}
+		hour := int64(binary.BigEndian.Uint64(key[9:17]))
+		root := int64(binary.BigEndian.Uint64(key[17:]))
+		value, err := s.stats.cipher.Open(key, iter.Value())
+		if err != nil {
+			return nil, fmt.Errorf("解密统计数据失败: %w", err)
+		}
+		n, _ := binary.Uvarint(value)
+		t := threads[root]
+		if t == nil {
+			t = &thread{}
+			threads[root] = t
+		}
+		t.replies += int(n)
+		t.lastHour = max(t.lastHour, hour)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("遍历统计数据失败: %w", err)
+	}
+
+	result := make([]ThreadStats, 0, len(threads))
+	for root, t := range threads {
+		result = append(result, ThreadStats{RootID: root, Replies: t.replies, LastReply: time.Unix(t.lastHour*3600, 0).In(q.location())})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Replies != result[j].Replies {
+			return result[i].Replies > result[j].Replies
+		}
+		return result[i].LastReply.After(result[j].LastReply)
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+
+	for i := range result {
+		root, err := s.statsMessageHistory(q.ChatID, result[i].RootID)
+		if err != nil {
+			return nil, err
+		}
+		result[i].Root = root
+	}
+	return result, nil
+}
+
+// statsMessageHistory 通过统计中保存的消息时间读取聊天记录，不存在时返回 nil
+func (s *ChatHistoryStorage) statsMessageHistory(chatID, messageID int64) (*models.ChatHistory, error) {
+	data, err := s.stats.get(statsMessageKey(chatID, messageID))
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取统计数据失败: %w", err)
+	}
+	message, ok := decodeStatsMessage(data)
+	if !ok {
+		return nil, nil
+	}
+
+	key := makeKey(chatID, message.Time, messageID)
+	value, err := s.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取聊天记录失败: %w", err)
+	}
+	return s.decode(key, value)
+}
+
+// eachHour 按时间顺序遍历查询范围内每小时的汇总
+func (s *ChatHistoryStorage) eachHour(q *StatsQuery, fn func(hour int64, hs *hourStats)) error {
+	start, end := q.hours()
+	iter := s.stats.db.NewIterator(statsHourRange(hourStatsPrefix, q.ChatID, start, end), nil)
+	defer iter.Release()
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) != 17 {
+			continue
+		}
+		value, err := s.stats.cipher.Open(key, iter.Value())
+		if err != nil {
+			return fmt.Errorf("解密统计数据失败: %w", err)
+		}
+		var hs hourStats
+		if err := json.Unmarshal(value, &hs); err != nil {
+			return fmt.Errorf("解析统计数据失败: %w", err)
+		}
+		fn(int64(binary.BigEndian.Uint64(key[9:])), &hs)
+	}
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("遍历统计数据失败: %w", err)
+	}
+	return nil
+}
+
+// share 计算占比，保留四位小数
+func share(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(n)/float64(total)*10000) / 10000
+}
@@ -0,0 +1,362 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"github.com/user/tg-forward-to-xx/internal/config"
+	"github.com/user/tg-forward-to-xx/internal/models"
+)
+
+// retentionBatchSize 清理时每批删除的记录数量
+const retentionBatchSize = 1000
+
+// 删除单个 S3 对象的超时时间
+const mediaDeleteTimeout = 30 * time.Second
+
+// RetentionReport 一次清理的结果
+type RetentionReport struct {
+	StartedAt      time.Time              `json:"started_at"`
+	FinishedAt     time.Time              `json:"finished_at"`
+	Chats          []*ChatRetentionReport `json:"chats"`
+	Deleted        int                    `json:"deleted"`                // 删除的聊天记录数量
+	MediaDeleted   int                    `json:"media_deleted"`          // 删除的 S3 媒体文件数量
+	MediaFailed    int                    `json:"media_failed,omitempty"` // 删除失败的媒体文件数量
+	SizeBefore     int64                  `json:"size_before"`            // 清理前聊天记录和搜索索引占用的字节数
+	SizeAfter      int64                  `json:"size_after"`             // 压缩后占用的字节数
+	BytesReclaimed int64                  `json:"bytes_reclaimed"`        // 释放的磁盘空间
+	Error          string                 `json:"error,omitempty"`        // 清理中途失败的原因
+}
+
+// ChatRetentionReport 单个群组的清理结果，只列出有删除或处于法律保全的群组
+type ChatRetentionReport struct {
+	ChatID    int64 `json:"chat_id"`
+	Deleted   int   `json:"deleted"`
+	LegalHold bool  `json:"legal_hold,omitempty"`
+}
+
+// ApplyRetention 按保留策略删除过期的聊天记录及其索引项，并压缩删除的范围
+// 处于法律保全中的群组不做任何删除；cfg.Enabled 只控制后台定期清理，不影响手动执行
+func (s *ChatHistoryStorage) ApplyRetention(cfg *config.RetentionConfig, now time.Time) (*RetentionReport, error) {
+	report := &RetentionReport{StartedAt: now, Chats: []*ChatRetentionReport{}}
+	report.SizeBefore = s.diskSize()
+
+	chats, err := s.chatIDs()
+	if err != nil {
+		return report, err
+	}
+
+	var media []models.Attachment
+	var ranges []*util.Range
+	for _, chatID := range chats {
+		policy, ok := cfg.Policy(chatID)
+		if !ok {
+			report.Chats = append(report.Chats, &ChatRetentionReport{ChatID: chatID, LegalHold: true})
+			continue
+		}
+
+		limit, err := s.expiredLimit(policy, now)
+		if err != nil {
+			return report, err
+		}
+		if limit == nil {
+			continue
+		}
+
+		r := &util.Range{Start: chatPrefix(chatID), Limit: limit}
+		deleted, attachments, err := s.deleteRange(r, cfg.DeleteMedia)
+		report.Deleted += deleted
+		if deleted > 0 {
+			report.Chats = append(report.Chats, &ChatRetentionReport{ChatID: chatID, Deleted: deleted})
+			ranges = append(ranges, r)
+		}
+		media = append(media, attachments...)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	// 删除只写入墓碑标记，压缩后才真正释放磁盘空间
+	for _, r := range ranges {
+		if err := s.db.CompactRange(*r); err != nil {
+			return report, fmt.Errorf("压缩聊天记录数据库失败: %w", err)
+		}
+	}
+	if len(ranges) > 0 {
+		// 倒排项按词元分散在整个索引中，压缩全部范围
+		if err := s.index.db.CompactRange(util.Range{}); err != nil {
+			return report, fmt.Errorf("压缩搜索索引失败: %w", err)
+		}
+		if err := s.stats.db.CompactRange(*util.BytesPrefix([]byte{messageStatsPrefix})); err != nil {
+			return report, fmt.Errorf("压缩统计数据库失败: %w", err)
+		}
+	}
+
+	if len(media) > 0 {
+		report.MediaDeleted, report.MediaFailed = deleteMedia(media)
+	}
+
+	report.SizeAfter = s.diskSize()
+	report.BytesReclaimed = max(report.SizeBefore-report.SizeAfter, 0)
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+// chatIDs 返回有聊天记录的全部群组
+func (s *ChatHistoryStorage) chatIDs() ([]int64, error) {
+	iter := s.db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	var ids []int64
+	for ok := iter.First(); ok; {
+		key := iter.Key()
+		if len(key) != historyKeyLength {
+			ok = iter.Next()
+			continue
+		}
+		ids = append(ids, int64(binary.BigEndian.Uint64(key[:8])))
+
+		// 直接跳到下一个群组
+		next := util.BytesPrefix(key[:8]).Limit
+		if next == nil {
+			break
+		}
+		ok = iter.Seek(next)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("遍历聊天记录失败: %w", err)
+	}
+	return ids, nil
+}
+
+// expiredLimit 计算群组过期记录的键上界（不包含），没有需要删除的记录时返回 nil
+// 按时间和按数量的条件满足其一即删除，取两者中较大的上界
+func (s *ChatHistoryStorage) expiredLimit(policy config.ChatRetentionConfig, now time.Time) ([]byte, error) {
+	var limit []byte
+	if policy.MaxAgeDays > 0 {
+		limit = rangeKey(policy.ChatID, now.AddDate(0, 0, -policy.MaxAgeDays).UnixNano())
+	}
+
+	if policy.MaxMessages > 0 {
+		iter := s.db.NewIterator(util.BytesPrefix(chatPrefix(policy.ChatID)), nil)
+		defer iter.Release()
+
+		// 从最新的记录向前数，保留 MaxMessages 条之后的第一条及更早的记录都需要删除
+		kept := 0
+		for ok := iter.Last(); ok; ok = iter.Prev() {
+			if len(iter.Key()) != historyKeyLength {
+				continue
+			}
+			if kept < policy.MaxMessages {
+				kept++
+				continue
+			}
+			next := append(append([]byte{}, iter.Key()...), 0)
+			if bytes.Compare(next, limit) > 0 {
+				limit = next
+			}
+			break
+		}
+		if err := iter.Error(); err != nil {
+			return nil, fmt.Errorf("遍历聊天记录失败: %w", err)
+		}
+	}
+	return limit, nil
+}
+
+// deleteRange 分批删除范围内的聊天记录和索引项，collectMedia 为 true 时返回被删除记录的媒体附件
+func (s *ChatHistoryStorage) deleteRange(r *util.Range, collectMedia bool) (int, []models.Attachment, error) {
+	iter := s.db.NewIterator(r, nil)
+	defer iter.Release()
+
+	deleted := 0
+	var media []models.Attachment
+	var keys [][]byte
+	flush := func() error {
+		b := new(leveldb.Batch)
+		for _, key := range keys {
+			b.Delete(key)
+		}
+		if err := s.db.Write(b, nil); err != nil {
+			return fmt.Errorf("删除聊天记录失败: %w", err)
+		}
+		if err := s.index.Remove(keys...); err != nil {
+			// 搜索时会跳过已删除的记录，不影响清理
+			logrus.Errorf("删除搜索索引失败: %v", err)
+		}
+		if err := s.stats.Remove(keys...); err != nil {
+			// 只影响之后回复这些消息时的讨论串归属和响应时间
+			logrus.Errorf("删除统计数据失败: %v", err)
+		}
+		deleted += len(keys)
+		keys = keys[:0]
+		return nil
+	}
+
+	for iter.Next() {
+		if len(iter.Key()) != historyKeyLength {
+			continue
+		}
+		if collectMedia {
+			if history, err := s.decode(iter.Key(), iter.Value()); err == nil {
+				media = append(media, history.Attachments...)
+			}
+		}
+		keys = append(keys, append([]byte{}, iter.Key()...))
+		if len(keys) >= retentionBatchSize {
+			if err := flush(); err != nil {
+				return deleted, media, err
+			}
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return deleted, media, fmt.Errorf("遍历聊天记录失败: %w", err)
+	}
+	if len(keys) > 0 {
+		if err := flush(); err != nil {
+			return deleted, media, err
+		}
+	}
+	return deleted, media, nil
+}
+
+// deleteMedia 删除 S3 上的媒体文件，返回成功和失败的数量
+// 优先使用附件记录的对象名称，升级前保存的附件从地址解析；不属于当前存储桶的地址（例如更换存储桶之前上传的文件）计为失败
+func deleteMedia(attachments []models.Attachment) (int, int) {
+	client, err := NewS3Client()
+	if err != nil {
+		logrus.Errorf("删除媒体文件失败: %v", err)
+		return 0, len(attachments)
+	}
+
+	deleted, failed := 0, 0
+	for _, a := range attachments {
+		name, ok := a.Object, a.Object != ""
+		if !ok {
+			name, ok = client.ObjectName(a.URL)
+		}
+		if !ok {
+			logrus.Warnf("无法从地址解析 S3 对象名称，跳过: %s", a.URL)
+			failed++
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), mediaDeleteTimeout)
+		err := client.DeleteObject(ctx, name)
+		cancel()
+		if err != nil {
+			logrus.Error(err)
+			failed++
+			continue
+		}
+		deleted++
+	}
+	return deleted, failed
+}
+
+// diskSize 返回聊天记录和搜索索引数据库占用的字节数
+func (s *ChatHistoryStorage) diskSize() int64 {
+	return dirSize(s.path) + dirSize(s.index.path) + dirSize(s.stats.path)
+}
+
+// dirSize 统计目录下文件的总大小
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// RetentionSweeper 按保留策略定期清理聊天记录
+type RetentionSweeper struct {
+	storage  *ChatHistoryStorage
+	stopChan chan struct{}
+	runMutex sync.Mutex // 保证同一时间只有一次清理
+
+	lastMutex sync.RWMutex
+	last      *RetentionReport
+}
+
+// NewRetentionSweeper 创建聊天记录清理任务
+func NewRetentionSweeper(storage *ChatHistoryStorage) *RetentionSweeper {
+	return &RetentionSweeper{storage: storage, stopChan: make(chan struct{})}
+}
+
+// Start 启动后台清理，启动时立即执行一次，之后按 chat_history.retention.interval 间隔执行
+// 每次执行前读取当前配置，重新加载配置后立即生效
+func (w *RetentionSweeper) Start() {
+	go w.run()
+}
+
+// Stop 停止后台清理，等待正在执行的清理完成后返回，之后可以安全关闭存储
+func (w *RetentionSweeper) Stop() {
+	close(w.stopChan)
+	w.runMutex.Lock()
+	defer w.runMutex.Unlock()
+}
+
+// run 后台清理循环
+func (w *RetentionSweeper) run() {
+	for {
+		cfg := config.Current().ChatHistory.Retention
+		if cfg.Enabled {
+			if _, err := w.Run(); err != nil {
+				logrus.Errorf("清理过期聊天记录失败: %v", err)
+			}
+		}
+
+		select {
+		case <-w.stopChan:
+			return
+		case <-time.After(time.Duration(cfg.Interval) * time.Second):
+		}
+	}
+}
+
+// Run 立即按当前配置执行一次清理
+func (w *RetentionSweeper) Run() (*RetentionReport, error) {
+	w.runMutex.Lock()
+	defer w.runMutex.Unlock()
+
+	report, err := w.storage.ApplyRetention(config.Current().ChatHistory.Retention, time.Now())
+	if err != nil {
+		report.Error = err.Error()
+		report.FinishedAt = time.Now()
+	}
+
+	w.lastMutex.Lock()
+	w.last = report
+	w.lastMutex.Unlock()
+
+	if report.Deleted > 0 || err != nil {
+		logrus.WithFields(logrus.Fields{
+			"deleted":         report.Deleted,
+			"media_deleted":   report.MediaDeleted,
+			"media_failed":    report.MediaFailed,
+			"bytes_reclaimed": report.BytesReclaimed,
+		}).Info("已清理过期聊天记录")
+	}
+	return report, err
+}
+
+// LastReport 返回最近一次清理的结果，尚未执行过时返回 nil
+func (w *RetentionSweeper) LastReport() *RetentionReport {
+	w.lastMutex.RLock()
+	defer w.lastMutex.RUnlock()
+	return w.last
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
@@ -20,11 +23,14 @@ type S3Client struct {
 
 // NewS3Client 创建新的 S3 客户端
 func NewS3Client() (*S3Client, error) {
+	// 每次按当前生效的配置创建，重新加载配置后立即生效
+	cfg := config.Current().S3
+
 	// 创建 MinIO 客户端
-	minioClient, err := minio.New(config.AppConfig.S3.Endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(config.AppConfig.S3.AccessKeyID, config.AppConfig.S3.SecretAccessKey, ""),
-		Secure: config.AppConfig.S3.UseSSL,
-		Region: config.AppConfig.S3.Region,
+	minioClient, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("创建 MinIO 客户端失败: %w", err)
@@ -32,8 +38,8 @@ func NewS3Client() (*S3Client, error) {
 
 	return &S3Client{
 		client:        minioClient,
-		bucket:        config.AppConfig.S3.Bucket,
-		publicBaseURL: config.AppConfig.S3.PublicBaseURL,
+		bucket:        cfg.Bucket,
+		publicBaseURL: cfg.PublicBaseURL,
 	}, nil
 }
 
@@ -53,7 +59,7 @@ func (s *S3Client) UploadFile(reader io.Reader, objectName, contentType string)
 	// 上传文件
 	_, err := s.client.PutObject(
 		context.Background(),
-		config.AppConfig.S3.Bucket,
+		s.bucket,
 		objectName,
 		reader,
 		-1,
@@ -77,4 +83,45 @@ func (s *S3Client) UploadFile(reader io.Reader, objectName, contentType string)
 	}).Debug("文件上传成功")
 
 	return publicURL, nil
-} 
\ No newline at end of file
+} 
+// CheckBucket 检查存储桶是否存在且凭证可用
+func (s *S3Client) CheckBucket(ctx context.Context) error {
+	exists, err := s.client.BucketExists(ctx, s.bucket)
+	if err != nil {
+		return fmt.Errorf("访问存储桶失败: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("存储桶 %s 不存在", s.bucket)
+	}
+	return nil
+}
+
+// ObjectName 从 UploadFile 返回的公共地址中解析对象名称，不是本存储桶的地址时返回 false
+func (s *S3Client) ObjectName(publicURL string) (string, bool) {
+	prefix := fmt.Sprintf("https://%s/%s/", s.publicBaseURL, s.bucket)
+	if !strings.HasPrefix(publicURL, prefix) || len(publicURL) == len(prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(publicURL, prefix), true
+}
+
+// DeleteObject 删除对象，对象不存在时不返回错误
+func (s *S3Client) DeleteObject(ctx context.Context, objectName string) error {
+	if err := s.client.RemoveObject(ctx, s.bucket, objectName, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("删除 S3 对象 %s 失败: %w", objectName, err)
+	}
+	return nil
+}
+
+// PresignedURL 生成对象的临时下载链接，fileName 不为空时作为浏览器保存的文件名
+func (s *S3Client) PresignedURL(ctx context.Context, objectName, fileName string, expiry time.Duration) (string, error) {
+	params := make(url.Values)
+	if fileName != "" {
+		params.Set("response-content-disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	}
+	u, err := s.client.PresignedGetObject(ctx, s.bucket, objectName, expiry, params)
+	if err != nil {
+		return "", fmt.Errorf("生成 S3 下载链接失败: %w", err)
+	}
+	return u.String(), nil
+}
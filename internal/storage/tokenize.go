@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxTokenLength 单个词元的最大字节数，过长的词（例如链接、哈希）截断后索引
+const maxTokenLength = 64
+
+// token 分词结果
+type token struct {
+	Text  string // 归一化后的词元
+	Pos   int    // 词元位置，用于短语匹配
+	Start int    // 在原文中的起始字节偏移
+	End   int    // 在原文中的结束字节偏移
+}
+
+// tokenize 对文本分词
+// 字母和数字组成的词转为小写后作为一个词元；中日韩文字没有空格分隔，按相邻两个字切分（bigram），
+// 索引时额外保留单字以支持单字搜索，查询时只在单独一个字时使用单字，保证多字查询按相邻关系匹配
+func tokenize(text string, forIndex bool) []token {
+	var tokens []token
+	pos := 0
+
+	// 当前的中日韩文字串
+	var run []token
+	flushRun := func() {
+		if len(run) == 0 {
+			return
+		}
+		for i, c := range run {
+			if forIndex || len(run) == 1 {
+				tokens = append(tokens, token{Text: c.Text, Pos: pos + i, Start: c.Start, End: c.End})
+			}
+			if i+1 < len(run) {
+				next := run[i+1]
+				tokens = append(tokens, token{Text: c.Text + next.Text, Pos: pos + i, Start: c.Start, End: next.End})
+			}
+		}
+		pos += len(run)
+		run = run[:0]
+	}
+
+	// 当前的字母数字词
+	wordStart := -1
+	var word strings.Builder
+	flushWord := func(end int) {
+		if wordStart < 0 {
+			return
+		}
+		text := word.String()
+		if len(text) > maxTokenLength {
+			text = truncateToken(text)
+		}
+		tokens = append(tokens, token{Text: text, Pos: pos, Start: wordStart, End: end})
+		pos++
+		wordStart = -1
+		word.Reset()
+	}
+
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		r = foldWidth(r)
+		end := i + size
+
+		switch {
+		case isCJK(r):
+			flushWord(i)
+			run = append(run, token{Text: string(r), Start: i, End: end})
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r):
+			flushRun()
+			if wordStart < 0 {
+				wordStart = i
+			}
+			word.WriteRune(unicode.ToLower(r))
+		default:
+			flushWord(i)
+			flushRun()
+		}
+		i = end
+	}
+	flushWord(len(text))
+	flushRun()
+	return tokens
+}
+
+// isCJK 判断是否为需要按字切分的中日韩文字
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) ||
+		unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) ||
+		unicode.Is(unicode.Hangul, r)
+}
+
+// foldWidth 将全角字母、数字和符号转换为半角，使 "ＡＢＣ１２３" 与 "abc123" 匹配
+func foldWidth(r rune) rune {
+	if r >= 0xFF01 && r <= 0xFF5E {
+		return r - 0xFEE0
+	}
+	if r == 0x3000 {
+		return ' '
+	}
+	return r
+}
+
+// truncateToken 将词元截断到 maxTokenLength 字节以内，不截断多字节字符
+func truncateToken(text string) string {
+	end := 0
+	for i := range text {
+		if i > maxTokenLength {
+			break
+		}
+		end = i
+	}
+	return text[:end]
+}